@@ -22,15 +22,21 @@ BEGIN
 END
 $$ LANGUAGE plpgsql;
 
--- FTS updater for pages.text -> tsv_ru/tsv_en
+-- FTS updater for pages.text (+ inbound anchor text, weight B) -> tsv_ru/tsv_en
 CREATE OR REPLACE FUNCTION pages_set_tsvectors() RETURNS trigger AS $$
 BEGIN
-  IF NEW.text IS NULL OR length(NEW.text) = 0 THEN
+  IF COALESCE(NEW.text, '') = '' AND COALESCE(NEW.anchor_text, '') = ''
+     AND COALESCE(array_length(NEW.headings, 1), 0) = 0 THEN
     NEW.tsv_ru := NULL;
     NEW.tsv_en := NULL;
   ELSE
-    NEW.tsv_ru := to_tsvector('russian', unaccent(NEW.text));
-    NEW.tsv_en := to_tsvector('english', unaccent(NEW.text));
+    -- headings (crawler.index_headings) rank highest, then inbound anchor text, then body text
+    NEW.tsv_ru := to_tsvector('russian', unaccent(COALESCE(NEW.text, '')))
+      || setweight(to_tsvector('russian', unaccent(COALESCE(NEW.anchor_text, ''))), 'B')
+      || setweight(to_tsvector('russian', unaccent(COALESCE(array_to_string(NEW.headings, ' '), ''))), 'A');
+    NEW.tsv_en := to_tsvector('english', unaccent(COALESCE(NEW.text, '')))
+      || setweight(to_tsvector('english', unaccent(COALESCE(NEW.anchor_text, ''))), 'B')
+      || setweight(to_tsvector('english', unaccent(COALESCE(array_to_string(NEW.headings, ' '), ''))), 'A');
   END IF;
   RETURN NEW;
 END
@@ -44,6 +50,14 @@ CREATE TABLE IF NOT EXISTS sites (
   rps_limit    integer NOT NULL DEFAULT 10,
   rps_burst    integer NOT NULL DEFAULT 20,
   depth_limit  integer NOT NULL DEFAULT 2,
+  politeness   text,             -- politeness preset name (crawler.politeness_presets); NULL = crawler default
+  max_pages    integer,          -- page cap (crawler.max_pages_per_site); NULL = crawler default, 0 = unlimited
+  user_agent   text,             -- User-Agent for this site's fetches; NULL = crawler.user_agent
+  headers      jsonb,            -- extra request headers {"Name": "value"}, over crawler.headers
+  -- denormalized crawl summary, maintained by the crawler
+  last_crawled_at timestamptz,
+  pages_count  bigint NOT NULL DEFAULT 0,
+  error_count  bigint NOT NULL DEFAULT 0,
   created_at   timestamptz NOT NULL DEFAULT now(),
   updated_at   timestamptz NOT NULL DEFAULT now()
 );
@@ -68,10 +82,13 @@ CREATE TABLE IF NOT EXISTS crawl_queue (
   url         text NOT NULL,
   url_hash    char(64) NOT NULL, -- sha256 hex, computed in application
   priority    integer NOT NULL DEFAULT 0,
+  depth       integer NOT NULL DEFAULT 0, -- link hops from the seed/API-enqueued URL (crawler.depth_limit)
   status      crawl_status NOT NULL DEFAULT 'queued',
   attempts    integer NOT NULL DEFAULT 0,
   last_error  text,
+  error_class text,             -- cause of the last failure: dns, timeout, conn_refused, tls, http_status, body, ...
   next_try_at timestamptz,
+  first_claimed_at timestamptz, -- first move to 'processing' (crawler.record_queue_wait)
   created_at  timestamptz NOT NULL DEFAULT now(),
   updated_at  timestamptz NOT NULL DEFAULT now()
 );
@@ -85,10 +102,29 @@ CREATE UNIQUE INDEX IF NOT EXISTS crawl_queue_site_urlhash_active_uq
   ON crawl_queue(site_id, url_hash)
   WHERE status IN ('queued','processing');
 
+-- Recently finished tasks per (site,url_hash) (crawler.enqueue_done_window)
+CREATE INDEX IF NOT EXISTS crawl_queue_site_urlhash_done_idx
+  ON crawl_queue(site_id, url_hash, updated_at)
+  WHERE status = 'done';
+
 -- Picker-friendly index
 CREATE INDEX IF NOT EXISTS crawl_queue_pick_idx
   ON crawl_queue(site_id, status, next_try_at, priority DESC, id);
 
+-- Crawl runs: explicit start time and per-run counters for elapsed/ETA (manager UI).
+-- Exactly one run is current; the crawler resumes it across restarts.
+CREATE TABLE IF NOT EXISTS crawl_runs (
+  id            bigserial PRIMARY KEY,
+  started_at    timestamptz NOT NULL DEFAULT now(),
+  finished_at   timestamptz,
+  items_done    bigint NOT NULL DEFAULT 0,
+  items_error   bigint NOT NULL DEFAULT 0,
+  items_skipped bigint NOT NULL DEFAULT 0,
+  is_current    boolean NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS crawl_runs_current_uq ON crawl_runs ((true)) WHERE is_current;
+
 -- Pages storage (stores original HTML for viewing and extracted text for search)
 CREATE TABLE IF NOT EXISTS pages (
   id            bigserial PRIMARY KEY,
@@ -97,22 +133,34 @@ CREATE TABLE IF NOT EXISTS pages (
   url_hash      char(64) NOT NULL, -- sha256 hex
   title         text,
   description   text,
-  lang          text CHECK (lang IN ('ru','en')),
+  lang          text CHECK (lang ~ '^[a-z]{2,3}(-[a-z0-9]+)*$'), -- hreflang of the page, e.g. 'ru', 'en-gb'
   http_status   integer,
-  content_type  text,              -- expected 'text/html; charset=...'
+  content_type  text,              -- e.g. 'text/html; charset=...'; filtered by crawler.content_types
   headers       jsonb,             -- raw response headers (optional)
+  meta          jsonb,             -- selected <meta> tags (crawler.meta_tags)
   charset       text,              -- detected charset on fetch
   raw_size      integer,           -- bytes of received body
-  html_hash     char(64),          -- sha256 of original HTML (UTF-8 normalized)
+  word_count    integer,           -- words of the extracted text
+  canonical_url text,              -- <link rel="canonical"> target (resolved); NULL = none declared
+  truncated     boolean NOT NULL DEFAULT false, -- body cut at crawler.html_max_size
+  ttfb_ms       integer,           -- request start -> response headers (crawler.record_fetch_metrics)
+  fetch_ms      integer,           -- request start -> body fully read
+  etag          text,              -- ETag of the stored response (crawler.conditional_get)
+  last_modified text,              -- Last-Modified of the stored response
+  html_hash     char(64),          -- sha256 of the stored HTML (UTF-8 normalized; rendered HTML for render_path 'headless')
+  fetch_hash    char(64),          -- sha256 of the fetched body before rendering (crawler.skip_unchanged)
   html          text,              -- original HTML (stored as UTF-8, for UI rendering)
+  html_ref      text,              -- external HTML store ref "<backend>:<html_hash>" (html is NULL then)
+  render_path   text,              -- 'static' or 'headless' (crawler.render_fallback)
   fetched_at    timestamptz,
   text          text,              -- extracted visible text for FTS
+  anchor_text   text,              -- aggregated anchor text of inbound links (crawler.index_anchor_text)
+  headings      text[],            -- <h1>-<h3> texts in document order (crawler.index_headings)
   tsv_ru        tsvector,
   tsv_en        tsvector,
   created_at    timestamptz NOT NULL DEFAULT now(),
   updated_at    timestamptz NOT NULL DEFAULT now(),
-  UNIQUE (site_id, url_hash),
-  CHECK (content_type IS NULL OR content_type ILIKE 'text/html%')
+  UNIQUE (site_id, url_hash)
 );
 
 CREATE TRIGGER trg_pages_updated_at
@@ -120,13 +168,44 @@ CREATE TRIGGER trg_pages_updated_at
   FOR EACH ROW EXECUTE FUNCTION set_updated_at();
 
 CREATE TRIGGER trg_pages_set_tsvectors
-  BEFORE INSERT OR UPDATE OF text ON pages
+  BEFORE INSERT OR UPDATE OF text, anchor_text, headings ON pages
   FOR EACH ROW EXECUTE FUNCTION pages_set_tsvectors();
 
 -- FTS indexes
 CREATE INDEX IF NOT EXISTS pages_tsv_ru_gin ON pages USING GIN (tsv_ru);
 CREATE INDEX IF NOT EXISTS pages_tsv_en_gin ON pages USING GIN (tsv_en);
 CREATE INDEX IF NOT EXISTS pages_site_fetched_idx ON pages(site_id, fetched_at DESC);
+-- "new pages since" (created_at is first-seen and never changes on recrawl)
+CREATE INDEX IF NOT EXISTS pages_created_idx ON pages(created_at);
+-- Stored response headers filter (/api/pages?header=&header_value=)
+CREATE INDEX IF NOT EXISTS pages_headers_gin ON pages USING GIN (headers);
+
+-- Globally deduplicated raw HTML (html_storage.backend: blob). Pages reference a blob via
+-- html_ref = 'blob:<html_hash>'; ref_count is maintained by the trigger below.
+CREATE TABLE IF NOT EXISTS html_blobs (
+  html_hash  char(64) PRIMARY KEY,
+  html       text NOT NULL,
+  ref_count  integer NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE OR REPLACE FUNCTION pages_html_blob_refs() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP IN ('UPDATE','DELETE') AND OLD.html_ref LIKE 'blob:%'
+     AND (TG_OP = 'DELETE' OR NEW.html_ref IS DISTINCT FROM OLD.html_ref) THEN
+    UPDATE html_blobs SET ref_count = ref_count - 1 WHERE html_hash = substr(OLD.html_ref, 6);
+  END IF;
+  IF TG_OP IN ('INSERT','UPDATE') AND NEW.html_ref LIKE 'blob:%'
+     AND (TG_OP = 'INSERT' OR NEW.html_ref IS DISTINCT FROM OLD.html_ref) THEN
+    UPDATE html_blobs SET ref_count = ref_count + 1 WHERE html_hash = substr(NEW.html_ref, 6);
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_pages_html_blob_refs
+  AFTER INSERT OR UPDATE OF html_ref OR DELETE ON pages
+  FOR EACH ROW EXECUTE FUNCTION pages_html_blob_refs();
 
 -- Links extracted from pages
 CREATE TABLE IF NOT EXISTS page_links (
@@ -134,9 +213,12 @@ CREATE TABLE IF NOT EXISTS page_links (
   from_page_id bigint NOT NULL REFERENCES pages(id) ON DELETE CASCADE,
   to_url       text NOT NULL,
   to_url_hash  char(64) NOT NULL, -- sha256 hex
+  anchor_text  text,
   created_at   timestamptz NOT NULL DEFAULT now(),
   UNIQUE (from_page_id, to_url_hash)
 );
+-- inbound link counts (crawler.link_priority_boost)
+CREATE INDEX IF NOT EXISTS page_links_to_hash_idx ON page_links (to_url_hash);
 
 -- Robots.txt cache per site
 CREATE TABLE IF NOT EXISTS robots_cache (
@@ -156,7 +238,53 @@ CREATE TABLE IF NOT EXISTS sitemaps (
   UNIQUE (site_id, url)
 );
 
+-- Domain search hit rates per TLD (cumulative over passes)
+CREATE TABLE IF NOT EXISTS domain_search_tld_stats (
+  tld        text PRIMARY KEY,
+  checked    bigint NOT NULL DEFAULT 0,
+  working    bigint NOT NULL DEFAULT 0,
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
+
 -- Optional helper view for search union (logic is handled in application)
 -- CREATE VIEW search_pages AS
 -- SELECT id, site_id, url, title, description, fetched_at, tsv_ru, tsv_en
--- FROM pages;
\ No newline at end of file
+-- FROM pages;
+
+-- Migrations for databases created from an older version of this file (idempotent)
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS last_crawled_at timestamptz;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS pages_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS error_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS politeness text;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS max_pages integer;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS user_agent text;
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS headers jsonb;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS html_ref text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS render_path text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS anchor_text text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS ttfb_ms integer;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS fetch_ms integer;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS meta jsonb;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS etag text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS last_modified text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS truncated boolean NOT NULL DEFAULT false;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS headings text[];
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS word_count integer;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS canonical_url text;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS fetch_hash char(64);
+ALTER TABLE crawl_queue ADD COLUMN IF NOT EXISTS depth integer NOT NULL DEFAULT 0;
+ALTER TABLE crawl_queue ADD COLUMN IF NOT EXISTS first_claimed_at timestamptz;
+ALTER TABLE crawl_queue ADD COLUMN IF NOT EXISTS error_class text;
+-- queue wait percentiles over recently claimed items (crawler.record_queue_wait)
+CREATE INDEX IF NOT EXISTS crawl_queue_first_claimed_idx
+  ON crawl_queue(first_claimed_at)
+  WHERE first_claimed_at IS NOT NULL;
+ALTER TABLE pages DROP CONSTRAINT IF EXISTS pages_lang_check;
+ALTER TABLE pages ADD CONSTRAINT pages_lang_check CHECK (lang ~ '^[a-z]{2,3}(-[a-z0-9]+)*$');
+-- content_types may allow non-HTML types (application/xhtml+xml, text/*): no text/html-only CHECK
+ALTER TABLE pages DROP CONSTRAINT IF EXISTS pages_content_type_check;
+ALTER TABLE page_links ADD COLUMN IF NOT EXISTS anchor_text text;
+DROP TRIGGER IF EXISTS trg_pages_set_tsvectors ON pages;
+CREATE TRIGGER trg_pages_set_tsvectors
+  BEFORE INSERT OR UPDATE OF text, anchor_text, headings ON pages
+  FOR EACH ROW EXECUTE FUNCTION pages_set_tsvectors();
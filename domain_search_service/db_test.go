@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tests that need Postgres run against GOSE_TEST_DSN, a scratch database initialized with
+// deploy/db/init.sql, and are skipped when it is not set.
+
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("GOSE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GOSE_TEST_DSN not set")
+	}
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
@@ -12,9 +12,12 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -29,6 +32,9 @@ type Config struct {
 	Limits    LimitsConfig    `yaml:"limits"`
 	HTTPCheck HTTPCheckConfig `yaml:"http_check"`
 	Run       RunConfig       `yaml:"run"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Wildcard  WildcardConfig  `yaml:"wildcard"`
+	Output    OutputConfig    `yaml:"output"`
 }
 
 type GeneratorConfig struct {
@@ -40,6 +46,17 @@ type GeneratorConfig struct {
 	ForbidLeadingHyphen  bool     `yaml:"forbid_leading_hyphen"`
 	ForbidTrailingHyphen bool     `yaml:"forbid_trailing_hyphen"`
 	ForbidDoubleHyphen   bool     `yaml:"forbid_double_hyphen"`
+	// AllowInvalidAlphabet keeps alphabet characters that can't appear in an LDH domain label
+	// (anything but a-z, 0-9, '-'); by default they fail config validation.
+	AllowInvalidAlphabet bool `yaml:"allow_invalid_alphabet"`
+	// Start/End bound the enumeration to labels in [start, end] (enumeration order: by length,
+	// then by alphabet position), e.g. to resume a scan from the last logged candidate.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Shard splits the keyspace across instances: instance ShardIndex of ShardTotal takes every
+	// ShardTotal-th label. Slices are disjoint and together cover the whole keyspace.
+	ShardIndex int `yaml:"shard_index"`
+	ShardTotal int `yaml:"shard_total"`
 }
 
 type LimitsConfig struct {
@@ -60,6 +77,13 @@ type HTTPCheckConfig struct {
 
 type RunConfig struct {
 	Loop bool `yaml:"loop"`
+	// DrainTimeout bounds DB writes of in-flight checks and the final stats flush after
+	// SIGINT/SIGTERM (default 10s).
+	DrainTimeout Duration `yaml:"drain_timeout"`
+}
+
+type MetricsConfig struct {
+	Addr string `yaml:"addr"` // e.g. ":9102"; empty = no metrics endpoint
 }
 
 // Duration wrapper for YAML
@@ -121,6 +145,13 @@ func main() {
 		log.Fatalf("config load error: %v", err)
 	}
 
+	// TLDs are normalized once here; the generator hot loop uses them as is
+	if cfg.Generator.TLDs, err = normalizeTLDs(cfg.Generator.TLDs); err != nil {
+		log.Fatalf("config validation error: %v", err)
+	}
+	if cfg.Generator.Alphabet, err = normalizeAlphabet(cfg.Generator.Alphabet, cfg.Generator.AllowInvalidAlphabet); err != nil {
+		log.Fatalf("config validation error: %v", err)
+	}
 	if err := validateConfig(cfg); err != nil {
 		log.Fatalf("config validation error: %v", err)
 	}
@@ -131,7 +162,8 @@ func main() {
 		log.Fatalf("PG_DSN is required in environment")
 	}
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	db, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		log.Fatalf("pgx pool error: %v", err)
@@ -141,6 +173,19 @@ func main() {
 	log.Printf("domain_search_service started (config: %s), RPS=%d, Concurrency=%d, Loop=%v",
 		cfgPath, cfg.Limits.RatePerSecond, cfg.Limits.Concurrency, cfg.Run.Loop)
 
+	if cfg.Metrics.Addr != "" {
+		go serveMetrics(cfg.Metrics.Addr)
+	}
+
+	var pub Publisher
+	if cfg.Output.toPublish() {
+		if pub, err = newPublisher(cfg.Output.Publish); err != nil {
+			log.Fatalf("publisher error: %v", err)
+		}
+		defer pub.Close()
+		log.Printf("publishing discoveries to %s %s (%s)", cfg.Output.Publish.Backend, cfg.Output.Publish.Addr, cfg.Output.Publish.Subject)
+	}
+
 	httpClient := &http.Client{
 		Timeout: cfg.HTTPCheck.Timeout.Duration,
 		Transport: &http.Transport{
@@ -159,20 +204,86 @@ func main() {
 	}
 
 	for {
-		if err := runOnce(ctx, db, httpClient, cfg); err != nil {
+		res, err := runOnce(ctx, db, httpClient, pub, cfg)
+		if err != nil {
 			log.Printf("runOnce error: %v", err)
 		}
-		if !cfg.Run.Loop {
+		if !cfg.Run.Loop || res.Reason == passCancelled {
 			break
 		}
 	}
 	log.Printf("domain_search_service finished")
 }
 
-func runOnce(ctx context.Context, db *pgxpool.Pool, httpClient *http.Client, cfg Config) error {
+// passReason tells why a generation pass ended.
+type passReason string
+
+const (
+	passExhausted     passReason = "exhausted"      // the generator ran out of candidates
+	passMaxCandidates passReason = "max_candidates" // limits.max_candidates was reached
+	passCancelled     passReason = "cancelled"      // SIGINT/SIGTERM
+	passError         passReason = "error"          // the generator failed (see the returned error)
+)
+
+// passResult is the structured outcome of runOnce.
+type passResult struct {
+	Reason     passReason
+	Candidates int
+	Last       string // last generated label; usable as generator.start to resume
+}
+
+// completionReason classifies a finished pass; cancellation wins over the other reasons.
+func completionReason(ctx context.Context, genErr error, hitMax bool) passReason {
+	switch {
+	case ctx.Err() != nil:
+		return passCancelled
+	case genErr != nil:
+		return passError
+	case hitMax:
+		return passMaxCandidates
+	}
+	return passExhausted
+}
+
+// drainContext is not cancelled with ctx right away: it lives drain longer, so writes
+// for work already done can finish on shutdown.
+func drainContext(ctx context.Context, drain time.Duration) (context.Context, context.CancelFunc) {
+	dctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() { time.AfterFunc(drain, cancel) })
+	return dctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// pub is nil unless output.mode publishes discoveries.
+func runOnce(ctx context.Context, db *pgxpool.Pool, httpClient *http.Client, pub Publisher, cfg Config) (passResult, error) {
 	candidates := make(chan string, cfg.Limits.Concurrency*2)
 	wg := &sync.WaitGroup{}
 
+	drain := cfg.Run.DrainTimeout.Duration
+	if drain <= 0 {
+		drain = 10 * time.Second
+	}
+	dbCtx, cancelDB := drainContext(ctx, drain)
+	defer cancelDB()
+
+	// Wildcard signatures are refreshed every pass (registrar parking changes over time)
+	var wildcards *wildcardSignatures
+	if cfg.Wildcard.Enabled {
+		wildcards = newWildcardSignatures()
+		wildcards.detect(ctx, cfg.Generator.TLDs, cfg.Wildcard.Probes)
+	}
+
+	stats := newTLDStats(cfg.Generator.TLDs)
+	currentTLDStats.Store(stats)
+	flushDone := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		runTLDStatsFlusher(dbCtx, db, stats, 30*time.Second, flushDone)
+		close(flushed)
+	}()
+
 	// Rate limiter: token channel refilled every second
 	rlTokens := make(chan struct{}, cfg.Limits.RatePerSecond)
 	fillTokens := func() {
@@ -206,16 +317,32 @@ func runOnce(ctx context.Context, db *pgxpool.Pool, httpClient *http.Client, cfg
 			}
 
 			// Build URL to check: try https, then http if configured
-			ok, finalURL := checkDomain(ctx, httpClient, name, cfg.HTTPCheck)
+			ok, finalURL, status := checkDomain(ctx, httpClient, name, cfg.HTTPCheck)
+			if ok && wildcards != nil && wildcards.isWildcard(ctx, name) {
+				ok = false
+			}
+			if ctx.Err() != nil {
+				return // check aborted by shutdown: not a real result
+			}
+			stats.record(name, ok)
 			if !ok {
 				continue
 			}
+			if pub != nil {
+				scheme, _, _ := strings.Cut(finalURL, "://")
+				publishDiscovery(dbCtx, pub, Discovery{
+					Domain: name, Scheme: scheme, Status: status, URL: finalURL, DiscoveredAt: time.Now().UTC(),
+				})
+			}
+			if !cfg.Output.toDB() {
+				continue
+			}
 			// Insert to DB: ensure site + enqueue "/" URL
 			host := strings.TrimPrefix(strings.TrimPrefix(finalURL, "https://"), "http://")
 			if i := strings.IndexByte(host, '/'); i >= 0 {
 				host = host[:i]
 			}
-			siteID, err := ensureSite(ctx, db, host, cfg)
+			siteID, err := ensureSite(dbCtx, db, host, cfg)
 			if err != nil {
 				log.Printf("ensureSite(%s) error: %v", host, err)
 				continue
@@ -225,7 +352,7 @@ func runOnce(ctx context.Context, db *pgxpool.Pool, httpClient *http.Client, cfg
 				rootURL += "/"
 			}
 			urlHash := sha256Hex(rootURL)
-			enq, err := enqueueIfNotExists(ctx, db, siteID, rootURL, urlHash, 0)
+			enq, err := enqueueIfNotExists(dbCtx, db, siteID, rootURL, urlHash, 0)
 			if err != nil {
 				log.Printf("enqueue error %s: %v", rootURL, err)
 				continue
@@ -248,26 +375,36 @@ func runOnce(ctx context.Context, db *pgxpool.Pool, httpClient *http.Client, cfg
 
 	// Generate candidates
 	total := 0
+	last := ""
+	hitMax := false
 	genErr := generateCandidates(cfg.Generator, func(name string) bool {
 		select {
 		case candidates <- name:
 			total++
-			return cfg.Limits.MaxCandidates <= 0 || total < cfg.Limits.MaxCandidates
+			last = name
+			hitMax = cfg.Limits.MaxCandidates > 0 && total >= cfg.Limits.MaxCandidates
+			return !hitMax
 		case <-ctx.Done():
 			return false
 		}
 	})
 
+	// workers finish (or, on shutdown, abandon) the buffered candidates; their DB writes and
+	// the final stats flush use dbCtx, so they complete within run.drain_timeout
 	close(candidates)
 	wg.Wait()
-	if genErr != nil {
-		return genErr
-	}
-	return nil
+	close(flushDone)
+	<-flushed
+	res := passResult{Reason: completionReason(ctx, genErr, hitMax), Candidates: total, Last: last}
+	// the label of the last candidate can be used as generator.start to resume
+	log.Printf("pass finished: reason=%s, %d candidates in %s, last=%s", res.Reason, total, time.Since(stats.startedAt).Round(time.Second), last)
+	stats.logSummary()
+	return res, genErr
 }
 
 // checkDomain performs HTTP GET (or configured method) to determine if a domain is "working".
-func checkDomain(ctx context.Context, client *http.Client, domain string, hc HTTPCheckConfig) (bool, string) {
+// It returns the working URL and its HTTP status.
+func checkDomain(ctx context.Context, client *http.Client, domain string, hc HTTPCheckConfig) (bool, string, int) {
 	method := hc.Method
 	if method == "" {
 		method = http.MethodGet
@@ -282,6 +419,7 @@ func checkDomain(ctx context.Context, client *http.Client, domain string, hc HTT
 	}
 	ok := false
 	var finalURL string
+	var status int
 
 	try := func(scheme string) bool {
 		url := scheme + "://" + domain + "/"
@@ -298,6 +436,7 @@ func checkDomain(ctx context.Context, client *http.Client, domain string, hc HTT
 		_, _ = io.CopyN(io.Discard, resp.Body, bodyLimit)
 		if resp.StatusCode >= hc.AcceptStatusMin && resp.StatusCode <= hc.AcceptStatusMax {
 			finalURL = url
+			status = resp.StatusCode
 			return true
 		}
 		return false
@@ -314,7 +453,7 @@ func checkDomain(ctx context.Context, client *http.Client, domain string, hc HTT
 			break
 		}
 	}
-	return ok, finalURL
+	return ok, finalURL, status
 }
 
 // generateCandidates runs lexicographic enumeration per length and emits "domain" (without TLD).
@@ -323,27 +462,40 @@ func generateCandidates(gen GeneratorConfig, emit func(string) bool) error {
 	if gen.MinLength < 1 || gen.MaxLength < gen.MinLength {
 		return fmt.Errorf("invalid lengths: min=%d max=%d", gen.MinLength, gen.MaxLength)
 	}
-	alpha := gen.Alphabet
-	if alpha == "" {
-		alpha = "abcdefghijklmnopqrstuvwxyz0123456789-"
-	}
-	alphaRunes := []rune(alpha)
+	alphaRunes := gen.alphabet()
 	alMap := make(map[rune]bool, len(alphaRunes))
 	for _, r := range alphaRunes {
 		alMap[r] = true
 	}
 	isAllowed := func(r rune) bool { return alMap[r] }
 
+	// Optional start/end labels, as alphabet positions
+	startIdx, err := alphabetPositions(gen.Start, alphaRunes)
+	if err != nil {
+		return fmt.Errorf("generator.start: %w", err)
+	}
+	endIdx, err := alphabetPositions(gen.End, alphaRunes)
+	if err != nil {
+		return fmt.Errorf("generator.end: %w", err)
+	}
+	shards := uint64(nonZero(gen.ShardTotal, 1))
+	shard := uint64(gen.ShardIndex)
+
 	// For each length and for each TLD
 	for ln := gen.MinLength; ln <= gen.MaxLength; ln++ {
+		if startIdx != nil && ln < len(startIdx) {
+			continue
+		}
 		// Counter array of positions in alphabet
 		idx := make([]int, ln)
-		totalComb := int64(1)
-		for i := 0; i < ln; i++ {
-			totalComb *= int64(len(alphaRunes))
-			if totalComb > math.MaxInt32 {
-				// high bound, but we'll naturally stop by emit false when reaching max_candidates
-			}
+		if startIdx != nil && ln == len(startIdx) {
+			copy(idx, startIdx)
+		}
+		// pos is the label's ordinal within this length modulo shards (ordinals overflow
+		// int64 for long labels, so only the remainder is tracked)
+		pos := uint64(0)
+		for _, d := range idx {
+			pos = (pos*uint64(len(alphaRunes)%int(shards)) + uint64(d)) % shards
 		}
 		for {
 			// Build name
@@ -375,20 +527,20 @@ func generateCandidates(gen GeneratorConfig, emit func(string) bool) error {
 				}
 				b.WriteRune(r)
 			}
-			if valid {
+			if valid && pos == shard {
 				name := b.String()
 				// emit for each TLD
 				for _, tld := range gen.TLDs {
-					tld = strings.ToLower(strings.TrimSpace(tld))
-					if tld == "" || tld[0] != '.' {
-						continue
-					}
 					domain := name + tld
 					if !emit(domain) {
 						return nil
 					}
 				}
 			}
+			if endIdx != nil && slices.Equal(idx, endIdx) {
+				return nil
+			}
+			pos = (pos + 1) % shards
 
 			// increment idx like odometer
 			carry := 1
@@ -409,6 +561,60 @@ func generateCandidates(gen GeneratorConfig, emit func(string) bool) error {
 	return nil
 }
 
+// alphabet returns the generator alphabet as runes (the LDH set when unset).
+func (gen GeneratorConfig) alphabet() []rune {
+	if gen.Alphabet == "" {
+		return []rune("abcdefghijklmnopqrstuvwxyz0123456789-")
+	}
+	return []rune(gen.Alphabet)
+}
+
+// validateBounds checks generator.start and generator.end: each must be spelled in the
+// alphabet with a length within min_length..max_length, and end must not precede start in
+// enumeration order (shorter labels first, then by alphabet position).
+func validateBounds(gen GeneratorConfig) error {
+	alpha := gen.alphabet()
+	var bounds [2][]int
+	for i, b := range []struct{ key, label string }{{"start", gen.Start}, {"end", gen.End}} {
+		pos, err := alphabetPositions(b.label, alpha)
+		if err != nil {
+			return fmt.Errorf("generator.%s: %w", b.key, err)
+		}
+		if n := len(pos); n > 0 && (n < gen.MinLength || n > gen.MaxLength) {
+			return fmt.Errorf("generator.%s length must be within min_length..max_length", b.key)
+		}
+		bounds[i] = pos
+	}
+	start, end := bounds[0], bounds[1]
+	if start != nil && end != nil &&
+		(len(end) < len(start) || len(end) == len(start) && slices.Compare(end, start) < 0) {
+		return fmt.Errorf("generator.end %q is before generator.start %q", gen.End, gen.Start)
+	}
+	return nil
+}
+
+// alphabetPositions maps a label to alphabet positions (nil for an empty label).
+func alphabetPositions(label string, alphabet []rune) ([]int, error) {
+	if label == "" {
+		return nil, nil
+	}
+	pos := make(map[rune]int, len(alphabet))
+	for i, r := range alphabet {
+		if _, ok := pos[r]; !ok {
+			pos[r] = i
+		}
+	}
+	var out []int
+	for _, r := range strings.ToLower(label) {
+		i, ok := pos[r]
+		if !ok {
+			return nil, fmt.Errorf("character %q is not in the alphabet", r)
+		}
+		out = append(out, i)
+	}
+	return out, nil
+}
+
 func ensureSite(ctx context.Context, db *pgxpool.Pool, domain string, cfg Config) (int64, error) {
 	var id int64
 	const q = `
@@ -478,6 +684,63 @@ func loadConfig(path string) (Config, error) {
 	return cfg, nil
 }
 
+// normalizeTLDs lowercases and trims TLDs, adds the leading dot, drops duplicates
+// (keeping the first occurrence) and rejects entries that aren't valid DNS labels.
+func normalizeTLDs(tlds []string) ([]string, error) {
+	out := make([]string, 0, len(tlds))
+	seen := make(map[string]bool, len(tlds))
+	for _, raw := range tlds {
+		tld := strings.ToLower(strings.TrimSpace(raw))
+		tld = "." + strings.TrimPrefix(tld, ".")
+		for _, label := range strings.Split(tld[1:], ".") {
+			if !isValidLabel(label) {
+				return nil, fmt.Errorf("generator.tlds: invalid TLD %q", raw)
+			}
+		}
+		if seen[tld] {
+			log.Printf("generator.tlds: duplicate %q ignored", raw)
+			continue
+		}
+		seen[tld] = true
+		out = append(out, tld)
+	}
+	return out, nil
+}
+
+// normalizeAlphabet lowercases the generator alphabet and drops repeated characters (keeping
+// the first), which would otherwise make the odometer emit the same names several times.
+// Characters invalid in a domain label are rejected unless allowInvalid.
+func normalizeAlphabet(alpha string, allowInvalid bool) (string, error) {
+	var b strings.Builder
+	seen := make(map[rune]bool, len(alpha))
+	for _, r := range strings.ToLower(alpha) {
+		if seen[r] {
+			log.Printf("generator.alphabet: duplicate %q ignored", r)
+			continue
+		}
+		if !allowInvalid && !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return "", fmt.Errorf("generator.alphabet: %q is not valid in a domain label (set allow_invalid_alphabet to keep it)", r)
+		}
+		seen[r] = true
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// isValidLabel reports an LDH DNS label: 1-63 chars of [a-z0-9-], no leading/trailing hyphen.
+func isValidLabel(l string) bool {
+	if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
 func validateConfig(cfg Config) error {
 	if len(cfg.Generator.TLDs) == 0 {
 		return errors.New("generator.tlds must not be empty")
@@ -491,8 +754,15 @@ func validateConfig(cfg Config) error {
 	if cfg.Limits.RatePerSecond <= 0 {
 		return errors.New("limits.rate_per_second must be > 0")
 	}
+	if cfg.Generator.ShardTotal < 0 || cfg.Generator.ShardIndex < 0 ||
+		(cfg.Generator.ShardIndex > 0 && cfg.Generator.ShardIndex >= cfg.Generator.ShardTotal) {
+		return fmt.Errorf("invalid shard %d of %d", cfg.Generator.ShardIndex, cfg.Generator.ShardTotal)
+	}
+	if err := validateBounds(cfg.Generator); err != nil {
+		return err
+	}
 	if cfg.HTTPCheck.AcceptStatusMin <= 0 || cfg.HTTPCheck.AcceptStatusMax < cfg.HTTPCheck.AcceptStatusMin {
 		return errors.New("invalid http_check accept status range")
 	}
-	return nil
+	return validateOutput(cfg.Output)
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateBounds(t *testing.T) {
+	base := GeneratorConfig{Alphabet: "abc", MinLength: 1, MaxLength: 3}
+	tests := []struct {
+		name       string
+		start, end string
+		wantErr    string
+	}{
+		{name: "unbounded"},
+		{name: "start only", start: "ab"},
+		{name: "end only", end: "cc"},
+		{name: "start before end", start: "ab", end: "ba"},
+		{name: "equal", start: "ab", end: "ab"},
+		{name: "shorter end length first", start: "c", end: "aa"},
+		{name: "start outside alphabet", start: "ax", wantErr: "generator.start"},
+		{name: "end outside alphabet", end: "ax", wantErr: "generator.end"},
+		{name: "end too long", end: "aaaa", wantErr: "generator.end length"},
+		{name: "start too long", start: "aaaa", wantErr: "generator.start length"},
+		{name: "end before start", start: "ba", end: "ab", wantErr: "before"},
+		{name: "end shorter than start", start: "aa", end: "c", wantErr: "before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := base
+			gen.Start, gen.End = tt.start, tt.end
+			err := validateBounds(gen)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// labels runs the generator and returns the emitted candidates.
+func labels(t *testing.T, gen GeneratorConfig) []string {
+	t.Helper()
+	if gen.TLDs == nil {
+		gen.TLDs = []string{""}
+	}
+	var out []string
+	if err := generateCandidates(gen, func(d string) bool { out = append(out, d); return true }); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestGenerateCandidatesBounds(t *testing.T) {
+	base := GeneratorConfig{Alphabet: "ab", MinLength: 1, MaxLength: 2}
+	tests := []struct {
+		start, end string
+		want       string
+	}{
+		{want: "a b aa ab ba bb"},
+		{start: "ab", want: "ab ba bb"},
+		{end: "ba", want: "a b aa ab ba"},
+		{start: "b", end: "ab", want: "b aa ab"},
+		{start: "ab", end: "ab", want: "ab"},
+		{start: "B", want: "b aa ab ba bb"}, // bounds are case-insensitive
+	}
+	for _, tt := range tests {
+		gen := base
+		gen.Start, gen.End = tt.start, tt.end
+		if got := strings.Join(labels(t, gen), " "); got != tt.want {
+			t.Errorf("start %q end %q: got %q, want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateCandidatesTLDs(t *testing.T) {
+	gen := GeneratorConfig{Alphabet: "ab", MinLength: 1, MaxLength: 1, TLDs: []string{".com", ".io"}}
+	if got := strings.Join(labels(t, gen), " "); got != "a.com a.io b.com b.io" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestGenerateCandidatesHyphenRules(t *testing.T) {
+	gen := GeneratorConfig{Alphabet: "a-", MinLength: 1, MaxLength: 3, AllowHyphen: true,
+		ForbidLeadingHyphen: true, ForbidTrailingHyphen: true, ForbidDoubleHyphen: true}
+	if got := strings.Join(labels(t, gen), " "); got != "a aa aaa a-a" {
+		t.Errorf("got %q", got)
+	}
+	gen.AllowHyphen = false
+	if got := strings.Join(labels(t, gen), " "); got != "a aa aaa" {
+		t.Errorf("without hyphens: got %q", got)
+	}
+}
+
+// Shards are disjoint, cover the keyspace, and a resumed shard (start) continues exactly
+// where the full shard enumeration would be.
+func TestGenerateCandidatesShards(t *testing.T) {
+	base := GeneratorConfig{Alphabet: "abc", MinLength: 1, MaxLength: 3}
+	all := labels(t, base)
+	for _, total := range []int{1, 2, 3, 4, 7} {
+		seen := map[string]int{}
+		for idx := 0; idx < total; idx++ {
+			gen := base
+			gen.ShardIndex, gen.ShardTotal = idx, total
+			shard := labels(t, gen)
+			for _, l := range shard {
+				seen[l]++
+			}
+			// resume from the middle of the shard
+			if len(shard) > 2 {
+				mid := len(shard) / 2
+				gen.Start = shard[mid]
+				if got, want := strings.Join(labels(t, gen), " "), strings.Join(shard[mid:], " "); got != want {
+					t.Errorf("shard %d/%d from %q: got %q, want %q", idx, total, gen.Start, got, want)
+				}
+			}
+		}
+		if len(seen) != len(all) {
+			t.Errorf("%d shards cover %d of %d labels", total, len(seen), len(all))
+		}
+		for l, n := range seen {
+			if n != 1 {
+				t.Errorf("%d shards: %q emitted %d times", total, l, n)
+			}
+		}
+	}
+}
+
+// The shard of a start label is computed modulo the shard count, so long labels whose
+// ordinal overflows 64 bits still land in the right shard.
+func TestGenerateCandidatesShardOfLongStart(t *testing.T) {
+	alpha := "abcdefghijklmnopqrstuvwxyz0123456789"
+	start := strings.Repeat("9", 19) + "8" // second to last label: ordinal 36^20-2 > 2^64
+	const total = 7
+	ord := new(big.Int)
+	for _, r := range start {
+		ord.Mul(ord, big.NewInt(int64(len(alpha))))
+		ord.Add(ord, big.NewInt(int64(strings.IndexRune(alpha, r))))
+	}
+	shard := int(new(big.Int).Mod(ord, big.NewInt(total)).Int64())
+	gen := GeneratorConfig{Alphabet: alpha, MinLength: 20, MaxLength: 20, Start: start, ShardIndex: shard, ShardTotal: total}
+	got := labels(t, gen)
+	if len(got) != 1 || got[0] != start {
+		t.Fatalf("shard %d from %q = %q, want just the start label", shard, start, got)
+	}
+	gen.ShardIndex = (shard + 1) % total
+	if got := labels(t, gen); len(got) != 1 || got[0] != strings.Repeat("9", 20) {
+		t.Fatalf("shard %d from %q = %q, want just the last label", gen.ShardIndex, start, got)
+	}
+}
+
+func TestNormalizeTLDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "leading dot added", in: []string{"com", ".org"}, want: []string{".com", ".org"}},
+		{name: "case and space", in: []string{" .COM ", "Net"}, want: []string{".com", ".net"}},
+		{name: "duplicates keep first", in: []string{".com", "COM", "org", ".com"}, want: []string{".com", ".org"}},
+		{name: "multi-label", in: []string{"co.uk"}, want: []string{".co.uk"}},
+		{name: "punycode", in: []string{"xn--p1ai"}, want: []string{".xn--p1ai"}},
+		{name: "empty", in: []string{""}, wantErr: true},
+		{name: "only a dot", in: []string{"."}, wantErr: true},
+		{name: "empty label", in: []string{"co..uk"}, wantErr: true},
+		{name: "trailing dot", in: []string{"com."}, wantErr: true},
+		{name: "hyphen edge", in: []string{"-com"}, wantErr: true},
+		{name: "invalid char", in: []string{"c_m"}, wantErr: true},
+		{name: "unicode", in: []string{"рф"}, wantErr: true},
+		{name: "invalid after valid", in: []string{"com", "b@d"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTLDs(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeTLDs(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeAlphabet(t *testing.T) {
+	tests := []struct {
+		alpha        string
+		allowInvalid bool
+		want         string
+		wantErr      bool
+	}{
+		{alpha: "abc", want: "abc"},
+		{alpha: "aab-b", want: "ab-"},
+		{alpha: "AbBa09", want: "ab09"},
+		{alpha: "", want: ""},
+		{alpha: "ab_", wantErr: true},
+		{alpha: "a.b", wantErr: true},
+		{alpha: "a\u00e9", wantErr: true},
+		{alpha: "ab_b_", allowInvalid: true, want: "ab_"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeAlphabet(tt.alpha, tt.allowInvalid)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeAlphabet(%q, %v) err = %v, wantErr %v", tt.alpha, tt.allowInvalid, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeAlphabet(%q, %v) = %q, want %q", tt.alpha, tt.allowInvalid, got, tt.want)
+		}
+	}
+}
+
+// A duplicate-containing alphabet, once normalized, enumerates every name exactly once.
+func TestGenerateCandidatesDuplicateAlphabet(t *testing.T) {
+	alpha, err := normalizeAlphabet("abacb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := labels(t, GeneratorConfig{Alphabet: alpha, MinLength: 1, MaxLength: 3})
+	if want := 3 + 9 + 27; len(got) != want {
+		t.Errorf("%d candidates, want %d", len(got), want)
+	}
+	seen := make(map[string]bool, len(got))
+	for _, d := range got {
+		if seen[d] {
+			t.Errorf("candidate %q emitted twice", d)
+		}
+		seen[d] = true
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// OutputConfig selects where discovered domains go: the crawl queue in the DB (default),
+// a message queue, or both.
+type OutputConfig struct {
+	// Mode: db (default) | publish | both
+	Mode    string        `yaml:"mode"`
+	Publish PublishConfig `yaml:"publish"`
+}
+
+// PublishConfig configures the message queue publisher.
+type PublishConfig struct {
+	// Backend: nats | redis
+	Backend string `yaml:"backend"`
+	// Addr is host:port of the broker.
+	Addr string `yaml:"addr"`
+	// Subject is the NATS subject or the Redis stream key.
+	Subject string `yaml:"subject"`
+	// Timeout bounds connecting and each publish (default 5s).
+	Timeout Duration `yaml:"timeout"`
+}
+
+func (o OutputConfig) toDB() bool      { return o.Mode == "" || o.Mode == "db" || o.Mode == "both" }
+func (o OutputConfig) toPublish() bool { return o.Mode == "publish" || o.Mode == "both" }
+
+func validateOutput(o OutputConfig) error {
+	switch o.Mode {
+	case "", "db":
+		return nil
+	case "publish", "both":
+	default:
+		return fmt.Errorf("output.mode must be db, publish or both, got %q", o.Mode)
+	}
+	switch o.Publish.Backend {
+	case "nats", "redis":
+	case "kafka":
+		return errors.New("output.publish.backend kafka is not supported (use nats or redis)")
+	default:
+		return fmt.Errorf("output.publish.backend must be nats or redis, got %q", o.Publish.Backend)
+	}
+	if o.Publish.Addr == "" || o.Publish.Subject == "" {
+		return errors.New("output.publish.addr and output.publish.subject are required")
+	}
+	return validateSubject(o.Publish.Backend, o.Publish.Subject)
+}
+
+// validateSubject rejects subjects that would break the wire protocol: a NATS subject is a
+// space-delimited PUB argument, so no whitespace or control characters; a Redis stream key
+// must not carry CR/LF.
+func validateSubject(backend, subject string) error {
+	bad := func(r rune) bool { return r == '\r' || r == '\n' }
+	if backend == "nats" {
+		bad = func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }
+	}
+	for _, r := range subject {
+		if bad(r) {
+			return fmt.Errorf("output.publish.subject %q: invalid character %q for %s", subject, r, backend)
+		}
+	}
+	return nil
+}
+
+// Discovery is the message published for each working domain.
+type Discovery struct {
+	Domain       string    `json:"domain"`
+	Scheme       string    `json:"scheme"`
+	Status       int       `json:"status"`
+	URL          string    `json:"url"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+}
+
+// Publisher sends discoveries to a message queue. Implementations are safe for concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, d Discovery) error
+	Close() error
+}
+
+// newPublisher builds the publisher for cfg.Backend; connections are opened lazily.
+func newPublisher(cfg PublishConfig) (Publisher, error) {
+	timeout := cfg.Timeout.Duration
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	c := &brokerConn{addr: cfg.Addr, timeout: timeout}
+	switch cfg.Backend {
+	case "nats":
+		return &natsPublisher{conn: c, subject: cfg.Subject}, nil
+	case "redis":
+		return &redisPublisher{conn: c, stream: cfg.Subject}, nil
+	}
+	return nil, fmt.Errorf("unknown publish backend %q", cfg.Backend)
+}
+
+// brokerConn is a single TCP connection, redialed after any error. Requests are serialized.
+type brokerConn struct {
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// do runs fn on a live connection under the lock; the connection is dropped if fn fails.
+// handshake runs once after each dial.
+func (b *brokerConn) do(ctx context.Context, handshake, fn func(net.Conn, *bufio.Reader) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		d := net.Dialer{Timeout: b.timeout}
+		conn, err := d.DialContext(ctx, "tcp", b.addr)
+		if err != nil {
+			return err
+		}
+		b.conn, b.rd = conn, bufio.NewReader(conn)
+		if handshake != nil {
+			_ = conn.SetDeadline(time.Now().Add(b.timeout))
+			if err := handshake(b.conn, b.rd); err != nil {
+				b.closeLocked()
+				return fmt.Errorf("handshake: %w", err)
+			}
+		}
+	}
+	deadline := time.Now().Add(b.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = b.conn.SetDeadline(deadline)
+	if err := fn(b.conn, b.rd); err != nil {
+		b.closeLocked()
+		return err
+	}
+	return nil
+}
+
+func (b *brokerConn) closeLocked() {
+	if b.conn != nil {
+		_ = b.conn.Close()
+		b.conn, b.rd = nil, nil
+	}
+}
+
+func (b *brokerConn) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closeLocked()
+	return nil
+}
+
+// --- NATS (core protocol: INFO/CONNECT handshake, PUB, PING/PONG) ---
+
+type natsPublisher struct {
+	conn    *brokerConn
+	subject string
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, d Discovery) error {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return p.conn.do(ctx, natsHandshake, func(c net.Conn, rd *bufio.Reader) error {
+		// PUB is followed by PING so a server -ERR is seen before the PONG
+		msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", p.subject, len(payload), payload)
+		if _, err := c.Write([]byte(msg)); err != nil {
+			return err
+		}
+		return natsAwaitPong(c, rd)
+	})
+}
+
+func (p *natsPublisher) Close() error { return p.conn.Close() }
+
+func natsHandshake(c net.Conn, rd *bufio.Reader) error {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if _, err := c.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"name":"domain_search_service"}` + "\r\nPING\r\n")); err != nil {
+		return err
+	}
+	return natsAwaitPong(c, rd)
+}
+
+// natsAwaitPong reads until PONG, answering server PINGs and failing on -ERR.
+func natsAwaitPong(c net.Conn, rd *bufio.Reader) error {
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := c.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + line)
+		}
+		// INFO updates and +OK are ignored
+	}
+}
+
+// --- Redis (XADD <stream> * data <json>) ---
+
+type redisPublisher struct {
+	conn   *brokerConn
+	stream string
+}
+
+func (p *redisPublisher) Publish(ctx context.Context, d Discovery) error {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return p.conn.do(ctx, nil, func(c net.Conn, rd *bufio.Reader) error {
+		if _, err := c.Write(respCommand("XADD", p.stream, "*", "data", string(payload))); err != nil {
+			return err
+		}
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "-"):
+			return errors.New("redis: " + line[1:])
+		case strings.HasPrefix(line, "$") && line != "$-1":
+			// bulk string reply (the entry id): consume its payload line
+			_, err = rd.ReadString('\n')
+			return err
+		}
+		return nil
+	})
+}
+
+func (p *redisPublisher) Close() error { return p.conn.Close() }
+
+// respCommand encodes a command as a RESP array of bulk strings.
+func respCommand(args ...string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return []byte(b.String())
+}
+
+// publishDiscovery sends d, logging failures (discovery publishing is best effort).
+func publishDiscovery(ctx context.Context, pub Publisher, d Discovery) {
+	if err := pub.Publish(ctx, d); err != nil {
+		log.Printf("publish %s error: %v", d.Domain, err)
+	}
+}
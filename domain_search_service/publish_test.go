@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateSubject(t *testing.T) {
+	tests := []struct {
+		backend, subject string
+		ok               bool
+	}{
+		{"nats", "gose.domains", true},
+		{"nats", "gose.domains.>", true},
+		{"nats", "gose domains", false},
+		{"nats", "gose.domains\r\n", false},
+		{"nats", "gose\tdomains", false},
+		{"nats", "gose\x00domains", false},
+		{"nats", "gose domains", false},
+		{"redis", "gose:domains", true},
+		{"redis", "gose domains", true},
+		{"redis", "gose:domains\r\n", false},
+		{"redis", "gose\ndomains", false},
+	}
+	for _, tt := range tests {
+		err := validateSubject(tt.backend, tt.subject)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateSubject(%q, %q) = %v, want ok=%v", tt.backend, tt.subject, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateOutputRejectsBadSubject(t *testing.T) {
+	o := OutputConfig{Mode: "publish", Publish: PublishConfig{Backend: "nats", Addr: "127.0.0.1:4222", Subject: "a b"}}
+	if err := validateOutput(o); err == nil {
+		t.Fatal("expected an error for a NATS subject with a space")
+	}
+}
+
+func TestRespCommand(t *testing.T) {
+	got := string(respCommand("XADD", "gose:domains", "*", "data", `{"a":"é"}`))
+	want := "*5\r\n$4\r\nXADD\r\n$12\r\ngose:domains\r\n$1\r\n*\r\n$4\r\ndata\r\n$10\r\n{\"a\":\"é\"}\r\n"
+	if got != want {
+		t.Errorf("respCommand = %q, want %q", got, want)
+	}
+}
+
+// fakeBroker accepts connections on a local port and runs serve for each.
+func fakeBroker(t *testing.T, serve func(c net.Conn, rd *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c, bufio.NewReader(c))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestNATSPublisher(t *testing.T) {
+	msgs := make(chan string, 4)
+	addr := fakeBroker(t, func(c net.Conn, rd *bufio.Reader) {
+		_, _ = io.WriteString(c, "INFO {}\r\n")
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				_, _ = io.WriteString(c, "PONG\r\n")
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := rd.ReadString('\n')
+				if strings.Contains(payload, "reject.test") {
+					_, _ = io.WriteString(c, "-ERR 'Permissions Violation'\r\n")
+					continue
+				}
+				msgs <- strings.TrimSpace(line) + " " + strings.TrimSpace(payload)
+			}
+		}
+	})
+	pub, err := newPublisher(PublishConfig{Backend: "nats", Addr: addr, Subject: "gose.domains"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	ctx := context.Background()
+	d := Discovery{Domain: "ok.test", Scheme: "https", Status: 200, URL: "https://ok.test/"}
+	if err := pub.Publish(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	got := <-msgs
+	if !strings.HasPrefix(got, "PUB gose.domains ") || !strings.Contains(got, `"domain":"ok.test"`) || !strings.Contains(got, `"status":200`) {
+		t.Errorf("published %q", got)
+	}
+	if err := pub.Publish(ctx, Discovery{Domain: "reject.test"}); err == nil || !strings.Contains(err.Error(), "Permissions") {
+		t.Errorf("server -ERR not reported: %v", err)
+	}
+	// the failed connection is dropped; the next publish redials and handshakes again
+	if err := pub.Publish(ctx, d); err != nil {
+		t.Fatalf("publish after error: %v", err)
+	}
+	<-msgs
+}
+
+func TestRedisPublisher(t *testing.T) {
+	cmds := make(chan []string, 4)
+	addr := fakeBroker(t, func(c net.Conn, rd *bufio.Reader) {
+		for {
+			head, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var n int
+			fmt.Sscanf(head, "*%d", &n)
+			args := make([]string, n)
+			for i := range args {
+				_, _ = rd.ReadString('\n') // $len
+				v, _ := rd.ReadString('\n')
+				args[i] = strings.TrimSuffix(v, "\r\n")
+			}
+			if strings.Contains(args[len(args)-1], "reject.test") {
+				_, _ = io.WriteString(c, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+				continue
+			}
+			cmds <- args
+			_, _ = io.WriteString(c, "$15\r\n1700000000000-0\r\n")
+		}
+	})
+	pub, err := newPublisher(PublishConfig{Backend: "redis", Addr: addr, Subject: "gose:domains"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ { // the bulk reply is fully consumed between commands
+		if err := pub.Publish(ctx, Discovery{Domain: "ok.test", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+		args := <-cmds
+		if len(args) != 5 || args[0] != "XADD" || args[1] != "gose:domains" || args[3] != "data" || !strings.Contains(args[4], `"domain":"ok.test"`) {
+			t.Errorf("command = %q", args)
+		}
+	}
+	if err := pub.Publish(ctx, Discovery{Domain: "reject.test"}); err == nil || !strings.HasPrefix(err.Error(), "redis: WRONGTYPE") {
+		t.Errorf("error reply not reported: %v", err)
+	}
+}
+
+func TestNewPublisherUnknownBackend(t *testing.T) {
+	if _, err := newPublisher(PublishConfig{Backend: "kafka"}); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+// memPublisher collects discoveries in memory.
+type memPublisher struct {
+	mu  sync.Mutex
+	got []Discovery
+}
+
+func (m *memPublisher) Publish(_ context.Context, d Discovery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.got = append(m.got, d)
+	return nil
+}
+
+func (m *memPublisher) Close() error { return nil }
+
+// okTransport answers 200 for every host without touching the network.
+type okTransport struct{}
+
+func (okTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok")), Request: r}, nil
+}
+
+// In publish mode every working domain reaches the publisher as a structured message.
+func TestRunOncePublishes(t *testing.T) {
+	db := testDB(t)
+	t.Cleanup(func() {
+		_, _ = db.Exec(context.Background(), `DELETE FROM domain_search_tld_stats WHERE tld IN ('.pubtest', 'pubtest')`)
+	})
+	cfg := Config{
+		Generator: GeneratorConfig{TLDs: []string{".pubtest"}, MinLength: 1, MaxLength: 1, Alphabet: "ab"},
+		Limits:    LimitsConfig{Concurrency: 2, RatePerSecond: 1000},
+		HTTPCheck: HTTPCheckConfig{TryHTTPSFirst: true, AcceptStatusMin: 200, AcceptStatusMax: 399},
+		Output:    OutputConfig{Mode: "publish"},
+	}
+	pub := &memPublisher{}
+	if _, err := runOnce(context.Background(), db, &http.Client{Transport: okTransport{}}, pub, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var domains []string
+	for _, d := range pub.got {
+		if d.Scheme != "https" || d.Status != 200 || d.URL != "https://"+d.Domain+"/" {
+			t.Errorf("discovery = %+v", d)
+		}
+		domains = append(domains, d.Domain)
+	}
+	slices.Sort(domains)
+	if want := []string{"a.pubtest", "b.pubtest"}; !slices.Equal(domains, want) {
+		t.Errorf("published %v, want %v", domains, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCompletionReason(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	genErr := errors.New("generator.start: bad")
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		genErr error
+		hitMax bool
+		want   passReason
+	}{
+		{name: "exhausted", ctx: context.Background(), want: passExhausted},
+		{name: "max candidates", ctx: context.Background(), hitMax: true, want: passMaxCandidates},
+		{name: "generator error", ctx: context.Background(), genErr: genErr, want: passError},
+		{name: "cancelled", ctx: cancelled, want: passCancelled},
+		{name: "cancel wins over max", ctx: cancelled, hitMax: true, want: passCancelled},
+		{name: "cancel wins over error", ctx: cancelled, genErr: genErr, want: passCancelled},
+	}
+	for _, tt := range tests {
+		if got := completionReason(tt.ctx, tt.genErr, tt.hitMax); got != tt.want {
+			t.Errorf("%s: completionReason = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// The drain context outlives a cancelled parent by the drain time, then ends.
+func TestDrainContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dctx, stop := drainContext(ctx, 50*time.Millisecond)
+	defer stop()
+	cancel()
+	select {
+	case <-dctx.Done():
+		t.Fatal("drain context cancelled together with its parent")
+	case <-time.After(20 * time.Millisecond):
+	}
+	select {
+	case <-dctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("drain context not cancelled after the drain time")
+	}
+
+	dctx, stop = drainContext(context.Background(), time.Hour)
+	stop()
+	if dctx.Err() == nil {
+		t.Error("stop did not cancel the drain context")
+	}
+}
+
+// failTransport fails every check without touching the network.
+type failTransport struct{}
+
+func (failTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("offline")
+}
+
+// runOnce reports each way a pass can end.
+func TestRunOnceReasons(t *testing.T) {
+	db := testDB(t)
+	t.Cleanup(func() {
+		_, _ = db.Exec(context.Background(), `DELETE FROM domain_search_tld_stats WHERE tld IN ('.runonce', 'runonce')`)
+	})
+	base := Config{
+		Generator: GeneratorConfig{TLDs: []string{".runonce"}, MinLength: 1, MaxLength: 1, Alphabet: "abc"},
+		Limits:    LimitsConfig{Concurrency: 2, RatePerSecond: 1000},
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		edit       func(*Config)
+		want       passReason
+		wantErr    bool
+		candidates int // -1 = any
+	}{
+		{name: "exhausted", ctx: context.Background(), want: passExhausted, candidates: 3},
+		{name: "max candidates", ctx: context.Background(), edit: func(c *Config) { c.Limits.MaxCandidates = 2 },
+			want: passMaxCandidates, candidates: 2},
+		{name: "cancelled", ctx: cancelled, want: passCancelled, candidates: -1},
+		{name: "generator error", ctx: context.Background(), edit: func(c *Config) { c.Generator.Start = "!" },
+			want: passError, wantErr: true},
+	}
+	client := &http.Client{Transport: failTransport{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.edit != nil {
+				tt.edit(&cfg)
+			}
+			res, err := runOnce(tt.ctx, db, client, nil, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res.Reason != tt.want || tt.candidates >= 0 && res.Candidates != tt.candidates {
+				t.Errorf("result = %+v, want reason %s with %d candidates", res, tt.want, tt.candidates)
+			}
+		})
+	}
+}
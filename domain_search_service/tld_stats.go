@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Per-TLD hit rates: candidates checked and working domains found, counted by the workers.
+// Counters of the current pass are reported in the pass summary and on the metrics endpoint;
+// cumulative totals are kept in domain_search_tld_stats.
+
+type tldCounter struct {
+	checked atomic.Int64
+	working atomic.Int64
+	// not yet flushed to the DB
+	pendingChecked atomic.Int64
+	pendingWorking atomic.Int64
+}
+
+// tldStats is created per pass with one counter per configured TLD; the map is read-only
+// afterwards, so workers update counters without locking.
+type tldStats struct {
+	startedAt time.Time
+	counters  map[string]*tldCounter
+}
+
+type TLDStat struct {
+	TLD     string  `json:"tld"`
+	Checked int64   `json:"checked"`
+	Working int64   `json:"working"`
+	HitRate float64 `json:"hit_rate"` // percent
+}
+
+// currentTLDStats points at the running pass (nil before the first pass).
+var currentTLDStats atomic.Pointer[tldStats]
+
+func newTLDStats(tlds []string) *tldStats {
+	s := &tldStats{startedAt: time.Now(), counters: make(map[string]*tldCounter, len(tlds))}
+	for _, t := range tlds {
+		s.counters[t] = &tldCounter{}
+	}
+	return s
+}
+
+// domainTLD returns the TLD a generated candidate was built with (labels never contain dots).
+func domainTLD(domain string) string {
+	if i := strings.IndexByte(domain, '.'); i >= 0 {
+		return domain[i:]
+	}
+	return ""
+}
+
+// record counts one checked candidate.
+func (s *tldStats) record(domain string, working bool) {
+	c := s.counters[domainTLD(domain)]
+	if c == nil {
+		return
+	}
+	c.checked.Add(1)
+	c.pendingChecked.Add(1)
+	if working {
+		c.working.Add(1)
+		c.pendingWorking.Add(1)
+	}
+}
+
+// snapshot returns per-TLD counters sorted by TLD.
+func (s *tldStats) snapshot() []TLDStat {
+	out := make([]TLDStat, 0, len(s.counters))
+	for tld, c := range s.counters {
+		st := TLDStat{TLD: tld, Checked: c.checked.Load(), Working: c.working.Load()}
+		if st.Checked > 0 {
+			st.HitRate = float64(st.Working) * 100 / float64(st.Checked)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TLD < out[j].TLD })
+	return out
+}
+
+// logSummary prints the per-TLD hit rates of the pass.
+func (s *tldStats) logSummary() {
+	for _, st := range s.snapshot() {
+		log.Printf("tld %s: checked=%d working=%d hit_rate=%.3f%%", st.TLD, st.Checked, st.Working, st.HitRate)
+	}
+}
+
+// flush adds the pending deltas to domain_search_tld_stats; failed deltas are kept for the next flush.
+func (s *tldStats) flush(ctx context.Context, db *pgxpool.Pool) {
+	const q = `
+INSERT INTO domain_search_tld_stats (tld, checked, working, updated_at)
+VALUES ($1, $2, $3, now())
+ON CONFLICT (tld) DO UPDATE
+SET checked = domain_search_tld_stats.checked + EXCLUDED.checked,
+    working = domain_search_tld_stats.working + EXCLUDED.working,
+    updated_at = now();`
+	for tld, c := range s.counters {
+		checked, working := c.pendingChecked.Swap(0), c.pendingWorking.Swap(0)
+		if checked == 0 && working == 0 {
+			continue
+		}
+		if _, err := db.Exec(ctx, q, tld, checked, working); err != nil {
+			log.Printf("tld stats flush error (%s): %v", tld, err)
+			c.pendingChecked.Add(checked)
+			c.pendingWorking.Add(working)
+		}
+	}
+}
+
+// runTLDStatsFlusher flushes s every interval until done is closed, then once more.
+func runTLDStatsFlusher(ctx context.Context, db *pgxpool.Pool, s *tldStats, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.flush(ctx, db)
+		case <-done:
+			s.flush(ctx, db)
+			return
+		}
+	}
+}
+
+// serveMetrics exposes the current pass counters as JSON on addr (GET /metrics).
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		type resp struct {
+			PassStartedAt *time.Time `json:"pass_started_at,omitempty"`
+			TLDs          []TLDStat  `json:"tlds"`
+		}
+		out := resp{TLDs: []TLDStat{}}
+		if s := currentTLDStats.Load(); s != nil {
+			out.PassStartedAt = &s.startedAt
+			out.TLDs = s.snapshot()
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	log.Printf("metrics listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("metrics server error: %v", err)
+	}
+}
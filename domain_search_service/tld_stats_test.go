@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDomainTLD(t *testing.T) {
+	tests := []struct{ domain, want string }{
+		{"abc.com", ".com"},
+		{"abc.co.uk", ".co.uk"},
+		{"a-b.xn--p1ai", ".xn--p1ai"},
+		{"nodot", ""},
+	}
+	for _, tt := range tests {
+		if got := domainTLD(tt.domain); got != tt.want {
+			t.Errorf("domainTLD(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestTLDStatsCounting(t *testing.T) {
+	s := newTLDStats([]string{".com", ".io"})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.record("a.com", i%10 == 0) // 10% working
+				s.record("b.io", i%2 == 0)   // 50% working
+				s.record("c.net", true)      // not a configured TLD: ignored
+			}
+		}()
+	}
+	wg.Wait()
+	want := []TLDStat{
+		{TLD: ".com", Checked: 800, Working: 80, HitRate: 10},
+		{TLD: ".io", Checked: 800, Working: 400, HitRate: 50},
+	}
+	got := s.snapshot()
+	if len(got) != len(want) {
+		t.Fatalf("snapshot = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("snapshot[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	// pending deltas match the totals until flushed
+	if c := s.counters[".com"]; c.pendingChecked.Load() != 800 || c.pendingWorking.Load() != 80 {
+		t.Errorf("pending .com = %d/%d", c.pendingChecked.Load(), c.pendingWorking.Load())
+	}
+}
+
+func TestTLDStatsNoChecks(t *testing.T) {
+	st := newTLDStats([]string{".org"}).snapshot()
+	if len(st) != 1 || st[0].Checked != 0 || st[0].HitRate != 0 {
+		t.Errorf("snapshot of an idle pass = %+v", st)
+	}
+}
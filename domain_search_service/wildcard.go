@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"net"
+	"sync"
+)
+
+// --- Wildcard DNS detection ---
+//
+// Some TLDs/registrars answer for every nonexistent name (usually with a parking page),
+// which would make every candidate look "working". Before a pass, a few random names that
+// can't exist are resolved per TLD; the addresses they resolve to form the TLD's wildcard
+// signature, and candidates resolving only to those addresses are not counted as working.
+
+type WildcardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Probes  int  `yaml:"probes"` // random names per TLD (default 3)
+}
+
+// wildcardSignatures caches per-TLD wildcard address sets (absent = no wildcard).
+type wildcardSignatures struct {
+	mu   sync.RWMutex
+	ips  map[string]map[string]bool // tld -> addresses
+	look func(ctx context.Context, host string) ([]string, error)
+}
+
+func newWildcardSignatures() *wildcardSignatures {
+	return &wildcardSignatures{ips: make(map[string]map[string]bool), look: net.DefaultResolver.LookupHost}
+}
+
+// detect probes random names under each TLD; a TLD is wildcarded when most probes resolve.
+func (w *wildcardSignatures) detect(ctx context.Context, tlds []string, probes int) {
+	probes = nonZero(probes, 3)
+	for _, tld := range tlds {
+		ips := make(map[string]bool)
+		resolved := 0
+		for i := 0; i < probes; i++ {
+			addrs, err := w.look(ctx, randomLabel(24)+tld)
+			if err != nil || len(addrs) == 0 {
+				continue
+			}
+			resolved++
+			for _, a := range addrs {
+				ips[a] = true
+			}
+		}
+		if resolved*2 > probes {
+			w.mu.Lock()
+			w.ips[tld] = ips
+			w.mu.Unlock()
+			log.Printf("wildcard DNS detected for %s (%d addresses), matching candidates are ignored", tld, len(ips))
+		}
+	}
+}
+
+// isWildcard reports whether domain resolves only to its TLD's wildcard addresses.
+func (w *wildcardSignatures) isWildcard(ctx context.Context, domain string) bool {
+	w.mu.RLock()
+	sig := w.ips[domainTLD(domain)]
+	w.mu.RUnlock()
+	if sig == nil {
+		return false
+	}
+	addrs, err := w.look(ctx, domain)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, a := range addrs {
+		if !sig[a] {
+			return false
+		}
+	}
+	return true
+}
+
+// randomLabel returns a lowercase alphanumeric label that is practically guaranteed not to be registered.
+func randomLabel(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = letters[int(b[i])%len(letters)]
+	}
+	// start with a letter
+	b[0] = letters[int(b[0])%26]
+	return string(b)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeResolver simulates DNS: wildcarded TLDs answer every name with the parking address,
+// names in real resolve to their own addresses, anything else is NXDOMAIN.
+func fakeResolver(wildcardTLDs map[string]string, real map[string][]string) func(context.Context, string) ([]string, error) {
+	return func(_ context.Context, host string) ([]string, error) {
+		if addrs, ok := real[host]; ok {
+			return addrs, nil
+		}
+		if ip, ok := wildcardTLDs[domainTLD(host)]; ok {
+			return []string{ip}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+func TestWildcardSignatures(t *testing.T) {
+	w := newWildcardSignatures()
+	w.look = fakeResolver(
+		map[string]string{".parked": "203.0.113.7"},
+		map[string][]string{
+			"real.parked":    {"198.51.100.1"},
+			"mixed.parked":   {"203.0.113.7", "198.51.100.2"},
+			"example.com":    {"93.184.216.34"},
+			"landing.parked": {"203.0.113.7"},
+		},
+	)
+	w.detect(context.Background(), []string{".parked", ".com"}, 3)
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"nonexistent.parked", true},
+		{"landing.parked", true},
+		{"real.parked", false},
+		{"mixed.parked", false},
+		{"example.com", false},
+		{"nonexistent.com", false},
+	}
+	for _, tt := range tests {
+		if got := w.isWildcard(context.Background(), tt.domain); got != tt.want {
+			t.Errorf("isWildcard(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
+
+// A TLD where only a minority of the random probes resolve is not treated as wildcarded.
+func TestWildcardDetectMajority(t *testing.T) {
+	w := newWildcardSignatures()
+	n := 0
+	w.look = func(_ context.Context, host string) ([]string, error) {
+		n++
+		if n == 1 {
+			return []string{"192.0.2.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	w.detect(context.Background(), []string{".flaky"}, 3)
+	if w.ips[".flaky"] != nil {
+		t.Error("one resolving probe out of three marked the TLD as wildcarded")
+	}
+}
+
+func TestRandomLabel(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		l := randomLabel(24)
+		if len(l) != 24 || l[0] < 'a' || l[0] > 'z' || strings.Trim(l, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			t.Fatalf("randomLabel = %q", l)
+		}
+		seen[l] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("randomLabel repeated: %d distinct of 100", len(seen))
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // Типы запросов/ответов API
 
 type EnqueueRequest struct {
@@ -13,4 +15,133 @@ type EnqueueResponse struct {
 	URL      string `json:"url"`
 	URLHash  string `json:"url_hash"`
 	Message  string `json:"message,omitempty"`
+	// Attempts/MaxAttempts of the queued item (the existing one for a duplicate).
+	Attempts    int `json:"attempts"`
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// EnqueueBatchResult is the outcome of one item of POST /api/enqueue/batch: status is
+// enqueued, duplicate or invalid; URL is canonicalized for valid items.
+type EnqueueBatchResult struct {
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	SiteID  int64  `json:"site_id,omitempty"`
+	URLHash string `json:"url_hash,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type EnqueueBatchResponse struct {
+	Total     int                  `json:"total"`
+	Enqueued  int                  `json:"enqueued"`
+	Duplicate int                  `json:"duplicate"`
+	Invalid   int                  `json:"invalid"`
+	Results   []EnqueueBatchResult `json:"results"`
+}
+
+type CrawlNowRequest struct {
+	URL string `json:"url"`
+}
+
+// CrawlNowResponse carries either the stored page, a skip reason or a fetch/store error.
+type CrawlNowResponse struct {
+	SiteID  int64     `json:"site_id"`
+	URL     string    `json:"url"`
+	URLHash string    `json:"url_hash"`
+	Page    *PageInfo `json:"page,omitempty"`
+	Skipped string    `json:"skipped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// PageInfo is page metadata returned by the pages API (no HTML/text bodies).
+type PageInfo struct {
+	ID          int64             `json:"id"`
+	SiteID      int64             `json:"site_id"`
+	URL         string            `json:"url"`
+	URLHash     string            `json:"url_hash"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	HTTPStatus  int               `json:"http_status"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	RenderPath  string            `json:"render_path,omitempty"`
+	FetchedAt   *time.Time        `json:"fetched_at,omitempty"` // last fetch
+	FirstSeenAt time.Time         `json:"first_seen_at"`        // created_at, kept across recrawls
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+type PagesResponse struct {
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+	Pages  []PageInfo `json:"pages"`
+}
+
+// LinkInfo is one edge of the link graph; PageID/Title/HTTPStatus are set when the linked page is stored.
+type LinkInfo struct {
+	URL        string `json:"url"`
+	URLHash    string `json:"url_hash"`
+	AnchorText string `json:"anchor_text,omitempty"`
+	PageID     *int64 `json:"page_id,omitempty"`
+	Title      string `json:"title,omitempty"`
+	HTTPStatus *int   `json:"http_status,omitempty"`
+}
+
+type LinksResponse struct {
+	URL       string     `json:"url"`
+	URLHash   string     `json:"url_hash"`
+	Direction string     `json:"direction"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+	Links     []LinkInfo `json:"links"`
+}
+
+// QueueItemInfo is one crawl_queue item with its retry budget (crawler.max_attempts).
+type QueueItemInfo struct {
+	ID          int64      `json:"id"`
+	SiteID      int64      `json:"site_id"`
+	URL         string     `json:"url"`
+	URLHash     string     `json:"url_hash"`
+	Status      string     `json:"status"`
+	Priority    int        `json:"priority"`
+	Depth       int        `json:"depth"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	Remaining   int        `json:"remaining"` // attempts left before the item stays in error
+	LastError   string     `json:"last_error,omitempty"`
+	ErrorClass  string     `json:"error_class,omitempty"` // dns, timeout, conn_refused, tls, http_status, body, ...
+	NextTryAt   *time.Time `json:"next_try_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// WaitSeconds is enqueue -> first claim (crawler.record_queue_wait); nil until claimed.
+	WaitSeconds *float64 `json:"wait_seconds,omitempty"`
+}
+
+// QueueStatsResponse counts queue items per status and lists those closest to exhausting their attempts.
+type QueueStatsResponse struct {
+	ByStatus       map[string]int  `json:"by_status"`
+	ErrorClasses   map[string]int  `json:"error_classes"` // items in error per crawl_queue.error_class
+	MaxAttempts    int             `json:"max_attempts"`
+	NearExhaustion []QueueItemInfo `json:"near_exhaustion"`
+	Wait           QueueWaitStats  `json:"wait"`
+}
+
+// QueueWaitStats summarizes enqueue -> first claim over items claimed in the window.
+type QueueWaitStats struct {
+	Window     string   `json:"window"`
+	Items      int      `json:"items"`
+	P50Seconds *float64 `json:"p50_seconds"`
+	P95Seconds *float64 `json:"p95_seconds"`
+}
+
+type HostLimitRequest struct {
+	Host  string  `json:"host"`
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// ProxyHealthResponse is returned by POST /api/proxies/healthcheck.
+type ProxyHealthResponse struct {
+	Total   int                `json:"total"`
+	Healthy int                `json:"healthy"`
+	Results []ProxyCheckResult `json:"results"`
 }
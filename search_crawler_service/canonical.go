@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// --- <link rel="canonical"> (crawler.canonical_dedupe) ---
+//
+// The resolved canonical URL of a page is stored in pages.canonical_url. With
+// canonical_dedupe, a page whose canonical is another in-scope URL (?page=1 vs the bare
+// URL, tracking variants) is an alias: the canonical is enqueued and the alias is not
+// stored. A canonical pointing at the page itself, or out of the crawl scope, changes nothing.
+
+// addCanonical records the first <link rel="canonical" href>.
+func (p *parsedPage) addCanonical(rel, href string) {
+	href = strings.TrimSpace(href)
+	if p.Canonical == "" && href != "" && hasToken(rel, "canonical") {
+		p.Canonical = href
+	}
+}
+
+// resolvePageHref resolves href found on pageURL (against baseHref when it is a valid
+// http(s) URL) and canonicalizes it like an enqueued link; "" when it is not http(s).
+func resolvePageHref(c CrawlerConfig, pageURL, baseHref, href string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	if baseHref != "" {
+		if b, err := base.Parse(baseHref); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+			base = b
+		}
+	}
+	u, err := base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	canonicalizeURL(u, c)
+	return u.String()
+}
+
+// urlInCrawlScope reports whether the absolute rawURL belongs to the site's crawl scope.
+func urlInCrawlScope(c CrawlerConfig, rawURL, siteDomain string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && inCrawlScope(u.Host, siteDomain, c.CrawlScope)
+}
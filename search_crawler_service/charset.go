@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// --- Charset ---
+//
+// Bodies are transcoded to UTF-8 before extraction. The encoding comes from a BOM, the
+// Content-Type charset parameter or a <meta charset> / <meta http-equiv> in the first
+// 1024 bytes, in that order; unknown or unsupported labels mean UTF-8.
+
+const charsetSniffLen = 1024
+
+var reMetaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.\-]+)`)
+
+// utf8Body wraps body so it yields UTF-8 and returns the detected charset name
+// ("utf-8" when nothing else was declared).
+func utf8Body(body io.Reader, contentType string) (io.Reader, string) {
+	br := bufio.NewReaderSize(body, charsetSniffLen)
+	// a short or failing read is fine here: the error resurfaces on the next Read
+	peek, _ := br.Peek(charsetSniffLen)
+	name := detectCharset(peek, contentType)
+	if name == "utf-8" {
+		return br, name
+	}
+	enc, canonical := charset.Lookup(name)
+	if enc == nil {
+		return br, "utf-8"
+	}
+	return transform.NewReader(br, enc.NewDecoder()), canonical
+}
+
+// detectCharset returns the lower-cased charset label declared for a body starting with head.
+func detectCharset(head []byte, contentType string) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xef\xbb\xbf")):
+		return "utf-8"
+	case bytes.HasPrefix(head, []byte("\xfe\xff")):
+		return "utf-16be"
+	case bytes.HasPrefix(head, []byte("\xff\xfe")):
+		return "utf-16le"
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if cs := strings.ToLower(strings.TrimSpace(params["charset"])); cs != "" {
+			if _, name := charset.Lookup(cs); name != "" {
+				return name
+			}
+		}
+	}
+	if m := reMetaCharset.FindSubmatch(head); m != nil {
+		if _, name := charset.Lookup(string(m[1])); name != "" {
+			return name
+		}
+	}
+	return "utf-8"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// privet is "Привет, мир" (hello, world).
+const privet = "Привет, мир"
+
+// cp1251 encodes s as Windows-1251.
+func cp1251(t *testing.T, s string) string {
+	t.Helper()
+	b, err := charmap.Windows1251.NewEncoder().String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name, head, contentType, want string
+	}{
+		{"nothing declared", "<html>", "text/html", "utf-8"},
+		{"header", "<html>", "text/html; charset=windows-1251", "windows-1251"},
+		{"header alias", "<html>", "text/html; charset=CP1251", "windows-1251"},
+		{"latin-1 label", "<html>", `text/html; charset="ISO-8859-1"`, "windows-1252"},
+		{"meta charset", `<html><head><meta charset="koi8-r">`, "text/html", "koi8-r"},
+		{"meta http-equiv", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">`, "", "windows-1251"},
+		{"header beats meta", `<meta charset="koi8-r">`, "text/html; charset=windows-1251", "windows-1251"},
+		{"unknown header falls to meta", `<meta charset="koi8-r">`, "text/html; charset=bogus", "koi8-r"},
+		{"unknown label", `<meta charset="bogus">`, "text/html", "utf-8"},
+		{"utf-8 bom beats header", "\xef\xbb\xbf<html>", "text/html; charset=windows-1251", "utf-8"},
+		{"utf-16le bom", "\xff\xfe<\x00", "text/html", "utf-16le"},
+		{"malformed content type", `<meta charset="koi8-r">`, "text/html; charset", "koi8-r"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectCharset([]byte(tt.head), tt.contentType); got != tt.want {
+				t.Errorf("detectCharset = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUTF8Body(t *testing.T) {
+	tests := []struct {
+		name, body, contentType string
+		wantCharset, wantText   string
+	}{
+		{name: "utf-8", body: "<p>" + privet + "</p>", contentType: "text/html; charset=utf-8", wantCharset: "utf-8", wantText: privet},
+		{name: "windows-1251 header", body: "<p>" + cp1251(t, privet) + "</p>", contentType: "text/html; charset=windows-1251", wantCharset: "windows-1251", wantText: privet},
+		{name: "windows-1251 meta", body: `<meta charset="windows-1251"><p>` + cp1251(t, privet) + "</p>", contentType: "text/html", wantCharset: "windows-1251", wantText: privet},
+		{name: "meta beyond sniff window", body: strings.Repeat(" ", charsetSniffLen) + `<meta charset="windows-1251">`, contentType: "text/html", wantCharset: "utf-8"},
+		{name: "short body", body: "hi", contentType: "text/html; charset=koi8-r", wantCharset: "koi8-r", wantText: "hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, name := utf8Body(strings.NewReader(tt.body), tt.contentType)
+			if name != tt.wantCharset {
+				t.Errorf("charset = %q, want %q", name, tt.wantCharset)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.wantText) {
+				t.Errorf("body = %q, want it to contain %q", b, tt.wantText)
+			}
+		})
+	}
+}
+
+// A Windows-1251 page is stored as UTF-8 and its title and text extract cleanly.
+func TestFetchHTMLWindows1251(t *testing.T) {
+	page := cp1251(t, "<html><head><title>"+privet+"</title></head><body><p>"+privet+"</p></body></html>")
+	for _, stream := range []bool{false, true} {
+		res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+			_, _ = io.WriteString(w, page)
+		}, fetchOptions{StreamParse: stream})
+		if err != nil {
+			t.Fatalf("stream=%v: %v", stream, err)
+		}
+		if res.Charset != "windows-1251" {
+			t.Errorf("stream=%v: Charset = %q", stream, res.Charset)
+		}
+		if !strings.Contains(res.HTML, privet) {
+			t.Errorf("stream=%v: HTML not transcoded: %q", stream, res.HTML)
+		}
+		p := parsePage(res.HTML)
+		if res.Parsed != nil {
+			p = *res.Parsed
+		}
+		if p.Title != privet || !strings.Contains(p.Text, privet) {
+			t.Errorf("stream=%v: title %q text %q, want %q", stream, p.Title, p.Text, privet)
+		}
+	}
+}
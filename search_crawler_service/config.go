@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -28,6 +30,9 @@ type Config struct {
 	Robots   RobotsConfig   `yaml:"robots"`
 	Sitemap  SitemapConfig  `yaml:"sitemap"`
 	Proxies  ProxiesRef     `yaml:"proxies"`
+
+	HTMLStorage HTMLStorageConfig `yaml:"html_storage"`
+	Auth        AuthConf          `yaml:"auth"`
 }
 
 type PostgresConfig struct {
@@ -41,15 +46,279 @@ type HTTPConfig struct {
 type CrawlerConfig struct {
 	WhitelistDomains []string `yaml:"whitelist_domains"`
 	SeedURLs         []string `yaml:"seed_urls"`
-	DepthLimit       int      `yaml:"depth_limit"`
+	DepthLimit       int      `yaml:"depth_limit"` // max link hops from an enqueued URL (crawl_queue.depth); 0 = unlimited
 	RPSPerHost       int      `yaml:"rps_per_host"`
 	RPSBurst         int      `yaml:"rps_burst"`
-	Workers          int      `yaml:"workers"` // 0 or missing -> default: min(runtime.NumCPU()*4, 64)
-	HTMLFetchTimeout Duration `yaml:"html_fetch_timeout"`
-	HTMLMaxSize      ByteSize `yaml:"html_max_size"`
+	// MaxPagesPerSite stops link discovery for a site once its stored plus pending pages reach
+	// it (sites.max_pages overrides per site); 0 = unlimited. See site_cap.go.
+	MaxPagesPerSite int `yaml:"max_pages_per_site"`
+	// Politeness is the default preset (gentle|normal|aggressive or a politeness_presets key);
+	// empty keeps rps_per_host/rps_burst. See politeness.go for the resolution order.
+	Politeness        string                       `yaml:"politeness"`
+	PolitenessPresets map[string]PolitenessProfile `yaml:"politeness_presets"`
+	SitePoliteness    map[string]SitePoliteness    `yaml:"site_politeness"`
+	Workers           int                          `yaml:"workers"` // 0 or missing -> default: min(runtime.NumCPU()*4, 64)
+	// MaxClaimConcurrency caps how many workers may run the claim transaction at once (0 = unlimited).
+	MaxClaimConcurrency int      `yaml:"max_claim_concurrency"`
+	HTMLFetchTimeout    Duration `yaml:"html_fetch_timeout"`
+	HTMLMaxSize         ByteSize `yaml:"html_max_size"`
+	// MaxBytesPerSecond caps total page download throughput across workers (0 = unlimited).
+	MaxBytesPerSecond ByteSize `yaml:"max_bytes_per_second"`
+	// DecompressMargin: decoded gzip/deflate/br bodies are stored cut at html_max_size like plain
+	// ones; those decoding past html_max_size + margin abort the fetch (0 = never abort).
+	DecompressMargin ByteSize `yaml:"decompress_margin"`
 	UserAgent        string   `yaml:"user_agent"`
-	ContentTypes     []string `yaml:"content_types"`
-	Languages        []string `yaml:"languages"`
+	// Headers are extra request headers for page fetches (e.g. Accept-Language, Accept);
+	// user_agent, if set, overrides a User-Agent entry.
+	Headers map[string]string `yaml:"headers"`
+	// ContentTypes are prefixes, globs ("text/*") or "+xml"-style suffixes (isAllowedContentType).
+	ContentTypes []string `yaml:"content_types"`
+	Languages    []string `yaml:"languages"`
+	// MainContent indexes only the page's main content block (readability-style, see
+	// main_content.go), dropping navigation/footer boilerplate; pages without a clear main
+	// region keep their whole visible text. Stream parsing then also keeps the raw body.
+	MainContent bool `yaml:"main_content"`
+	// Soft404 skips 200 responses that look like "not found" pages (see soft404.go).
+	Soft404 Soft404Config `yaml:"soft_404"`
+	// MetaRefresh follows short <meta http-equiv="refresh"> redirects (see meta_refresh.go).
+	MetaRefresh MetaRefreshConfig `yaml:"meta_refresh"`
+	// CanonicalDedupe skips pages whose <link rel="canonical"> names another in-scope URL
+	// and enqueues that URL instead (see canonical.go).
+	CanonicalDedupe bool `yaml:"canonical_dedupe"`
+	// DetectLanguage sets pages.lang from the visible text's script for pages without an
+	// hreflang of their own (see lang_detect.go); only Languages are reported.
+	DetectLanguage bool `yaml:"detect_language"`
+	// StoreHeaders is the allowlist of response headers kept in pages.headers.
+	// Missing -> defaultStoredHeaders; an explicit empty list disables header storage.
+	StoreHeaders []string `yaml:"store_headers"`
+	// MetaTags lists <meta> names/properties (e.g. keywords, author, twitter:description) kept
+	// in pages.meta; empty = none.
+	MetaTags []string `yaml:"meta_tags"`
+	// DescriptionMeta is the order of meta names pages.description is taken from
+	// (default: description, og:description).
+	DescriptionMeta []string `yaml:"description_meta"`
+	// Declared Content-Length bounds; responses outside are skipped without reading the body (0 = unbounded).
+	MinContentLength ByteSize `yaml:"min_content_length"`
+	MaxContentLength ByteSize `yaml:"max_content_length"`
+	// Response statuses treated as success (default 200..399).
+	AcceptStatusMin int `yaml:"accept_status_min"`
+	AcceptStatusMax int `yaml:"accept_status_max"`
+	// RecordRejectedStatus stores the status of out-of-range responses (no content) instead of erroring the item.
+	RecordRejectedStatus bool `yaml:"record_rejected_status"`
+	// UnfollowedRedirect handles accepted 3xx responses the client could not follow
+	// (no Location, 304 without a conditional request): "skip" (default) or "error" (retried).
+	UnfollowedRedirect string `yaml:"unfollowed_redirect"`
+	// MaxRedirects bounds a followed redirect chain (default 10); a chain revisiting a URL is
+	// aborted right away. Both fail the item without retry.
+	MaxRedirects int `yaml:"max_redirects"`
+	// RecordRedirects stores the redirect hops of a fetch as page_links of the page,
+	// at most MaxRedirectLinks (default MaxRedirects) per fetch.
+	RecordRedirects  bool `yaml:"record_redirects"`
+	MaxRedirectLinks int  `yaml:"max_redirect_links"`
+	// StreamParse extracts title/description/links/text with a tokenizer while the body is read.
+	StreamParse bool `yaml:"stream_parse"`
+	// DiscardHTML skips storing raw HTML in pages.html (text and metadata are still stored).
+	DiscardHTML bool `yaml:"discard_html"`
+	// MaxConcurrentFetches caps in-flight HTTP fetches across all workers (0 = unlimited).
+	MaxConcurrentFetches int `yaml:"max_concurrent_fetches"`
+	// MaxConcurrentPerHost caps in-flight fetches to one host when its politeness profile
+	// sets no concurrency (default 4; negative = unlimited).
+	MaxConcurrentPerHost int `yaml:"max_concurrent_per_host"`
+	// AuxFetch limits background robots.txt/sitemap fetches separately from page fetches.
+	AuxFetch AuxFetchConfig `yaml:"aux_fetch"`
+	// RetryForbiddenWithNewProxy retries 401/403 responses right away through other proxies
+	// (up to ForbiddenProxyRetries, default 2) before the item is errored.
+	RetryForbiddenWithNewProxy bool `yaml:"retry_forbidden_with_new_proxy"`
+	ForbiddenProxyRetries      int  `yaml:"forbidden_proxy_retries"`
+	// BodyReadRetries re-issues a request whose body read failed midway (e.g. unexpected EOF
+	// from a flaky proxy), picking the next proxy each time (0 = fail right away).
+	BodyReadRetries int `yaml:"body_read_retries"`
+	// FetchRetries re-issues a fetch that failed with a connection/timeout error through
+	// another proxy (only with more than one proxy; HTTP error statuses are not retried).
+	FetchRetries int `yaml:"fetch_retries"`
+	// Temporary DNS failures are requeued after DNSRetryAfter (default 30s) for up to
+	// DNSRetryMaxAttempts claims (default 3); NXDOMAIN is terminal.
+	DNSRetryAfter       Duration `yaml:"dns_retry_after"`
+	DNSRetryMaxAttempts int      `yaml:"dns_retry_max_attempts"`
+	// MaxAttempts requeues items after a regular fetch error until they have been claimed
+	// this many times; then they stay in error (default 5; 1 = no retry).
+	MaxAttempts int `yaml:"max_attempts"`
+	// RecordQueueWait stamps crawl_queue.first_claimed_at, so /api/queue/stats can report the
+	// wait from enqueue to first processing.
+	RecordQueueWait bool `yaml:"record_queue_wait"`
+	// RetryBackoff grows the delay before each retry with the attempt count (see workers.go).
+	RetryBackoff RetryBackoff `yaml:"retry_backoff"`
+	// ThrottleMaxAttempts bounds the claims of an item answered with 429/503 + Retry-After,
+	// which is requeued for the time the server asked (default 10).
+	ThrottleMaxAttempts int `yaml:"throttle_max_attempts"`
+	// ErrorBackoff overrides the first retry delay of a fetch error class (dns, timeout,
+	// conn_refused, tls, http_status, body, other); see fetch_errors.go for the defaults.
+	ErrorBackoff map[string]Duration `yaml:"error_backoff"`
+	// ReclaimAfter returns 'processing' items untouched for this long to 'queued' (items of
+	// a crashed crawler; default 15m). Checked at startup and every ReclaimInterval (0 = only then).
+	ReclaimAfter    Duration `yaml:"reclaim_after"`
+	ReclaimInterval Duration `yaml:"reclaim_interval"`
+	// RenderFallback re-fetches near-empty pages through a headless browser service (opt-in).
+	RenderFallback RenderFallbackConfig `yaml:"render_fallback"`
+	// EnqueueDoneWindow suppresses re-enqueueing discovered links that finished this recently (0 = off).
+	// /api/enqueue is an explicit request and ignores it.
+	EnqueueDoneWindow Duration `yaml:"enqueue_done_window"`
+	// EnqueueBatchMax caps the items of one POST /api/enqueue/batch (default 1000).
+	EnqueueBatchMax int `yaml:"enqueue_batch_max"`
+	// LinkPriorityBoost raises the enqueue priority of links found on well-linked pages.
+	LinkPriorityBoost LinkPriorityBoostConfig `yaml:"link_priority_boost"`
+	// RecrawlHeadCheck probes known pages with HEAD and skips the GET when Content-Length and
+	// Last-Modified match the stored response (pages without those headers are always fetched).
+	RecrawlHeadCheck bool `yaml:"recrawl_head_check"`
+	// HeadPreflight probes every URL with HEAD and skips the GET when Content-Type isn't in
+	// ContentTypes or Content-Length exceeds HTMLMaxSize (see head_check.go).
+	HeadPreflight bool `yaml:"head_preflight"`
+	// ConditionalGet stores ETag/Last-Modified and recrawls known pages with If-None-Match /
+	// If-Modified-Since; a 304 only updates pages.fetched_at.
+	ConditionalGet bool `yaml:"conditional_get"`
+	// SkipUnchanged only bumps pages.fetched_at when a recrawl returns the stored body
+	// (same pages.fetch_hash, the hash before render_fallback): no text/link extraction, no page update.
+	SkipUnchanged bool `yaml:"skip_unchanged"`
+	// RecordFetchMetrics stores per-page TTFB, total fetch time and body size (pages.ttfb_ms/fetch_ms/raw_size).
+	RecordFetchMetrics bool `yaml:"record_fetch_metrics"`
+	// PreferredHreflang lists the wanted languages ("en" also matches "en-gb"). Alternates in
+	// these languages are enqueued with priority +HreflangBoost (default 10); HreflangMode
+	// "restrict" also drops the other variants and skips pages that are a non-preferred variant.
+	PreferredHreflang []string `yaml:"preferred_hreflang"`
+	HreflangMode      string   `yaml:"hreflang_mode"` // prioritize (default) | restrict
+	HreflangBoost     int      `yaml:"hreflang_boost"`
+	// Pagination caps how many pages of a ?page=N style sequence are followed (see pagination.go).
+	Pagination PaginationConfig `yaml:"pagination"`
+	// PageBatch groups page upserts of concurrent workers into one transaction (see page_batch.go).
+	PageBatch PageBatchConfig `yaml:"page_batch"`
+	// PreferHTTPS enqueues in-scope http:// links as https:// once the host is known to serve
+	// https (see https_upgrade.go); SitePreferHTTPS overrides it per site domain.
+	PreferHTTPS     bool            `yaml:"prefer_https"`
+	SitePreferHTTPS map[string]bool `yaml:"site_prefer_https"`
+	// Focus makes workers claim this site / priority band first from startup (see focus.go);
+	// /api/focus changes it at runtime.
+	Focus *CrawlFocus `yaml:"focus"`
+	// MaxSites caps the number of sites rows; new domains are refused once reached (0 = unlimited).
+	MaxSites int `yaml:"max_sites"`
+	// CrawlScope decides which discovered hosts belong to the site: host | domain (default) | registrable.
+	CrawlScope string `yaml:"crawl_scope"`
+	// AssetLinks handles discovered links whose path ends in a non-HTML extension:
+	// "skip" (don't enqueue), "deprioritize" (enqueue at AssetPriority) or "" (off).
+	AssetLinks      string   `yaml:"asset_links"`
+	AssetPriority   int      `yaml:"asset_priority"`
+	AssetExtensions []string `yaml:"asset_extensions"` // missing -> defaultAssetExtensions
+	// RobotsUATokens are the <meta name> values (besides "robots") and robots.txt User-agent
+	// names whose rules apply to us, e.g. ["gosecrawler"]. Missing -> the product token of
+	// robots.user_agent / user_agent.
+	RobotsUATokens []string `yaml:"robots_ua_tokens"`
+	// IndexAnchorText aggregates inbound link anchor text into pages.anchor_text (searchable).
+	// Anchor text is always recorded in page_links.
+	IndexAnchorText bool `yaml:"index_anchor_text"`
+	// IndexHeadings stores <h1>-<h3> texts in pages.headings; they are indexed with the
+	// highest tsvector weight, so heading matches rank above body text.
+	IndexHeadings bool `yaml:"index_headings"`
+	// HeadingTitleFallback uses the first heading as the title of pages without <title>.
+	HeadingTitleFallback bool `yaml:"heading_title_fallback"`
+	// NormalizeUnicode applies Unicode NFC to extracted title/description/text before storing,
+	// so decomposed input ("е" + U+0308) matches precomposed queries ("ё").
+	NormalizeUnicode bool `yaml:"normalize_unicode"`
+	// NormalizePercentEncoding applies RFC 3986 percent-encoding and dot-segment normalization before hashing.
+	NormalizePercentEncoding bool `yaml:"normalize_percent_encoding"`
+	// CollapseSlashes rewrites "//" runs in the path to "/" before hashing (some servers treat them distinctly).
+	CollapseSlashes bool `yaml:"collapse_slashes"`
+	// StripQueryParams are query parameters dropped before hashing (tracking ids); a trailing
+	// "*" matches by prefix ("utm_*"). Names are case-insensitive.
+	StripQueryParams []string `yaml:"strip_query_params"`
+	// SortQueryParams orders the remaining query parameters by name before hashing
+	// (?b=1&a=2 == ?a=2&b=1); values of a repeated name keep their order.
+	SortQueryParams bool `yaml:"sort_query_params"`
+}
+
+type AuxFetchConfig struct {
+	Concurrency int     `yaml:"concurrency"` // 0 = unlimited (still bounded by max_concurrent_fetches)
+	RPS         float64 `yaml:"rps"`         // 0 = unlimited
+}
+
+// LinkPriorityBoostConfig: priority = min(max, floor(weight * log2(1 + inbound links of the source page))).
+type LinkPriorityBoostConfig struct {
+	Weight float64 `yaml:"weight"` // 0 = off
+	Max    int     `yaml:"max"`    // 0 = no cap
+}
+
+// linkPriority returns the enqueue priority for links found on a page with the given inbound link count.
+func (b LinkPriorityBoostConfig) linkPriority(inbound int64) int {
+	if b.Weight <= 0 || inbound <= 0 {
+		return 0
+	}
+	p := int(b.Weight * math.Log2(1+float64(inbound)))
+	if b.Max > 0 && p > b.Max {
+		p = b.Max
+	}
+	return p
+}
+
+var defaultStoredHeaders = []string{"Server", "X-Powered-By", "Content-Language", "Last-Modified", "ETag"}
+
+func (c CrawlerConfig) storedHeaders() []string {
+	h := c.StoreHeaders
+	if h == nil {
+		h = defaultStoredHeaders
+	}
+	if c.RecrawlHeadCheck {
+		h = append(append([]string(nil), h...), headCheckHeaders...)
+	}
+	return h
+}
+
+func (c CrawlerConfig) maxRedirects() int { return nonZero(c.MaxRedirects, 10) }
+
+func (c CrawlerConfig) maxAttempts() int { return nonZero(c.MaxAttempts, 5) }
+
+// maxConcurrentPerHost is the in-flight fetch cap for hosts whose politeness profile sets
+// no concurrency (default 4; negative = unlimited, returned as 0).
+func (c CrawlerConfig) maxConcurrentPerHost() int {
+	if c.MaxConcurrentPerHost < 0 {
+		return 0
+	}
+	return nonZero(c.MaxConcurrentPerHost, 4)
+}
+
+func (c CrawlerConfig) reclaimAfter() time.Duration {
+	if c.ReclaimAfter.Duration > 0 {
+		return c.ReclaimAfter.Duration
+	}
+	return 15 * time.Minute
+}
+
+var defaultAssetExtensions = []string{
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg", ".ico", ".bmp",
+	".css", ".js", ".mjs", ".map", ".json", ".xml",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp3", ".mp4", ".webm", ".avi", ".mov", ".wav", ".ogg",
+	".zip", ".gz", ".tgz", ".rar", ".7z", ".tar", ".bz2",
+	".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+	".exe", ".msi", ".dmg", ".apk", ".iso", ".bin",
+}
+
+func (c CrawlerConfig) assetExtensions() []string {
+	if c.AssetExtensions == nil {
+		return defaultAssetExtensions
+	}
+	return c.AssetExtensions
+}
+
+// robotsUATokens returns the agent tokens for meta robots, X-Robots-Tag and robots.txt group matching.
+func (c CrawlerConfig) robotsUATokens(r RobotsConfig) []string {
+	if c.RobotsUATokens != nil {
+		return c.RobotsUATokens
+	}
+	ua := firstNonEmpty(r.UserAgent, c.UserAgent)
+	// product token: "GoseCrawler/1.0 (+https://...)" -> "gosecrawler"
+	tok, _, _ := strings.Cut(strings.TrimSpace(ua), "/")
+	if tok, _, _ = strings.Cut(tok, " "); tok == "" {
+		return nil
+	}
+	return []string{strings.ToLower(tok)}
 }
 
 type RobotsConfig struct {
@@ -70,10 +339,40 @@ type ProxiesRef struct {
 // ProxiesConfig is the YAML loaded from proxies.yaml
 type ProxiesConfig struct {
 	Version     int               `yaml:"version"`
-	Rotation    string            `yaml:"rotation"` // round_robin, random (MVP: round_robin)
+	Rotation    string            `yaml:"rotation"` // round_robin (default), random, least_used
 	BanPolicy   BanPolicyConfig   `yaml:"ban_policy"`
 	Healthcheck HealthcheckConfig `yaml:"healthcheck"`
-	Proxies     []string          `yaml:"proxies"`
+	Proxies     []ProxyEntry      `yaml:"proxies"`
+}
+
+// ProxyEntry is one proxies.yaml entry: either a plain URL string or an object with
+// separate credentials and a rotation weight.
+type ProxyEntry struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Weight   int    `yaml:"weight,omitempty"` // share of round_robin/random picks (default 1)
+}
+
+func (e *ProxyEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = ProxyEntry{}
+		return value.Decode(&e.URL)
+	}
+	type plain ProxyEntry
+	return value.Decode((*plain)(e))
+}
+
+// proxyURL parses the entry; Username/Password replace credentials given in the URL.
+func (e ProxyEntry) proxyURL() (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(e.URL))
+	if err != nil {
+		return nil, err
+	}
+	if e.Username != "" {
+		u.User = url.UserPassword(e.Username, e.Password)
+	}
+	return u, nil
 }
 
 type BanPolicyConfig struct {
@@ -85,7 +384,9 @@ type HealthcheckConfig struct {
 	Method   string   `yaml:"method"`
 	URL      string   `yaml:"url"`
 	Timeout  Duration `yaml:"timeout"`
-	Interval Duration `yaml:"interval"`
+	Interval Duration `yaml:"interval"` // > 0: check pass this often; failing proxies leave rotation until they pass
+	// Concurrency bounds parallel checks per pass, also for POST /api/proxies/healthcheck (default 4).
+	Concurrency int `yaml:"concurrency"`
 }
 
 // Duration is a thin wrapper to parse Go durations from YAML.
@@ -106,11 +407,19 @@ func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
 // ByteSize parses sizes like "2MB", "512KB".
 type ByteSize struct {
 	Bytes int64
 }
 
+func (b ByteSize) MarshalYAML() (any, error) {
+	return fmt.Sprintf("%dB", b.Bytes), nil
+}
+
 func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
 	var s string
 	if err := value.Decode(&s); err != nil {
@@ -161,9 +470,44 @@ func loadConfig(path string) (Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return Config{}, err
 	}
+	if err := validateConfig(cfg); err != nil {
+		return Config{}, err
+	}
 	return cfg, nil
 }
 
+func validateConfig(cfg Config) error {
+	c := cfg.Crawler
+	if c.AcceptStatusMin < 0 || c.AcceptStatusMax < 0 ||
+		(c.AcceptStatusMin > 0 && c.AcceptStatusMax > 0 && c.AcceptStatusMax < c.AcceptStatusMin) {
+		return fmt.Errorf("invalid crawler accept status range: %d..%d", c.AcceptStatusMin, c.AcceptStatusMax)
+	}
+	switch c.AssetLinks {
+	case "", "skip", "deprioritize":
+	default:
+		return fmt.Errorf("invalid crawler.asset_links %q (want skip|deprioritize or empty)", c.AssetLinks)
+	}
+	if err := validatePoliteness(c); err != nil {
+		return err
+	}
+	switch c.CrawlScope {
+	case "", "host", "domain", "registrable":
+	default:
+		return fmt.Errorf("invalid crawler.crawl_scope %q (want host|domain|registrable)", c.CrawlScope)
+	}
+	switch c.UnfollowedRedirect {
+	case "", "skip", "error":
+	default:
+		return fmt.Errorf("invalid crawler.unfollowed_redirect %q (want skip|error or empty)", c.UnfollowedRedirect)
+	}
+	switch c.HreflangMode {
+	case "", "prioritize", "restrict":
+	default:
+		return fmt.Errorf("invalid crawler.hreflang_mode %q (want prioritize|restrict)", c.HreflangMode)
+	}
+	return nil
+}
+
 func loadProxies(path string) (ProxiesConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- /api/config: effective configuration, secrets redacted ---
+
+const redacted = "REDACTED"
+
+// AuthConf protects operator endpoints (/api/config). Env CRAWLER_AUTH_TOKEN overrides Token.
+type AuthConf struct {
+	Token string `yaml:"token"`
+}
+
+// requireAuth guards operator endpoints with the configured bearer token.
+// Without a configured token the endpoints are disabled.
+func requireAuth(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "disabled: auth.token is not configured", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="crawler"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAuthForWrites is requireAuth for everything but GET/HEAD, for endpoints whose
+// read side is harmless but whose writes change the crawler at runtime.
+func requireAuthForWrites(token string, next http.HandlerFunc) http.HandlerFunc {
+	authed := requireAuth(token, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		authed(w, r)
+	}
+}
+
+// redactURL hides the password of a DSN or proxy URL: user:pass@host, a ?password=
+// query parameter, or password= in a key/value DSN. Other strings are returned unchanged.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return reDSNPassword.ReplaceAllString(s, "${1}"+redacted)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// reDSNPassword matches the password of a key/value DSN ("host=db password='s e'").
+var reDSNPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactSecret hides a non-empty secret value.
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// secretHeaders are crawler.headers whose values are credentials.
+var secretHeaders = map[string]bool{"Authorization": true, "Cookie": true, "Proxy-Authorization": true}
+
+// redactedConfig returns cfg and the loaded proxies with credentials hidden.
+func redactedConfig(cfg Config, pcfg ProxiesConfig) (Config, ProxiesConfig) {
+	cfg.Postgres.DSN = redactURL(cfg.Postgres.DSN)
+	cfg.HTMLStorage.S3.AccessKey = redactSecret(cfg.HTMLStorage.S3.AccessKey)
+	cfg.HTMLStorage.S3.SecretKey = redactSecret(cfg.HTMLStorage.S3.SecretKey)
+	cfg.Auth.Token = redactSecret(cfg.Auth.Token)
+	if len(cfg.Crawler.Headers) > 0 {
+		headers := make(map[string]string, len(cfg.Crawler.Headers))
+		for k, v := range cfg.Crawler.Headers {
+			if secretHeaders[http.CanonicalHeaderKey(k)] {
+				v = redactSecret(v)
+			}
+			headers[k] = v
+		}
+		cfg.Crawler.Headers = headers
+	}
+	proxies := make([]ProxyEntry, len(pcfg.Proxies))
+	for i, p := range pcfg.Proxies {
+		p.URL = redactURL(p.URL)
+		p.Password = redactSecret(p.Password)
+		proxies[i] = p
+	}
+	pcfg.Proxies = proxies
+	return cfg, pcfg
+}
+
+// configJSON re-keys v by its YAML names (via a YAML round trip) so /api/config mirrors the file.
+func configJSON(v any) (any, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// handleConfig: GET /api/config — the effective (file + env) config and proxies, redacted.
+func handleConfig(cfg Config, pcfg ProxiesConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rc, rp := redactedConfig(cfg, pcfg)
+		c, err := configJSON(rc)
+		if err != nil {
+			http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, err := configJSON(rp)
+		if err != nil {
+			http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"config": c, "proxies": p})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       CrawlerConfig
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "custom status range", c: CrawlerConfig{AcceptStatusMin: 200, AcceptStatusMax: 499}},
+		{name: "only max", c: CrawlerConfig{AcceptStatusMax: 299}},
+		{name: "inverted status range", c: CrawlerConfig{AcceptStatusMin: 400, AcceptStatusMax: 200}, wantErr: true},
+		{name: "negative status", c: CrawlerConfig{AcceptStatusMin: -1}, wantErr: true},
+		{name: "unfollowed redirect error", c: CrawlerConfig{UnfollowedRedirect: "error"}},
+		{name: "unknown unfollowed redirect", c: CrawlerConfig{UnfollowedRedirect: "store"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := validateConfig(Config{Crawler: tt.c})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateConfig = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLinkPriority(t *testing.T) {
+	tests := []struct {
+		cfg     LinkPriorityBoostConfig
+		inbound int64
+		want    int
+	}{
+		{LinkPriorityBoostConfig{}, 1000, 0},
+		{LinkPriorityBoostConfig{Weight: 2}, 0, 0},
+		{LinkPriorityBoostConfig{Weight: 2}, -5, 0},
+		{LinkPriorityBoostConfig{Weight: 1}, 1, 1},
+		{LinkPriorityBoostConfig{Weight: 1}, 3, 2},
+		{LinkPriorityBoostConfig{Weight: 1}, 6, 2},
+		{LinkPriorityBoostConfig{Weight: 2}, 7, 6},
+		{LinkPriorityBoostConfig{Weight: 0.5}, 1, 0},
+		{LinkPriorityBoostConfig{Weight: 3, Max: 10}, 1 << 20, 10},
+		{LinkPriorityBoostConfig{Weight: 3, Max: 100}, 1023, 30},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.linkPriority(tt.inbound); got != tt.want {
+			t.Errorf("%+v.linkPriority(%d) = %d, want %d", tt.cfg, tt.inbound, got, tt.want)
+		}
+	}
+	// more inbound links never lower the priority
+	b := LinkPriorityBoostConfig{Weight: 1.5, Max: 20}
+	prev := 0
+	for n := int64(0); n < 5000; n += 7 {
+		p := b.linkPriority(n)
+		if p < prev {
+			t.Fatalf("linkPriority(%d) = %d < %d", n, p, prev)
+		}
+		prev = p
+	}
+}
+
+func TestRobotsUATokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		ua     string
+		robots string
+		want   []string
+	}{
+		{name: "product token of user_agent", ua: "GoseCrawler/1.0 (+https://example.com/bot)", want: []string{"gosecrawler"}},
+		{name: "robots user_agent wins", ua: "Other/2", robots: "GoseBot/1.0", want: []string{"gosebot"}},
+		{name: "no version", ua: "Gose Crawler", want: []string{"gose"}},
+		{name: "no agent", want: nil},
+		{name: "explicit", tokens: []string{"gose", "gosecrawler"}, ua: "X/1", want: []string{"gose", "gosecrawler"}},
+		{name: "explicit empty", tokens: []string{}, ua: "X/1", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CrawlerConfig{RobotsUATokens: tt.tokens, UserAgent: tt.ua}
+			got := c.robotsUATokens(RobotsConfig{UserAgent: tt.robots})
+			if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("robotsUATokens = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
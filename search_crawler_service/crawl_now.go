@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleCrawlNow serves POST /api/crawl-now: fetch, parse and store a URL right now
+// (bypassing the queue) and return the stored page or why it wasn't stored.
+func handleCrawlNow(db *pgxpool.Pool, cfg Config, pool *ProxyPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req CrawlNowRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		parsed, err := url.Parse(strings.TrimSpace(req.URL))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+		canonicalizeURL(parsed, cfg.Crawler)
+		host := parsed.Host
+		if len(cfg.Crawler.WhitelistDomains) > 0 && !isHostAllowed(host, cfg.Crawler.WhitelistDomains) {
+			http.Error(w, "host not in whitelist", http.StatusForbidden)
+			return
+		}
+		siteID, err := ensureSite(r.Context(), db, host, cfg)
+		if errors.Is(err, errSiteLimit) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "ensure site error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		finalURL := parsed.String()
+		resp := CrawlNowResponse{SiteID: siteID, URL: finalURL, URLHash: sha256Hex(finalURL)}
+		pageID, err := processURL(r.Context(), db, cfg, pool, siteID, finalURL, 0)
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			resp.Skipped = skipErr.reason
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+		if err != nil {
+			status, retryAfter := crawlNowErrorStatus(err)
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			resp.Error = err.Error()
+			writeJSON(w, status, resp)
+			return
+		}
+		// by id: a redirected URL is stored under the URL it was served from
+		page, ok, err := getPageByID(r.Context(), db, pageID)
+		if err != nil || !ok {
+			http.Error(w, "stored page lookup failed", http.StatusInternalServerError)
+			return
+		}
+		resp.Page = &page
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// crawlNowErrorStatus maps a processURL failure to the crawl-now HTTP status and, for a
+// throttled fetch, the delay the server asked for: throttled 429, permanent 422, a
+// fetch/store error to retry later 502, transient (DNS, cancelled) and anything else 503.
+func crawlNowErrorStatus(err error) (int, time.Duration) {
+	var retryErr *retryError
+	var transientErr *transientError
+	var throttleErr *throttledError
+	var permErr *permanentError
+	switch {
+	case errors.As(err, &throttleErr):
+		return http.StatusTooManyRequests, throttleErr.after
+	case errors.As(err, &permErr):
+		return http.StatusUnprocessableEntity, 0
+	case errors.As(err, &retryErr):
+		return http.StatusBadGateway, 0
+	case errors.As(err, &transientErr):
+		return http.StatusServiceUnavailable, 0
+	}
+	return http.StatusServiceUnavailable, 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A redirected URL is stored under the URL it was served from; crawl-now returns that page.
+func TestCrawlNowRedirect(t *testing.T) {
+	db := testDB(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><head><title>Moved here</title></head><body><p>Content</p></body></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := testCrawlConfig()
+	testSite(t, db, cfg, srv.URL)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/crawl-now", strings.NewReader(`{"url":"`+srv.URL+`/old"}`))
+	handleCrawlNow(db, cfg, testProxyPool(t))(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp CrawlNowResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Page == nil {
+		t.Fatalf("no page in response: %+v", resp)
+	}
+	if resp.Page.URL != srv.URL+"/new" || resp.Page.Title != "Moved here" {
+		t.Errorf("page = %q %q, want the redirect target", resp.Page.URL, resp.Page.Title)
+	}
+}
+
+func TestCrawlNowErrorStatus(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter time.Duration
+	}{
+		{&throttledError{"429", 30 * time.Second, errClassHTTPStatus}, http.StatusTooManyRequests, 30 * time.Second},
+		{&permanentError{"gone", errClassHTTPStatus}, http.StatusUnprocessableEntity, 0},
+		{&retryError{"store: boom", 10 * time.Minute, errClassStore}, http.StatusBadGateway, 0},
+		{&transientError{"dns", time.Minute, errClassDNS}, http.StatusServiceUnavailable, 0},
+		{fmt.Errorf("wrapped: %w", &permanentError{"gone", errClassHTTPStatus}), http.StatusUnprocessableEntity, 0},
+		{context.Canceled, http.StatusServiceUnavailable, 0},
+		{errors.New("other"), http.StatusServiceUnavailable, 0},
+	}
+	for _, tt := range tests {
+		status, after := crawlNowErrorStatus(tt.err)
+		if status != tt.status || after != tt.retryAfter {
+			t.Errorf("crawlNowErrorStatus(%v) = %d, %s; want %d, %s", tt.err, status, after, tt.status, tt.retryAfter)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Crawl run counters ---
+//
+// Workers bump in-memory counters; a single flusher adds the deltas to the current
+// crawl_runs row periodically, so the hot row is written once per interval rather
+// than once per processed item.
+
+type runCounters struct {
+	done    atomic.Int64
+	errored atomic.Int64
+	skipped atomic.Int64
+}
+
+var currentRun runCounters
+
+// ensureCurrentRun resumes the current crawl run or starts a new one.
+func ensureCurrentRun(ctx context.Context, db *pgxpool.Pool) (id int64, startedAt time.Time, err error) {
+	const q = `
+WITH cur AS (
+  SELECT id, started_at FROM crawl_runs WHERE is_current
+), ins AS (
+  INSERT INTO crawl_runs (is_current)
+  SELECT true WHERE NOT EXISTS (SELECT 1 FROM cur)
+  ON CONFLICT DO NOTHING
+  RETURNING id, started_at
+)
+SELECT id, started_at FROM cur
+UNION ALL
+SELECT id, started_at FROM ins;`
+	for attempt := 0; attempt < 3; attempt++ {
+		err = db.QueryRow(ctx, q).Scan(&id, &startedAt)
+		// no rows: a peer instance created the run concurrently; read it on the next attempt
+		if !errors.Is(err, pgx.ErrNoRows) {
+			break
+		}
+	}
+	return id, startedAt, err
+}
+
+// runCountersFlusher adds accumulated deltas to whichever run is current at flush time.
+func runCountersFlusher(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushRunCounters(context.Background(), db)
+			return
+		case <-t.C:
+			flushRunCounters(ctx, db)
+		}
+	}
+}
+
+func flushRunCounters(ctx context.Context, db *pgxpool.Pool) {
+	done, errored, skipped := currentRun.done.Swap(0), currentRun.errored.Swap(0), currentRun.skipped.Swap(0)
+	if done == 0 && errored == 0 && skipped == 0 {
+		return
+	}
+	const q = `
+UPDATE crawl_runs
+SET items_done = items_done + $1, items_error = items_error + $2, items_skipped = items_skipped + $3
+WHERE is_current;`
+	if _, err := db.Exec(ctx, q, done, errored, skipped); err != nil {
+		// put the deltas back so the next flush retries them
+		currentRun.done.Add(done)
+		currentRun.errored.Add(errored)
+		currentRun.skipped.Add(skipped)
+		Warn("flush run counters failed", "err", err)
+	}
+}
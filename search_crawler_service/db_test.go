@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tests that need Postgres run against GOSE_TEST_DSN, a scratch database initialized with
+// deploy/db/init.sql, and are skipped when it is not set.
+
+func testDB(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("GOSE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GOSE_TEST_DSN not set")
+	}
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testSite creates the site row for the host of rawURL and removes it (with its pages and
+// queue items) when the test ends.
+func testSite(t testing.TB, db *pgxpool.Pool, cfg Config, rawURL string) int64 {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalizeURL(u, cfg.Crawler)
+	ctx := context.Background()
+	siteID, err := ensureSite(ctx, db, u.Host, cfg)
+	if err != nil {
+		t.Fatalf("ensureSite: %v", err)
+	}
+	t.Cleanup(func() { _, _ = db.Exec(context.Background(), `DELETE FROM sites WHERE id = $1`, siteID) })
+	return siteID
+}
+
+// testCrawlConfig is a minimal config for fetching from a local httptest server.
+func testCrawlConfig() Config {
+	var cfg Config
+	cfg.Crawler.HTMLMaxSize = ByteSize{Bytes: 1 << 20}
+	cfg.Crawler.HTMLFetchTimeout = Duration{10 * time.Second}
+	cfg.Crawler.ContentTypes = []string{"text/html"}
+	return cfg
+}
+
+func testProxyPool(t *testing.T) *ProxyPool {
+	t.Helper()
+	ppool, err := NewProxyPool(ProxiesConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ppool
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Bulk enqueue (POST /api/enqueue/batch) ---
+//
+// Imports a JSON array of {url, priority} objects. Every item goes through the same
+// validation, canonicalization, whitelist and site creation as /api/enqueue and gets its own
+// result; one bad URL doesn't fail the batch. The array is decoded item by item and a batch
+// over crawler.enqueue_batch_max is rejected before anything is enqueued.
+
+// Batch item outcomes.
+const (
+	enqueueEnqueued  = "enqueued"
+	enqueueDuplicate = "duplicate"
+	enqueueInvalid   = "invalid"
+)
+
+// maxEnqueueItemBytes bounds the request body per allowed item (a long URL plus priority).
+const maxEnqueueItemBytes = 8 << 10
+
+func (c CrawlerConfig) enqueueBatchMax() int { return nonZero(c.EnqueueBatchMax, 1000) }
+
+// parseEnqueueURL validates and canonicalizes a URL submitted for enqueueing. On failure
+// it returns the HTTP status and message /api/enqueue answers with.
+func parseEnqueueURL(c CrawlerConfig, raw string) (*url.URL, int, string) {
+	u := strings.TrimSpace(raw)
+	if u == "" {
+		return nil, http.StatusBadRequest, "url is required"
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, http.StatusBadRequest, "invalid url"
+	}
+	// Canonicalize: drop fragment, normalize host (lower-case, default ports, trailing dot), encoding
+	canonicalizeURL(parsed, c)
+	if parsed.Host == "" {
+		return nil, http.StatusBadRequest, "invalid host"
+	}
+	// Optional: enforce whitelist if provided
+	if len(c.WhitelistDomains) > 0 && !isHostAllowed(parsed.Host, c.WhitelistDomains) {
+		return nil, http.StatusForbidden, "host not in whitelist"
+	}
+	return parsed, http.StatusOK, ""
+}
+
+var errBatchTooLarge = errors.New("batch too large")
+
+// decodeEnqueueBatch reads the JSON array, stopping with an error as soon as it holds more
+// than max items.
+func decodeEnqueueBatch(dec *json.Decoder, max int) ([]EnqueueRequest, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, errors.New("expected a JSON array")
+	}
+	var items []EnqueueRequest
+	for dec.More() {
+		if len(items) == max {
+			return nil, fmt.Errorf("%w (max %d items)", errBatchTooLarge, max)
+		}
+		var it EnqueueRequest
+		if err := dec.Decode(&it); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// handleEnqueueBatch serves POST /api/enqueue/batch.
+func handleEnqueueBatch(db *pgxpool.Pool, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := cfg.Crawler.enqueueBatchMax()
+		body := http.MaxBytesReader(w, r.Body, int64(limit)*maxEnqueueItemBytes)
+		items, err := decodeEnqueueBatch(json.NewDecoder(body), limit)
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) || errors.Is(err, errBatchTooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		resp := EnqueueBatchResponse{Total: len(items), Results: make([]EnqueueBatchResult, 0, len(items))}
+		sites := make(map[string]int64) // host -> site id within this batch
+		for _, it := range items {
+			res := EnqueueBatchResult{URL: it.URL}
+			parsed, _, msg := parseEnqueueURL(cfg.Crawler, it.URL)
+			if parsed == nil {
+				res.Status, res.Message = enqueueInvalid, msg
+				resp.Invalid++
+				resp.Results = append(resp.Results, res)
+				continue
+			}
+			siteID, ok := sites[parsed.Host]
+			if !ok {
+				siteID, err = ensureSite(ctx, db, parsed.Host, cfg)
+				if errors.Is(err, errSiteLimit) {
+					res.Status, res.Message = enqueueInvalid, err.Error()
+					resp.Invalid++
+					resp.Results = append(resp.Results, res)
+					continue
+				}
+				if err != nil {
+					http.Error(w, "ensure site error: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				sites[parsed.Host] = siteID
+			}
+			res.SiteID, res.URL, res.URLHash = siteID, parsed.String(), sha256Hex(parsed.String())
+			priority := 0
+			if it.Priority != nil {
+				priority = *it.Priority
+			}
+			enq, err := enqueueIfNotExists(ctx, db, siteID, res.URL, res.URLHash, priority, 0, 0)
+			if err != nil {
+				http.Error(w, "enqueue error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if enq {
+				res.Status = enqueueEnqueued
+				resp.Enqueued++
+			} else {
+				res.Status, res.Message = enqueueDuplicate, "already queued or processing"
+				resp.Duplicate++
+			}
+			resp.Results = append(resp.Results, res)
+		}
+		Info("enqueue batch", "total", resp.Total, "enqueued", resp.Enqueued, "duplicate", resp.Duplicate, "invalid", resp.Invalid)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// --- Fetch error classes ---
+//
+// Failed queue items record a coarse cause in crawl_queue.error_class for triage, and
+// regular retries start from a per-class delay (crawler.error_backoff), doubled per
+// attempt by retry_backoff.
+
+const (
+	errClassDNS         = "dns"
+	errClassTimeout     = "timeout"
+	errClassConnRefused = "conn_refused"
+	errClassTLS         = "tls"
+	errClassHTTPStatus  = "http_status"
+	errClassBody        = "body"
+	errClassRedirect    = "redirect"
+	errClassContentType = "content_type"
+	errClassStore       = "store"
+	errClassOther       = "other"
+)
+
+// defaultErrorBackoff is the first retry delay per class; a broken resolver or certificate
+// is not fixed in minutes, a timeout often is.
+var defaultErrorBackoff = map[string]time.Duration{
+	errClassDNS:         time.Hour,
+	errClassTimeout:     2 * time.Minute,
+	errClassConnRefused: 15 * time.Minute,
+	errClassTLS:         time.Hour,
+	errClassHTTPStatus:  30 * time.Minute,
+	errClassBody:        2 * time.Minute,
+	errClassOther:       5 * time.Minute,
+}
+
+// errorBackoff is the base retry delay for class: crawler.error_backoff, else the default.
+func (c CrawlerConfig) errorBackoff(class string) time.Duration {
+	if d := c.ErrorBackoff[class].Duration; d > 0 {
+		return d
+	}
+	if d, ok := defaultErrorBackoff[class]; ok {
+		return d
+	}
+	return defaultErrorBackoff[errClassOther]
+}
+
+// fetchErrorClass inspects a fetch error chain and names its cause.
+func fetchErrorClass(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		stErr      *statusError
+		brErr      *bodyReadError
+		loopErr    *redirectLoopError
+		limitErr   *redirectLimitError
+		certErr    *tls.CertificateVerificationError
+		alertErr   tls.AlertError
+		recordErr  tls.RecordHeaderError
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+		netErr     net.Error
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return errClassDNS
+	case errors.As(err, &stErr):
+		return errClassHTTPStatus
+	case errors.As(err, &brErr):
+		return errClassBody
+	case errors.As(err, &loopErr), errors.As(err, &limitErr):
+		return errClassRedirect
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errClassConnRefused
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &authErr), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return errClassTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errClassTimeout
+	}
+	return errClassOther
+}
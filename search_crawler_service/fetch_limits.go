@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// --- Global fetch concurrency ---
+//
+// Every outbound fetch (pages and auxiliary robots.txt/sitemap requests) holds a slot of
+// fetchSem. Auxiliary fetches additionally hold a slot of the much smaller auxFetchSem and
+// pass a low-rate limiter first, so metadata fetching can never take over the global cap.
+
+var (
+	fetchSem    chan struct{} // nil = unlimited
+	auxFetchSem chan struct{} // nil = unlimited
+	auxLimiter  *rate.Limiter // nil = unlimited
+	// bandwidthLimiter meters response body bytes (as received, before decompression)
+	// across all workers; nil = unlimited.
+	bandwidthLimiter *rate.Limiter
+)
+
+// initFetchLimits sets up the shared semaphores; call once before workers start.
+func initFetchLimits(cfg CrawlerConfig) {
+	if n := cfg.MaxConcurrentFetches; n > 0 {
+		fetchSem = make(chan struct{}, n)
+	}
+	aux := cfg.AuxFetch
+	if aux.Concurrency > 0 {
+		auxFetchSem = make(chan struct{}, aux.Concurrency)
+	}
+	if aux.RPS > 0 {
+		auxLimiter = rate.NewLimiter(rate.Limit(aux.RPS), 1)
+	}
+	if bps := cfg.MaxBytesPerSecond.Bytes; bps > 0 {
+		// burst of one second of traffic, at least one read buffer
+		bandwidthLimiter = rate.NewLimiter(rate.Limit(bps), int(max(bps, 32*1024)))
+	}
+	Info("fetch limits", "max_concurrent_fetches", cap(fetchSem), "aux_concurrency", cap(auxFetchSem), "aux_rps", aux.RPS,
+		"max_bytes_per_second", cfg.MaxBytesPerSecond.Bytes)
+}
+
+func acquireSem(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireFetchSlot reserves a global fetch slot for a page fetch.
+func acquireFetchSlot(ctx context.Context) (func(), error) {
+	return acquireSem(ctx, fetchSem)
+}
+
+// acquireAuxFetchSlot reserves slots for a robots/sitemap fetch: aux rate, aux cap, then the global cap.
+func acquireAuxFetchSlot(ctx context.Context) (func(), error) {
+	if auxLimiter != nil {
+		if err := auxLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	releaseAux, err := acquireSem(ctx, auxFetchSem)
+	if err != nil {
+		return nil, err
+	}
+	releaseGlobal, err := acquireSem(ctx, fetchSem)
+	if err != nil {
+		releaseAux()
+		return nil, err
+	}
+	return func() { releaseGlobal(); releaseAux() }, nil
+}
+
+// throttledReader charges every read against a shared bytes-per-second limiter.
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+// throttleBody wraps r with the global bandwidth limiter (no-op when unlimited).
+func throttleBody(ctx context.Context, r io.Reader) io.Reader {
+	if bandwidthLimiter == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, lim: bandwidthLimiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// never ask for more than the limiter can grant at once
+	if b := t.lim.Burst(); len(p) > b {
+		p = p[:b]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.lim.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
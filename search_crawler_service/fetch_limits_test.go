@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setFetchLimits installs the limits of cfg for one test and restores the previous ones.
+func setFetchLimits(t *testing.T, cfg CrawlerConfig) {
+	t.Helper()
+	fs, aux, lim, bw := fetchSem, auxFetchSem, auxLimiter, bandwidthLimiter
+	fetchSem, auxFetchSem, auxLimiter, bandwidthLimiter = nil, nil, nil, nil
+	initFetchLimits(cfg)
+	t.Cleanup(func() { fetchSem, auxFetchSem, auxLimiter, bandwidthLimiter = fs, aux, lim, bw })
+}
+
+// blocked reports whether acquire is still waiting after a short while.
+func blocked(acquire func(context.Context) (func(), error)) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release, err := acquire(ctx)
+	if err != nil {
+		return true
+	}
+	release()
+	return false
+}
+
+func TestAuxFetchesShareGlobalCap(t *testing.T) {
+	setFetchLimits(t, CrawlerConfig{MaxConcurrentFetches: 2, AuxFetch: AuxFetchConfig{Concurrency: 5}})
+	ctx := context.Background()
+	r1, _ := acquireFetchSlot(ctx)
+	r2, _ := acquireFetchSlot(ctx)
+	if !blocked(acquireAuxFetchSlot) {
+		t.Fatal("aux fetch got a slot while page fetches hold the whole global cap")
+	}
+	r1()
+	if blocked(acquireAuxFetchSlot) {
+		t.Fatal("aux fetch blocked with a global slot free")
+	}
+	r2()
+}
+
+func TestAuxFetchConcurrency(t *testing.T) {
+	setFetchLimits(t, CrawlerConfig{MaxConcurrentFetches: 10, AuxFetch: AuxFetchConfig{Concurrency: 1}})
+	ctx := context.Background()
+	release, err := acquireAuxFetchSlot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blocked(acquireAuxFetchSlot) {
+		t.Fatal("second aux fetch got a slot with aux_fetch.concurrency 1")
+	}
+	if blocked(acquireFetchSlot) {
+		t.Fatal("page fetch blocked by the aux cap")
+	}
+	release()
+	// releasing an aux slot also returns its global slot
+	if len(fetchSem) != 0 || len(auxFetchSem) != 0 {
+		t.Fatalf("slots still held after release: global %d, aux %d", len(fetchSem), len(auxFetchSem))
+	}
+}
+
+func TestFetchLimitsUnlimited(t *testing.T) {
+	setFetchLimits(t, CrawlerConfig{})
+	for i := 0; i < 100; i++ {
+		if blocked(acquireFetchSlot) || blocked(acquireAuxFetchSlot) {
+			t.Fatal("fetch blocked without limits")
+		}
+	}
+}
+
+// Concurrent body reads together stay near max_bytes_per_second once the burst is spent.
+func TestBandwidthLimiterThroughput(t *testing.T) {
+	const bps = 128 << 10
+	setFetchLimits(t, CrawlerConfig{MaxBytesPerSecond: ByteSize{Bytes: bps}})
+	const readers, perReader = 4, 64 << 10 // 256 KiB: one second of burst + one second at the cap
+	ctx := context.Background()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := io.Copy(io.Discard, throttleBody(ctx, bytes.NewReader(make([]byte, perReader))))
+			if err != nil || n != perReader {
+				t.Errorf("read %d bytes, err %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if elapsed < 800*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("256 KiB at 128 KiB/s (128 KiB burst) took %v, want about 1s", elapsed)
+	}
+}
+
+func TestThrottleBody(t *testing.T) {
+	setFetchLimits(t, CrawlerConfig{})
+	r := bytes.NewReader(nil)
+	if got := throttleBody(context.Background(), r); got != io.Reader(r) {
+		t.Error("unlimited bandwidth still wraps the body")
+	}
+
+	setFetchLimits(t, CrawlerConfig{MaxBytesPerSecond: ByteSize{Bytes: 1}})
+	if burst := bandwidthLimiter.Burst(); burst != 32<<10 {
+		t.Errorf("burst = %d, want one 32 KiB read buffer", burst)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := throttleBody(ctx, bytes.NewReader(make([]byte, 64<<10)))
+	if _, err := io.Copy(io.Discard, body); err == nil {
+		t.Error("read past the burst with a cancelled context")
+	}
+}
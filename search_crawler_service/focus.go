@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Crawl focus ---
+//
+// A focus makes workers drain one site and/or priority band before anything else:
+// claims try the focused items first and fall back to the whole queue when none is due.
+// An exclusive focus never falls back (other work waits). Set at startup by crawler.focus,
+// changed at runtime via /api/focus; an optional TTL clears it automatically.
+
+// CrawlFocus selects the queue items workers claim first.
+type CrawlFocus struct {
+	Domain string `yaml:"domain" json:"domain,omitempty"`
+	SiteID int64  `yaml:"-" json:"site_id,omitempty"` // resolved from Domain (or given directly via the API)
+	// Priority band, inclusive; nil = unbounded.
+	MinPriority *int `yaml:"min_priority" json:"min_priority,omitempty"`
+	MaxPriority *int `yaml:"max_priority" json:"max_priority,omitempty"`
+	// Exclusive claims only focused items until the focus is cleared.
+	Exclusive bool `yaml:"exclusive" json:"exclusive"`
+	// TTL clears the focus after this long (0 = until cleared).
+	TTL   Duration  `yaml:"ttl" json:"-"`
+	Until time.Time `yaml:"-" json:"until,omitzero"`
+}
+
+// FocusRequest is the body of POST /api/focus.
+type FocusRequest struct {
+	Domain      string `json:"domain"`
+	SiteID      int64  `json:"site_id"`
+	MinPriority *int   `json:"min_priority"`
+	MaxPriority *int   `json:"max_priority"`
+	Exclusive   bool   `json:"exclusive"`
+	TTL         string `json:"ttl"` // Go duration, e.g. "2h"
+}
+
+var crawlFocus atomic.Pointer[CrawlFocus]
+
+// currentFocus returns the active focus, clearing it once expired.
+func currentFocus() *CrawlFocus {
+	f := crawlFocus.Load()
+	if f != nil && !f.Until.IsZero() && time.Now().After(f.Until) {
+		if crawlFocus.CompareAndSwap(f, nil) {
+			Info("crawl focus expired", "domain", f.Domain, "site_id", f.SiteID)
+		}
+		return nil
+	}
+	return f
+}
+
+// setFocus validates f, resolves its domain to a site and makes it the active focus.
+func setFocus(ctx context.Context, db *pgxpool.Pool, f CrawlFocus) (*CrawlFocus, error) {
+	f.Domain = normalizeHost(strings.TrimSpace(f.Domain))
+	if f.Domain != "" {
+		err := db.QueryRow(ctx, `SELECT id FROM sites WHERE domain = $1`, f.Domain).Scan(&f.SiteID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("unknown site %q", f.Domain)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.SiteID == 0 && f.MinPriority == nil && f.MaxPriority == nil {
+		return nil, errors.New("focus needs a site (domain or site_id) and/or a priority band")
+	}
+	if f.MinPriority != nil && f.MaxPriority != nil && *f.MinPriority > *f.MaxPriority {
+		return nil, errors.New("min_priority is above max_priority")
+	}
+	if f.TTL.Duration > 0 {
+		f.Until = time.Now().Add(f.TTL.Duration)
+	}
+	crawlFocus.Store(&f)
+	Info("crawl focus set", "domain", f.Domain, "site_id", f.SiteID, "exclusive", f.Exclusive, "until", f.Until)
+	return &f, nil
+}
+
+// where returns the claim filter for the focus, numbering placeholders from $1.
+func (f *CrawlFocus) where() (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.SiteID != 0 {
+		add("site_id = $%d", f.SiteID)
+	}
+	if f.MinPriority != nil {
+		add("priority >= $%d", *f.MinPriority)
+	}
+	if f.MaxPriority != nil {
+		add("priority <= $%d", *f.MaxPriority)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// handleFocus serves /api/focus: GET shows the focus, POST sets it, DELETE clears it.
+func handleFocus(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"focus": currentFocus()})
+		case http.MethodPost:
+			var req FocusRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			f := CrawlFocus{Domain: req.Domain, SiteID: req.SiteID, MinPriority: req.MinPriority, MaxPriority: req.MaxPriority, Exclusive: req.Exclusive}
+			if req.TTL != "" {
+				d, err := time.ParseDuration(req.TTL)
+				if err != nil {
+					http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				f.TTL = Duration{d}
+			}
+			cur, err := setFocus(r.Context(), db, f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"focus": cur})
+		case http.MethodDelete:
+			if f := crawlFocus.Swap(nil); f != nil {
+				Info("crawl focus cleared", "domain", f.Domain, "site_id", f.SiteID)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"focus": nil})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetFocus clears the crawl focus after the test.
+func resetFocus(t *testing.T) {
+	t.Helper()
+	prev := crawlFocus.Swap(nil)
+	t.Cleanup(func() { crawlFocus.Store(prev) })
+}
+
+func TestCrawlFocusWhere(t *testing.T) {
+	lo, hi := 3, 7
+	tests := []struct {
+		name     string
+		focus    CrawlFocus
+		want     string
+		wantArgs []any
+	}{
+		{name: "site", focus: CrawlFocus{SiteID: 42}, want: "site_id = $1", wantArgs: []any{int64(42)}},
+		{name: "band", focus: CrawlFocus{MinPriority: &lo, MaxPriority: &hi}, want: "priority >= $1 AND priority <= $2", wantArgs: []any{3, 7}},
+		{name: "site and floor", focus: CrawlFocus{SiteID: 5, MinPriority: &lo}, want: "site_id = $1 AND priority >= $2", wantArgs: []any{int64(5), 3}},
+		{name: "ceiling only", focus: CrawlFocus{MaxPriority: &hi}, want: "priority <= $1", wantArgs: []any{7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.focus.where()
+			if got != tt.want || !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("where() = %q %v, want %q %v", got, args, tt.want, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCurrentFocusExpires(t *testing.T) {
+	resetFocus(t)
+	crawlFocus.Store(&CrawlFocus{SiteID: 1, Until: time.Now().Add(time.Hour)})
+	if currentFocus() == nil {
+		t.Fatal("focus within its TTL was dropped")
+	}
+	crawlFocus.Store(&CrawlFocus{SiteID: 1, Until: time.Now().Add(-time.Second)})
+	if f := currentFocus(); f != nil {
+		t.Errorf("expired focus still active: %+v", f)
+	}
+	if crawlFocus.Load() != nil {
+		t.Error("expired focus not cleared")
+	}
+}
+
+func TestHandleFocus(t *testing.T) {
+	resetFocus(t)
+	h := handleFocus(nil) // no domain lookups below
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantSite   int64 // site of the active focus afterwards, 0 = none
+		wantUntil  bool
+	}{
+		{name: "get empty", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "set site", method: http.MethodPost, body: `{"site_id": 7, "exclusive": true}`, wantStatus: http.StatusOK, wantSite: 7},
+		{name: "get set", method: http.MethodGet, wantStatus: http.StatusOK, wantSite: 7},
+		{name: "empty focus rejected", method: http.MethodPost, body: `{}`, wantStatus: http.StatusBadRequest, wantSite: 7},
+		{name: "inverted band rejected", method: http.MethodPost, body: `{"min_priority": 5, "max_priority": 1}`, wantStatus: http.StatusBadRequest, wantSite: 7},
+		{name: "bad ttl rejected", method: http.MethodPost, body: `{"site_id": 8, "ttl": "soon"}`, wantStatus: http.StatusBadRequest, wantSite: 7},
+		{name: "bad json rejected", method: http.MethodPost, body: `{`, wantStatus: http.StatusBadRequest, wantSite: 7},
+		{name: "set with ttl", method: http.MethodPost, body: `{"site_id": 9, "ttl": "1h"}`, wantStatus: http.StatusOK, wantSite: 9, wantUntil: true},
+		{name: "method not allowed", method: http.MethodPut, wantStatus: http.StatusMethodNotAllowed, wantSite: 9, wantUntil: true},
+		{name: "clear", method: http.MethodDelete, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(tt.method, "/api/focus", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d (%s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		f := currentFocus()
+		var site int64
+		if f != nil {
+			site = f.SiteID
+		}
+		if site != tt.wantSite {
+			t.Fatalf("%s: focused site = %d, want %d", tt.name, site, tt.wantSite)
+		}
+		if f != nil && f.Until.IsZero() == tt.wantUntil {
+			t.Errorf("%s: until = %v, want set = %v", tt.name, f.Until, tt.wantUntil)
+		}
+		if tt.wantStatus == http.StatusOK {
+			var resp struct {
+				Focus *CrawlFocus `json:"focus"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if (resp.Focus != nil) != (tt.wantSite != 0) {
+				t.Errorf("%s: response focus = %+v", tt.name, resp.Focus)
+			}
+		}
+	}
+}
+
+// Claims prefer the focused site/band; an exclusive focus claims nothing else.
+func TestClaimQueueItemFocus(t *testing.T) {
+	db := testDB(t)
+	resetFocus(t)
+	ctx := context.Background()
+	cfg := testCrawlConfig()
+	focused := testSite(t, db, cfg, "http://focus-a.test/")
+	other := testSite(t, db, cfg, "http://focus-b.test/")
+	enqueue := func(siteID int64, u string, prio int) {
+		if _, err := enqueueIfNotExists(ctx, db, siteID, u, sha256Hex(u), prio, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue(focused, "http://focus-a.test/low", 1)
+	enqueue(focused, "http://focus-a.test/mid", 5)
+	enqueue(other, "http://focus-b.test/high", 1000)
+
+	floor := 5
+	if _, err := setFocus(ctx, db, CrawlFocus{Domain: "focus-a.test", MinPriority: &floor}); err != nil {
+		t.Fatal(err)
+	}
+	it, ok, err := claimQueueItem(ctx, db)
+	if err != nil || !ok || it.URL != "http://focus-a.test/mid" {
+		t.Fatalf("focused claim = %+v ok=%v err=%v, want the focus-a mid item", it, ok, err)
+	}
+
+	if _, err := setFocus(ctx, db, CrawlFocus{SiteID: focused, Exclusive: true}); err != nil {
+		t.Fatal(err)
+	}
+	it, ok, err = claimQueueItem(ctx, db)
+	if err != nil || !ok || it.URL != "http://focus-a.test/low" {
+		t.Fatalf("exclusive claim = %+v ok=%v err=%v, want the focus-a low item", it, ok, err)
+	}
+	if it, ok, err = claimQueueItem(ctx, db); ok || err != nil {
+		t.Fatalf("exclusive focus drained, got %+v ok=%v err=%v, want nothing", it, ok, err)
+	}
+
+	if _, err := setFocus(ctx, db, CrawlFocus{Domain: "unknown-focus.test"}); err == nil {
+		t.Error("focus on an unknown domain accepted")
+	}
+}
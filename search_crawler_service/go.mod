@@ -5,7 +5,10 @@ go 1.24.0
 toolchain go1.24.7
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/jackc/pgx/v5 v5.5.5
+	golang.org/x/net v0.30.0
+	golang.org/x/text v0.19.0
 	golang.org/x/time v0.13.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -18,5 +21,4 @@ require (
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
-	golang.org/x/text v0.19.0 // indirect
 )
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Recrawl HEAD check ---
+//
+// With crawler.recrawl_head_check, a known page is first probed with HEAD; when its
+// Content-Length and Last-Modified match what was stored on the previous fetch, the GET
+// is skipped. Servers that send neither header always get the full GET.
+
+// headCheckHeaders are the response headers the check compares; they are kept in
+// pages.headers whenever the check is enabled, whatever store_headers says.
+var headCheckHeaders = []string{"Content-Length", "Last-Modified"}
+
+// headUnchanged decides skip (true) vs fetch (false). Every header present on both sides
+// must match, and at least one must be comparable.
+func headUnchanged(stored map[string]string, head http.Header) bool {
+	compared := 0
+	for _, name := range headCheckHeaders {
+		was := strings.TrimSpace(stored[name])
+		now := strings.TrimSpace(head.Get(name))
+		if was == "" || now == "" {
+			continue
+		}
+		if was != now {
+			return false
+		}
+		compared++
+	}
+	return compared > 0
+}
+
+// fetchHead issues a HEAD with the same headers as fetchHTML, so
+// Content-Length is comparable with the stored GET response.
+func fetchHead(ctx context.Context, client *http.Client, target string, opts fetchOptions) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req, opts)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Status: resp.StatusCode}
+	}
+	return resp.Header, nil
+}
+
+// headCheck sends the HEAD under the same host and global fetch caps as a page fetch,
+// with the page's fetch options (User-Agent and headers).
+func headCheck(ctx context.Context, cfg Config, host string, polite PolitenessProfile, proxyURL *url.URL, rawURL string, opts fetchOptions) (http.Header, error) {
+	releaseHost, err := acquireHostSlot(ctx, host, polite.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseHost()
+	release, err := acquireFetchSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	client := buildHTTPClient(proxyURL, cfg.Crawler.HTMLFetchTimeout.Duration)
+	return fetchHead(ctx, client, rawURL, opts)
+}
+
+// --- HEAD preflight (crawler.head_preflight) ---
+//
+// Every URL is first probed with HEAD so that large PDFs, images and other in-domain links
+// of a disallowed Content-Type or over html_max_size are never downloaded. Servers that
+// reject HEAD (405/501), fail it or omit the headers get the plain GET.
+
+// preflightReject returns why the GET should not be issued for a HEAD response, or nil.
+func preflightReject(c CrawlerConfig, head http.Header) error {
+	if ct := head.Get("Content-Type"); ct != "" && !isAllowedContentType(ct, c.ContentTypes) {
+		return &retryError{fmt.Sprintf("content-type not allowed: %s (HEAD)", ct), 30 * time.Minute, errClassContentType}
+	}
+	limit := c.HTMLMaxSize.Bytes
+	if n, err := strconv.ParseInt(head.Get("Content-Length"), 10, 64); err == nil && limit > 0 && n > int64(limit) {
+		return &skipError{fmt.Sprintf("content-length %d exceeds html_max_size (HEAD)", n)}
+	}
+	return nil
+}
+
+// touchPageFetched records that an unchanged page was verified now.
+func touchPageFetched(ctx context.Context, db *pgxpool.Pool, pageID int64) {
+	_, _ = db.Exec(ctx, `UPDATE pages SET fetched_at = $2 WHERE id = $1`, pageID, time.Now())
+}
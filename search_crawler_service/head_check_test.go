@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadUnchanged(t *testing.T) {
+	stored := map[string]string{"Content-Length": "1234", "Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"}
+	tests := []struct {
+		name   string
+		stored map[string]string
+		head   http.Header
+		skip   bool
+	}{
+		{name: "both match", stored: stored, skip: true,
+			head: http.Header{"Content-Length": {"1234"}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}},
+		{name: "length changed", stored: stored,
+			head: http.Header{"Content-Length": {"1235"}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}},
+		{name: "modified", stored: stored,
+			head: http.Header{"Content-Length": {"1234"}, "Last-Modified": {"Tue, 03 Jan 2006 15:04:05 GMT"}}},
+		{name: "only length sent", stored: stored, skip: true, head: http.Header{"Content-Length": {"1234"}}},
+		{name: "only date stored", stored: map[string]string{"Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"}, skip: true,
+			head: http.Header{"Content-Length": {"99"}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}},
+		{name: "no headers sent", stored: stored, head: http.Header{}},
+		{name: "nothing stored", stored: nil, head: http.Header{"Content-Length": {"1234"}}},
+		{name: "nothing comparable", stored: map[string]string{"Last-Modified": "x"}, head: http.Header{"Content-Length": {"1"}}},
+		{name: "whitespace ignored", stored: map[string]string{"Content-Length": " 1234 "}, skip: true,
+			head: http.Header{"Content-Length": {"1234"}}},
+	}
+	for _, tt := range tests {
+		if got := headUnchanged(tt.stored, tt.head); got != tt.skip {
+			t.Errorf("%s: headUnchanged = %v, want %v", tt.name, got, tt.skip)
+		}
+	}
+}
+
+func TestFetchHead(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus int // statusError, 0 = success
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound, wantStatus: 404},
+		{name: "method not allowed", status: http.StatusMethodNotAllowed, wantStatus: 405},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					t.Errorf("method = %s, want HEAD", r.Method)
+				}
+				if ua := r.Header.Get("User-Agent"); ua != "GoseTest/1.0" {
+					t.Errorf("User-Agent = %q", ua)
+				}
+				w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			head, err := fetchHead(context.Background(), srv.Client(), srv.URL, fetchOptions{UserAgent: "GoseTest/1.0"})
+			var se *statusError
+			if tt.wantStatus != 0 {
+				if !errors.As(err, &se) || se.Status != tt.wantStatus {
+					t.Fatalf("err = %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil || head.Get("Last-Modified") == "" {
+				t.Fatalf("head = %v, err = %v", head, err)
+			}
+		})
+	}
+}
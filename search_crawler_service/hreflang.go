@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// --- hreflang alternates ---
+//
+// <link rel="alternate" hreflang="xx" href="..."> names the language variants of a page.
+// The variant pointing at the page itself gives pages.lang. With crawler.preferred_hreflang
+// set, preferred variants are enqueued with a priority boost; in restrict mode the other
+// variants are not followed, and a page that is itself a non-preferred variant of a page
+// with a preferred one is not stored.
+
+// reLangTag is the language tag shape pages.lang accepts (x-default and junk are not stored).
+var reLangTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)*$`)
+
+// hreflangAlt is one <link rel="alternate" hreflang> of a page.
+type hreflangAlt struct {
+	Lang string // lower-cased, e.g. "en", "ru-ru", "x-default"
+	Href string
+}
+
+// addAlternate records a <link> if it is an hreflang alternate.
+func (p *parsedPage) addAlternate(rel, hreflang, href string) {
+	hreflang = strings.ToLower(strings.TrimSpace(hreflang))
+	href = strings.TrimSpace(href)
+	if hreflang == "" || href == "" || !hasToken(rel, "alternate") {
+		return
+	}
+	p.Alternates = append(p.Alternates, hreflangAlt{Lang: hreflang, Href: href})
+}
+
+// hasToken reports whether the space-separated list contains tok (case-insensitive).
+func hasToken(list, tok string) bool {
+	for _, f := range strings.Fields(list) {
+		if strings.EqualFold(f, tok) {
+			return true
+		}
+	}
+	return false
+}
+
+// hreflangMatches reports whether lang is one of preferred: "en" matches "en" and "en-gb",
+// "en-gb" only itself. x-default never matches.
+func hreflangMatches(lang string, preferred []string) bool {
+	for _, p := range preferred {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" && (lang == p || strings.HasPrefix(lang, p+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHreflang resolves the page's alternates against pageURL, sets page.Lang from the
+// self-referencing one and adjusts page.Links per crawler.preferred_hreflang. A non-empty
+// result is the reason the page itself should not be stored (restrict mode).
+func applyHreflang(c CrawlerConfig, pageURL string, page *parsedPage) string {
+	if len(page.Alternates) == 0 {
+		return ""
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	if page.BaseHref != "" {
+		if b, err := base.Parse(page.BaseHref); err == nil {
+			base = b
+		}
+	}
+	resolve := func(href string) string {
+		u, err := base.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ""
+		}
+		canonicalizeURL(u, c)
+		return u.String()
+	}
+
+	restrict := c.HreflangMode == "restrict"
+	boost := nonZero(c.HreflangBoost, 10)
+	drop := make(map[string]bool)
+	var preferredAlts []pageLink
+	for _, alt := range page.Alternates {
+		abs := resolve(alt.Href)
+		if abs == "" {
+			continue
+		}
+		if abs == pageURL && page.Lang == "" && reLangTag.MatchString(alt.Lang) {
+			page.Lang = alt.Lang
+		}
+		switch {
+		case len(c.PreferredHreflang) == 0 || abs == pageURL:
+		case hreflangMatches(alt.Lang, c.PreferredHreflang):
+			preferredAlts = append(preferredAlts, pageLink{Href: abs, Boost: boost})
+		case restrict:
+			drop[abs] = true
+		}
+	}
+	if len(c.PreferredHreflang) == 0 {
+		return ""
+	}
+	if len(drop) > 0 {
+		links := page.Links[:0:0]
+		for _, l := range page.Links {
+			if !drop[resolve(l.Href)] {
+				links = append(links, l)
+			}
+		}
+		page.Links = links
+	}
+	// first, so the boosted entry wins over a plain <a> to the same URL
+	page.Links = append(preferredAlts, page.Links...)
+	if restrict && page.Lang != "" && !hreflangMatches(page.Lang, c.PreferredHreflang) && len(preferredAlts) > 0 {
+		return "hreflang " + page.Lang + " not preferred"
+	}
+	return ""
+}
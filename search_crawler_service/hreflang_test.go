@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const hreflangDoc = `<html><head>
+<link rel="alternate" hreflang="en" href="http://site.test/en/">
+<link rel="Alternate" hreflang="RU-ru" href="/ru/">
+<link rel="alternate" hreflang="x-default" href="/">
+<link rel="stylesheet" href="/site.css">
+<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+</head><body><a href="/ru/">ru</a><a href="/about">about</a></body></html>`
+
+func TestExtractAlternates(t *testing.T) {
+	want := []hreflangAlt{{"en", "http://site.test/en/"}, {"ru-ru", "/ru/"}, {"x-default", "/"}}
+	stream, err := parseHTMLStream(strings.NewReader(hreflangDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mode, p := range map[string]parsedPage{"regex": parsePage(hreflangDoc), "stream": stream} {
+		if !slices.Equal(p.Alternates, want) {
+			t.Errorf("%s: Alternates = %v, want %v", mode, p.Alternates, want)
+		}
+	}
+}
+
+func TestHreflangMatches(t *testing.T) {
+	tests := []struct {
+		lang      string
+		preferred []string
+		want      bool
+	}{
+		{"en", []string{"en"}, true},
+		{"en-gb", []string{"en"}, true},
+		{"en", []string{"en-gb"}, false},
+		{"eng", []string{"en"}, false},
+		{"ru-ru", []string{"de", " RU "}, true},
+		{"x-default", []string{"en"}, false},
+		{"en", nil, false},
+		{"en", []string{""}, false},
+	}
+	for _, tt := range tests {
+		if got := hreflangMatches(tt.lang, tt.preferred); got != tt.want {
+			t.Errorf("hreflangMatches(%q, %v) = %v, want %v", tt.lang, tt.preferred, got, tt.want)
+		}
+	}
+}
+
+func TestApplyHreflang(t *testing.T) {
+	tests := []struct {
+		name       string
+		pageURL    string
+		preferred  []string
+		mode       string
+		wantLang   string
+		wantLinks  []pageLink
+		wantReason bool
+	}{
+		{
+			name:      "no preference: only the language is taken",
+			pageURL:   "http://site.test/ru/",
+			wantLang:  "ru-ru",
+			wantLinks: []pageLink{{Href: "/ru/", Text: "ru"}, {Href: "/about", Text: "about"}},
+		},
+		{
+			name:      "prioritize boosts preferred alternates",
+			pageURL:   "http://site.test/ru/",
+			preferred: []string{"en"},
+			wantLang:  "ru-ru",
+			wantLinks: []pageLink{{Href: "http://site.test/en/", Boost: 10}, {Href: "/ru/", Text: "ru"}, {Href: "/about", Text: "about"}},
+		},
+		{
+			name:       "restrict rejects a non-preferred page",
+			pageURL:    "http://site.test/ru/",
+			preferred:  []string{"en"},
+			mode:       "restrict",
+			wantLang:   "ru-ru",
+			wantLinks:  []pageLink{{Href: "http://site.test/en/", Boost: 10}, {Href: "/ru/", Text: "ru"}, {Href: "/about", Text: "about"}},
+			wantReason: true,
+		},
+		{
+			name:      "restrict keeps a preferred page and drops other variants",
+			pageURL:   "http://site.test/en/",
+			preferred: []string{"en"},
+			mode:      "restrict",
+			wantLang:  "en",
+			wantLinks: []pageLink{{Href: "/about", Text: "about"}},
+		},
+		{
+			name:      "page not among its alternates",
+			pageURL:   "http://site.test/other",
+			wantLinks: []pageLink{{Href: "/ru/", Text: "ru"}, {Href: "/about", Text: "about"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parsePage(hreflangDoc)
+			c := CrawlerConfig{PreferredHreflang: tt.preferred, HreflangMode: tt.mode}
+			reason := applyHreflang(c, tt.pageURL, &p)
+			if (reason != "") != tt.wantReason {
+				t.Errorf("reason = %q, want set = %v", reason, tt.wantReason)
+			}
+			if p.Lang != tt.wantLang {
+				t.Errorf("Lang = %q, want %q", p.Lang, tt.wantLang)
+			}
+			if !slices.Equal(p.Links, tt.wantLinks) {
+				t.Errorf("Links = %+v, want %+v", p.Links, tt.wantLinks)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Raw HTML storage backends ---
+//
+// Pages keep raw HTML either inline (pages.html, backend "db") or in a content-addressed
+// store keyed by html_hash, with only pages.html_ref in the DB. The ref format is
+// "<backend>:<html_hash>". Content-addressed backends store identical HTML once globally,
+// no matter how many pages (on any site) reference it. The search UI has a read-only
+// counterpart (search_ui_service/html_store.go) relying on the ref format and object keys.
+
+// HTMLStorageConfig selects where raw HTML is kept.
+type HTMLStorageConfig struct {
+	Backend string          `yaml:"backend"` // db (default), blob, fs, s3
+	FSDir   string          `yaml:"fs_dir"`
+	S3      S3StorageConfig `yaml:"s3"`
+}
+
+type S3StorageConfig struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"` // env HTML_STORE_S3_ACCESS_KEY overrides
+	SecretKey string `yaml:"secret_key"` // env HTML_STORE_S3_SECRET_KEY overrides
+}
+
+var errHTMLNotFound = errors.New("html not found in store")
+
+type htmlStore interface {
+	// Put stores html under its hash and returns the ref to keep in pages.html_ref.
+	Put(ctx context.Context, hash string, html []byte) (string, error)
+	// Get loads html by ref.
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// newHTMLStore builds the configured store; nil means HTML stays inline in the DB.
+func newHTMLStore(cfg HTMLStorageConfig, db *pgxpool.Pool) (htmlStore, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", "db":
+		return nil, nil
+	case "blob":
+		return &blobHTMLStore{db: db}, nil
+	case "fs":
+		if cfg.FSDir == "" {
+			return nil, errors.New("html_storage.fs_dir is required for fs backend")
+		}
+		if err := os.MkdirAll(cfg.FSDir, 0o755); err != nil {
+			return nil, err
+		}
+		return &fsHTMLStore{dir: cfg.FSDir}, nil
+	case "s3":
+		sc := cfg.S3
+		sc.AccessKey = getenv("HTML_STORE_S3_ACCESS_KEY", sc.AccessKey)
+		sc.SecretKey = getenv("HTML_STORE_S3_SECRET_KEY", sc.SecretKey)
+		if sc.Endpoint == "" || sc.Bucket == "" {
+			return nil, errors.New("html_storage.s3.endpoint and bucket are required for s3 backend")
+		}
+		ep, err := url.Parse(strings.TrimSuffix(sc.Endpoint, "/"))
+		if err != nil || ep.Host == "" {
+			return nil, fmt.Errorf("invalid html_storage.s3.endpoint %q", sc.Endpoint)
+		}
+		if sc.Region == "" {
+			sc.Region = "us-east-1"
+		}
+		return &s3HTMLStore{cfg: sc, endpoint: ep, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown html_storage.backend %q (want db|blob|fs|s3)", cfg.Backend)
+	}
+}
+
+// splitHTMLRef parses "<backend>:<hash>" and validates the hash so it is safe in paths/keys.
+func splitHTMLRef(ref string) (backend, hash string, err error) {
+	backend, hash, ok := strings.Cut(ref, ":")
+	if !ok || len(hash) != 64 {
+		return "", "", fmt.Errorf("invalid html ref %q", ref)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", "", fmt.Errorf("invalid html ref %q", ref)
+	}
+	return backend, hash, nil
+}
+
+// htmlObjectKey shards objects by the first hash byte to keep directories small.
+func htmlObjectKey(hash string) string {
+	return hash[:2] + "/" + hash + ".html"
+}
+
+// blobHTMLStore keeps HTML in the html_blobs table, one row per distinct html_hash.
+// Reference counts are maintained by a trigger on pages.html_ref (see init.sql).
+type blobHTMLStore struct {
+	db *pgxpool.Pool
+}
+
+func (s *blobHTMLStore) Put(ctx context.Context, hash string, html []byte) (string, error) {
+	ref := "blob:" + hash
+	if _, _, err := splitHTMLRef(ref); err != nil {
+		return "", err
+	}
+	// Reusing a blob restarts its GC grace period: an orphan revived here must survive until
+	// the page upsert referencing it commits.
+	const q = `INSERT INTO html_blobs (html_hash, html) VALUES ($1, $2)
+ON CONFLICT (html_hash) DO UPDATE SET created_at = now();`
+	if _, err := s.db.Exec(ctx, q, hash, string(html)); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *blobHTMLStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	var html string
+	err = s.db.QueryRow(ctx, "SELECT html FROM html_blobs WHERE html_hash = $1", hash).Scan(&html)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errHTMLNotFound
+	}
+	return []byte(html), err
+}
+
+// fsHTMLStore keeps HTML files under dir/<hh>/<hash>.html.
+type fsHTMLStore struct {
+	dir string
+}
+
+func (s *fsHTMLStore) Put(_ context.Context, hash string, html []byte) (string, error) {
+	ref := "fs:" + hash
+	if _, _, err := splitHTMLRef(ref); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(htmlObjectKey(hash)))
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil // content-addressed: already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// write to a temp file and rename so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(html); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *fsHTMLStore) Get(_ context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(htmlObjectKey(hash))))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errHTMLNotFound
+	}
+	return b, err
+}
+
+// s3HTMLStore talks to any S3-compatible service using path-style URLs and SigV4.
+type s3HTMLStore struct {
+	cfg      S3StorageConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (s *s3HTMLStore) Put(ctx context.Context, hash string, html []byte) (string, error) {
+	ref := "s3:" + hash
+	if _, _, err := splitHTMLRef(ref); err != nil {
+		return "", err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+htmlObjectKey(hash), html)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("s3 put: status %d", resp.StatusCode)
+	}
+	return ref, nil
+}
+
+func (s *s3HTMLStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+htmlObjectKey(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errHTMLNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("s3 get: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// do sends a SigV4-signed request for key (path-style: endpoint/bucket/key).
+func (s *s3HTMLStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + strings.TrimPrefix(key, "/")
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		"", // no query
+		"host:" + u.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+
+	key4 := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key4 = hmacSHA256(key4, s.cfg.Region)
+	key4 = hmacSHA256(key4, "s3")
+	key4 = hmacSHA256(key4, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key4, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+	return s.client.Do(req)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
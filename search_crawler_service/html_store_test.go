@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitHTMLRef(t *testing.T) {
+	hash := sha256Hex("<html></html>")
+	tests := []struct {
+		ref         string
+		wantBackend string
+		wantErr     bool
+	}{
+		{ref: "fs:" + hash, wantBackend: "fs"},
+		{ref: "s3:" + hash, wantBackend: "s3"},
+		{ref: "blob:" + hash, wantBackend: "blob"},
+		{ref: hash, wantErr: true},
+		{ref: "fs:" + hash[:63], wantErr: true},
+		{ref: "fs:" + strings.Repeat("z", 64), wantErr: true},
+		{ref: "fs:../../" + hash[:58], wantErr: true},
+	}
+	for _, tt := range tests {
+		backend, h, err := splitHTMLRef(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitHTMLRef(%q) err = %v, want error %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (backend != tt.wantBackend || h != hash) {
+			t.Errorf("splitHTMLRef(%q) = %q, %q", tt.ref, backend, h)
+		}
+	}
+}
+
+func TestNewHTMLStore(t *testing.T) {
+	tests := []struct {
+		cfg     HTMLStorageConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{cfg: HTMLStorageConfig{}, wantNil: true},
+		{cfg: HTMLStorageConfig{Backend: "db"}, wantNil: true},
+		{cfg: HTMLStorageConfig{Backend: "fs"}, wantErr: true},
+		{cfg: HTMLStorageConfig{Backend: "fs", FSDir: t.TempDir()}},
+		{cfg: HTMLStorageConfig{Backend: "s3"}, wantErr: true},
+		{cfg: HTMLStorageConfig{Backend: "s3", S3: S3StorageConfig{Endpoint: "http://minio:9000", Bucket: "html"}}},
+		{cfg: HTMLStorageConfig{Backend: "ftp"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		st, err := newHTMLStore(tt.cfg, nil)
+		if (err != nil) != tt.wantErr || (err == nil && (st == nil) != tt.wantNil) {
+			t.Errorf("newHTMLStore(%+v) = %v, %v", tt.cfg, st, err)
+		}
+	}
+}
+
+func TestFSHTMLStore(t *testing.T) {
+	dir := t.TempDir()
+	st, err := newHTMLStore(HTMLStorageConfig{Backend: "fs", FSDir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	html := []byte("<html><body>hello</body></html>")
+	hash := sha256Hex(string(html))
+	ref, err := st.Put(ctx, hash, html)
+	if err != nil || ref != "fs:"+hash {
+		t.Fatalf("Put = %q, %v", ref, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hash[:2], hash+".html")); err != nil {
+		t.Fatalf("object not stored under its sharded key: %v", err)
+	}
+	// content-addressed: storing the same hash again keeps the first object
+	if ref2, err := st.Put(ctx, hash, []byte("other")); err != nil || ref2 != ref {
+		t.Fatalf("second Put = %q, %v", ref2, err)
+	}
+	got, err := st.Get(ctx, ref)
+	if err != nil || string(got) != string(html) {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if _, err := st.Get(ctx, "fs:"+sha256Hex("missing")); !errors.Is(err, errHTMLNotFound) {
+		t.Errorf("Get of a missing object: err = %v, want errHTMLNotFound", err)
+	}
+	if _, err := st.Put(ctx, "../escape", html); err == nil {
+		t.Error("Put with an invalid hash should fail")
+	}
+}
+
+// The same HTML stored for pages of two sites is one blob whose ref_count follows the
+// pages referencing it.
+func TestBlobHTMLStoreRefCount(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	ctx := context.Background()
+	st, err := newHTMLStore(HTMLStorageConfig{Backend: "blob"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := []byte("<html><body>mirrored " + t.Name() + "</body></html>")
+	hash := sha256Hex(string(html))
+	t.Cleanup(func() { _, _ = db.Exec(context.Background(), `DELETE FROM html_blobs WHERE html_hash = $1`, hash) })
+
+	refCount := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(ctx, `SELECT ref_count FROM html_blobs WHERE html_hash = $1`, hash).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	var pages []string
+	for _, site := range []string{"http://mirror-a.test/", "http://mirror-b.test/"} {
+		siteID := testSite(t, db, cfg, site)
+		ref, err := st.Put(ctx, hash, html)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordPageStatus(ctx, db, siteID, site+"index.html", 200); err != nil {
+			t.Fatal(err)
+		}
+		pageURL := site + "index.html"
+		if _, err := db.Exec(ctx, `UPDATE pages SET html_ref = $1 WHERE site_id = $2 AND url_hash = $3`, ref, siteID, sha256Hex(pageURL)); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, sha256Hex(pageURL))
+	}
+	var blobs int
+	if err := db.QueryRow(ctx, `SELECT count(*) FROM html_blobs WHERE html_hash = $1`, hash).Scan(&blobs); err != nil || blobs != 1 {
+		t.Fatalf("blobs for the hash = %d (%v), want 1", blobs, err)
+	}
+	if n := refCount(); n != 2 {
+		t.Fatalf("ref_count = %d after two pages, want 2", n)
+	}
+	if _, err := db.Exec(ctx, `UPDATE pages SET html_ref = NULL WHERE url_hash = $1`, pages[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(); n != 1 {
+		t.Fatalf("ref_count = %d after dropping one ref, want 1", n)
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM pages WHERE url_hash = $1`, pages[1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(); n != 0 {
+		t.Fatalf("ref_count = %d after deleting the last page, want 0", n)
+	}
+}
+
+// Reusing an orphaned blob restarts its grace period, so GC cannot delete it before the
+// page upsert that references it commits.
+func TestBlobHTMLStorePutRevivesOrphan(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	st, err := newHTMLStore(HTMLStorageConfig{Backend: "blob"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := []byte("<html><body>orphan " + t.Name() + "</body></html>")
+	hash := sha256Hex(string(html))
+	t.Cleanup(func() { _, _ = db.Exec(context.Background(), `DELETE FROM html_blobs WHERE html_hash = $1`, hash) })
+	if _, err := db.Exec(ctx, `INSERT INTO html_blobs (html_hash, html, created_at) VALUES ($1, $2, now() - interval '2 hours')`, hash, string(html)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Put(ctx, hash, html); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deleteOrphanHTMLBlobs(ctx, db, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(ctx, "blob:"+hash); err != nil {
+		t.Fatalf("revived blob: %v", err)
+	}
+	// untouched past the grace period, the orphan goes
+	if _, err := db.Exec(ctx, `UPDATE html_blobs SET created_at = now() - interval '2 hours' WHERE html_hash = $1`, hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deleteOrphanHTMLBlobs(ctx, db, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(ctx, "blob:"+hash); !errors.Is(err, errHTMLNotFound) {
+		t.Fatalf("orphan after gc: %v, want not found", err)
+	}
+}
+
+func TestHTMLBlobUsage(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	before, err := htmlBlobUsage(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := strings.Repeat("x", 1000)
+	hash := sha256Hex(t.Name())
+	t.Cleanup(func() { _, _ = db.Exec(context.Background(), `DELETE FROM html_blobs WHERE html_hash = $1`, hash) })
+	// one blob shared by three mirrored pages
+	if _, err := db.Exec(ctx, `INSERT INTO html_blobs (html_hash, html, ref_count) VALUES ($1, $2, 3)`, hash, html); err != nil {
+		t.Fatal(err)
+	}
+	after, err := htmlBlobUsage(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := htmlBlobStats{after.Blobs - before.Blobs, after.Refs - before.Refs, after.StoredBytes - before.StoredBytes, after.ReferencedBytes - before.ReferencedBytes}
+	if want := (htmlBlobStats{Blobs: 1, Refs: 3, StoredBytes: 1000, ReferencedBytes: 3000}); got != want {
+		t.Errorf("usage delta = %+v, want %+v", got, want)
+	}
+}
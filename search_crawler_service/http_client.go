@@ -1,13 +1,22 @@
 package main
 
 import (
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/andybalholm/brotli"
 )
 
 // buildHTTPClient builds HTTP client with optional proxy and sane defaults.
@@ -15,16 +24,19 @@ func buildHTTPClient(p *url.URL, timeout time.Duration) *http.Client {
 	tr := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		// Limits/timeouts (simple defaults)
-		MaxIdleConns:          64,
-		MaxConnsPerHost:       0,
-		IdleConnTimeout:       30 * time.Second,
-		DisableCompression:    false,
+		MaxIdleConns:    64,
+		MaxConnsPerHost: 0,
+		IdleConnTimeout: 30 * time.Second,
+		// decompression is done in fetchHTML so the decoded size can be capped
+		DisableCompression:    true,
 		ResponseHeaderTimeout: 10 * time.Second,
 	}
 	// If explicit proxy provided and supported scheme
 	if p != nil {
 		switch p.Scheme {
 		case "http", "https":
+			// credentials in p (URL userinfo or proxies.yaml username/password) are sent by the
+			// transport as Proxy-Authorization: Basic, on plain requests and on CONNECT
 			tr.Proxy = func(_ *http.Request) (*url.URL, error) { return p, nil }
 		default:
 			// socks5 not supported in MVP client; fall back to no proxy
@@ -38,45 +50,335 @@ func buildHTTPClient(p *url.URL, timeout time.Duration) *http.Client {
 	}
 }
 
-// fetchHTML performs a GET and returns status, content-type, and body (limited by maxBytes).
-func fetchHTML(ctx context.Context, client *http.Client, target string, maxBytes int, userAgent string) (status int, contentType string, html string, err error) {
+// fetchResult is the outcome of a single page fetch.
+type fetchResult struct {
+	Status      int
+	ContentType string
+	HTML        string // empty when streamed with KeepHTML=false
+	Header      http.Header
+	Size        int64         // body bytes read
+	BodyHash    string        // sha256 hex of the body
+	Parsed      *parsedPage   // set when the body was tokenized while reading
+	TTFB        time.Duration // request start -> response headers
+	Elapsed     time.Duration // request start -> body read
+	Redirects   []string      // URLs of the followed redirect hops, in order (last = final URL)
+	FinalURL    string        // URL of the response after redirects
+	NotModified bool          // 304 to a conditional request: no body, the stored page is current
+	Charset     string        // body encoding detected before transcoding to UTF-8 (see charset.go)
+	Truncated   bool          // body was cut at html_max_size (decoded bytes)
+}
+
+// redirectLoopError reports a redirect chain that came back to a URL it already visited.
+type redirectLoopError struct {
+	URL string
+}
+
+func (e *redirectLoopError) Error() string { return fmt.Sprintf("redirect loop at %s", e.URL) }
+
+// redirectLimitError reports a redirect chain longer than crawler.max_redirects.
+type redirectLimitError struct {
+	Max int
+}
+
+func (e *redirectLimitError) Error() string { return fmt.Sprintf("stopped after %d redirects", e.Max) }
+
+// redirectPolicy is a CheckRedirect func following at most max hops and stopping as soon as
+// a URL repeats; followed hop URLs are appended to hops.
+func redirectPolicy(max int, hops *[]string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		next := req.URL.String()
+		for _, prev := range via {
+			if prev.URL.String() == next {
+				return &redirectLoopError{URL: next}
+			}
+		}
+		if len(via) > max {
+			return &redirectLimitError{Max: max}
+		}
+		*hops = append(*hops, next)
+		return nil
+	}
+}
+
+// fetchOptions carries per-request fetch settings derived from config.
+type fetchOptions struct {
+	MaxBytes         int
+	UserAgent        string
+	MinContentLength int64 // 0 = no lower bound
+	MaxContentLength int64 // 0 = no upper bound (body is still capped by MaxBytes)
+	AcceptStatusMin  int
+	AcceptStatusMax  int
+	RedirectAsError  bool // unfollowed 3xx fails the fetch instead of skipping it
+	StreamParse      bool // tokenize while reading instead of buffering the body for regex extraction
+	KeepHTML         bool // materialize the raw body in fetchResult.HTML
+	// DecompressMargin is how far decoded gzip/deflate/br output may run past MaxBytes
+	// (read and discarded) before the fetch is aborted as a compression bomb; 0 = never.
+	DecompressMargin int64
+	// Validators of the stored page; set, they make the GET conditional.
+	IfNoneMatch     string
+	IfModifiedSince string
+	// Headers are crawler.headers, sent before the fields above (which win on conflict).
+	Headers map[string]string
+}
+
+func newFetchOptions(cfg Config) fetchOptions {
+	return fetchOptions{
+		MaxBytes:         int(cfg.Crawler.HTMLMaxSize.Bytes),
+		UserAgent:        cfg.Crawler.UserAgent,
+		MinContentLength: cfg.Crawler.MinContentLength.Bytes,
+		MaxContentLength: cfg.Crawler.MaxContentLength.Bytes,
+		AcceptStatusMin:  nonZero(cfg.Crawler.AcceptStatusMin, 200),
+		AcceptStatusMax:  nonZero(cfg.Crawler.AcceptStatusMax, 399),
+		RedirectAsError:  cfg.Crawler.UnfollowedRedirect == "error",
+		StreamParse:      cfg.Crawler.StreamParse,
+		KeepHTML:         !cfg.Crawler.StreamParse || !cfg.Crawler.DiscardHTML || cfg.Crawler.MainContent,
+		DecompressMargin: cfg.Crawler.DecompressMargin.Bytes,
+		Headers:          cfg.Crawler.Headers,
+	}
+}
+
+// setRequestHeaders applies the configured extra headers, then User-Agent and
+// Accept-Encoding: the body decoder only understands acceptEncoding.
+func setRequestHeaders(req *http.Request, opts fetchOptions) {
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+}
+
+// skipError marks a fetch that was intentionally not processed (not a failure, no retry).
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string { return "skipped: " + e.reason }
+
+// statusError reports a response whose status is outside the accepted range.
+type statusError struct {
+	Status int
+	// RetryAfter is the delay a 429/503 asked for in its Retry-After header (0 = none).
+	RetryAfter time.Duration
+}
+
+// parseRetryAfter reads a Retry-After value: delay-seconds or an HTTP-date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(min(secs, int64(365*24*3600))) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("http status %d", e.Status) }
+
+// bodyReadError reports a response whose body could not be read to the end (e.g. a proxy
+// dropping the connection mid-body: unexpected EOF). Unlike statusError, re-issuing the
+// request may well succeed.
+type bodyReadError struct {
+	Err error
+}
+
+func (e *bodyReadError) Error() string { return "read body: " + e.Err.Error() }
+func (e *bodyReadError) Unwrap() error { return e.Err }
+
+// bombError reports a compressed body whose decoded size exceeded the cap.
+type bombError struct {
+	Limit int64
+}
+
+func (e *bombError) Error() string {
+	return fmt.Sprintf("decompressed body exceeds %d bytes (compression bomb?)", e.Limit)
+}
+
+// acceptEncoding lists the content codings decodeBody handles.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody wraps body with a decoder for the response Content-Encoding.
+// ok=false means the body is not compressed.
+func decodeBody(body io.Reader, encoding string) (r io.Reader, ok bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(body)
+	case "deflate":
+		r, err = zlib.NewReader(body)
+	case "br":
+		r = brotli.NewReader(body)
+	default:
+		return body, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+	return r, true, nil
+}
+
+// fetchHTML performs a GET and returns status, content-type, headers and body (limited by MaxBytes).
+func fetchHTML(ctx context.Context, client *http.Client, target string, opts fetchOptions) (fetchResult, error) {
+	var res fetchResult
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
 	if err != nil {
-		return 0, "", "", err
+		return res, err
+	}
+	setRequestHeaders(req, opts)
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
 	}
-	if userAgent != "" {
-		req.Header.Set("User-Agent", userAgent)
+	if opts.IfModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", opts.IfModifiedSince)
 	}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, "", "", err
+		return res, err
 	}
 	defer resp.Body.Close()
+	res.TTFB = time.Since(start)
+	res.FinalURL = resp.Request.URL.String()
 
-	status = resp.StatusCode
-	if status < 200 || status >= 400 {
-		return status, resp.Header.Get("Content-Type"), "", fmt.Errorf("http status %d", status)
+	res.Status = resp.StatusCode
+	res.ContentType = resp.Header.Get("Content-Type")
+	res.Header = resp.Header
+	if res.Status == http.StatusNotModified && (opts.IfNoneMatch != "" || opts.IfModifiedSince != "") {
+		res.NotModified = true
+		return res, nil
+	}
+	if res.Status < opts.AcceptStatusMin || res.Status > opts.AcceptStatusMax {
+		stErr := &statusError{Status: res.Status}
+		if res.Status == http.StatusTooManyRequests || res.Status == http.StatusServiceUnavailable {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				stErr.RetryAfter = max(d, time.Second)
+			}
+		}
+		return res, stErr
 	}
-	contentType = resp.Header.Get("Content-Type")
-	// limit body
-	lim := io.LimitedReader{R: resp.Body, N: int64(maxBytes)}
-	buf, err := io.ReadAll(&lim)
+	// The client follows redirects itself, so a 3xx reaching here has no usable Location
+	// (or is a stray 304): there is no content to store.
+	if res.Status >= 300 && res.Status < 400 {
+		msg := fmt.Sprintf("unfollowed redirect: status %d", res.Status)
+		if loc := resp.Header.Get("Location"); loc != "" {
+			msg += fmt.Sprintf(" (location %q)", loc)
+		}
+		if opts.RedirectAsError {
+			return res, errors.New(msg)
+		}
+		return res, &skipError{reason: msg}
+	}
+	// Declared Content-Length outside the accepted range: don't download the body.
+	// Chunked responses report -1 and fall through to the MaxBytes limit below.
+	if cl := resp.ContentLength; cl >= 0 {
+		if cl < opts.MinContentLength {
+			return res, &skipError{reason: fmt.Sprintf("content-length %d below min %d", cl, opts.MinContentLength)}
+		}
+		if opts.MaxContentLength > 0 && cl > opts.MaxContentLength {
+			return res, &skipError{reason: fmt.Sprintf("content-length %d above max %d", cl, opts.MaxContentLength)}
+		}
+	}
+	// Decode gzip/deflate/br ourselves. Plain and decoded bodies alike are cut at MaxBytes;
+	// decoded output running on past MaxBytes + DecompressMargin aborts the fetch.
+	// bandwidth is metered on the wire bytes (crawler.max_bytes_per_second)
+	raw, compressed, err := decodeBody(throttleBody(ctx, resp.Body), resp.Header.Get("Content-Encoding"))
 	if err != nil {
-		return status, contentType, "", err
+		return res, err
+	}
+	limit := int64(opts.MaxBytes)
+	// limit body; hash and count while reading so the streaming path never needs the full string
+	lim := &io.LimitedReader{R: raw, N: limit}
+	hasher := sha256.New()
+	body, charsetName := utf8Body(io.TeeReader(lim, hasher), res.ContentType)
+	res.Charset = charsetName
+	var buf strings.Builder
+	if opts.KeepHTML {
+		body = io.TeeReader(body, &buf)
+	}
+	if opts.StreamParse {
+		p, err := parseHTMLStream(body)
+		if err != nil {
+			return res, &bodyReadError{Err: err}
+		}
+		res.Parsed = &p
+	} else if _, err := io.Copy(io.Discard, body); err != nil {
+		return res, &bodyReadError{Err: err}
+	}
+	res.Size = limit - lim.N
+	// cut at the limit: the page is kept, marked truncated when more data followed
+	if lim.N == 0 {
+		var one [1]byte
+		if _, err := io.ReadFull(raw, one[:]); err == nil {
+			res.Truncated = true
+			// past the margin as well (one byte already read): a compression bomb
+			if m := opts.DecompressMargin; compressed && m > 0 {
+				if n, _ := io.CopyN(io.Discard, raw, m); n == m {
+					res.Elapsed = time.Since(start)
+					return res, &bombError{Limit: limit + m}
+				}
+			}
+		}
+	}
+	res.Elapsed = time.Since(start)
+	if res.Size < opts.MinContentLength {
+		return res, &skipError{reason: fmt.Sprintf("body %d bytes below min %d", res.Size, opts.MinContentLength)}
+	}
+	res.HTML = buf.String()
+	res.BodyHash = hex.EncodeToString(hasher.Sum(nil))
+	return res, nil
+}
+
+// selectHeaders picks allowlisted response headers (canonical names) for storage; nil when none match.
+func selectHeaders(h http.Header, allow []string) map[string]string {
+	var out map[string]string
+	for _, name := range allow {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if v := h.Values(name); len(v) > 0 {
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[name] = strings.Join(v, ", ")
+		}
 	}
-	// if truncated (N==0 and more data), we treat as ok since size limit reached
-	return status, contentType, string(buf), nil
+	return out
 }
 
-// isAllowedContentType checks whether ctype belongs to allowed list (prefix match).
+// isAllowedContentType checks whether ctype belongs to the allowed list. An entry is a
+// prefix ("text/html" also matches "text/html; charset=utf-8"), a glob over the media type
+// ("text/*", "application/*+xml") or a structured syntax suffix ("+xml" matches
+// "application/xhtml+xml"); all case-insensitive.
 func isAllowedContentType(ctype string, allow []string) bool {
 	if len(allow) == 0 {
 		return true
 	}
 	// normalize
 	ct := strings.ToLower(strings.TrimSpace(ctype))
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.TrimSpace(mt)
 	for _, a := range allow {
-		if strings.HasPrefix(ct, strings.ToLower(strings.TrimSpace(a))) {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch {
+		case a == "":
+			continue
+		case strings.HasPrefix(a, "+"):
+			if strings.HasSuffix(mt, a) && strings.Contains(mt, "/") {
+				return true
+			}
+		case strings.Contains(a, "*"):
+			if ok, _ := path.Match(a, mt); ok {
+				return true
+			}
+		case strings.HasPrefix(ct, a):
 			return true
 		}
 	}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestIsAllowedContentType(t *testing.T) {
+	tests := []struct {
+		ctype string
+		allow []string
+		want  bool
+	}{
+		{"text/html", nil, true},
+		{"text/html; charset=utf-8", []string{"text/html"}, true},
+		{"TEXT/HTML", []string{"text/html"}, true},
+		{"application/pdf", []string{"text/html"}, false},
+		{"text/plain", []string{"text/*"}, true},
+		{"text/plain; charset=koi8-r", []string{"Text/*"}, true},
+		{"application/xhtml+xml", []string{"text/*"}, false},
+		{"application/xhtml+xml", []string{"+xml"}, true},
+		{"application/atom+xml; charset=utf-8", []string{"application/*+xml"}, true},
+		{"application/json", []string{"application/*+xml"}, false},
+		{"application/ld+json", []string{"+xml"}, false},
+		{"xml", []string{"+xml"}, false},
+		{"image/png", []string{"*/*"}, true},
+		{"", []string{"text/*"}, false},
+		{"text/html", []string{" ", "text/html"}, true},
+		// plain entries stay prefix matches
+		{"text/html", []string{"text/"}, true},
+		{"application/xhtml+xml", []string{"application/xhtml"}, true},
+		{"application/pdf", []string{"text/html", "application/pdf"}, true},
+		{"image/png", []string{"text/", "+xml"}, false},
+	}
+	for _, tt := range tests {
+		if got := isAllowedContentType(tt.ctype, tt.allow); got != tt.want {
+			t.Errorf("isAllowedContentType(%q, %q) = %v, want %v", tt.ctype, tt.allow, got, tt.want)
+		}
+	}
+}
+
+// A page of a non-HTML type allowed by content_types is stored like an HTML page.
+func TestProcessURLStoresAllowedNonHTMLType(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xhtml+xml; charset=utf-8")
+		_, _ = w.Write([]byte(`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>XHTML page</title></head><body><p>Some text</p></body></html>`))
+	}))
+	defer srv.Close()
+
+	cfg := testCrawlConfig()
+	cfg.Crawler.ContentTypes = []string{"text/html", "application/*+xml"}
+	siteID := testSite(t, db, cfg, srv.URL)
+	ctx := context.Background()
+	pageURL := srv.URL + "/doc.xhtml"
+	pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, pageURL, 0)
+	if err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	p, ok, err := getPageByHash(ctx, db, siteID, sha256Hex(pageURL))
+	if err != nil || !ok {
+		t.Fatalf("stored page not found: ok=%v err=%v", ok, err)
+	}
+	if p.ID != pageID || p.ContentType != "application/xhtml+xml; charset=utf-8" || p.Title != "XHTML page" {
+		t.Errorf("stored page = id %d, content type %q, title %q", p.ID, p.ContentType, p.Title)
+	}
+}
+
+// fetchFrom serves h on a test server and fetches its root with opts.
+func fetchFrom(t *testing.T, h http.HandlerFunc, opts fetchOptions) (fetchResult, error) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = 1 << 20
+	}
+	if opts.AcceptStatusMin == 0 {
+		opts.AcceptStatusMin, opts.AcceptStatusMax = 200, 399
+	}
+	opts.KeepHTML = true
+	return fetchHTML(context.Background(), srv.Client(), srv.URL+"/", opts)
+}
+
+func TestFetchHTMLContentLengthBounds(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	tests := []struct {
+		name     string
+		body     string
+		chunked  bool
+		min, max int64
+		wantSkip bool
+	}{
+		{name: "no bounds", body: body},
+		{name: "within bounds", body: body, min: 10, max: 1000},
+		{name: "below min", body: body, min: 101, wantSkip: true},
+		{name: "above max", body: body, max: 99, wantSkip: true},
+		{name: "at max", body: body, max: 100},
+		{name: "empty body", body: "", min: 1, wantSkip: true},
+		{name: "chunked above max is read", body: body, chunked: true, max: 10},
+		{name: "chunked empty body", body: "", chunked: true, min: 1, wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				if tt.chunked {
+					w.(http.Flusher).Flush() // no Content-Length
+				}
+				_, _ = io.WriteString(w, tt.body)
+			}, fetchOptions{MinContentLength: tt.min, MaxContentLength: tt.max})
+			var skip *skipError
+			if got := errors.As(err, &skip); got != tt.wantSkip {
+				t.Fatalf("err = %v, want skip %v", err, tt.wantSkip)
+			}
+			if !tt.wantSkip && (err != nil || res.HTML != tt.body) {
+				t.Fatalf("err = %v, body %d bytes, want %d", err, len(res.HTML), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestFetchHTMLAcceptStatusRange(t *testing.T) {
+	tests := []struct {
+		status   int
+		min, max int
+		wantErr  bool
+	}{
+		{status: 200, min: 200, max: 399},
+		{status: 404, min: 200, max: 399, wantErr: true},
+		{status: 404, min: 200, max: 499},
+		{status: 410, min: 200, max: 404, wantErr: true},
+		{status: 500, min: 200, max: 599},
+		{status: 204, min: 300, max: 399, wantErr: true},
+	}
+	for _, tt := range tests {
+		res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = io.WriteString(w, "<html><title>x</title></html>")
+		}, fetchOptions{AcceptStatusMin: tt.min, AcceptStatusMax: tt.max})
+		var stErr *statusError
+		if got := errors.As(err, &stErr); got != tt.wantErr {
+			t.Errorf("status %d in %d..%d: err = %v, want status error %v", tt.status, tt.min, tt.max, err, tt.wantErr)
+			continue
+		}
+		if res.Status != tt.status || (stErr != nil && stErr.Status != tt.status) {
+			t.Errorf("status %d: result status %d", tt.status, res.Status)
+		}
+	}
+}
+
+// gzipped returns s compressed with gzip.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// serveEncoded answers with body as is under the given Content-Encoding.
+func serveEncoded(encoding string, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", encoding)
+		_, _ = w.Write(body)
+	}
+}
+
+func TestFetchHTMLCompressionBomb(t *testing.T) {
+	const maxBytes = 64 << 10
+	bomb := gzipped(t, strings.Repeat("\x00", 16<<20)) // 16 MiB of zeros
+	if len(bomb) > 64<<10 {
+		t.Fatalf("payload not high-ratio: %d bytes", len(bomb))
+	}
+	tests := []struct {
+		name                    string
+		body                    string
+		margin                  int64
+		wantBomb, wantTruncated bool
+	}{
+		{name: "bomb", body: strings.Repeat("\x00", 16<<20), margin: 1 << 20, wantBomb: true},
+		{name: "no margin truncates", body: strings.Repeat("\x00", 16<<20), wantTruncated: true},
+		{name: "beyond the margin", body: strings.Repeat("a", maxBytes+1024), margin: 512, wantBomb: true},
+		{name: "one byte past the margin", body: strings.Repeat("a", maxBytes+513), margin: 512, wantBomb: true},
+		{name: "at the margin", body: strings.Repeat("a", maxBytes+512), margin: 512, wantTruncated: true},
+		{name: "within the margin", body: strings.Repeat("a", maxBytes+1024), margin: 2048, wantTruncated: true},
+		{name: "exactly at the cap", body: strings.Repeat("a", maxBytes), margin: 512},
+		{name: "small page", body: "<html><title>ok</title></html>", margin: 512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := fetchFrom(t, serveEncoded("gzip", gzipped(t, tt.body)),
+				fetchOptions{MaxBytes: maxBytes, DecompressMargin: tt.margin})
+			var be *bombError
+			if got := errors.As(err, &be); got != tt.wantBomb {
+				t.Fatalf("err = %v, want bomb error %v", err, tt.wantBomb)
+			}
+			if tt.wantBomb {
+				return
+			}
+			// decoded bodies are cut at the cap like plain ones, never above it
+			want := tt.body[:min(len(tt.body), maxBytes)]
+			if err != nil || res.HTML != want || res.Truncated != tt.wantTruncated {
+				t.Errorf("err = %v, decoded %d bytes truncated=%v; want %d truncated=%v",
+					err, len(res.HTML), res.Truncated, len(want), tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestFetchHTMLUnfollowedRedirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		location  string
+		asError   bool
+		wantHTML  string
+		wantSkip  bool
+		wantError bool
+	}{
+		{name: "followed", status: http.StatusFound, location: "/final", wantHTML: "<p>final</p>"},
+		{name: "301 followed", status: http.StatusMovedPermanently, location: "/final", wantHTML: "<p>final</p>"},
+		{name: "no location", status: http.StatusFound, wantSkip: true},
+		{name: "no location as error", status: http.StatusFound, asError: true, wantError: true},
+		{name: "stray 304", status: http.StatusNotModified, wantSkip: true},
+		{name: "300 with location", status: http.StatusMultipleChoices, location: "/final", wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/final" {
+					w.Header().Set("Content-Type", "text/html")
+					_, _ = io.WriteString(w, "<p>final</p>")
+					return
+				}
+				if tt.location != "" {
+					w.Header().Set("Location", tt.location)
+				}
+				w.WriteHeader(tt.status)
+			}, fetchOptions{RedirectAsError: tt.asError})
+			var se *skipError
+			isSkip := errors.As(err, &se)
+			switch {
+			case tt.wantSkip:
+				if !isSkip {
+					t.Fatalf("err = %v, want skip", err)
+				}
+			case tt.wantError:
+				if err == nil || isSkip {
+					t.Fatalf("err = %v, want a retryable error", err)
+				}
+			default:
+				if err != nil || res.HTML != tt.wantHTML {
+					t.Fatalf("html = %q, err = %v; want %q", res.HTML, err, tt.wantHTML)
+				}
+			}
+		})
+	}
+}
+
+// TTFB covers the wait for headers, Elapsed the body read as well; Size counts body bytes.
+func TestFetchHTMLTimings(t *testing.T) {
+	const headerDelay, bodyDelay = 40 * time.Millisecond, 40 * time.Millisecond
+	res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(headerDelay)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><body>")
+		w.(http.Flusher).Flush()
+		time.Sleep(bodyDelay)
+		_, _ = io.WriteString(w, "done</body></html>")
+	}, fetchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TTFB < headerDelay {
+		t.Errorf("TTFB = %v, want >= %v", res.TTFB, headerDelay)
+	}
+	if res.Elapsed < res.TTFB+bodyDelay {
+		t.Errorf("Elapsed = %v, want >= TTFB (%v) + %v", res.Elapsed, res.TTFB, bodyDelay)
+	}
+	if want := int64(len("<html><body>done</body></html>")); res.Size != want {
+		t.Errorf("Size = %d, want %d", res.Size, want)
+	}
+}
+
+// truncatingHandler declares the full body but the first `truncate` responses stop midway.
+func truncatingHandler(body string, truncate int32) (http.HandlerFunc, *atomic.Int32) {
+	var calls atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if calls.Add(1) <= truncate {
+			_, _ = io.WriteString(w, body[:len(body)/2])
+			panic(http.ErrAbortHandler) // drop the connection mid-body
+		}
+		_, _ = io.WriteString(w, body)
+	}, &calls
+}
+
+func TestFetchHTMLBodyReadError(t *testing.T) {
+	h, _ := truncatingHandler("<html><body>"+strings.Repeat("x", 4096)+"</body></html>", 1)
+	_, err := fetchFrom(t, h, fetchOptions{})
+	if !isBodyReadError(err) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v (%T), want a body read error wrapping unexpected EOF", err, err)
+	}
+	if isBodyReadError(&statusError{Status: 502}) || isBodyReadError(nil) {
+		t.Error("status errors are not body read errors")
+	}
+	if got := fetchErrorClass(err); got != errClassBody {
+		t.Errorf("error class = %q, want %q", got, errClassBody)
+	}
+}
+
+// chainHandler redirects /hop/N to /hop/N+1 up to /hop/last, which serves a page;
+// loopAt > 0 sends /hop/loopAt back to /hop/1.
+func chainHandler(last, loopAt int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		switch {
+		case n == loopAt:
+			http.Redirect(w, r, "/hop/1", http.StatusFound)
+		case n < last:
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(n+1), http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body>end of chain</body></html>")
+		}
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		last      int
+		loopAt    int
+		max       int
+		wantLoop  bool
+		wantLimit bool
+		wantHops  int
+	}{
+		{name: "short chain", last: 3, max: 5, wantHops: 3},
+		{name: "chain at limit", last: 5, max: 5, wantHops: 5},
+		{name: "long chain", last: 20, max: 5, wantLimit: true, wantHops: 5},
+		{name: "loop", last: 20, loopAt: 3, max: 10, wantLoop: true, wantHops: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(chainHandler(tt.last, tt.loopAt))
+			defer srv.Close()
+			var hops []string
+			client := srv.Client()
+			client.CheckRedirect = redirectPolicy(tt.max, &hops)
+			res, err := fetchHTML(context.Background(), client, srv.URL+"/hop/0", fetchOptions{
+				MaxBytes: 1 << 20, AcceptStatusMin: 200, AcceptStatusMax: 399, KeepHTML: true,
+			})
+			var loopErr *redirectLoopError
+			var limitErr *redirectLimitError
+			if got := errors.As(err, &loopErr); got != tt.wantLoop {
+				t.Errorf("loop error = %v, want %v (err %v)", got, tt.wantLoop, err)
+			}
+			if got := errors.As(err, &limitErr); got != tt.wantLimit {
+				t.Errorf("limit error = %v, want %v (err %v)", got, tt.wantLimit, err)
+			}
+			if !tt.wantLoop && !tt.wantLimit {
+				if err != nil {
+					t.Fatalf("fetchHTML: %v", err)
+				}
+				if !strings.Contains(res.HTML, "end of chain") {
+					t.Errorf("body = %q, want final page", res.HTML)
+				}
+			}
+			if len(hops) != tt.wantHops {
+				t.Fatalf("hops = %v, want %d", hops, tt.wantHops)
+			}
+			for i, h := range hops {
+				if want := srv.URL + "/hop/" + strconv.Itoa(i+1); h != want {
+					t.Errorf("hop %d = %s, want %s", i, h, want)
+				}
+			}
+			if class := fetchErrorClass(err); (tt.wantLoop || tt.wantLimit) && class != errClassRedirect {
+				t.Errorf("fetchErrorClass = %q, want %q", class, errClassRedirect)
+			}
+		})
+	}
+}
+
+// etagHandler serves a page with ETag "v1" and Last-Modified lastMod, answering matching
+// conditional requests with 304.
+func etagHandler(lastMod string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastMod)
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			if inm == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else if r.Header.Get("If-Modified-Since") == lastMod {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head><title>v1</title></head><body>current</body></html>")
+	}
+}
+
+func TestFetchHTMLConditional(t *testing.T) {
+	const lastMod = "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name            string
+		etag, modSince  string
+		wantNotModified bool
+	}{
+		{name: "unconditional"},
+		{name: "etag match", etag: `"v1"`, wantNotModified: true},
+		{name: "etag changed", etag: `"v0"`},
+		{name: "last-modified match", modSince: lastMod, wantNotModified: true},
+		{name: "last-modified changed", modSince: "Sun, 01 Jan 2006 00:00:00 GMT"},
+		{name: "etag wins over last-modified", etag: `"v0"`, modSince: lastMod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := fetchFrom(t, etagHandler(lastMod), fetchOptions{IfNoneMatch: tt.etag, IfModifiedSince: tt.modSince})
+			if err != nil {
+				t.Fatalf("fetchHTML: %v", err)
+			}
+			if res.NotModified != tt.wantNotModified {
+				t.Errorf("NotModified = %v, want %v", res.NotModified, tt.wantNotModified)
+			}
+			if tt.wantNotModified && res.HTML != "" {
+				t.Errorf("304 carried a body: %q", res.HTML)
+			}
+			if !tt.wantNotModified && !strings.Contains(res.HTML, "current") {
+				t.Errorf("body = %q, want the page", res.HTML)
+			}
+			if got := res.Header.Get("ETag"); got != `"v1"` {
+				t.Errorf("ETag header = %q, want surfaced to the caller", got)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-5", 0, false},
+		{"99999999999", 365 * 24 * time.Hour, true},
+		{"Sun, 01 Mar 2026 12:05:00 GMT", 5 * time.Minute, true},
+		{"Sunday, 01-Mar-26 12:00:30 GMT", 30 * time.Second, true},
+		{"Sun Mar  1 12:01:00 2026", time.Minute, true},
+		{"Sun, 01 Mar 2026 11:00:00 GMT", 0, true}, // already past
+		{"soon", 0, false},
+		{"1.5", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.v, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.v, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFetchHTMLRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       time.Duration
+	}{
+		{name: "429 seconds", status: http.StatusTooManyRequests, retryAfter: "30", want: 30 * time.Second},
+		{name: "503 seconds", status: http.StatusServiceUnavailable, retryAfter: "90", want: 90 * time.Second},
+		{name: "429 date", status: http.StatusTooManyRequests, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), want: time.Hour},
+		{name: "zero raised to a second", status: http.StatusTooManyRequests, retryAfter: "0", want: time.Second},
+		{name: "429 without header", status: http.StatusTooManyRequests},
+		{name: "429 invalid header", status: http.StatusTooManyRequests, retryAfter: "later"},
+		{name: "500 header ignored", status: http.StatusInternalServerError, retryAfter: "30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+			}, fetchOptions{})
+			var stErr *statusError
+			if !errors.As(err, &stErr) || stErr.Status != tt.status {
+				t.Fatalf("err = %v, want status %d", err, tt.status)
+			}
+			// HTTP dates have second precision
+			if d := stErr.RetryAfter - tt.want; d < -2*time.Second || d > 0 || (tt.want == 0) != (stErr.RetryAfter == 0) {
+				t.Errorf("RetryAfter = %v, want %v", stErr.RetryAfter, tt.want)
+			}
+		})
+	}
+}
+
+// encoded compresses s with the given Content-Encoding ("" or identity: as is).
+func encoded(t *testing.T, encoding, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzipped(t, s)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "br":
+		w = brotli.NewWriter(&b)
+	default:
+		return []byte(s)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	const page = "<html><title>encoded</title></html>"
+	tests := []struct {
+		name, header, encodeAs string
+		wantDecoded, wantErr   bool
+	}{
+		{name: "gzip", header: "gzip", encodeAs: "gzip", wantDecoded: true},
+		{name: "x-gzip", header: "x-gzip", encodeAs: "gzip", wantDecoded: true},
+		{name: "deflate", header: "deflate", encodeAs: "deflate", wantDecoded: true},
+		{name: "brotli", header: "br", encodeAs: "br", wantDecoded: true},
+		{name: "case and spaces", header: " GZIP ", encodeAs: "gzip", wantDecoded: true},
+		{name: "identity", header: "identity"},
+		{name: "none", header: ""},
+		{name: "unknown passes through", header: "zstd"},
+		{name: "corrupt gzip", header: "gzip", encodeAs: "", wantErr: true},
+		{name: "corrupt deflate", header: "deflate", encodeAs: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, decoded, err := decodeBody(bytes.NewReader(encoded(t, tt.encodeAs, page)), tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if decoded != tt.wantDecoded {
+				t.Errorf("decoded = %v, want %v", decoded, tt.wantDecoded)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil || string(b) != page {
+				t.Errorf("body = %q, %v; want %q", b, err, page)
+			}
+		})
+	}
+}
+
+// Every accepted encoding is requested, decoded and cut at html_max_size after decoding.
+func TestFetchHTMLEncodings(t *testing.T) {
+	const maxBytes = 4 << 10
+	small := "<html><title>small</title></html>"
+	large := strings.Repeat("abcdefgh", maxBytes) // 8x the cap, compresses well
+	for _, enc := range []string{"identity", "gzip", "deflate", "br"} {
+		t.Run(enc, func(t *testing.T) {
+			var gotAccept string
+			serve := func(body string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					gotAccept = r.Header.Get("Accept-Encoding")
+					if enc != "identity" {
+						w.Header().Set("Content-Encoding", enc)
+					}
+					w.Header().Set("Content-Type", "text/html")
+					_, _ = w.Write(encoded(t, enc, body))
+				}
+			}
+			res, err := fetchFrom(t, serve(small), fetchOptions{MaxBytes: maxBytes})
+			if err != nil || res.HTML != small || res.Truncated {
+				t.Fatalf("small page: html=%q truncated=%v err=%v", res.HTML, res.Truncated, err)
+			}
+			if gotAccept != acceptEncoding {
+				t.Errorf("Accept-Encoding = %q, want %q", gotAccept, acceptEncoding)
+			}
+			// with no margin, large decoded bodies are kept cut at the cap like plain ones
+			res, err = fetchFrom(t, serve(large), fetchOptions{MaxBytes: maxBytes})
+			if err != nil || res.HTML != large[:maxBytes] || !res.Truncated {
+				t.Errorf("large page: %d bytes truncated=%v err=%v, want %d truncated", len(res.HTML), res.Truncated, err, maxBytes)
+			}
+		})
+	}
+}
+
+func TestFetchHTMLTruncated(t *testing.T) {
+	const maxBytes = 1 << 10
+	tests := []struct {
+		name          string
+		size          int
+		wantTruncated bool
+	}{
+		{name: "under the cap", size: maxBytes - 1},
+		{name: "exactly the cap", size: maxBytes},
+		{name: "one byte over", size: maxBytes + 1, wantTruncated: true},
+		{name: "far over", size: 4 * maxBytes, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+			res, err := fetchFrom(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = io.WriteString(w, body)
+			}, fetchOptions{MaxBytes: maxBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", res.Truncated, tt.wantTruncated)
+			}
+			if want := min(tt.size, maxBytes); len(res.HTML) != want {
+				t.Errorf("len(HTML) = %d, want %d", len(res.HTML), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Scheme upgrade ---
+//
+// With crawler.prefer_https (or site_prefer_https[domain]), in-scope http:// links are
+// enqueued as https:// once the host is known to serve https: the page they were found on
+// was fetched over https from that host, or a page of the host was stored under https.
+// Both forms then hash to the same queue/page entry instead of being crawled twice.
+// Links with an explicit non-default port are left alone.
+
+// preferHTTPS reports whether http links of the site should be upgraded.
+func (c CrawlerConfig) preferHTTPS(siteDomain string) bool {
+	if v, ok := c.SitePreferHTTPS[siteDomain]; ok {
+		return v
+	}
+	return c.PreferHTTPS
+}
+
+// httpsNegativeTTL is how long "no https page seen for this host" is trusted before re-checking.
+const httpsNegativeTTL = 10 * time.Minute
+
+var httpsHosts = struct {
+	sync.Mutex
+	known map[string]time.Time // host -> zero time: serves https; else: not seen as of then
+}{known: make(map[string]time.Time)}
+
+// hostServesHTTPS reports whether host is known to serve https; from is the page the link was found on.
+func hostServesHTTPS(ctx context.Context, db *pgxpool.Pool, siteID int64, host string, from *url.URL) bool {
+	httpsHosts.Lock()
+	checked, ok := httpsHosts.known[host]
+	httpsHosts.Unlock()
+	if ok && (checked.IsZero() || time.Since(checked) < httpsNegativeTTL) {
+		return checked.IsZero()
+	}
+	serves := from != nil && from.Scheme == "https" && normalizeHost(from.Host) == host
+	if !serves && db != nil {
+		const q = `
+SELECT EXISTS (
+  SELECT 1 FROM pages
+  WHERE site_id = $1 AND url LIKE 'https://' || $2 || '/%' AND http_status BETWEEN 200 AND 399
+)`
+		_ = db.QueryRow(ctx, q, siteID, host).Scan(&serves)
+	}
+	httpsHosts.Lock()
+	if serves {
+		httpsHosts.known[host] = time.Time{}
+	} else {
+		httpsHosts.known[host] = time.Now()
+	}
+	httpsHosts.Unlock()
+	return serves
+}
+
+// upgradeScheme switches a canonical in-scope http link to https when the site prefers it
+// and its host serves https.
+func upgradeScheme(ctx context.Context, db *pgxpool.Pool, c CrawlerConfig, siteID int64, siteDomain string, from, link *url.URL) {
+	if link.Scheme != "http" || link.Port() != "" || !c.preferHTTPS(siteDomain) {
+		return
+	}
+	if hostServesHTTPS(ctx, db, siteID, normalizeHost(link.Host), from) {
+		link.Scheme = "https"
+	}
+}
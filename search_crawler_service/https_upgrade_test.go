@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// resetHTTPSHosts gives the test an empty https host cache.
+func resetHTTPSHosts(t *testing.T) {
+	t.Helper()
+	httpsHosts.Lock()
+	prev := httpsHosts.known
+	httpsHosts.known = make(map[string]time.Time)
+	httpsHosts.Unlock()
+	t.Cleanup(func() {
+		httpsHosts.Lock()
+		httpsHosts.known = prev
+		httpsHosts.Unlock()
+	})
+}
+
+func TestPreferHTTPS(t *testing.T) {
+	c := CrawlerConfig{PreferHTTPS: true, SitePreferHTTPS: map[string]bool{"legacy.test": false}}
+	if !c.preferHTTPS("site.test") || c.preferHTTPS("legacy.test") {
+		t.Errorf("global on: site.test=%v legacy.test=%v", c.preferHTTPS("site.test"), c.preferHTTPS("legacy.test"))
+	}
+	c = CrawlerConfig{SitePreferHTTPS: map[string]bool{"secure.test": true}}
+	if c.preferHTTPS("site.test") || !c.preferHTTPS("secure.test") {
+		t.Errorf("global off: site.test=%v secure.test=%v", c.preferHTTPS("site.test"), c.preferHTTPS("secure.test"))
+	}
+}
+
+func TestUpgradeScheme(t *testing.T) {
+	on := CrawlerConfig{PreferHTTPS: true}
+	tests := []struct {
+		name  string
+		cfg   CrawlerConfig
+		from  string
+		link  string
+		known map[string]time.Time
+		want  string
+	}{
+		{name: "found on https page of the host", cfg: on, from: "https://site.test/", link: "http://site.test/a", want: "https://site.test/a"},
+		{name: "disabled", from: "https://site.test/", link: "http://site.test/a", want: "http://site.test/a"},
+		{name: "site override off", cfg: CrawlerConfig{PreferHTTPS: true, SitePreferHTTPS: map[string]bool{"site.test": false}}, from: "https://site.test/", link: "http://site.test/a", want: "http://site.test/a"},
+		{name: "found on http page", cfg: on, from: "http://site.test/", link: "http://site.test/a", want: "http://site.test/a"},
+		{name: "other host not vouched for", cfg: on, from: "https://site.test/", link: "http://sub.site.test/a", want: "http://sub.site.test/a"},
+		{name: "explicit port kept", cfg: on, from: "https://site.test/", link: "http://site.test:8080/a", want: "http://site.test:8080/a"},
+		{name: "already https", cfg: on, from: "http://site.test/", link: "https://site.test/a", want: "https://site.test/a"},
+		{name: "host known from earlier", cfg: on, from: "http://site.test/", link: "http://site.test/a", known: map[string]time.Time{"site.test": {}}, want: "https://site.test/a"},
+		{name: "recent negative cached", cfg: on, from: "https://site.test/", link: "http://site.test/a", known: map[string]time.Time{"site.test": time.Now()}, want: "http://site.test/a"},
+		{name: "stale negative rechecked", cfg: on, from: "https://site.test/", link: "http://site.test/a", known: map[string]time.Time{"site.test": time.Now().Add(-2 * httpsNegativeTTL)}, want: "https://site.test/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHTTPSHosts(t)
+			for h, v := range tt.known {
+				httpsHosts.known[h] = v
+			}
+			from, _ := url.Parse(tt.from)
+			link, _ := url.Parse(tt.link)
+			upgradeScheme(context.Background(), nil, tt.cfg, 1, "site.test", from, link)
+			if got := link.String(); got != tt.want {
+				t.Errorf("upgraded link = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// http links of an https site are queued once, in their https form.
+func TestExtractAndEnqueueLinksUpgradesHTTP(t *testing.T) {
+	db := testDB(t)
+	resetHTTPSHosts(t)
+	cfg := testCrawlConfig()
+	cfg.Crawler.PreferHTTPS = true
+	const host = "upgrade-https.test"
+	siteID := testSite(t, db, cfg, "https://"+host+"/")
+	ctx := context.Background()
+	links := []pageLink{{Href: "http://" + host + "/doc"}, {Href: "https://" + host + "/doc"}, {Href: "/other"}}
+	n, total, err := extractAndEnqueueLinks(ctx, db, cfg, siteID, host, 0, "https://"+host+"/", "", 1, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || n != 2 {
+		t.Errorf("enqueued %d of %d distinct links, want 2 of 2", n, total)
+	}
+	var httpRows, httpsRows int
+	if err := db.QueryRow(ctx, `SELECT count(*) FILTER (WHERE url LIKE 'http://%'), count(*) FILTER (WHERE url = $2)
+FROM crawl_queue WHERE site_id = $1`, siteID, "https://"+host+"/doc").Scan(&httpRows, &httpsRows); err != nil {
+		t.Fatal(err)
+	}
+	if httpRows != 0 || httpsRows != 1 {
+		t.Errorf("queued http rows = %d, https /doc rows = %d; want 0 and 1", httpRows, httpsRows)
+	}
+}
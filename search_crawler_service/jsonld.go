@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// --- JSON-LD metadata ---
+//
+// <script type="application/ld+json"> blocks often carry a better title (headline/name)
+// and description than the HTML. They are only fallbacks: <title>, og:title and the meta
+// description win when present (see applyMetaFallbacks).
+
+// maxJSONLDBlock caps the bytes of one JSON-LD block that are parsed.
+const maxJSONLDBlock = 256 * 1024
+
+var reJSONLD = regexp.MustCompile(`(?is)<script\b[^>]*\btype\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script\s*>`)
+
+// extractJSONLD reads the JSON-LD blocks of a fully buffered body into p.
+func extractJSONLD(p *parsedPage, htmlStr string) {
+	for _, m := range reJSONLD.FindAllStringSubmatch(htmlStr, -1) {
+		p.addJSONLD(m[1])
+	}
+}
+
+// isJSONLDType reports whether a <script type> holds JSON-LD.
+func isJSONLDType(t string) bool {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.EqualFold(strings.TrimSpace(t), "application/ld+json")
+}
+
+// addJSONLD takes the first title and description found across a page's JSON-LD blocks.
+// Invalid JSON is ignored.
+func (p *parsedPage) addJSONLD(raw string) {
+	if (p.LDTitle != "" && p.LDDescription != "") || len(raw) > maxJSONLDBlock {
+		return
+	}
+	var v any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &v); err != nil {
+		return
+	}
+	// an article's headline beats the name of the WebSite/Organization next to it
+	objs := jsonLDObjects(v)
+	for _, key := range []string{"headline", "name"} {
+		for _, obj := range objs {
+			if p.LDTitle == "" {
+				p.LDTitle = jsonLDText(jsonLDString(obj[key]), 512)
+			}
+		}
+	}
+	for _, obj := range objs {
+		if p.LDDescription == "" {
+			p.LDDescription = jsonLDText(jsonLDString(obj["description"]), 1024)
+		}
+	}
+}
+
+// jsonLDObjects flattens a JSON-LD document into its top-level objects: a single object,
+// an array of them, or the members of an @graph.
+func jsonLDObjects(v any) []map[string]any {
+	var out []map[string]any
+	switch t := v.(type) {
+	case []any:
+		for _, e := range t {
+			out = append(out, jsonLDObjects(e)...)
+		}
+	case map[string]any:
+		out = append(out, t)
+		if g, ok := t["@graph"]; ok {
+			out = append(out, jsonLDObjects(g)...)
+		}
+	}
+	return out
+}
+
+// jsonLDString returns a string property; non-string values (objects, numbers) are ignored.
+func jsonLDString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// jsonLDText unescapes entities some generators leave in JSON-LD strings and cleans the text.
+func jsonLDText(s string, max int) string {
+	return cleanInlineText(html.UnescapeString(rmTags.ReplaceAllString(s, " ")), max)
+}
+
+// applyMetaFallbacks fills an empty title from og:title, then JSON-LD headline/name, and an
+// empty description from JSON-LD description.
+func (p *parsedPage) applyMetaFallbacks() {
+	if p.Title == "" {
+		p.Title = firstNonEmpty(p.Meta["og:title"], p.LDTitle)
+	}
+	if p.Description == "" {
+		p.Description = p.LDDescription
+	}
+}
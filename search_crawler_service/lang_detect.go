@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// --- Language detection (crawler.detect_language) ---
+//
+// Pages without an hreflang of their own get pages.lang from the script of their visible
+// text: mostly Cyrillic letters -> the first configured Cyrillic-script language (ru by
+// default), mostly Latin -> the first Latin-script one (en). Only crawler.languages are
+// ever reported; mixed or too short text stays unlabeled.
+
+const (
+	langSampleRunes = 20000 // text prefix inspected
+	langMinLetters  = 40    // fewer letters: no verdict
+	langMinShare    = 0.6   // share of letters the winning script needs
+)
+
+var cyrillicLangs = map[string]bool{"ru": true, "uk": true, "be": true, "bg": true, "sr": true, "mk": true, "kk": true}
+
+// detectLanguage guesses the language of text among langs (default ru, en); "" when unsure.
+func detectLanguage(text string, langs []string) string {
+	if len(langs) == 0 {
+		langs = []string{"ru", "en"}
+	}
+	var cyr, lat, n int
+	for _, r := range text {
+		if n++; n > langSampleRunes {
+			break
+		}
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyr++
+		case unicode.Is(unicode.Latin, r):
+			lat++
+		}
+	}
+	letters := cyr + lat
+	if letters < langMinLetters {
+		return ""
+	}
+	wantCyrillic := false
+	switch {
+	case float64(cyr) >= langMinShare*float64(letters):
+		wantCyrillic = true
+	case float64(lat) >= langMinShare*float64(letters):
+	default:
+		return ""
+	}
+	for _, l := range langs {
+		l = strings.ToLower(strings.TrimSpace(l))
+		base, _, _ := strings.Cut(l, "-")
+		if cyrillicLangs[base] == wantCyrillic && reLangTag.MatchString(l) {
+			return l
+		}
+	}
+	return ""
+}
+
+// wordCount counts whitespace-separated words of the extracted text.
+func wordCount(text string) int {
+	return len(strings.Fields(text))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleLinks serves GET /api/links?url=&direction=out|in&limit=&offset=: the page's
+// outbound links or the links pointing to it, with stored metadata of the other end.
+func handleLinks(db *pgxpool.Pool, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		qs := r.URL.Query()
+		parsed, err := url.Parse(strings.TrimSpace(qs.Get("url")))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+		canonicalizeURL(parsed, cfg.Crawler)
+		direction := strings.ToLower(qs.Get("direction"))
+		switch direction {
+		case "":
+			direction = "out"
+		case "out", "in":
+		default:
+			http.Error(w, "direction must be out or in", http.StatusBadRequest)
+			return
+		}
+		limit := parseIntDefault(qs.Get("limit"), 100)
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		offset := parseIntDefault(qs.Get("offset"), 0)
+		if offset < 0 {
+			offset = 0
+		}
+		finalURL := parsed.String()
+		urlHash := sha256Hex(finalURL)
+		links, total, err := listPageLinks(r.Context(), db, urlHash, direction, limit, offset)
+		if err != nil {
+			http.Error(w, "links error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, LinksResponse{
+			URL:       finalURL,
+			URLHash:   urlHash,
+			Direction: direction,
+			Total:     total,
+			Limit:     limit,
+			Offset:    offset,
+			Links:     links,
+		})
+	}
+}
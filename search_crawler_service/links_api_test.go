@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHandleLinksValidation(t *testing.T) {
+	h := handleLinks(nil, Config{})
+	tests := []struct {
+		method, query string
+		want          int
+	}{
+		{http.MethodPost, "url=http://a.test/", http.StatusMethodNotAllowed},
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodGet, "url=/relative", http.StatusBadRequest},
+		{http.MethodGet, "url=http://a.test/&direction=sideways", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(tt.method, "/api/links?"+tt.query, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s ?%s: status %d, want %d", tt.method, tt.query, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestHandleLinks(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	ctx := context.Background()
+	const from, to = "http://links-api.test/", "http://links-api.test/b"
+	siteID := testSite(t, db, cfg, from)
+	for _, u := range []string{from, to} {
+		if err := recordPageStatus(ctx, db, siteID, u, 200); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, _, err := getPageByHash(ctx, db, siteID, sha256Hex(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{to, "http://links-api.test/c", "http://elsewhere.test/"} {
+		if err := insertPageLink(ctx, db, src.ID, l, sha256Hex(l), "anchor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query string) LinksResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleLinks(db, cfg)(rec, httptest.NewRequest(http.MethodGet, "/api/links?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("?%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var resp LinksResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	out := get("url=" + url.QueryEscape(from) + "&limit=2")
+	if out.Direction != "out" || out.Total != 3 || len(out.Links) != 2 {
+		t.Fatalf("out: direction %q, total %d, %d links", out.Direction, out.Total, len(out.Links))
+	}
+	if out.Links[0].URL != to || out.Links[0].PageID == nil {
+		t.Errorf("first outbound link = %+v, want the stored page %s", out.Links[0], to)
+	}
+	if page2 := get("url=" + url.QueryEscape(from) + "&limit=2&offset=2"); len(page2.Links) != 1 {
+		t.Errorf("second page: %d links, want 1", len(page2.Links))
+	}
+	in := get("url=" + url.QueryEscape(to) + "&direction=in")
+	if in.Total != 1 || len(in.Links) != 1 || in.Links[0].URL != from {
+		t.Errorf("in: %+v", in)
+	}
+}
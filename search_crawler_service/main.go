@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -26,9 +28,13 @@ func main() {
 	if dsn := os.Getenv("PG_DSN"); dsn != "" {
 		cfg.Postgres.DSN = dsn
 	}
+	cfg.Auth.Token = getenv("CRAWLER_AUTH_TOKEN", cfg.Auth.Token)
+
+	// SIGINT/SIGTERM stop workers and background loops; pending page writes are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// DB
-	ctx := context.Background()
 	db, err := pgxpool.New(ctx, cfg.Postgres.DSN)
 	if err != nil {
 		Error("failed to init pgx pool", "dsn", cfg.Postgres.DSN, "err", err)
@@ -36,6 +42,27 @@ func main() {
 	}
 	defer db.Close()
 
+	// Raw HTML storage backend (db by default)
+	pageStore, err = newHTMLStore(cfg.HTMLStorage, db)
+	if err != nil {
+		Error("failed to init html storage", "backend", cfg.HTMLStorage.Backend, "err", err)
+		os.Exit(1)
+	}
+	if _, ok := pageStore.(*blobHTMLStore); ok {
+		go runHTMLBlobGC(ctx, db, 10*time.Minute, time.Hour)
+	}
+
+	// Page upserts of concurrent workers share transactions (crawler.page_batch)
+	var pageWriterDone chan struct{}
+	if cfg.Crawler.PageBatch.Enabled {
+		pageWriter = newPageBatcher(db, cfg.Crawler.PageBatch)
+		pageWriterDone = make(chan struct{})
+		go func() {
+			pageWriter.run(ctx)
+			close(pageWriterDone)
+		}()
+	}
+
 	// Load proxies config
 	proxiesPath := cfg.Proxies.ConfigPath
 	if proxiesPath == "" {
@@ -82,6 +109,12 @@ func main() {
 			DepthLimit  int       `json:"depth_limit"`
 			RPSPerHost  int       `json:"rps_per_host"`
 			RPSBurst    int       `json:"rps_burst"`
+			// host -> proxies that got 401/403 there (retry_forbidden_with_new_proxy)
+			BlockedProxies map[string]int `json:"blocked_proxies,omitempty"`
+			// proxies out of rotation under proxies ban_policy
+			BannedProxies int `json:"banned_proxies"`
+			// proxies that passed the last health check (healthcheck.interval)
+			ProxiesHealthy int `json:"proxies_healthy"`
 		}
 		out := resp{
 			Status:      "ok",
@@ -95,10 +128,33 @@ func main() {
 			DepthLimit:  cfg.Crawler.DepthLimit,
 			RPSPerHost:  cfg.Crawler.RPSPerHost,
 			RPSBurst:    cfg.Crawler.RPSBurst,
+
+			BlockedProxies: proxyBlocks.snapshot(),
+			BannedProxies:  pool.Banned(),
+			ProxiesHealthy: pool.Healthy(),
 		}
 		writeJSON(w, http.StatusOK, out)
 	})
 
+	// API: effective config with secrets redacted (bearer auth.token)
+	mux.HandleFunc("/api/config", requireAuth(cfg.Auth.Token, handleConfig(cfg, pcfg)))
+
+	// API: check every proxy now (synchronous health check pass)
+	mux.HandleFunc("/api/proxies/healthcheck", requireAuth(cfg.Auth.Token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		results := pool.CheckAll(r.Context(), pcfg.Healthcheck, pcfg.Healthcheck.Concurrency)
+		healthy := 0
+		for _, res := range results {
+			if res.OK {
+				healthy++
+			}
+		}
+		writeJSON(w, http.StatusOK, ProxyHealthResponse{Total: len(results), Healthy: healthy, Results: results})
+	}))
+
 	// API: enqueue URL into crawl_queue
 	mux.HandleFunc("/api/enqueue", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -110,32 +166,18 @@ func main() {
 			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		u := strings.TrimSpace(req.URL)
-		if u == "" {
-			http.Error(w, "url is required", http.StatusBadRequest)
-			return
-		}
-		parsed, err := url.Parse(u)
-		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
-			http.Error(w, "invalid url", http.StatusBadRequest)
-			return
-		}
-		// Sanitize: drop fragment
-		parsed.Fragment = ""
-		// Normalize host: lower-case, strip default ports, strip trailing dot
-		host := normalizeHost(parsed.Host)
-		if host == "" {
-			http.Error(w, "invalid host", http.StatusBadRequest)
-			return
-		}
-		parsed.Host = host
-		// Optional: enforce whitelist if provided
-		if len(cfg.Crawler.WhitelistDomains) > 0 && !isHostAllowed(host, cfg.Crawler.WhitelistDomains) {
-			http.Error(w, "host not in whitelist", http.StatusForbidden)
+		parsed, status, msg := parseEnqueueURL(cfg.Crawler, req.URL)
+		if parsed == nil {
+			http.Error(w, msg, status)
 			return
 		}
+		host := parsed.Host
 		// Ensure site exists
 		siteID, err := ensureSite(r.Context(), db, host, cfg)
+		if errors.Is(err, errSiteLimit) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, "ensure site error: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -147,7 +189,7 @@ func main() {
 		if req.Priority != nil {
 			priority = *req.Priority
 		}
-		enq, err := enqueueIfNotExists(r.Context(), db, siteID, finalURL, urlHash, priority)
+		enq, err := enqueueIfNotExists(r.Context(), db, siteID, finalURL, urlHash, priority, 0, 0)
 		if err != nil {
 			http.Error(w, "enqueue error: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -158,12 +200,106 @@ func main() {
 			URL:      finalURL,
 			URLHash:  urlHash,
 		}
+		resp.MaxAttempts = cfg.Crawler.maxAttempts()
 		if !enq {
 			resp.Message = "duplicate (already queued or processing)"
+			if it, ok, err := getQueueItem(r.Context(), db, siteID, urlHash, resp.MaxAttempts); err == nil && ok {
+				resp.Attempts = it.Attempts
+			}
 		}
 		writeJSON(w, http.StatusOK, resp)
 	})
 
+	// API: enqueue a JSON array of URLs, with a result per item
+	mux.HandleFunc("/api/enqueue/batch", handleEnqueueBatch(db, cfg))
+
+	// API: fetch, parse and store a URL right now (bypasses the queue), returns the page or the failure
+	mux.HandleFunc("/api/crawl-now", handleCrawlNow(db, cfg, pool))
+
+	// API: list stored pages (metadata only), optionally filtered by site and stored header
+	mux.HandleFunc("/api/pages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		qs := r.URL.Query()
+		f := pageFilter{
+			Header:      http.CanonicalHeaderKey(strings.TrimSpace(qs.Get("header"))),
+			HeaderValue: strings.TrimSpace(qs.Get("header_value")),
+			Limit:       parseIntDefault(qs.Get("limit"), 50),
+			Offset:      parseIntDefault(qs.Get("offset"), 0),
+		}
+		if v := qs.Get("site_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				http.Error(w, "invalid site_id", http.StatusBadRequest)
+				return
+			}
+			f.SiteID = id
+		}
+		if v := qs.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid since (want RFC3339)", http.StatusBadRequest)
+				return
+			}
+			f.Since = t
+		}
+		if f.Limit <= 0 || f.Limit > 500 {
+			f.Limit = 50
+		}
+		if f.Offset < 0 {
+			f.Offset = 0
+		}
+		pages, total, err := listPages(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, "list pages error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, PagesResponse{Total: total, Limit: f.Limit, Offset: f.Offset, Pages: pages})
+	})
+
+	// API: page metadata by (site_id, url_hash)
+	mux.HandleFunc("/api/page/by-hash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		siteID, err := strconv.ParseInt(r.URL.Query().Get("site_id"), 10, 64)
+		if err != nil || siteID <= 0 {
+			http.Error(w, "invalid site_id", http.StatusBadRequest)
+			return
+		}
+		hash := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("hash")))
+		if !isSHA256Hex(hash) {
+			http.Error(w, "invalid hash (want 64 hex chars)", http.StatusBadRequest)
+			return
+		}
+		page, ok, err := getPageByHash(r.Context(), db, siteID, hash)
+		if err != nil {
+			http.Error(w, "lookup error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "page not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	})
+
+	// API: link graph around a page (direction=out: links found on it, in: pages linking to it)
+	mux.HandleFunc("/api/links", handleLinks(db, cfg))
+
+	// API: per-host rate limits — GET lists effective limits, POST (bearer auth.token) overrides one host at runtime
+	mux.HandleFunc("/api/host-limit", requireAuthForWrites(cfg.Auth.Token, handleHostLimit))
+
+	// API: crawl focus (drain a site / priority band first), bearer auth.token
+	mux.HandleFunc("/api/focus", requireAuth(cfg.Auth.Token, handleFocus(db)))
+
+	// API: queue item lookup and queue stats with per-item attempts
+	mux.HandleFunc("/api/queue/item", handleQueueItem(db, cfg))
+	mux.HandleFunc("/api/queue/stats", handleQueueStats(db, cfg))
+
 	addr := cfg.HTTP.Addr
 	if addr == "" {
 		addr = ":8082"
@@ -174,6 +310,34 @@ func main() {
 		"proxies_path", proxiesPath,
 		"proxy_pool_size", pool.Len())
 
+	// Resume (or start) the current crawl run; per-run counters are flushed in the background
+	runID, runStarted, err := ensureCurrentRun(ctx, db)
+	if err != nil {
+		Error("failed to init crawl run", "err", err)
+		os.Exit(1)
+	}
+	Info("crawl run", "id", runID, "started_at", runStarted)
+	go runCountersFlusher(ctx, db, 10*time.Second)
+	go runSitePagesRecount(ctx, db, time.Hour)
+
+	// Proxy health checks take failing proxies out of rotation
+	go pool.RunHealthchecks(ctx)
+
+	if cfg.Crawler.Focus != nil {
+		if _, err := setFocus(ctx, db, *cfg.Crawler.Focus); err != nil {
+			Warn("crawler.focus ignored", "err", err)
+		}
+	}
+
+	// Items a crashed crawler left in 'processing' go back to the queue
+	reclaimStuckItems(ctx, db, cfg.Crawler.reclaimAfter())
+	if iv := cfg.Crawler.ReclaimInterval.Duration; iv > 0 {
+		go runQueueReclaim(ctx, db, iv, cfg.Crawler.reclaimAfter())
+	}
+
+	// Seed the queue from crawler.seed_urls (idempotent across restarts)
+	seedQueue(ctx, db, cfg)
+
 	// Start background workers for crawling
 	go runWorkers(ctx, db, cfg, pool)
 
@@ -182,8 +346,19 @@ func main() {
 		Handler:           mux,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
 	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		Error("http server error", "err", err)
 		os.Exit(1)
 	}
+	Info("crawler service stopping")
+	// wait for the batcher's final flush so buffered page upserts are committed
+	if pageWriterDone != nil {
+		<-pageWriterDone
+	}
 }
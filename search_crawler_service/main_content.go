@@ -0,0 +1,210 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// --- Main-content extraction (crawler.main_content) ---
+//
+// A readability-style pass over the parsed DOM: <main>/role=main/a single <article> is
+// taken as is; otherwise paragraphs score their parent (fully) and grandparent (half),
+// class/id names nudge the score, and the best container scaled by its link density wins.
+// Navigation, headers, footers, asides, forms and boilerplate-named blocks never count.
+// Without a clear winner the caller keeps the whole-page text.
+
+const (
+	minMainText  = 250  // bytes of text a main region needs
+	minMainShare = 0.15 // ... and its share of the page's (boilerplate-free) text
+)
+
+var (
+	reBoilerplateName = regexp.MustCompile(`(?i)nav|menu|footer|header|sidebar|cookie|consent|banner|breadcrumb|comment|share|social|related|promo|advert|popup|modal|subscribe`)
+	reContentName     = regexp.MustCompile(`(?i)article|content|entry|main|post|story|text|body`)
+)
+
+// extractMainText returns the text of the page's main content block; ok=false when none
+// stands out.
+func extractMainText(htmlStr string) (string, bool) {
+	doc, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		return "", false
+	}
+	total := len(mainNodeText(doc))
+	accept := func(t string) bool {
+		return len(t) >= minMainText && float64(len(t)) >= minMainShare*float64(total)
+	}
+	if n := semanticMain(doc); n != nil {
+		if t := mainNodeText(n); accept(t) {
+			return t, true
+		}
+	}
+	if n := bestContentNode(doc); n != nil {
+		if t := mainNodeText(n); accept(t) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// semanticMain finds <main>, role="main", or the page's only <article>.
+func semanticMain(doc *html.Node) *html.Node {
+	var main *html.Node
+	var articles []*html.Node
+	walkElements(doc, func(n *html.Node) bool {
+		if isBoilerplate(n) {
+			return false
+		}
+		switch {
+		case main != nil:
+		case n.DataAtom == atom.Main, strings.EqualFold(nodeAttr(n, "role"), "main"):
+			main = n
+		case n.DataAtom == atom.Article:
+			articles = append(articles, n)
+		}
+		return true
+	})
+	if main != nil {
+		return main
+	}
+	if len(articles) == 1 {
+		return articles[0]
+	}
+	return nil
+}
+
+// bestContentNode scores containers by the paragraphs inside them.
+func bestContentNode(doc *html.Node) *html.Node {
+	scores := make(map[*html.Node]float64)
+	add := func(n *html.Node, v float64) {
+		if n == nil || n.Type != html.ElementNode || n.DataAtom == atom.Body || n.DataAtom == atom.Html {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = nameWeight(n)
+		}
+		scores[n] += v
+	}
+	walkElements(doc, func(n *html.Node) bool {
+		if isBoilerplate(n) {
+			return false
+		}
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Td {
+			return true
+		}
+		t := mainNodeText(n)
+		if len(t) < 25 {
+			return true
+		}
+		v := 1 + float64(strings.Count(t, ",")) + min(float64(len(t))/100, 3)
+		add(n.Parent, v)
+		if n.Parent != nil {
+			add(n.Parent.Parent, v/2)
+		}
+		return true
+	})
+	var best *html.Node
+	var bestScore float64
+	for n, s := range scores {
+		s *= 1 - linkDensity(n)
+		if best == nil || s > bestScore {
+			best, bestScore = n, s
+		}
+	}
+	if bestScore <= 0 {
+		return nil
+	}
+	return best
+}
+
+// nameWeight is the class/id bonus or penalty of a candidate container.
+func nameWeight(n *html.Node) float64 {
+	name := nodeAttr(n, "class") + " " + nodeAttr(n, "id")
+	w := 0.0
+	if reContentName.MatchString(name) {
+		w += 25
+	}
+	if reBoilerplateName.MatchString(name) {
+		w -= 25
+	}
+	return w
+}
+
+// linkDensity is the share of n's text inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(mainNodeText(n))
+	if total == 0 {
+		return 1
+	}
+	links := 0
+	walkElements(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.A {
+			links += len(mainNodeText(c))
+			return false
+		}
+		return true
+	})
+	return float64(links) / float64(total)
+}
+
+// isBoilerplate reports an element whose text never belongs to the main content.
+func isBoilerplate(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Math,
+		atom.Nav, atom.Header, atom.Footer, atom.Aside, atom.Form, atom.Iframe:
+		return true
+	case atom.Body, atom.Html, atom.Main, atom.Article:
+		return false
+	}
+	switch strings.ToLower(nodeAttr(n, "role")) {
+	case "navigation", "banner", "contentinfo", "complementary", "dialog":
+		return true
+	}
+	name := nodeAttr(n, "class") + " " + nodeAttr(n, "id")
+	return reBoilerplateName.MatchString(name) && !reContentName.MatchString(name)
+}
+
+// mainNodeText is the collapsed text under n, skipping boilerplate subtrees.
+func mainNodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+			return
+		case html.ElementNode:
+			if isBoilerplate(n) {
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(spaceSeq.ReplaceAllString(b.String(), " "))
+}
+
+// walkElements visits element nodes under n depth-first; fn returning false skips children.
+func walkElements(n *html.Node, fn func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && !fn(c) {
+			continue
+		}
+		walkElements(c, fn)
+	}
+}
+
+func nodeAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- <meta http-equiv="refresh"> redirects (crawler.meta_refresh) ---
+//
+// A page whose only job is `<meta http-equiv="refresh" content="0;url=...">` is an empty
+// landing page. With follow enabled a refresh of at most max_delay is treated like a
+// redirect: the target is enqueued (same crawl scope rules as links) and, with skip_index,
+// the intermediate page is not stored.
+
+// MetaRefreshConfig configures how refresh redirects are handled.
+type MetaRefreshConfig struct {
+	Follow bool `yaml:"follow"`
+	// MaxDelay: longer refreshes (e.g. a page reloading itself every minute) are ignored (default 5s).
+	MaxDelay Duration `yaml:"max_delay"`
+	// SkipIndex does not store the page carrying the refresh.
+	SkipIndex bool `yaml:"skip_index"`
+}
+
+func (c MetaRefreshConfig) maxDelay() time.Duration {
+	if c.MaxDelay.Duration > 0 {
+		return c.MaxDelay.Duration
+	}
+	return 5 * time.Second
+}
+
+// refreshRedirect returns the refresh target of p (fetched as pageURL) when it should be
+// followed, else "". A refresh to the page itself is a reload, not a redirect.
+func (c CrawlerConfig) refreshRedirect(pageURL string, p parsedPage) string {
+	mr := c.MetaRefresh
+	if !mr.Follow || p.Refresh == "" || p.RefreshDelay > mr.maxDelay() {
+		return ""
+	}
+	if target := resolvePageHref(c, pageURL, p.BaseHref, p.Refresh); target != pageURL {
+		return target
+	}
+	return ""
+}
+
+// addRefresh records the first <meta http-equiv="refresh"> naming a target URL.
+func (p *parsedPage) addRefresh(httpEquiv, content string) {
+	if p.Refresh != "" || !strings.EqualFold(strings.TrimSpace(httpEquiv), "refresh") {
+		return
+	}
+	if delay, target, ok := parseMetaRefresh(content); ok {
+		p.Refresh, p.RefreshDelay = target, delay
+	}
+}
+
+// parseMetaRefresh parses a refresh content value: a delay in seconds, then ';' or ','
+// and the URL, optionally as url=..., quoted or not ("0;url=/a", "5; URL='/a'", "0, /a").
+// A bare delay (reload of the same page) is not a redirect.
+func parseMetaRefresh(content string) (time.Duration, string, bool) {
+	s := strings.TrimSpace(content)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	if i == 0 {
+		return 0, "", false
+	}
+	secs, err := strconv.ParseFloat(strings.TrimRight(s[:i], "."), 64)
+	if err != nil {
+		return 0, "", false
+	}
+	rest := strings.TrimSpace(s[i:])
+	if rest == "" || (rest[0] != ';' && rest[0] != ',') {
+		return 0, "", false
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		if after := strings.TrimSpace(rest[3:]); strings.HasPrefix(after, "=") {
+			rest = strings.TrimSpace(after[1:])
+		}
+	}
+	if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+		q := rest[0]
+		rest = rest[1:]
+		if j := strings.IndexByte(rest, q); j >= 0 {
+			rest = rest[:j]
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return 0, "", false
+	}
+	return time.Duration(secs * float64(time.Second)), rest, true
+}
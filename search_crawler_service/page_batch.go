@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Page write batching ---
+//
+// With crawler.page_batch enabled, workers hand their page upserts to one writer that
+// sends up to max_rows of them (or whatever arrived within max_delay) as a single pgx batch
+// in one transaction: one round trip and one commit instead of one per page. Each row is
+// the regular upsertPageSQL, so ON CONFLICT (site_id, url_hash) behaves as before, also for
+// two upserts of the same URL in one batch. Workers block until the commit, so a queue item
+// is never marked done before its page is durable; if the batch fails, its rows are retried
+// one by one so a bad row fails alone.
+
+// PageBatchConfig controls the page write batcher.
+type PageBatchConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	MaxRows  int      `yaml:"max_rows"`  // flush once this many upserts are pending (default 50)
+	MaxDelay Duration `yaml:"max_delay"` // flush at most this long after the first pending upsert (default 50ms)
+}
+
+// pageWriter batches page upserts; nil = each worker upserts directly.
+var pageWriter *pageBatcher
+
+type pageUpsert struct {
+	rec  pageRecord
+	done chan pageUpsertResult // buffered: the writer never blocks on a caller that gave up
+}
+
+type pageUpsertResult struct {
+	id  int64
+	err error
+}
+
+type pageBatcher struct {
+	db       *pgxpool.Pool
+	maxRows  int
+	maxDelay time.Duration
+	in       chan pageUpsert
+}
+
+func newPageBatcher(db *pgxpool.Pool, c PageBatchConfig) *pageBatcher {
+	b := &pageBatcher{db: db, maxRows: nonZero(c.MaxRows, 50), maxDelay: c.MaxDelay.Duration}
+	if b.maxDelay <= 0 {
+		b.maxDelay = 50 * time.Millisecond
+	}
+	b.in = make(chan pageUpsert, b.maxRows)
+	return b
+}
+
+// upsert queues p and waits until its batch is committed.
+func (b *pageBatcher) upsert(ctx context.Context, p pageRecord) (int64, error) {
+	u := pageUpsert{rec: p, done: make(chan pageUpsertResult, 1)}
+	select {
+	case b.in <- u:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	select {
+	case r := <-u.done:
+		return r.id, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// run collects upserts into batches until ctx is done, then flushes what is pending and
+// returns. A batch in flight when ctx is cancelled is still committed.
+func (b *pageBatcher) run(ctx context.Context) {
+	wctx := context.WithoutCancel(ctx)
+	var pending []pageUpsert
+	timer := time.NewTimer(b.maxDelay)
+	timer.Stop()
+	flush := func(ctx context.Context) {
+		timer.Stop()
+		if len(pending) > 0 {
+			b.flush(ctx, pending)
+			pending = nil
+		}
+	}
+	for {
+		select {
+		case u := <-b.in:
+			if len(pending) == 0 {
+				timer.Reset(b.maxDelay)
+			}
+			pending = append(pending, u)
+			if len(pending) >= b.maxRows {
+				flush(wctx)
+			}
+		case <-timer.C:
+			flush(wctx)
+		case <-ctx.Done():
+		drain:
+			for {
+				select {
+				case u := <-b.in:
+					pending = append(pending, u)
+				default:
+					break drain
+				}
+			}
+			fctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			flush(fctx)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush writes one batch and reports each row's page id to its caller.
+func (b *pageBatcher) flush(ctx context.Context, batch []pageUpsert) {
+	ids, inserted, err := b.writeBatch(ctx, batch)
+	if err != nil {
+		Warn("page batch failed, writing rows one by one", "rows", len(batch), "err", err)
+		for _, u := range batch {
+			id, err := upsertPage(ctx, b.db, u.rec)
+			u.done <- pageUpsertResult{id, err}
+		}
+		return
+	}
+	// a URL upserted twice in one transaction may report both rows as inserts
+	counted := make(map[int64]bool, len(batch))
+	for i, u := range batch {
+		if inserted[i] && !counted[ids[i]] {
+			counted[ids[i]] = true
+			incSitePages(ctx, b.db, u.rec.SiteID)
+		}
+		u.done <- pageUpsertResult{ids[i], nil}
+	}
+	Debug("page batch written", "rows", len(batch))
+}
+
+func (b *pageBatcher) writeBatch(ctx context.Context, batch []pageUpsert) ([]int64, []bool, error) {
+	tx, err := b.db.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	var pb pgx.Batch
+	for _, u := range batch {
+		pb.Queue(upsertPageSQL, upsertPageArgs(u.rec)...)
+	}
+	br := tx.SendBatch(ctx, &pb)
+	ids := make([]int64, len(batch))
+	inserted := make([]bool, len(batch))
+	for i := range batch {
+		if err := br.QueryRow().Scan(&ids[i], &inserted[i]); err != nil {
+			_ = br.Close()
+			return nil, nil, err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return ids, inserted, nil
+}
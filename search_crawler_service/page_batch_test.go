@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewPageBatcherDefaults(t *testing.T) {
+	tests := []struct {
+		cfg       PageBatchConfig
+		wantRows  int
+		wantDelay time.Duration
+	}{
+		{PageBatchConfig{}, 50, 50 * time.Millisecond},
+		{PageBatchConfig{MaxRows: 10, MaxDelay: Duration{time.Second}}, 10, time.Second},
+		{PageBatchConfig{MaxDelay: Duration{-time.Second}}, 50, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		b := newPageBatcher(nil, tt.cfg)
+		if b.maxRows != tt.wantRows || b.maxDelay != tt.wantDelay || cap(b.in) != tt.wantRows {
+			t.Errorf("%+v: rows=%d delay=%v cap=%d, want %d %v", tt.cfg, b.maxRows, b.maxDelay, cap(b.in), tt.wantRows, tt.wantDelay)
+		}
+	}
+}
+
+// A caller that gives up is not stuck behind a writer that is not running.
+func TestPageBatcherUpsertCancelled(t *testing.T) {
+	b := newPageBatcher(nil, PageBatchConfig{MaxRows: 1})
+	b.in <- pageUpsert{} // full
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.upsert(ctx, pageRecord{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("upsert err = %v, want deadline exceeded", err)
+	}
+}
+
+// startBatcher runs a batcher until the test ends.
+func startBatcher(t testing.TB, b *pageBatcher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// upsertAll stores the records concurrently through b and returns their ids and errors.
+func upsertAll(b *pageBatcher, recs []pageRecord) ([]int64, []error) {
+	ids := make([]int64, len(recs))
+	errs := make([]error, len(recs))
+	var wg sync.WaitGroup
+	for i, r := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = b.upsert(context.Background(), r)
+		}()
+	}
+	wg.Wait()
+	return ids, errs
+}
+
+func TestPageBatcherFlush(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	tests := []struct {
+		name  string
+		batch PageBatchConfig
+		rows  int
+	}{
+		{name: "by size", batch: PageBatchConfig{MaxRows: 4, MaxDelay: Duration{time.Hour}}, rows: 8},
+		{name: "by delay", batch: PageBatchConfig{MaxRows: 100, MaxDelay: Duration{20 * time.Millisecond}}, rows: 3},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := fmt.Sprintf("batch-flush-%d.test", i)
+			siteID := testSite(t, db, cfg, "http://"+host+"/")
+			b := newPageBatcher(db, tt.batch)
+			startBatcher(t, b)
+			var recs []pageRecord
+			for n := range tt.rows {
+				recs = append(recs, pageRecord{SiteID: siteID, URL: fmt.Sprintf("http://%s/p%d", host, n), Title: "t", HTTPStatus: 200, Text: "body"})
+			}
+			start := time.Now()
+			ids, errs := upsertAll(b, recs)
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("flush took %v", time.Since(start))
+			}
+			seen := map[int64]bool{}
+			for n := range recs {
+				if errs[n] != nil || ids[n] == 0 || seen[ids[n]] {
+					t.Errorf("row %d: id=%d err=%v", n, ids[n], errs[n])
+				}
+				seen[ids[n]] = true
+			}
+			var pages, counter int
+			if err := db.QueryRow(context.Background(), `SELECT (SELECT count(*) FROM pages WHERE site_id = $1), pages_count FROM sites WHERE id = $1`, siteID).Scan(&pages, &counter); err != nil {
+				t.Fatal(err)
+			}
+			if pages != tt.rows || counter != tt.rows {
+				t.Errorf("pages=%d pages_count=%d, want %d", pages, counter, tt.rows)
+			}
+		})
+	}
+}
+
+// Two upserts of one URL in the same batch resolve to one row; a failing row fails alone.
+func TestPageBatcherConflicts(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://batch-conflict.test/")
+	b := newPageBatcher(db, PageBatchConfig{MaxRows: 3, MaxDelay: Duration{time.Hour}})
+	startBatcher(t, b)
+
+	same := pageRecord{SiteID: siteID, URL: "http://batch-conflict.test/same", HTTPStatus: 200, Text: "v"}
+	ids, errs := upsertAll(b, []pageRecord{same, same, {SiteID: siteID, URL: "http://batch-conflict.test/other", HTTPStatus: 200}})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+	}
+	if ids[0] != ids[1] || ids[0] == ids[2] {
+		t.Errorf("ids = %v, want the two same-URL rows to share one id", ids)
+	}
+	var counter int
+	if err := db.QueryRow(context.Background(), `SELECT pages_count FROM sites WHERE id = $1`, siteID).Scan(&counter); err != nil {
+		t.Fatal(err)
+	}
+	if counter != 2 {
+		t.Errorf("pages_count = %d, want 2", counter)
+	}
+
+	ids, errs = upsertAll(b, []pageRecord{
+		{SiteID: siteID, URL: "http://batch-conflict.test/ok1", HTTPStatus: 200},
+		{SiteID: -1, URL: "http://batch-conflict.test/no-site", HTTPStatus: 200}, // violates the sites FK
+		{SiteID: siteID, URL: "http://batch-conflict.test/ok2", HTTPStatus: 200},
+	})
+	if errs[0] != nil || errs[2] != nil || ids[0] == 0 || ids[2] == 0 {
+		t.Errorf("valid rows of a failed batch: ids=%v errs=%v", ids, errs)
+	}
+	if errs[1] == nil {
+		t.Error("row without a site stored")
+	}
+}
+
+// Upserts pending at shutdown are still written.
+func TestPageBatcherFlushesOnShutdown(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://batch-shutdown.test/")
+	b := newPageBatcher(db, PageBatchConfig{MaxRows: 100, MaxDelay: Duration{time.Hour}})
+	u := pageUpsert{rec: pageRecord{SiteID: siteID, URL: "http://batch-shutdown.test/", HTTPStatus: 200}, done: make(chan pageUpsertResult, 1)}
+	b.in <- u
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.run(ctx) // returns at once, flushing what is queued
+	select {
+	case r := <-u.done:
+		if r.err != nil || r.id == 0 {
+			t.Errorf("upsert at shutdown: id=%d err=%v", r.id, r.err)
+		}
+	default:
+		t.Fatal("pending upsert not flushed on shutdown")
+	}
+}
+
+// BenchmarkPageUpserts compares per-row upserts with the batcher under concurrent workers
+// (4 per CPU, as runWorkers); run with GOSE_TEST_DSN set: go test -bench PageUpserts -run ^$
+func BenchmarkPageUpserts(b *testing.B) {
+	db := testDB(b)
+	cfg := testCrawlConfig()
+	text := strings.Repeat("lorem ipsum dolor sit amet ", 200)
+	for _, mode := range []string{"per_row", "batched"} {
+		b.Run(mode, func(b *testing.B) {
+			host := "bench-" + strings.ReplaceAll(mode, "_", "-") + ".test"
+			siteID := testSite(b, db, cfg, "http://"+host+"/")
+			store := func(ctx context.Context, p pageRecord) (int64, error) { return upsertPage(ctx, db, p) }
+			if mode == "batched" {
+				w := newPageBatcher(db, PageBatchConfig{})
+				startBatcher(b, w)
+				store = w.upsert
+			}
+			var n atomic.Int64
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rec := pageRecord{SiteID: siteID, URL: fmt.Sprintf("http://%s/p%d", host, n.Add(1)), HTTPStatus: 200, Text: text}
+					if _, err := store(context.Background(), rec); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
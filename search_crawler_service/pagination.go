@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// --- Pagination trap defense ---
+//
+// A link whose only varying part is an integer pagination parameter (?page=N, ?offset=N,
+// /page/N/) belongs to a sequence identified by its URL template (the URL with that integer
+// replaced). Each template is followed for at most pagination.max_depth distinct values;
+// later pages are not enqueued. Counts are kept in memory and restart with the process.
+
+// PaginationConfig caps how deep pagination sequences are followed.
+type PaginationConfig struct {
+	// MaxDepth is the number of pages followed per sequence (0 = unlimited).
+	MaxDepth int `yaml:"max_depth"`
+	// Params are the query parameter names treated as pagination (case-insensitive).
+	Params []string `yaml:"params"`
+}
+
+var defaultPaginationParams = []string{"page", "p", "pg", "paged", "pagenum", "page_num", "offset", "start"}
+
+func (c PaginationConfig) params() []string {
+	if c.Params == nil {
+		return defaultPaginationParams
+	}
+	return c.Params
+}
+
+var (
+	reDigits   = regexp.MustCompile(`^\d{1,9}$`)
+	rePagePath = regexp.MustCompile(`(?i)(/page/)(\d{1,9})(/|$)`)
+)
+
+// paginationTemplates returns "template -> integer value" for every pagination
+// parameter of u.
+func paginationTemplates(u *url.URL, params []string) map[string]string {
+	var out map[string]string
+	add := func(tmpl, v string) {
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[tmpl] = v
+	}
+	prefix := u.Host + u.EscapedPath()
+	if m := rePagePath.FindStringSubmatchIndex(u.EscapedPath()); m != nil {
+		p := u.EscapedPath()
+		add(u.Host+p[:m[3]]+"{n}"+p[m[5]:]+"?"+u.RawQuery, p[m[4]:m[5]])
+	}
+	if u.RawQuery == "" {
+		return out
+	}
+	q := u.Query()
+	for name, vals := range q {
+		if len(vals) != 1 || !reDigits.MatchString(vals[0]) {
+			continue
+		}
+		if !slices.ContainsFunc(params, func(p string) bool { return strings.EqualFold(p, name) }) {
+			continue
+		}
+		tq := url.Values{}
+		for k, v := range q {
+			tq[k] = v
+		}
+		tq.Set(name, "{n}")
+		add(prefix+"?"+strings.Replace(tq.Encode(), "%7Bn%7D", "{n}", 1), vals[0])
+	}
+	return out
+}
+
+// maxPaginationTemplates bounds the tracker; it is reset (forgetting all counts) beyond that.
+const maxPaginationTemplates = 50000
+
+type paginationTracker struct {
+	mu      sync.Mutex
+	seen    map[string]map[string]struct{} // template -> values followed
+	stopped map[string]bool                // templates already logged as capped
+}
+
+var paginationSeen = &paginationTracker{}
+
+// allow reports whether u may be enqueued: false once one of its pagination sequences
+// already has maxDepth other values.
+func (t *paginationTracker) allow(u *url.URL, c PaginationConfig) bool {
+	if c.MaxDepth <= 0 {
+		return true
+	}
+	tmpls := paginationTemplates(u, c.params())
+	if len(tmpls) == 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil || len(t.seen) > maxPaginationTemplates {
+		t.seen = make(map[string]map[string]struct{})
+		t.stopped = make(map[string]bool)
+	}
+	for tmpl, v := range tmpls {
+		vals := t.seen[tmpl]
+		if _, ok := vals[v]; ok || len(vals) < c.MaxDepth {
+			continue
+		}
+		if !t.stopped[tmpl] {
+			t.stopped[tmpl] = true
+			Info("pagination cap reached, not following further pages", "sequence", tmpl, "max_depth", c.MaxDepth, "url", u.String())
+		}
+		return false
+	}
+	for tmpl, v := range tmpls {
+		if t.seen[tmpl] == nil {
+			t.seen[tmpl] = make(map[string]struct{})
+		}
+		t.seen[tmpl][v] = struct{}{}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"testing"
+)
+
+func TestPaginationTemplates(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want map[string]string
+	}{
+		{raw: "http://s.test/list?page=7", want: map[string]string{"s.test/list?page={n}": "7"}},
+		{raw: "http://s.test/list?PAGE=7&sort=asc", want: map[string]string{"s.test/list?PAGE={n}&sort=asc": "7"}},
+		{raw: "http://s.test/blog/page/12/", want: map[string]string{"s.test/blog/page/{n}/?": "12"}},
+		{raw: "http://s.test/blog/page/3?offset=20", want: map[string]string{
+			"s.test/blog/page/{n}?offset=20": "3",
+			"s.test/blog/page/3?offset={n}":  "20",
+		}},
+		{raw: "http://s.test/list?page=next", want: nil},
+		{raw: "http://s.test/list?id=7", want: nil},
+		{raw: "http://s.test/list?page=1&page=2", want: nil},
+		{raw: "http://s.test/list", want: nil},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := paginationTemplates(u, defaultPaginationParams); !maps.Equal(got, tt.want) {
+			t.Errorf("paginationTemplates(%s) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestPaginationTrackerAllow(t *testing.T) {
+	type step struct {
+		raw  string
+		want bool
+	}
+	tests := []struct {
+		name  string
+		cfg   PaginationConfig
+		steps []step
+	}{
+		{
+			name: "query series capped",
+			cfg:  PaginationConfig{MaxDepth: 3},
+			steps: []step{
+				{"http://s.test/list?page=1", true},
+				{"http://s.test/list?page=2", true},
+				{"http://s.test/list?page=3", true},
+				{"http://s.test/list?page=4", false},
+				{"http://s.test/list?page=50", false},
+				{"http://s.test/list?page=2", true}, // already followed
+				{"http://s.test/list?page=1&sort=desc", true},
+				{"http://s.test/other?page=4", true},
+			},
+		},
+		{
+			name: "path series capped",
+			cfg:  PaginationConfig{MaxDepth: 2},
+			steps: []step{
+				{"http://s.test/blog/page/1/", true},
+				{"http://s.test/blog/page/2/", true},
+				{"http://s.test/blog/page/3/", false},
+				{"http://s.test/news/page/3/", true},
+			},
+		},
+		{
+			name: "custom params",
+			cfg:  PaginationConfig{MaxDepth: 1, Params: []string{"cursor"}},
+			steps: []step{
+				{"http://s.test/feed?cursor=1", true},
+				{"http://s.test/feed?cursor=2", false},
+				{"http://s.test/feed?page=2", true},
+				{"http://s.test/feed?page=3", true},
+			},
+		},
+		{
+			name:  "unlimited",
+			cfg:   PaginationConfig{},
+			steps: []step{{"http://s.test/list?page=1", true}, {"http://s.test/list?page=2", true}, {"http://s.test/list?page=3", true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &paginationTracker{}
+			for i, s := range tt.steps {
+				u, err := url.Parse(s.raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := tr.allow(u, tt.cfg); got != s.want {
+					t.Errorf("step %d: allow(%s) = %v, want %v", i, s.raw, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+// A long ?page=N series only yields max_depth pages.
+func TestPaginationTrackerSeries(t *testing.T) {
+	tr := &paginationTracker{}
+	cfg := PaginationConfig{MaxDepth: 50}
+	allowed := 0
+	for n := 1; n <= 500; n++ {
+		u, _ := url.Parse(fmt.Sprintf("http://s.test/catalog?page=%d&q=shoes", n))
+		if tr.allow(u, cfg) {
+			allowed++
+		}
+	}
+	if allowed != 50 {
+		t.Errorf("followed %d pages, want 50", allowed)
+	}
+}
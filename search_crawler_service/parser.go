@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"html"
+	"net"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/net/publicsuffix"
 )
 
 // --- Text extraction (MVP) ---
@@ -30,54 +33,123 @@ func extractVisibleText(html string) string {
 
 // --- Title/Description extraction (MVP) ---
 var (
-	reTitle    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
-	reMetaDesc = regexp.MustCompile(`(?is)<meta\s+[^>]*name\s*=\s*["']description["'][^>]*content\s*=\s*["']([^"']+)["'][^>]*>`)
-	reOGDesc   = regexp.MustCompile(`(?is)<meta\s+[^>]*property\s*=\s*["']og:description["'][^>]*content\s*=\s*["']([^"']+)["'][^>]*>`)
+	reTitle   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	reHead    = regexp.MustCompile(`(?is)<head\b[^>]*>(.*?)</head>`)
+	reForeign = regexp.MustCompile(`(?is)<svg\b.*?</svg>|<math\b.*?</math>`)
 )
 
-// extractTitle returns trimmed & unescaped <title> or empty string.
+// extractTitle returns trimmed & unescaped <title> or empty string. A title inside <head>
+// is preferred; titles in svg/math foreign content are ignored.
 func extractTitle(htmlStr string) string {
+	htmlStr = reForeign.ReplaceAllString(htmlStr, " ")
 	m := reTitle.FindStringSubmatch(htmlStr)
-	if len(m) >= 2 {
-		t := strings.TrimSpace(m[1])
-		t = rmTags.ReplaceAllString(t, " ")
-		t = spaceSeq.ReplaceAllString(t, " ")
-		t = strings.TrimSpace(t)
-		t = html.UnescapeString(t)
-		if len(t) > 512 {
-			t = t[:512]
+	if h := reHead.FindStringSubmatch(htmlStr); len(h) >= 2 {
+		if hm := reTitle.FindStringSubmatch(h[1]); len(hm) >= 2 {
+			m = hm
 		}
-		return t
+	}
+	if len(m) >= 2 {
+		// unescape first so &nbsp; and friends are collapsed with the rest of the whitespace
+		t := rmTags.ReplaceAllString(m[1], " ")
+		return cleanInlineText(html.UnescapeString(t), 512)
 	}
 	return ""
 }
 
-// extractMetaDescription tries common meta description tags.
-func extractMetaDescription(htmlStr string) string {
-	if m := reMetaDesc.FindStringSubmatch(htmlStr); len(m) >= 2 {
-		s := strings.TrimSpace(m[1])
-		s = html.UnescapeString(s)
-		s = spaceSeq.ReplaceAllString(s, " ")
-		if len(s) > 1024 {
-			s = s[:1024]
+var (
+	reMetaTag = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	reTagAttr = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// extractMeta collects meta tags into p.Meta, those that may carry robots directives
+// into p.MetaRobots and a refresh redirect into p.Refresh.
+func extractMeta(p *parsedPage, htmlStr string) {
+	for _, tag := range reMetaTag.FindAllString(htmlStr, -1) {
+		var name, property, httpEquiv, content string
+		for _, m := range reTagAttr.FindAllStringSubmatch(tag, -1) {
+			v := html.UnescapeString(strings.Trim(m[2], `"'`))
+			switch strings.ToLower(m[1]) {
+			case "name":
+				name = v
+			case "property":
+				property = v
+			case "http-equiv":
+				httpEquiv = v
+			case "content":
+				content = v
+			}
 		}
-		return s
+		p.addMetaRobots(name, content)
+		p.addMeta(firstNonEmpty(name, property), content)
+		p.addRefresh(httpEquiv, content)
 	}
-	if m := reOGDesc.FindStringSubmatch(htmlStr); len(m) >= 2 {
-		s := strings.TrimSpace(m[1])
-		s = html.UnescapeString(s)
-		s = spaceSeq.ReplaceAllString(s, " ")
-		if len(s) > 1024 {
-			s = s[:1024]
+}
+
+// extractAlternates collects <link rel="alternate" hreflang> and <link rel="canonical"> tags into p.
+func extractAlternates(p *parsedPage, htmlStr string) {
+	for _, tag := range reLinkTag.FindAllString(reForeign.ReplaceAllString(htmlStr, " "), -1) {
+		var rel, hreflang, href string
+		for _, m := range reTagAttr.FindAllStringSubmatch(tag, -1) {
+			v := html.UnescapeString(strings.Trim(m[2], `"'`))
+			switch strings.ToLower(m[1]) {
+			case "rel":
+				rel = v
+			case "hreflang":
+				hreflang = v
+			case "href":
+				href = v
+			}
 		}
-		return s
+		p.addAlternate(rel, hreflang, href)
+		p.addCanonical(rel, href)
+	}
+}
+
+// extractBaseHref returns the href of the first <base> outside svg/math, or "".
+func extractBaseHref(htmlStr string) string {
+	if m := reBase.FindStringSubmatch(reForeign.ReplaceAllString(htmlStr, " ")); len(m) >= 2 {
+		return strings.TrimSpace(html.UnescapeString(m[1]))
 	}
 	return ""
 }
 
+var reHeading = regexp.MustCompile(`(?is)<h([1-3])\b[^>]*>(.*?)</h[1-3]\s*>`)
+
+// extractHeadings collects <h1>-<h3> texts into p.Headings (scripts, styles and svg/math
+// content are ignored).
+func extractHeadings(p *parsedPage, htmlStr string) {
+	htmlStr = rmStyle.ReplaceAllString(rmScript.ReplaceAllString(htmlStr, " "), " ")
+	htmlStr = reForeign.ReplaceAllString(htmlStr, " ")
+	for _, m := range reHeading.FindAllStringSubmatch(htmlStr, -1) {
+		p.addHeading(html.UnescapeString(rmTags.ReplaceAllString(m[2], " ")))
+	}
+}
+
+// parsePage runs the regex extractors over a fully buffered body.
+func parsePage(html string) parsedPage {
+	p := parsedPage{
+		Title: extractTitle(html),
+		Links: extractLinks(html),
+		Text:  extractVisibleText(html),
+	}
+	extractMeta(&p, html)
+	p.BaseHref = extractBaseHref(html)
+	extractAlternates(&p, html)
+	extractHeadings(&p, html)
+	extractJSONLD(&p, html)
+	p.Description = p.metaFirst(defaultDescriptionMeta)
+	p.applyMetaFallbacks()
+	return p
+}
+
 // --- Link extraction and enqueue (MVP) ---
 
-var reHref = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+var (
+	reLinkTag = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	reBase    = regexp.MustCompile(`(?is)<base\s[^>]*href\s*=\s*["']([^"']+)["']`)
+	reHref    = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>`)
+	reAnchorE = regexp.MustCompile(`(?i)</a\s*>`)
+)
 
 func isInDomain(host string, siteDomain string) bool {
 	h := strings.ToLower(strings.TrimSpace(host))
@@ -88,20 +160,110 @@ func isInDomain(host string, siteDomain string) bool {
 	return false
 }
 
-func extractAndEnqueueLinks(ctx context.Context, db *pgxpool.Pool, cfg Config, siteID int64, siteDomain string, fromPageID int64, baseURL string, html string) (int, int, error) {
+// inCrawlScope applies crawler.crawl_scope to a discovered link host:
+// "host" — the site host only (www. ignored), "domain" (default) — the site domain and its
+// subdomains, "registrable" — anything under the same registrable domain per the public
+// suffix list (a.example.co.uk ~ b.example.co.uk, but never a.co.uk ~ b.co.uk). IP hosts
+// have no registrable domain and only match themselves.
+func inCrawlScope(host, siteDomain, scope string) bool {
+	h := normalizeHost(host)
+	d := normalizeHost(siteDomain)
+	switch scope {
+	case "host":
+		return h == d
+	case "registrable":
+		if h == d {
+			return true
+		}
+		if net.ParseIP(h) != nil || net.ParseIP(d) != nil {
+			return false
+		}
+		hr, err := publicsuffix.EffectiveTLDPlusOne(h)
+		if err != nil {
+			return false
+		}
+		dr, err := publicsuffix.EffectiveTLDPlusOne(d)
+		if err != nil {
+			return false
+		}
+		return hr == dr
+	}
+	return isInDomain(h, d)
+}
+
+// hasAssetExtension reports whether the last path segment ends in one of exts (case-insensitive).
+// Only the path is checked, so "/download.php?file=a.zip" is still crawled, and a
+// trailing slash ("/photo.jpg/") marks a directory, not a file.
+func hasAssetExtension(p string, exts []string) bool {
+	if strings.HasSuffix(p, "/") {
+		return false
+	}
+	seg := strings.ToLower(path.Base(p))
+	dot := strings.LastIndexByte(seg, '.')
+	if dot <= 0 {
+		return false
+	}
+	ext := seg[dot:]
+	for _, e := range exts {
+		if strings.EqualFold(strings.TrimSpace(e), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractLinks returns raw <a href> values with their anchor text in document order.
+// Anchor text is what lies between the tag and its </a>, never past the next link.
+func extractLinks(htmlStr string) []pageLink {
+	matches := reHref.FindAllStringSubmatchIndex(htmlStr, -1)
+	out := make([]pageLink, 0, len(matches))
+	for i, m := range matches {
+		link := pageLink{Href: htmlStr[m[2]:m[3]]}
+		rest := htmlStr[m[1]:]
+		if i+1 < len(matches) {
+			rest = htmlStr[m[1]:matches[i+1][0]]
+		}
+		if !strings.HasSuffix(htmlStr[m[0]:m[1]], "/>") {
+			if end := reAnchorE.FindStringIndex(rest); end != nil {
+				text := html.UnescapeString(rmTags.ReplaceAllString(rest[:end[0]], " "))
+				link.Text = cleanInlineText(text, maxAnchorText)
+			}
+		}
+		out = append(out, link)
+	}
+	return out
+}
+
+// extractAndEnqueueLinks resolves links against baseURL, records in-domain links (with anchor text) and enqueues them.
+// Relative links resolve against baseHref (the page's <base href>) when it is a valid
+// http(s) URL, else against the page URL. Links are enqueued at depth; beyond
+// crawler.depth_limit they are only recorded in page_links.
+func extractAndEnqueueLinks(ctx context.Context, db *pgxpool.Pool, cfg Config, siteID int64, siteDomain string, fromPageID int64, baseURL, baseHref string, depth int, links []pageLink) (int, int, error) {
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return 0, 0, err
 	}
-	matches := reHref.FindAllStringSubmatch(html, -1)
+	pageURL := base
+	if baseHref != "" {
+		if b, err := base.Parse(baseHref); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+			base = b
+		}
+	}
 	seen := make(map[string]struct{})
-	enqueued := 0
+	var pageLinks []pageLinkRow
+	var toEnqueue []queueLink
+	tooDeep := cfg.Crawler.DepthLimit > 0 && depth > cfg.Crawler.DepthLimit
 
-	for _, m := range matches {
-		if len(m) < 2 {
-			continue
+	// Links from well-linked pages are crawled sooner (off by default)
+	priority := 0
+	if cfg.Crawler.LinkPriorityBoost.Weight > 0 {
+		if inbound, err := countInboundLinks(ctx, db, sha256Hex(baseURL)); err == nil {
+			priority = cfg.Crawler.LinkPriorityBoost.linkPriority(inbound)
 		}
-		href := strings.TrimSpace(m[1])
+	}
+
+	for _, link := range links {
+		href := strings.TrimSpace(link.Href)
 		if href == "" {
 			continue
 		}
@@ -120,14 +282,23 @@ func extractAndEnqueueLinks(ctx context.Context, db *pgxpool.Pool, cfg Config, s
 		} else {
 			abs = base.ResolveReference(u)
 		}
-		abs.Fragment = ""
 		if abs.Scheme != "http" && abs.Scheme != "https" {
 			continue
 		}
-		abs.Host = normalizeHost(abs.Host)
-		if !isInDomain(abs.Host, siteDomain) {
+		canonicalizeURL(abs, cfg.Crawler)
+		if !inCrawlScope(abs.Host, siteDomain, cfg.Crawler.CrawlScope) {
 			continue
 		}
+		upgradeScheme(ctx, db, cfg.Crawler, siteID, siteDomain, pageURL, abs)
+
+		// obvious assets would only be rejected by content-type after a wasted fetch
+		linkPriority := priority + link.Boost
+		if cfg.Crawler.AssetLinks != "" && hasAssetExtension(abs.Path, cfg.Crawler.assetExtensions()) {
+			if cfg.Crawler.AssetLinks == "skip" {
+				continue
+			}
+			linkPriority = cfg.Crawler.AssetPriority
+		}
 
 		final := abs.String()
 		if _, ok := seen[final]; ok {
@@ -136,12 +307,25 @@ func extractAndEnqueueLinks(ctx context.Context, db *pgxpool.Pool, cfg Config, s
 		seen[final] = struct{}{}
 
 		toHash := sha256Hex(final)
-		_ = insertPageLink(ctx, db, fromPageID, final, toHash)
+		if fromPageID > 0 { // 0: source page isn't stored (meta robots noindex)
+			pageLinks = append(pageLinks, pageLinkRow{URL: final, Hash: toHash, AnchorText: link.Text})
+		}
 
-		if ok, err := enqueueIfNotExists(ctx, db, siteID, final, toHash, 0); err == nil && ok {
-			enqueued++
+		if tooDeep || !paginationSeen.allow(abs, cfg.Crawler.Pagination) {
+			continue
 		}
+		if siteCapReached(ctx, db, cfg.Crawler, siteID, siteDomain) {
+			continue
+		}
+		// reserve a slot under the site cap; unused ones are returned after the insert
+		siteCapAdd(siteID, 1)
+		toEnqueue = append(toEnqueue, queueLink{URL: final, Hash: toHash, Priority: linkPriority})
 	}
 
+	// one statement each for the link graph and the queue
+	_ = insertPageLinks(ctx, db, fromPageID, pageLinks)
+	enqueued, _ := enqueueLinks(ctx, db, siteID, toEnqueue, depth, cfg.Crawler.EnqueueDoneWindow.Duration)
+	siteCapAdd(siteID, int64(enqueued-len(toEnqueue)))
+
 	return enqueued, len(seen), nil
 }
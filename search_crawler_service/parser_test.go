@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractLinksAnchorText(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	tests := []struct {
+		name string
+		html string
+		want []pageLink
+	}{
+		{name: "plain", html: `<a href="/a">Pricing plans</a>`, want: []pageLink{{Href: "/a", Text: "Pricing plans"}}},
+		{name: "nested tags and spaces", html: `<a href="/b"> <b>Big</b>
+  <i>deal</i> </a>`, want: []pageLink{{Href: "/b", Text: "Big deal"}}},
+		{name: "entities", html: `<a href="/c">Fish &amp; chips</a>`, want: []pageLink{{Href: "/c", Text: "Fish & chips"}}},
+		{name: "image only", html: `<a href="/d"><img src="x.png"></a>`, want: []pageLink{{Href: "/d"}}},
+		{name: "self-closing", html: `<a href="/e"/>after`, want: []pageLink{{Href: "/e"}}},
+		{name: "unclosed stops at next link", html: `<a href="/f">first<a href="/g">second</a>`,
+			want: []pageLink{{Href: "/f"}, {Href: "/g", Text: "second"}}},
+		{name: "truncated", html: `<a href="/h">` + long + `</a>`, want: []pageLink{{Href: "/h", Text: cleanInlineText(long, maxAnchorText)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractLinks(tt.html)
+			if len(got) != len(tt.want) {
+				t.Fatalf("extractLinks = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("link %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+			if len([]rune(got[len(got)-1].Text)) > maxAnchorText {
+				t.Errorf("anchor text longer than %d runes", maxAnchorText)
+			}
+		})
+	}
+}
+
+// The streaming parser captures the same anchor text as the regex one.
+func TestParseHTMLStreamAnchorText(t *testing.T) {
+	page, err := parseHTMLStream(strings.NewReader(`<html><body>
+<a href="/a">Pricing <b>plans</b></a> <a href="/b"><img src="x.png"></a> <a href="/c">Fish &amp; chips</a>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pageLink{{Href: "/a", Text: "Pricing plans"}, {Href: "/b"}, {Href: "/c", Text: "Fish & chips"}}
+	if len(page.Links) != len(want) {
+		t.Fatalf("links = %+v, want %+v", page.Links, want)
+	}
+	for i := range want {
+		if page.Links[i] != want[i] {
+			t.Errorf("link %d = %+v, want %+v", i, page.Links[i], want[i])
+		}
+	}
+}
+
+// Inbound anchors are aggregated onto the target page; its own links to itself are not.
+func TestUpdatePageAnchorText(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://anchors.test/")
+	ctx := context.Background()
+	target := "http://anchors.test/target"
+	targetID, err := upsertPage(ctx, db, pageRecord{SiteID: siteID, URL: target, HTTPStatus: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromID, err := upsertPage(ctx, db, pageRecord{SiteID: siteID, URL: "http://anchors.test/from", HTTPStatus: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []struct {
+		from   int64
+		anchor string
+	}{{fromID, "blue widgets"}, {fromID, "blue widgets"}, {fromID, ""}, {targetID, "self link"}} {
+		if err := insertPageLink(ctx, db, l.from, target, sha256Hex(target), l.anchor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updatePageAnchorText(ctx, db, targetID, sha256Hex(target)); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := db.QueryRow(ctx, `SELECT COALESCE(anchor_text,'') FROM pages WHERE id = $1`, targetID).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "blue widgets" {
+		t.Errorf("anchor_text = %q, want %q", got, "blue widgets")
+	}
+}
+
+func TestHasAssetExtension(t *testing.T) {
+	exts := []string{".jpg", ".css", " .ZIP "}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/img/photo.jpg", true},
+		{"/IMG/PHOTO.JPG", true},
+		{"/static/site.css", true},
+		{"/files/archive.zip", true},
+		{"/about", false},
+		{"/page.html", false},
+		{"/", false},
+		{"", false},
+		{"/.css", false}, // dotfile, not an extension
+		{"/jpg", false},
+		{"/photo.jpg/", false}, // last segment is a directory
+		{"/download.php", false},
+		{"/a.jpg.html", false},
+		{"/photo.jpgx", false},
+	}
+	for _, tt := range tests {
+		if got := hasAssetExtension(tt.path, exts); got != tt.want {
+			t.Errorf("hasAssetExtension(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	var c CrawlerConfig
+	if !hasAssetExtension("/a.png", c.assetExtensions()) {
+		t.Error("default list does not cover .png")
+	}
+	c.AssetExtensions = []string{}
+	if hasAssetExtension("/a.png", c.assetExtensions()) {
+		t.Error("an explicit empty list still matched")
+	}
+}
+
+// Asset links are skipped or queued at asset_priority; the query string is not checked.
+func TestExtractAndEnqueueAssetLinks(t *testing.T) {
+	db := testDB(t)
+	links := []pageLink{
+		{Href: "/about"}, {Href: "/logo.png"}, {Href: "/site.css"},
+		{Href: "/download.php?file=a.zip"}, {Href: "/docs/"},
+	}
+	tests := []struct {
+		mode string
+		want map[string]int // path+query -> priority
+	}{
+		{mode: "", want: map[string]int{"/about": 0, "/logo.png": 0, "/site.css": 0, "/download.php?file=a.zip": 0, "/docs/": 0}},
+		{mode: "skip", want: map[string]int{"/about": 0, "/download.php?file=a.zip": 0, "/docs/": 0}},
+		{mode: "deprioritize", want: map[string]int{"/about": 0, "/logo.png": -5, "/site.css": -5, "/download.php?file=a.zip": 0, "/docs/": 0}},
+	}
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			cfg := testCrawlConfig()
+			cfg.Crawler.AssetLinks = tt.mode
+			cfg.Crawler.AssetPriority = -5
+			host := "assets-" + firstNonEmpty(tt.mode, "off") + ".test"
+			siteID := testSite(t, db, cfg, "http://"+host+"/")
+			ctx := context.Background()
+			if _, _, err := extractAndEnqueueLinks(ctx, db, cfg, siteID, host, 0, "http://"+host+"/", "", 1, links); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(ctx, `SELECT url, priority FROM crawl_queue WHERE site_id = $1`, siteID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int{}
+			for rows.Next() {
+				var u string
+				var p int
+				if err := rows.Scan(&u, &p); err != nil {
+					t.Fatal(err)
+				}
+				got[strings.TrimPrefix(u, "http://"+host)] = p
+			}
+			if rows.Err() != nil {
+				t.Fatal(rows.Err())
+			}
+			for path, p := range tt.want {
+				if gp, ok := got[path]; !ok || gp != p {
+					t.Errorf("%s: queued=%v priority=%d, want priority %d", path, ok, gp, p)
+				}
+			}
+			for path := range got {
+				if _, ok := tt.want[path]; !ok && path != "/" {
+					t.Errorf("%s queued, want skipped", path)
+				}
+			}
+		})
+	}
+}
+
+func TestRobotsDirectives(t *testing.T) {
+	tokens := []string{"gosecrawler"}
+	tests := []struct {
+		name         string
+		meta         string
+		wantNoindex  bool
+		wantNofollow bool
+	}{
+		{name: "no meta"},
+		{name: "generic noindex", meta: `<meta name="robots" content="noindex">`, wantNoindex: true},
+		{name: "generic none", meta: `<meta name="ROBOTS" content="None">`, wantNoindex: true, wantNofollow: true},
+		{name: "ours", meta: `<meta name="GoseCrawler" content="nofollow">`, wantNofollow: true},
+		{name: "other bot", meta: `<meta name="googlebot" content="noindex, nofollow">`},
+		{name: "merged", meta: `<meta name="robots" content="noindex"><meta name="gosecrawler" content="nofollow">`,
+			wantNoindex: true, wantNofollow: true},
+		{name: "repeated name", meta: `<meta name="robots" content="index"><meta name="robots" content="nofollow">`,
+			wantNofollow: true},
+		{name: "permissive", meta: `<meta name="robots" content="index, follow">`},
+		{name: "non-directive name", meta: `<meta name="description" content="noindex nofollow">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := "<html><head>" + tt.meta + "</head><body>x</body></html>"
+			stream, err := parseHTMLStream(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for parser, p := range map[string]parsedPage{"regex": parsePage(doc), "stream": stream} {
+				ni, nf := p.robotsDirectives(tokens)
+				if ni != tt.wantNoindex || nf != tt.wantNofollow {
+					t.Errorf("%s: noindex=%v nofollow=%v, want %v %v", parser, ni, nf, tt.wantNoindex, tt.wantNofollow)
+				}
+			}
+		})
+	}
+}
+
+func TestInCrawlScope(t *testing.T) {
+	tests := []struct {
+		host, site, scope string
+		want              bool
+	}{
+		// domain (default): the site and its subdomains, by label
+		{"example.com", "example.com", "", true},
+		{"blog.example.com", "example.com", "", true},
+		{"www.example.com", "example.com", "domain", true},
+		{"Example.COM:8080", "example.com", "", true},
+		{"evil-example.com", "example.com", "", false},
+		{"example.com.evil.net", "example.com", "", false},
+		{"example.com", "blog.example.com", "", false},
+		// host: exact host, www. and port ignored
+		{"example.com", "example.com", "host", true},
+		{"www.example.com", "example.com", "host", true},
+		{"example.com:443", "www.example.com", "host", true},
+		{"blog.example.com", "example.com", "host", false},
+		// registrable: same eTLD+1 per the public suffix list
+		{"blog.example.com", "shop.example.com", "registrable", true},
+		{"example.com", "blog.example.com", "registrable", true},
+		{"a.example.co.uk", "b.example.co.uk", "registrable", true},
+		{"a.co.uk", "b.co.uk", "registrable", false},
+		{"example.co.uk", "other.co.uk", "registrable", false},
+		{"alice.github.io", "bob.github.io", "registrable", false}, // private suffix
+		{"x.alice.github.io", "alice.github.io", "registrable", true},
+		{"evil-example.com", "example.com", "registrable", false},
+		{"co.uk", "co.uk", "registrable", true}, // the site itself
+		{"a.co.uk", "co.uk", "registrable", false},
+		{"10.0.0.1", "127.0.0.1", "registrable", false},
+		{"127.0.0.1:8080", "127.0.0.1", "registrable", true},
+		{"localhost", "localhost", "registrable", true},
+	}
+	for _, tt := range tests {
+		if got := inCrawlScope(tt.host, tt.site, tt.scope); got != tt.want {
+			t.Errorf("inCrawlScope(%q, %q, %q) = %v, want %v", tt.host, tt.site, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestTitleWhitespace(t *testing.T) {
+	tests := []struct{ name, title, want string }{
+		{"nbsp entity", "Hello&nbsp;&nbsp;world", "Hello world"},
+		{"raw nbsp", "Hello\u00a0world\u00a0", "Hello world"},
+		{"edges", "&nbsp; Hello world &nbsp;", "Hello world"},
+		{"zero-width", "Zero&#8203;width\u200b and\ufeff BOM", "Zerowidth and BOM"},
+		{"mixed entities", "A&ensp;&amp;&#x2009;B&emsp;\u2060&mdash;\tC", "A & B \u2014 C"},
+		{"newlines and tabs", "\n\tLine one\r\n  line two\t", "Line one line two"},
+		{"ideographic space", "\u6771\u4eac\u3000\u30bf\u30ef\u30fc", "\u6771\u4eac \u30bf\u30ef\u30fc"},
+		{"only whitespace", "&nbsp;\u200b ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := "<html><head><title>" + tt.title + "</title></head><body></body></html>"
+			if got := extractTitle(doc); got != tt.want {
+				t.Errorf("extractTitle = %q, want %q", got, tt.want)
+			}
+			p, err := parseHTMLStream(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Title != tt.want {
+				t.Errorf("stream title = %q, want %q", p.Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitlePrefersHead(t *testing.T) {
+	tests := []struct{ name, doc, want string }{
+		{"svg title before head", `<svg><title>Icon</title></svg><html><head><title>Real</title></head></html>`, "Real"},
+		{"body title after head", `<html><head><title>Real</title></head><body><title>Decoy</title></body></html>`, "Real"},
+		{"decoy in body before head-less title", `<html><body><svg><title>Icon</title></svg><title>Body</title></body></html>`, "Body"},
+		{"math title ignored", `<html><head><meta charset="utf-8"></head><body><math><title>Formula</title></math></body></html>`, ""},
+		{"svg inside head", `<html><head><svg><title>Icon</title></svg><title>Real</title></head></html>`, "Real"},
+		{"plain", `<title>Only</title>`, "Only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractTitle(tt.doc); got != tt.want {
+				t.Errorf("extractTitle = %q, want %q", got, tt.want)
+			}
+			p, err := parseHTMLStream(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Title != tt.want {
+				t.Errorf("stream title = %q, want %q", p.Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		wantMeta map[string]string
+		wantDesc string
+	}{
+		{
+			name:     "description",
+			head:     `<meta name="description" content="Plain desc">`,
+			wantMeta: map[string]string{"description": "Plain desc"},
+			wantDesc: "Plain desc",
+		},
+		{
+			name:     "og fallback",
+			head:     `<meta property="og:description" content="OG desc"><meta name="keywords" content="a, b">`,
+			wantMeta: map[string]string{"og:description": "OG desc", "keywords": "a, b"},
+			wantDesc: "OG desc",
+		},
+		{
+			name:     "description wins over og",
+			head:     `<meta property="og:description" content="OG"><meta name="description" content="Desc">`,
+			wantMeta: map[string]string{"og:description": "OG", "description": "Desc"},
+			wantDesc: "Desc",
+		},
+		{
+			name:     "custom tags, case folded, first occurrence",
+			head:     `<meta name="Author" content="Ann"><meta name="author" content="Bob"><meta name="twitter:description" content="Tw &amp; more">`,
+			wantMeta: map[string]string{"author": "Ann", "twitter:description": "Tw & more"},
+		},
+		{
+			name:     "empty content and nameless tags skipped",
+			head:     `<meta charset="utf-8"><meta name="keywords" content="  ">`,
+			wantMeta: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := "<html><head>" + tt.head + "</head><body>text</body></html>"
+			stream, err := parseHTMLStream(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for mode, p := range map[string]parsedPage{"regex": parsePage(doc), "stream": stream} {
+				if !maps.Equal(p.Meta, tt.wantMeta) {
+					t.Errorf("%s: Meta = %v, want %v", mode, p.Meta, tt.wantMeta)
+				}
+				if p.Description != tt.wantDesc {
+					t.Errorf("%s: Description = %q, want %q", mode, p.Description, tt.wantDesc)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectMeta(t *testing.T) {
+	p := parsedPage{Meta: map[string]string{"keywords": "go, search", "author": "Ann", "og:description": "OG"}}
+	tests := []struct {
+		name      string
+		names     []string
+		want      map[string]string
+		wantFirst string
+	}{
+		{name: "none configured", want: nil},
+		{name: "configured subset", names: []string{"Keywords", " author "}, want: map[string]string{"keywords": "go, search", "author": "Ann"}, wantFirst: "go, search"},
+		{name: "absent tag", names: []string{"twitter:description"}, want: nil},
+		{name: "order decides first", names: []string{"description", "og:description", "author"}, want: map[string]string{"og:description": "OG", "author": "Ann"}, wantFirst: "OG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.selectMeta(tt.names); !maps.Equal(got, tt.want) {
+				t.Errorf("selectMeta = %v, want %v", got, tt.want)
+			}
+			if got := p.metaFirst(tt.names); got != tt.wantFirst {
+				t.Errorf("metaFirst = %q, want %q", got, tt.wantFirst)
+			}
+		})
+	}
+}
+
+// crawler.meta_tags are stored in pages.meta; crawler.description_meta picks the description.
+func TestProcessURLStoresMeta(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><title>meta</title>
+<meta name="description" content="Site desc"><meta name="twitter:description" content="Tweet desc">
+<meta name="keywords" content="alpha, beta"><meta name="generator" content="none">
+</head><body>body</body></html>`)
+	}))
+	defer srv.Close()
+	cfg := testCrawlConfig()
+	cfg.Crawler.MetaTags = []string{"keywords", "twitter:description", "author"}
+	cfg.Crawler.DescriptionMeta = []string{"twitter:description", "description"}
+	siteID := testSite(t, db, cfg, srv.URL)
+	ctx := context.Background()
+	pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+	if err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	var desc string
+	var meta map[string]string
+	if err := db.QueryRow(ctx, `SELECT description, meta FROM pages WHERE id = $1`, pageID).Scan(&desc, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"keywords": "alpha, beta", "twitter:description": "Tweet desc"}; !maps.Equal(meta, want) {
+		t.Errorf("meta = %v, want %v", meta, want)
+	}
+	if desc != "Tweet desc" {
+		t.Errorf("description = %q, want %q", desc, "Tweet desc")
+	}
+}
+
+func TestExtractBaseHref(t *testing.T) {
+	tests := []struct{ name, doc, want string }{
+		{"none", `<html><head><title>x</title></head></html>`, ""},
+		{"subdirectory", `<html><head><base href="/docs/v2/"></head></html>`, "/docs/v2/"},
+		{"first wins", `<head><base href="/a/"><base href="/b/"></head>`, "/a/"},
+		{"entities and spaces", `<head><base href=" http://cdn.test/x/?a=1&amp;b=2 "></head>`, "http://cdn.test/x/?a=1&b=2"},
+		{"target only", `<head><base target="_blank"></head>`, ""},
+		{"svg base ignored", `<svg><base href="/icons/"></svg><head><base href="/real/"></head>`, "/real/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractBaseHref(tt.doc); got != tt.want {
+				t.Errorf("extractBaseHref = %q, want %q", got, tt.want)
+			}
+			p, err := parseHTMLStream(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.BaseHref != tt.want {
+				t.Errorf("stream BaseHref = %q, want %q", p.BaseHref, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePageHref(t *testing.T) {
+	const page = "http://site.test/blog/post.html"
+	tests := []struct{ name, baseHref, href, want string }{
+		{"no base", "", "img/a.html", "http://site.test/blog/img/a.html"},
+		{"subdirectory base", "/docs/v2/", "intro.html", "http://site.test/docs/v2/intro.html"},
+		{"relative base", "../shop/", "cart", "http://site.test/shop/cart"},
+		{"absolute base", "http://other.test/root/", "x", "http://other.test/root/x"},
+		{"root-relative href ignores base path", "/docs/v2/", "/top", "http://site.test/top"},
+		{"non-http base falls back", "javascript:void(0)", "next.html", "http://site.test/blog/next.html"},
+		{"invalid base falls back", "http://[bad", "next.html", "http://site.test/blog/next.html"},
+		{"non-http href", "/docs/", "mailto:a@site.test", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePageHref(CrawlerConfig{}, page, tt.baseHref, tt.href); got != tt.want {
+				t.Errorf("resolvePageHref(%q, %q) = %q, want %q", tt.baseHref, tt.href, got, tt.want)
+			}
+		})
+	}
+}
+
+// Relative links of a page declaring <base href="/docs/v2/"> are queued under that directory.
+func TestExtractAndEnqueueLinksBaseHref(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name     string
+		baseHref string
+		want     []string
+	}{
+		{name: "no base", want: []string{"/blog/intro.html", "/top"}},
+		{name: "subdirectory base", baseHref: "/docs/v2/", want: []string{"/docs/v2/intro.html", "/top"}},
+		{name: "invalid base", baseHref: "ftp://files.test/", want: []string{"/blog/intro.html", "/top"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testCrawlConfig()
+			host := fmt.Sprintf("base-href-%d.test", i)
+			siteID := testSite(t, db, cfg, "http://"+host+"/")
+			ctx := context.Background()
+			links := []pageLink{{Href: "intro.html"}, {Href: "/top"}}
+			if _, _, err := extractAndEnqueueLinks(ctx, db, cfg, siteID, host, 0, "http://"+host+"/blog/post.html", tt.baseHref, 1, links); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(ctx, `SELECT url FROM crawl_queue WHERE site_id = $1 AND url <> $2 ORDER BY url`, siteID, "http://"+host+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for rows.Next() {
+				var u string
+				if err := rows.Scan(&u); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, strings.TrimPrefix(u, "http://"+host))
+			}
+			if rows.Err() != nil {
+				t.Fatal(rows.Err())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("queued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Links are queued one hop deeper than their page, and not at all beyond depth_limit.
+func TestExtractAndEnqueueLinksDepth(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name       string
+		depthLimit int
+		depth      int // depth of the queued links
+		wantQueued bool
+	}{
+		{name: "unlimited", depthLimit: 0, depth: 12, wantQueued: true},
+		{name: "within limit", depthLimit: 2, depth: 1, wantQueued: true},
+		{name: "at limit", depthLimit: 2, depth: 2, wantQueued: true},
+		{name: "beyond limit", depthLimit: 2, depth: 3},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testCrawlConfig()
+			cfg.Crawler.DepthLimit = tt.depthLimit
+			host := fmt.Sprintf("depth-%d.test", i)
+			siteID := testSite(t, db, cfg, "http://"+host+"/")
+			ctx := context.Background()
+			n, total, err := extractAndEnqueueLinks(ctx, db, cfg, siteID, host, 0, "http://"+host+"/", "", tt.depth, []pageLink{{Href: "/child"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 1 || (n == 1) != tt.wantQueued {
+				t.Fatalf("enqueued %d of %d links, want queued=%v", n, total, tt.wantQueued)
+			}
+			var depth int
+			err = db.QueryRow(ctx, `SELECT depth FROM crawl_queue WHERE site_id = $1 AND url = $2`, siteID, "http://"+host+"/child").Scan(&depth)
+			if tt.wantQueued && (err != nil || depth != tt.depth) {
+				t.Errorf("queued depth = %d (err %v), want %d", depth, err, tt.depth)
+			}
+			if !tt.wantQueued && err == nil {
+				t.Errorf("link beyond depth_limit queued at depth %d", depth)
+			}
+		})
+	}
+}
+
+func TestExtractHeadings(t *testing.T) {
+	many := strings.Repeat("<h2>section</h2>", maxHeadings+5)
+	tests := []struct {
+		name, doc string
+		want      []string
+	}{
+		{"levels 1-3 in order", `<h3>Three</h3><h1>One</h1><h4>Four</h4><h2>Two</h2>`, []string{"Three", "One", "Two"}},
+		{"inline markup and entities", `<h1 class="t">Cats &amp; <em>dogs</em></h1>`, []string{"Cats & dogs"}},
+		{"whitespace collapsed", "<h2>\n  spaced\t\tout  </h2>", []string{"spaced out"}},
+		{"empty dropped", `<h1> </h1><h2>kept</h2>`, []string{"kept"}},
+		{"svg ignored", `<svg><h1>icon</h1></svg><h1>real</h1>`, []string{"real"}},
+		{"script ignored", `<script>"<h1>fake</h1>"</script><h1>real</h1>`, []string{"real"}},
+		{"capped", many, slices.Repeat([]string{"section"}, maxHeadings)},
+		{"none", `<p>no headings</p>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := parseHTMLStream(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for parser, p := range map[string]parsedPage{"regex": parsePage(tt.doc), "stream": stream} {
+				if !slices.Equal(p.Headings, tt.want) {
+					t.Errorf("%s: Headings = %q, want %q", parser, p.Headings, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessURLHeadings(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/titled" {
+			_, _ = io.WriteString(w, `<html><head><title>Own title</title></head><body><h1>Heading</h1></body></html>`)
+			return
+		}
+		_, _ = io.WriteString(w, `<html><body><h2>First heading</h2><p>text</p><h3>Second</h3></body></html>`)
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name, path   string
+		index, title bool
+		wantTitle    string
+		wantHeadings []string
+	}{
+		{name: "off", path: "/", wantTitle: "", wantHeadings: nil},
+		{name: "indexed", path: "/", index: true, wantHeadings: []string{"First heading", "Second"}},
+		{name: "title fallback", path: "/", title: true, wantTitle: "First heading"},
+		{name: "own title kept", path: "/titled", index: true, title: true, wantTitle: "Own title", wantHeadings: []string{"Heading"}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testCrawlConfig()
+			cfg.Crawler.IndexHeadings = tt.index
+			cfg.Crawler.HeadingTitleFallback = tt.title
+			siteID := testSite(t, db, cfg, srv.URL)
+			pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+tt.path, 0)
+			if err != nil {
+				t.Fatalf("processURL: %v", err)
+			}
+			var title string
+			var headings []string
+			if err := db.QueryRow(ctx, `SELECT COALESCE(title, ''), headings FROM pages WHERE id = $1`, pageID).Scan(&title, &headings); err != nil {
+				t.Fatal(err)
+			}
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			if !slices.Equal(headings, tt.wantHeadings) {
+				t.Errorf("headings = %q, want %q", headings, tt.wantHeadings)
+			}
+		})
+	}
+}
+
+// benchPage is a ~1 MiB article page: head, navigation and many text blocks with links.
+func benchPage() []byte {
+	var b strings.Builder
+	b.WriteString(`<!doctype html><html><head><title>Benchmark page</title>
+<meta name="description" content="A large page for parser benchmarks">
+<style>body { font: 14px sans-serif }</style><script>var x = "<p>not text</p>";</script></head><body>`)
+	for i := 0; b.Len() < 1<<20; i++ {
+		fmt.Fprintf(&b, `<h2>Section %d</h2><p>Lorem ipsum dolor sit amet, <a href="/article/%d">consectetur</a>
+adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua &amp; more.</p>
+<div class="nav"><a href="https://other.test/%d">elsewhere</a> <img src="/i/%d.png" alt=""></div>`, i, i, i, i)
+	}
+	b.WriteString(`</body></html>`)
+	return []byte(b.String())
+}
+
+// BenchmarkParse compares the buffered regex path (body read into a string, as fetchHTML
+// does without stream_parse) with the streaming tokenizer; see allocs and B/op.
+func BenchmarkParse(b *testing.B) {
+	page := benchPage()
+	b.Run("regex", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(page)))
+		for b.Loop() {
+			body, err := io.ReadAll(bytes.NewReader(page))
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = parsePage(string(body))
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(page)))
+		for b.Loop() {
+			if _, err := parseHTMLStream(bytes.NewReader(page)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
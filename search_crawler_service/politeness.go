@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/time/rate"
+)
+
+// --- Per-site politeness presets ---
+//
+// A preset names a set of per-host limits. A site gets its preset from
+// crawler.site_politeness[domain].preset, else sites.politeness, else crawler.politeness;
+// without any preset the global rps_per_host/rps_burst apply as before. Fields set in
+// crawler.politeness_presets[name] and then in site_politeness[domain] override the preset.
+
+// PolitenessProfile is the effective per-host limits. Zero fields mean "not set" in overrides.
+type PolitenessProfile struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+	// Delay is the minimum gap between requests to the host (caps RPS at 1/delay).
+	Delay Duration `yaml:"delay"`
+	// Concurrency caps in-flight fetches to the host (0 = unlimited).
+	Concurrency int `yaml:"concurrency"`
+}
+
+// SitePoliteness assigns a preset to one domain, with optional per-field overrides.
+type SitePoliteness struct {
+	Preset            string `yaml:"preset"`
+	PolitenessProfile `yaml:",inline"`
+}
+
+var builtinPolitenessPresets = map[string]PolitenessProfile{
+	"gentle":     {RPS: 1, Burst: 1, Delay: Duration{2 * time.Second}, Concurrency: 1},
+	"normal":     {RPS: 5, Burst: 10, Concurrency: 4},
+	"aggressive": {RPS: 20, Burst: 40, Concurrency: 16},
+}
+
+// merge returns p with the non-zero fields of o applied.
+func (p PolitenessProfile) merge(o PolitenessProfile) PolitenessProfile {
+	if o.RPS > 0 {
+		p.RPS = o.RPS
+	}
+	if o.Burst > 0 {
+		p.Burst = o.Burst
+	}
+	if o.Delay.Duration > 0 {
+		p.Delay = o.Delay
+	}
+	if o.Concurrency > 0 {
+		p.Concurrency = o.Concurrency
+	}
+	return p
+}
+
+// limit is the token rate of the profile: RPS, capped by Delay.
+func (p PolitenessProfile) limit() rate.Limit {
+	lim := rate.Limit(p.RPS)
+	if p.Delay.Duration > 0 {
+		if every := rate.Every(p.Delay.Duration); lim <= 0 || every < lim {
+			lim = every
+		}
+	}
+	return lim
+}
+
+// presetProfile looks up a preset by name, built-in or defined in politeness_presets.
+func presetProfile(cfg CrawlerConfig, name string) (PolitenessProfile, bool) {
+	base, builtin := builtinPolitenessPresets[name]
+	over, custom := cfg.PolitenessPresets[name]
+	if !builtin && !custom {
+		return PolitenessProfile{}, false
+	}
+	return base.merge(over), true
+}
+
+// resolvePoliteness computes the effective limits for domain; sitePreset is sites.politeness.
+func resolvePoliteness(cfg CrawlerConfig, domain, sitePreset string) PolitenessProfile {
+	site := cfg.SitePoliteness[domain]
+	name := firstNonEmpty(site.Preset, sitePreset, cfg.Politeness)
+	p := PolitenessProfile{RPS: float64(nonZero(cfg.RPSPerHost, 10)), Burst: nonZero(cfg.RPSBurst, 20)}
+	if name != "" {
+		if preset, ok := presetProfile(cfg, name); ok {
+			p = preset
+		} else {
+			Warn("unknown politeness preset, using global limits", "domain", domain, "preset", name)
+		}
+	}
+	p = p.merge(site.PolitenessProfile)
+	if p.Burst <= 0 {
+		p.Burst = 1
+	}
+	if p.Concurrency <= 0 {
+		p.Concurrency = cfg.maxConcurrentPerHost()
+	}
+	return p
+}
+
+func validatePoliteness(c CrawlerConfig) error {
+	if c.Politeness != "" {
+		if _, ok := presetProfile(c, c.Politeness); !ok {
+			return fmt.Errorf("unknown crawler.politeness preset %q", c.Politeness)
+		}
+	}
+	for domain, sp := range c.SitePoliteness {
+		if sp.Preset == "" {
+			continue
+		}
+		if _, ok := presetProfile(c, sp.Preset); !ok {
+			return fmt.Errorf("unknown politeness preset %q for site %q", sp.Preset, domain)
+		}
+	}
+	return nil
+}
+
+// hostPolitenessMap caches the resolved profile per host (map[string]PolitenessProfile).
+var hostPolitenessMap sync.Map
+
+// hostPoliteness resolves host's profile once, reading the site's preset from sites.politeness.
+func hostPoliteness(ctx context.Context, db *pgxpool.Pool, cfg CrawlerConfig, siteID int64, host string) PolitenessProfile {
+	if v, ok := hostPolitenessMap.Load(host); ok {
+		return v.(PolitenessProfile)
+	}
+	var preset string
+	if db != nil {
+		_ = db.QueryRow(ctx, `SELECT COALESCE(politeness, '') FROM sites WHERE id = $1`, siteID).Scan(&preset)
+	}
+	p := resolvePoliteness(cfg, host, preset)
+	actual, _ := hostPolitenessMap.LoadOrStore(host, p)
+	return actual.(PolitenessProfile)
+}
+
+// hostSems holds per-host fetch semaphores (map[string]chan struct{}).
+var hostSems sync.Map
+
+// acquireHostSlot reserves one of the host's n concurrent fetch slots (n <= 0 = unlimited).
+func acquireHostSlot(ctx context.Context, host string, n int) (func(), error) {
+	if host == "" || n <= 0 {
+		return func() {}, nil
+	}
+	v, _ := hostSems.LoadOrStore(host, make(chan struct{}, n))
+	return acquireSem(ctx, v.(chan struct{}))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestResolvePoliteness(t *testing.T) {
+	sec := func(n int) Duration { return Duration{time.Duration(n) * time.Second} }
+	cfg := CrawlerConfig{
+		RPSPerHost: 8,
+		RPSBurst:   16,
+		PolitenessPresets: map[string]PolitenessProfile{
+			"gentle": {RPS: 0.5, Delay: sec(3)},
+			"crawly": {RPS: 50, Burst: 100},
+		},
+		SitePoliteness: map[string]SitePoliteness{
+			"slow.test":     {Preset: "gentle"},
+			"tuned.test":    {Preset: "normal", PolitenessProfile: PolitenessProfile{Concurrency: 2}},
+			"override.test": {PolitenessProfile: PolitenessProfile{RPS: 3}},
+		},
+	}
+	tests := []struct {
+		name       string
+		cfg        CrawlerConfig
+		domain     string
+		sitePreset string
+		want       PolitenessProfile
+	}{
+		{name: "no preset: global limits", cfg: cfg, domain: "plain.test",
+			want: PolitenessProfile{RPS: 8, Burst: 16, Concurrency: 4}},
+		{name: "no preset: defaults", domain: "plain.test",
+			want: PolitenessProfile{RPS: 10, Burst: 20, Concurrency: 4}},
+		{name: "built-in normal", domain: "x.test", sitePreset: "normal",
+			want: PolitenessProfile{RPS: 5, Burst: 10, Concurrency: 4}},
+		{name: "built-in aggressive", domain: "x.test", sitePreset: "aggressive",
+			want: PolitenessProfile{RPS: 20, Burst: 40, Concurrency: 16}},
+		{name: "preset fields overridden in config", cfg: cfg, domain: "slow.test",
+			want: PolitenessProfile{RPS: 0.5, Burst: 1, Delay: sec(3), Concurrency: 1}},
+		{name: "custom preset", cfg: cfg, domain: "x.test", sitePreset: "crawly",
+			want: PolitenessProfile{RPS: 50, Burst: 100, Concurrency: 4}},
+		{name: "site override on a preset", cfg: cfg, domain: "tuned.test",
+			want: PolitenessProfile{RPS: 5, Burst: 10, Concurrency: 2}},
+		{name: "config preset wins over sites.politeness", cfg: cfg, domain: "slow.test", sitePreset: "aggressive",
+			want: PolitenessProfile{RPS: 0.5, Burst: 1, Delay: sec(3), Concurrency: 1}},
+		{name: "site override on global limits", cfg: cfg, domain: "override.test",
+			want: PolitenessProfile{RPS: 3, Burst: 16, Concurrency: 4}},
+		{name: "default preset", cfg: CrawlerConfig{Politeness: "gentle"}, domain: "x.test",
+			want: PolitenessProfile{RPS: 1, Burst: 1, Delay: sec(2), Concurrency: 1}},
+		{name: "unknown preset keeps global limits", domain: "x.test", sitePreset: "nope",
+			want: PolitenessProfile{RPS: 10, Burst: 20, Concurrency: 4}},
+		{name: "unlimited per-host concurrency", cfg: CrawlerConfig{MaxConcurrentPerHost: -1}, domain: "x.test",
+			want: PolitenessProfile{RPS: 10, Burst: 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePoliteness(tt.cfg, tt.domain, tt.sitePreset); got != tt.want {
+				t.Errorf("resolvePoliteness = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolitenessLimit(t *testing.T) {
+	tests := []struct {
+		p    PolitenessProfile
+		want rate.Limit
+	}{
+		{PolitenessProfile{RPS: 5}, 5},
+		{PolitenessProfile{RPS: 5, Delay: Duration{2 * time.Second}}, 0.5},   // delay caps the rate
+		{PolitenessProfile{RPS: 0.1, Delay: Duration{2 * time.Second}}, 0.1}, // rate already lower
+		{PolitenessProfile{Delay: Duration{time.Second}}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.p.limit(); got != tt.want {
+			t.Errorf("%+v.limit() = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePoliteness(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       CrawlerConfig
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "built-in default", c: CrawlerConfig{Politeness: "gentle"}},
+		{name: "custom default", c: CrawlerConfig{Politeness: "slow", PolitenessPresets: map[string]PolitenessProfile{"slow": {RPS: 1}}}},
+		{name: "unknown default", c: CrawlerConfig{Politeness: "slow"}, wantErr: true},
+		{name: "unknown site preset", c: CrawlerConfig{SitePoliteness: map[string]SitePoliteness{"a.test": {Preset: "slow"}}}, wantErr: true},
+		{name: "site override without preset", c: CrawlerConfig{SitePoliteness: map[string]SitePoliteness{"a.test": {PolitenessProfile: PolitenessProfile{RPS: 1}}}}},
+	}
+	for _, tt := range tests {
+		if err := validatePoliteness(tt.c); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validatePoliteness = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAcquireHostSlot(t *testing.T) {
+	ctx := context.Background()
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := acquireHostSlot(ctx, "slots.test", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	full, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireHostSlot(full, "slots.test", 2); err == nil {
+		t.Fatal("third slot acquired on a host limited to 2")
+	}
+	if release, err := acquireHostSlot(ctx, "other-slots.test", 2); err != nil {
+		t.Fatalf("other host blocked: %v", err)
+	} else {
+		release()
+	}
+	releases[0]()
+	release, err := acquireHostSlot(ctx, "slots.test", 2)
+	if err != nil {
+		t.Fatalf("slot not returned: %v", err)
+	}
+	release()
+	releases[1]()
+	if release, err := acquireHostSlot(full, "", 0); err != nil {
+		t.Fatalf("unlimited: %v", err)
+	} else {
+		release()
+	}
+}
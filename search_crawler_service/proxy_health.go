@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ProxyCheckResult is the outcome of one proxy health check.
+type ProxyCheckResult struct {
+	Proxy     string `json:"proxy"` // password redacted
+	OK        bool   `json:"ok"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// checkProxy requests hc.URL through proxy; any 2xx/3xx response counts as healthy.
+func checkProxy(ctx context.Context, proxy *url.URL, hc HealthcheckConfig) ProxyCheckResult {
+	res := ProxyCheckResult{Proxy: redactURL(proxy.String())}
+	if proxy.Scheme != "http" && proxy.Scheme != "https" {
+		// buildHTTPClient would silently go direct
+		res.Error = "proxy scheme " + proxy.Scheme + " is not supported by the fetch client"
+		return res
+	}
+	timeout := hc.Timeout.Duration
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	method := hc.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	target := hc.URL
+	if target == "" {
+		target = "https://example.com"
+	}
+	client := buildHTTPClient(proxy, timeout)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	res.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	resp.Body.Close()
+	res.Status = resp.StatusCode
+	res.OK = resp.StatusCode >= 200 && resp.StatusCode < 400
+	return res
+}
+
+// CheckAll runs one health check pass over every proxy, at most concurrency at a time.
+// Proxies failing the check leave rotation until a later pass succeeds. Results keep the pool order.
+func (p *ProxyPool) CheckAll(ctx context.Context, hc HealthcheckConfig, concurrency int) []ProxyCheckResult {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	out := make([]ProxyCheckResult, len(p.proxies))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, u := range p.proxies {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = checkProxy(ctx, u, hc)
+			if ctx.Err() == nil {
+				p.setHealthy(u, out[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// setHealthy records a check result, logging transitions.
+func (p *ProxyPool) setHealthy(u *url.URL, r ProxyCheckResult) {
+	st := p.state[u]
+	st.mu.Lock()
+	was := !st.unhealthy
+	st.unhealthy = !r.OK
+	st.mu.Unlock()
+	switch {
+	case was && !r.OK:
+		Warn("proxy unhealthy, out of rotation", "proxy", r.Proxy, "status", r.Status, "err", r.Error)
+	case !was && r.OK:
+		Info("proxy healthy again", "proxy", r.Proxy, "latency_ms", r.LatencyMS)
+	}
+}
+
+// RunHealthchecks checks every proxy each healthcheck.interval until ctx is done
+// (no-op without proxies or with a zero interval).
+func (p *ProxyPool) RunHealthchecks(ctx context.Context) {
+	interval := p.hc.Interval.Duration
+	if len(p.proxies) == 0 || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		p.CheckAll(ctx, p.hc, p.hc.Concurrency)
+		Debug("proxy health check pass", "healthy", p.Healthy(), "total", p.Len())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProxy answers every proxied request with the current status.
+func fakeProxy(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var st atomic.Int32
+	st.Store(int32(status))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(st.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &st
+}
+
+func TestCheckAll(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	tests := []struct {
+		name    string
+		status  int
+		proxy   string // overrides the fake proxy
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK, wantOK: true},
+		{name: "redirect", status: http.StatusFound, wantOK: true},
+		{name: "bad gateway", status: http.StatusBadGateway},
+		{name: "unreachable", proxy: dead.URL, wantErr: true},
+		{name: "socks5 unsupported", proxy: "socks5://127.0.0.1:1080", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := tt.proxy
+			if proxy == "" {
+				srv, _ := fakeProxy(t, tt.status)
+				proxy = srv.URL
+			}
+			hc := HealthcheckConfig{URL: "http://health.example/", Timeout: Duration{time.Second}}
+			p := testPool(t, ProxiesConfig{Healthcheck: hc}, proxy)
+			res := p.CheckAll(context.Background(), hc, 0)
+			if len(res) != 1 {
+				t.Fatalf("results = %v", res)
+			}
+			if res[0].OK != tt.wantOK || (res[0].Error != "") != tt.wantErr {
+				t.Errorf("result = %+v, want ok=%v err=%v", res[0], tt.wantOK, tt.wantErr)
+			}
+			wantHealthy := 0
+			if tt.wantOK {
+				wantHealthy = 1
+			}
+			if got := p.Healthy(); got != wantHealthy {
+				t.Errorf("Healthy() = %d, want %d", got, wantHealthy)
+			}
+			if got := p.Next(); (got != nil) != tt.wantOK {
+				t.Errorf("Next() = %v, want in rotation = %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+// The loop takes a failing proxy out of rotation, restores it once it passes and stops with ctx.
+func TestRunHealthchecks(t *testing.T) {
+	good, _ := fakeProxy(t, http.StatusOK)
+	flaky, status := fakeProxy(t, http.StatusServiceUnavailable)
+	hc := HealthcheckConfig{URL: "http://health.example/", Timeout: Duration{time.Second}, Interval: Duration{10 * time.Millisecond}}
+	p := testPool(t, ProxiesConfig{Healthcheck: hc}, good.URL, flaky.URL)
+	if got := p.Healthy(); got != 2 {
+		t.Fatalf("Healthy() before any check = %d, want 2", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.RunHealthchecks(ctx)
+		close(done)
+	}()
+	waitHealthy := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for p.Healthy() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Healthy() = %d, want %d", p.Healthy(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitHealthy(1)
+	for range 4 {
+		if got := p.Next(); got == nil || got.String() != good.URL {
+			t.Fatalf("Next() = %v, want only %s while the other is unhealthy", got, good.URL)
+		}
+	}
+	status.Store(http.StatusOK)
+	waitHealthy(2)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunHealthchecks did not stop on cancel")
+	}
+}
+
+func TestRunHealthchecksDisabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		hc      HealthcheckConfig
+	}{
+		{name: "no proxies", hc: HealthcheckConfig{Interval: Duration{time.Millisecond}}},
+		{name: "zero interval", proxies: []string{"http://127.0.0.1:3128"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPool(t, ProxiesConfig{Healthcheck: tt.hc}, tt.proxies...)
+			done := make(chan struct{})
+			go func() {
+				p.RunHealthchecks(context.Background())
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("RunHealthchecks should return at once")
+			}
+		})
+	}
+}
@@ -2,26 +2,57 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"net/url"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// ProxyPool is a minimal round-robin pool (MVP).
+// ProxyPool hands out proxies per rotation: round_robin (default), random, or least_used
+// (fewest in-flight fetches, see Acquire). round_robin and random are weighted by the
+// proxies.yaml weight (default 1): a weight-3 proxy gets three times the picks. Proxies
+// failing ban_policy.consecutive_errors fetches in a row are taken out of rotation for
+// ban_policy.ban_duration.
 type ProxyPool struct {
 	rotation string
 	proxies  []*url.URL
+	weights  []int
+	cum      []int // cumulative weights: proxies[i] owns picks [cum[i-1], cum[i])
 	counter  uint64
+	ban      BanPolicyConfig
+	hc       HealthcheckConfig
+	state    map[*url.URL]*proxyState
+}
+
+// proxyState tracks one proxy's recent fetch outcomes.
+type proxyState struct {
+	mu          sync.Mutex
+	errors      int // consecutive failed fetches
+	bannedUntil time.Time
+	unhealthy   bool         // failed the last health check
+	inflight    atomic.Int64 // fetches currently going through the proxy
 }
 
 func NewProxyPool(cfg ProxiesConfig) (*ProxyPool, error) {
+	rot := cfg.Rotation
+	switch rot {
+	case "":
+		rot = "round_robin"
+	case "round_robin", "random", "least_used":
+	default:
+		return nil, fmt.Errorf("unknown proxy rotation %q (want round_robin|random|least_used)", rot)
+	}
 	if len(cfg.Proxies) == 0 {
-		return &ProxyPool{rotation: cfg.Rotation}, nil
+		return &ProxyPool{rotation: rot, ban: cfg.BanPolicy, hc: cfg.Healthcheck}, nil
 	}
 	var parsed []*url.URL
+	var weights []int
 	for _, p := range cfg.Proxies {
-		u, err := url.Parse(p)
+		u, err := p.proxyURL()
 		if err != nil {
-			return nil, fmt.Errorf("invalid proxy URL %q: %w", p, err)
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", redactURL(p.URL), err)
 		}
 		switch u.Scheme {
 		case "http", "https", "socks5":
@@ -29,22 +60,218 @@ func NewProxyPool(cfg ProxiesConfig) (*ProxyPool, error) {
 		default:
 			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
 		}
+		if p.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for proxy %q", redactURL(u.String()))
+		}
+		weights = append(weights, max(p.Weight, 1))
 	}
-	rot := cfg.Rotation
-	if rot == "" {
-		rot = "round_robin"
+	state := make(map[*url.URL]*proxyState, len(parsed))
+	for _, u := range parsed {
+		state[u] = &proxyState{}
 	}
-	return &ProxyPool{rotation: rot, proxies: parsed}, nil
+	pool := &ProxyPool{rotation: rot, proxies: parsed, weights: weights, ban: cfg.BanPolicy, hc: cfg.Healthcheck, state: state}
+	pool.rebuildWeights()
+	return pool, nil
+}
+
+// rebuildWeights recomputes the cumulative weight table; call it whenever proxies/weights change.
+func (p *ProxyPool) rebuildWeights() {
+	p.cum = make([]int, len(p.proxies))
+	total := 0
+	for i := range p.proxies {
+		w := 1
+		if i < len(p.weights) {
+			w = max(p.weights[i], 1)
+		}
+		total += w
+		p.cum[i] = total
+	}
+}
+
+// weightedIndex maps a pick number in [0, total weight) to the proxy owning it.
+func (p *ProxyPool) weightedIndex(pick int) int {
+	i, _ := slices.BinarySearch(p.cum, pick+1)
+	return i
 }
 
 func (p *ProxyPool) Len() int {
 	return len(p.proxies)
 }
 
+// Next returns the next proxy per rotation, skipping banned and unhealthy ones; nil (direct)
+// when the pool is empty or every proxy is out of rotation.
 func (p *ProxyPool) Next() *url.URL {
-	if len(p.proxies) == 0 {
+	n := len(p.proxies)
+	if n == 0 {
 		return nil
 	}
-	i := atomic.AddUint64(&p.counter, 1)
-	return p.proxies[(int(i)-1)%len(p.proxies)]
+	now := time.Now()
+	total := p.cum[n-1]
+	start := 0
+	switch p.rotation {
+	case "random":
+		start = p.weightedIndex(rand.IntN(total))
+	default:
+		start = p.weightedIndex(int((atomic.AddUint64(&p.counter, 1) - 1) % uint64(total)))
+	}
+	var best *url.URL
+	var bestLoad int64
+	for i := range n {
+		u := p.proxies[(start+i)%n]
+		st := p.state[u]
+		if !st.usable(now) {
+			continue
+		}
+		if p.rotation != "least_used" {
+			return u
+		}
+		// ties go to the first one after the rotating start, spreading idle load
+		if load := st.inflight.Load(); best == nil || load < bestLoad {
+			best, bestLoad = u, load
+		}
+	}
+	return best
+}
+
+// Acquire counts a fetch through u as in flight (least_used rotation) until the returned
+// func is called. u may be nil (direct).
+func (p *ProxyPool) Acquire(u *url.URL) func() {
+	st := p.state[u]
+	if st == nil {
+		return func() {}
+	}
+	st.inflight.Add(1)
+	return func() { st.inflight.Add(-1) }
+}
+
+// NextFor returns the next proxy in rotation that has not been blocked by host,
+// falling back to plain rotation when every proxy has been blocked.
+func (p *ProxyPool) NextFor(host string) *url.URL {
+	for range p.proxies {
+		u := p.Next()
+		if u == nil || !proxyBlocks.blocked(host, u) {
+			return u
+		}
+	}
+	return p.Next()
+}
+
+// Report records a fetch outcome through proxy u. After ban_policy.consecutive_errors
+// failures in a row the proxy is banned for ban_policy.ban_duration; a success resets the count.
+func (p *ProxyPool) Report(u *url.URL, ok bool) {
+	st := p.state[u]
+	if st == nil || p.ban.ConsecutiveErrors <= 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if ok {
+		st.errors = 0
+		return
+	}
+	st.errors++
+	if st.errors < p.ban.ConsecutiveErrors {
+		return
+	}
+	d := p.ban.BanDuration.Duration
+	if d <= 0 {
+		d = 10 * time.Minute
+	}
+	st.errors = 0
+	st.bannedUntil = time.Now().Add(d)
+	Warn("proxy banned", "proxy", redactURL(u.String()), "for", d.String())
+}
+
+// Banned returns the number of proxies currently out of rotation.
+func (p *ProxyPool) Banned() int {
+	now := time.Now()
+	n := 0
+	for _, u := range p.proxies {
+		if p.state[u].banned(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Healthy returns the number of proxies that passed their last health check (all of them
+// until a check has run).
+func (p *ProxyPool) Healthy() int {
+	n := 0
+	for _, u := range p.proxies {
+		st := p.state[u]
+		st.mu.Lock()
+		if !st.unhealthy {
+			n++
+		}
+		st.mu.Unlock()
+	}
+	return n
+}
+
+func (st *proxyState) banned(now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return now.Before(st.bannedUntil)
+}
+
+func (st *proxyState) usable(now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return !st.unhealthy && !now.Before(st.bannedUntil)
+}
+
+// proxyBlocks remembers which proxies got 401/403 from which hosts.
+var proxyBlocks = &proxyBlockTracker{m: make(map[string]map[string]int)}
+
+type proxyBlockTracker struct {
+	mu sync.Mutex
+	m  map[string]map[string]int // host -> proxy -> consecutive blocks
+}
+
+func (t *proxyBlockTracker) record(host string, proxy *url.URL) {
+	if proxy == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m[host] == nil {
+		t.m[host] = make(map[string]int)
+	}
+	t.m[host][proxy.String()]++
+}
+
+// clear forgets blocks after the proxy succeeded on host again.
+func (t *proxyBlockTracker) clear(host string, proxy *url.URL) {
+	if proxy == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if hm := t.m[host]; hm != nil {
+		delete(hm, proxy.String())
+		if len(hm) == 0 {
+			delete(t.m, host)
+		}
+	}
+}
+
+func (t *proxyBlockTracker) blocked(host string, proxy *url.URL) bool {
+	if proxy == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.m[host][proxy.String()] > 0
+}
+
+// snapshot returns host -> number of proxies currently considered blocked.
+func (t *proxyBlockTracker) snapshot() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int, len(t.m))
+	for h, hm := range t.m {
+		out[h] = len(hm)
+	}
+	return out
 }
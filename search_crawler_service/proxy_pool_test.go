@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPool builds a pool of the given proxy URLs.
+func testPool(t *testing.T, cfg ProxiesConfig, urls ...string) *ProxyPool {
+	t.Helper()
+	for _, u := range urls {
+		cfg.Proxies = append(cfg.Proxies, ProxyEntry{URL: u})
+	}
+	p, err := NewProxyPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// resetProxyBlocks gives the test an empty block tracker.
+func resetProxyBlocks(t *testing.T) {
+	t.Helper()
+	prev := proxyBlocks
+	proxyBlocks = &proxyBlockTracker{m: make(map[string]map[string]int)}
+	t.Cleanup(func() { proxyBlocks = prev })
+}
+
+func TestProxyBlockTracker(t *testing.T) {
+	tr := &proxyBlockTracker{m: make(map[string]map[string]int)}
+	a, _ := url.Parse("http://a.proxy:3128")
+	b, _ := url.Parse("http://b.proxy:3128")
+	tr.record("x.com", a)
+	tr.record("x.com", a)
+	tr.record("y.com", b)
+	tr.record("y.com", nil) // direct fetches are never tracked
+	tests := []struct {
+		host  string
+		proxy *url.URL
+		want  bool
+	}{
+		{"x.com", a, true},
+		{"x.com", b, false},
+		{"y.com", b, true},
+		{"y.com", a, false},
+		{"x.com", nil, false},
+	}
+	for _, tt := range tests {
+		if got := tr.blocked(tt.host, tt.proxy); got != tt.want {
+			t.Errorf("blocked(%s, %v) = %v, want %v", tt.host, tt.proxy, got, tt.want)
+		}
+	}
+	if s := tr.snapshot(); s["x.com"] != 1 || s["y.com"] != 1 {
+		t.Errorf("snapshot = %v", s)
+	}
+	tr.clear("x.com", a)
+	if tr.blocked("x.com", a) {
+		t.Error("proxy still blocked after clear")
+	}
+	if _, ok := tr.snapshot()["x.com"]; ok {
+		t.Error("host without blocked proxies kept in snapshot")
+	}
+}
+
+func TestNextForSkipsBlockedProxies(t *testing.T) {
+	resetProxyBlocks(t)
+	p := testPool(t, ProxiesConfig{}, "http://a.proxy:3128", "http://b.proxy:3128", "http://c.proxy:3128")
+	proxyBlocks.record("x.com", p.proxies[0])
+	proxyBlocks.record("x.com", p.proxies[1])
+	for i := 0; i < 6; i++ {
+		if got := p.NextFor("x.com"); got != p.proxies[2] {
+			t.Fatalf("NextFor(x.com) = %v, want the only unblocked proxy %v", got, p.proxies[2])
+		}
+	}
+	// other hosts rotate over every proxy
+	seen := map[*url.URL]bool{}
+	for i := 0; i < 3; i++ {
+		seen[p.NextFor("y.com")] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("NextFor(y.com) used %d proxies, want 3", len(seen))
+	}
+	// every proxy blocked: plain rotation rather than nothing
+	proxyBlocks.record("x.com", p.proxies[2])
+	if p.NextFor("x.com") == nil {
+		t.Error("NextFor returned nil with all proxies blocked")
+	}
+}
+
+// A 403 from one proxy is retried through another, and the blocking proxy is remembered.
+func TestRetryForbiddenWithNewProxy(t *testing.T) {
+	db := testDB(t)
+	resetProxyBlocks(t)
+	var blockedHits, goodHits atomic.Int32
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blockedHits.Add(1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer blocking.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goodHits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>via good proxy</title></head><body>ok</body></html>"))
+	}))
+	defer good.Close()
+
+	cfg := testCrawlConfig()
+	cfg.Crawler.RetryForbiddenWithNewProxy = true
+	ppool := testPool(t, ProxiesConfig{}, blocking.URL, good.URL)
+	const target = "http://forbidden-retry.test/page"
+	siteID := testSite(t, db, cfg, target)
+	if _, err := processURL(context.Background(), db, cfg, ppool, siteID, target, 0); err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	if blockedHits.Load() != 1 || goodHits.Load() != 1 {
+		t.Errorf("hits: blocking %d, good %d; want 1 each", blockedHits.Load(), goodHits.Load())
+	}
+	if !proxyBlocks.blocked("forbidden-retry.test", ppool.proxies[0]) || proxyBlocks.blocked("forbidden-retry.test", ppool.proxies[1]) {
+		t.Errorf("blocks = %v, want only the blocking proxy", proxyBlocks.snapshot())
+	}
+}
+
+func TestProxyBanPolicy(t *testing.T) {
+	const banFor = 50 * time.Millisecond
+	policy := BanPolicyConfig{ConsecutiveErrors: 3, BanDuration: Duration{banFor}}
+	tests := []struct {
+		name       string
+		policy     BanPolicyConfig
+		reports    []bool // outcomes reported for proxy a
+		wantBanned bool
+	}{
+		{name: "below the threshold", policy: policy, reports: []bool{false, false}},
+		{name: "banned after N errors", policy: policy, reports: []bool{false, false, false}, wantBanned: true},
+		{name: "success resets the count", policy: policy, reports: []bool{false, false, true, false, false}},
+		{name: "policy disabled", reports: []bool{false, false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPool(t, ProxiesConfig{BanPolicy: tt.policy}, "http://a.proxy:1", "http://b.proxy:2")
+			a := p.proxies[0]
+			for _, ok := range tt.reports {
+				p.Report(a, ok)
+			}
+			if got := p.Banned() == 1; got != tt.wantBanned {
+				t.Fatalf("banned = %d, want a banned: %v", p.Banned(), tt.wantBanned)
+			}
+			picks := map[string]int{}
+			for i := 0; i < 4; i++ {
+				picks[p.Next().Host]++
+			}
+			if tt.wantBanned && picks["a.proxy:1"] > 0 {
+				t.Errorf("banned proxy still picked: %v", picks)
+			}
+			if !tt.wantBanned && picks["a.proxy:1"] == 0 {
+				t.Errorf("proxy out of rotation without a ban: %v", picks)
+			}
+		})
+	}
+}
+
+func TestProxyBanExpires(t *testing.T) {
+	p := testPool(t, ProxiesConfig{BanPolicy: BanPolicyConfig{ConsecutiveErrors: 1, BanDuration: Duration{30 * time.Millisecond}}},
+		"http://a.proxy:1")
+	a := p.proxies[0]
+	p.Report(a, false)
+	if p.Next() != nil {
+		t.Fatal("the only proxy is banned: want a direct fetch (nil)")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if p.Banned() != 0 || p.Next() != a {
+		t.Error("proxy not back in rotation after ban_duration")
+	}
+}
+
+func TestProxyAllBannedFallsBackToDirect(t *testing.T) {
+	p := testPool(t, ProxiesConfig{BanPolicy: BanPolicyConfig{ConsecutiveErrors: 1, BanDuration: Duration{time.Hour}}},
+		"http://a.proxy:1", "http://b.proxy:2")
+	for _, u := range p.proxies {
+		p.Report(u, false)
+	}
+	if p.Banned() != 2 {
+		t.Fatalf("banned = %d, want 2", p.Banned())
+	}
+	if u := p.Next(); u != nil {
+		t.Errorf("Next = %v, want nil (direct)", u)
+	}
+	p.Report(nil, false) // direct fetches are not tracked
+}
+
+func TestNewProxyPoolRotation(t *testing.T) {
+	tests := []struct {
+		rotation string
+		want     string
+		wantErr  bool
+	}{
+		{rotation: "", want: "round_robin"},
+		{rotation: "round_robin", want: "round_robin"},
+		{rotation: "random", want: "random"},
+		{rotation: "least_used", want: "least_used"},
+		{rotation: "roundrobin", wantErr: true},
+		{rotation: "LRU", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := NewProxyPool(ProxiesConfig{Rotation: tt.rotation, Proxies: []ProxyEntry{{URL: "http://a.proxy:3128"}}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("rotation %q: err = %v, wantErr %v", tt.rotation, err, tt.wantErr)
+			continue
+		}
+		if err == nil && p.rotation != tt.want {
+			t.Errorf("rotation %q: got %q, want %q", tt.rotation, p.rotation, tt.want)
+		}
+	}
+}
+
+func TestProxyRotation(t *testing.T) {
+	urls := []string{"http://a.proxy:3128", "http://b.proxy:3128", "http://c.proxy:3128"}
+
+	t.Run("round_robin", func(t *testing.T) {
+		p := testPool(t, ProxiesConfig{Rotation: "round_robin"}, urls...)
+		for i := range 6 {
+			if got, want := p.Next().String(), urls[i%len(urls)]; got != want {
+				t.Errorf("pick %d = %s, want %s", i, got, want)
+			}
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		p := testPool(t, ProxiesConfig{Rotation: "random"}, urls...)
+		seen := make(map[string]int)
+		for range 300 {
+			seen[p.Next().String()]++
+		}
+		for _, u := range urls {
+			if seen[u] == 0 {
+				t.Errorf("%s never picked: %v", u, seen)
+			}
+		}
+	})
+
+	t.Run("least_used", func(t *testing.T) {
+		p := testPool(t, ProxiesConfig{Rotation: "least_used"}, urls...)
+		byURL := make(map[string]*url.URL)
+		for _, u := range p.proxies {
+			byURL[u.String()] = u
+		}
+		releaseA1 := p.Acquire(byURL[urls[0]])
+		releaseA2 := p.Acquire(byURL[urls[0]])
+		releaseB := p.Acquire(byURL[urls[1]])
+		for range 3 {
+			if got := p.Next().String(); got != urls[2] {
+				t.Fatalf("Next() = %s, want idle %s", got, urls[2])
+			}
+		}
+		releaseC := p.Acquire(byURL[urls[2]])
+		if got := p.Next().String(); got == urls[0] {
+			t.Errorf("Next() = %s, the busiest proxy", got)
+		}
+		releaseA1()
+		releaseA2()
+		releaseB()
+		releaseC()
+		for _, u := range p.proxies {
+			if n := p.state[u].inflight.Load(); n != 0 {
+				t.Errorf("%s in flight = %d after release, want 0", u, n)
+			}
+		}
+		p.Acquire(nil)() // direct fetches are not tracked
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Stuck 'processing' items ---
+//
+// A worker moves an item to 'processing' when it claims it and to done/error/queued when
+// it is finished. A crash in between leaves the item in 'processing' forever, so items not
+// updated for crawler.reclaim_after are put back. The claim already counted the attempt.
+
+// reclaimStuckItems returns stale 'processing' items to 'queued' and logs how many moved.
+func reclaimStuckItems(ctx context.Context, db *pgxpool.Pool, after time.Duration) {
+	const q = `
+UPDATE crawl_queue
+SET status = 'queued', next_try_at = NULL, last_error = 'reclaimed: stuck in processing'
+WHERE status = 'processing' AND updated_at < now() - $1::interval;`
+	ct, err := db.Exec(ctx, q, fmt.Sprintf("%f seconds", after.Seconds()))
+	if err != nil {
+		Warn("reclaim stuck queue items failed", "err", err)
+		return
+	}
+	if n := ct.RowsAffected(); n > 0 {
+		Info("reclaimed stuck queue items", "count", n, "older_than", after.String())
+	}
+}
+
+// runQueueReclaim repeats reclaimStuckItems every interval (items of crashed peer instances).
+func runQueueReclaim(ctx context.Context, db *pgxpool.Pool, interval, after time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		reclaimStuckItems(ctx, db, after)
+	}
+}
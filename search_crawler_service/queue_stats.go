@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Queue visibility ---
+//
+// Attempts count claims of a queue item; after crawler.max_attempts a regular fetch error
+// is final. /api/queue/item shows one URL's budget, /api/queue/stats the items closest to
+// running out of it and, with crawler.record_queue_wait, how long items wait to be claimed.
+
+const queueItemColumns = `id, site_id, url, url_hash, status::text, priority, depth, attempts,
+COALESCE(last_error, ''), COALESCE(error_class, ''), next_try_at, updated_at,
+EXTRACT(EPOCH FROM first_claimed_at - created_at)::float8`
+
+func scanQueueItem(row pgx.Row, maxAttempts int) (QueueItemInfo, error) {
+	var it QueueItemInfo
+	err := row.Scan(&it.ID, &it.SiteID, &it.URL, &it.URLHash, &it.Status, &it.Priority, &it.Depth,
+		&it.Attempts, &it.LastError, &it.ErrorClass, &it.NextTryAt, &it.UpdatedAt, &it.WaitSeconds)
+	it.MaxAttempts = maxAttempts
+	it.Remaining = max(maxAttempts-it.Attempts, 0)
+	return it, err
+}
+
+// getQueueItem returns the latest queue item for urlHash in site siteID (0: in any site).
+func getQueueItem(ctx context.Context, db *pgxpool.Pool, siteID int64, urlHash string, maxAttempts int) (QueueItemInfo, bool, error) {
+	q := "SELECT " + queueItemColumns + " FROM crawl_queue WHERE url_hash = $1 AND ($2::bigint = 0 OR site_id = $2) ORDER BY id DESC LIMIT 1"
+	it, err := scanQueueItem(db.QueryRow(ctx, q, urlHash, siteID), maxAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueueItemInfo{}, false, nil
+	}
+	if err != nil {
+		return QueueItemInfo{}, false, err
+	}
+	return it, true, nil
+}
+
+// queueWaitWindow is how far back queue wait percentiles look (by first claim).
+const queueWaitWindow = 24 * time.Hour
+
+// queueStats counts items per status and lists unfinished or failed items that already
+// used attempts, those with the fewest remaining first.
+func queueStats(ctx context.Context, db *pgxpool.Pool, maxAttempts, limit int) (QueueStatsResponse, error) {
+	resp := QueueStatsResponse{ByStatus: map[string]int{}, ErrorClasses: map[string]int{}, MaxAttempts: maxAttempts, NearExhaustion: []QueueItemInfo{}}
+	counts := []struct {
+		q   string
+		dst map[string]int
+	}{
+		{`SELECT status::text, count(*) FROM crawl_queue GROUP BY status`, resp.ByStatus},
+		{`SELECT error_class, count(*) FROM crawl_queue WHERE status = 'error' AND error_class IS NOT NULL GROUP BY error_class`, resp.ErrorClasses},
+	}
+	for _, c := range counts {
+		rows, err := db.Query(ctx, c.q)
+		if err != nil {
+			return resp, err
+		}
+		for rows.Next() {
+			var key string
+			var n int
+			if err := rows.Scan(&key, &n); err != nil {
+				rows.Close()
+				return resp, err
+			}
+			c.dst[key] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return resp, err
+		}
+	}
+
+	resp.Wait.Window = queueWaitWindow.String()
+	const qWait = `
+SELECT count(*),
+       percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_claimed_at - created_at)::float8),
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_claimed_at - created_at)::float8)
+FROM crawl_queue
+WHERE first_claimed_at > now() - make_interval(secs => $1)`
+	if err := db.QueryRow(ctx, qWait, queueWaitWindow.Seconds()).Scan(&resp.Wait.Items, &resp.Wait.P50Seconds, &resp.Wait.P95Seconds); err != nil {
+		return resp, err
+	}
+
+	q := "SELECT " + queueItemColumns + ` FROM crawl_queue
+WHERE status IN ('queued','processing','error') AND attempts > 0
+ORDER BY attempts DESC, updated_at DESC
+LIMIT $1`
+	rows, err := db.Query(ctx, q, limit)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		it, err := scanQueueItem(rows, maxAttempts)
+		if err != nil {
+			return resp, err
+		}
+		resp.NearExhaustion = append(resp.NearExhaustion, it)
+	}
+	return resp, rows.Err()
+}
+
+// handleQueueItem serves GET /api/queue/item?url=: the URL's latest queue item (404 if never queued).
+func handleQueueItem(db *pgxpool.Pool, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		parsed, err := url.Parse(strings.TrimSpace(r.URL.Query().Get("url")))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+		canonicalizeURL(parsed, cfg.Crawler)
+		it, ok, err := getQueueItem(r.Context(), db, 0, sha256Hex(parsed.String()), cfg.Crawler.maxAttempts())
+		if err != nil {
+			http.Error(w, "lookup error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "queue item not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, it)
+	}
+}
+
+// handleQueueStats serves GET /api/queue/stats?limit=.
+func handleQueueStats(db *pgxpool.Pool, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				http.Error(w, "invalid limit (1..1000)", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		resp, err := queueStats(r.Context(), db, cfg.Crawler.maxAttempts(), limit)
+		if err != nil {
+			http.Error(w, "queue stats error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
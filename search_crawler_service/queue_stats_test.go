@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The same URL queued under two sites (crawl_scope domain/registrable) is looked up per site.
+func TestGetQueueItemBySite(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	suffix := time.Now().UnixNano()
+	siteA := testSite(t, db, cfg, fmt.Sprintf("http://a%d.test/", suffix))
+	siteB := testSite(t, db, cfg, fmt.Sprintf("http://b%d.test/", suffix))
+	ctx := context.Background()
+	pageURL := fmt.Sprintf("http://a%d.test/page", suffix)
+	hash := sha256Hex(pageURL)
+	for _, site := range []int64{siteA, siteB} {
+		if _, err := enqueueIfNotExists(ctx, db, site, pageURL, hash, int(site%7), 0, 0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	for _, site := range []int64{siteA, siteB} {
+		it, ok, err := getQueueItem(ctx, db, site, hash, 3)
+		if err != nil || !ok {
+			t.Fatalf("site %d: ok=%v err=%v", site, ok, err)
+		}
+		if it.SiteID != site {
+			t.Errorf("getQueueItem(site %d) returned the item of site %d", site, it.SiteID)
+		}
+	}
+	if _, ok, err := getQueueItem(ctx, db, 0, hash, 3); err != nil || !ok {
+		t.Errorf("any-site lookup: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestMaxAttemptsDefault(t *testing.T) {
+	tests := []struct{ set, want int }{{0, 5}, {1, 1}, {3, 3}}
+	for _, tt := range tests {
+		if got := (CrawlerConfig{MaxAttempts: tt.set}).maxAttempts(); got != tt.want {
+			t.Errorf("maxAttempts(%d) = %d, want %d", tt.set, got, tt.want)
+		}
+	}
+}
+
+func TestHandleQueueItemValidation(t *testing.T) {
+	h := handleQueueItem(nil, testCrawlConfig()) // rejected before any lookup
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodPost, "/api/queue/item?url=http://x.test/", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/queue/item", http.StatusBadRequest},
+		{http.MethodGet, "/api/queue/item?url=x.test/page", http.StatusBadRequest},
+		{http.MethodGet, "/api/queue/item?url=" + url.QueryEscape("http://%zz"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+// Each claim of a retried item counts one attempt; the API reports what is left.
+func TestQueueItemAttemptsAcrossRetries(t *testing.T) {
+	db := testDB(t)
+	resetFocus(t)
+	ctx := context.Background()
+	cfg := testCrawlConfig()
+	cfg.Crawler.MaxAttempts = 3
+	host := fmt.Sprintf("attempts%d.test", time.Now().UnixNano())
+	siteID := testSite(t, db, cfg, "http://"+host+"/")
+	pageURL := "http://" + host + "/flaky"
+	if _, err := enqueueIfNotExists(ctx, db, siteID, pageURL, sha256Hex(pageURL), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	// claim only this site's items
+	if _, err := setFocus(ctx, db, CrawlFocus{SiteID: siteID, Exclusive: true}); err != nil {
+		t.Fatal(err)
+	}
+	h := handleQueueItem(db, cfg)
+	lookup := func() QueueItemInfo {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/api/queue/item?url="+url.QueryEscape(pageURL), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("lookup status = %d: %s", rec.Code, rec.Body)
+		}
+		var it QueueItemInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &it); err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	if it := lookup(); it.Attempts != 0 || it.Remaining != 3 {
+		t.Fatalf("fresh item: attempts=%d remaining=%d, want 0/3", it.Attempts, it.Remaining)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		it, ok, err := claimQueueItem(ctx, db)
+		if err != nil || !ok {
+			t.Fatalf("attempt %d: claim ok=%v err=%v", attempt, ok, err)
+		}
+		if it.Attempts != attempt {
+			t.Errorf("claimed with attempts = %d, want %d", it.Attempts, attempt)
+		}
+		markQueueRetry(ctx, db, it.ID, "boom", errClassTimeout, 0)
+		if _, err := db.Exec(ctx, `UPDATE crawl_queue SET next_try_at = NULL WHERE id = $1`, it.ID); err != nil {
+			t.Fatal(err)
+		}
+		info := lookup()
+		if info.Attempts != attempt || info.MaxAttempts != 3 || info.Remaining != 3-attempt {
+			t.Errorf("after attempt %d: attempts=%d max=%d remaining=%d", attempt, info.Attempts, info.MaxAttempts, info.Remaining)
+		}
+		if info.LastError != "boom" || info.ErrorClass != errClassTimeout {
+			t.Errorf("after attempt %d: last_error=%q class=%q", attempt, info.LastError, info.ErrorClass)
+		}
+	}
+}
+
+// The first claim stamps the wait since enqueue; later claims keep it.
+func TestQueueWaitRecorded(t *testing.T) {
+	db := testDB(t)
+	resetFocus(t)
+	prev := recordQueueWait
+	t.Cleanup(func() { recordQueueWait = prev })
+	ctx := context.Background()
+	cfg := testCrawlConfig()
+	for _, record := range []bool{true, false} {
+		t.Run(fmt.Sprintf("record_queue_wait=%v", record), func(t *testing.T) {
+			recordQueueWait = record
+			host := fmt.Sprintf("wait%d.test", time.Now().UnixNano())
+			siteID := testSite(t, db, cfg, "http://"+host+"/")
+			pageURL := "http://" + host + "/waited"
+			hash := sha256Hex(pageURL)
+			if _, err := enqueueIfNotExists(ctx, db, siteID, pageURL, hash, 0, 0, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(ctx, `UPDATE crawl_queue SET created_at = now() - interval '90 seconds' WHERE site_id = $1`, siteID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := setFocus(ctx, db, CrawlFocus{SiteID: siteID, Exclusive: true}); err != nil {
+				t.Fatal(err)
+			}
+			for claim := 1; claim <= 2; claim++ {
+				it, ok, err := claimQueueItem(ctx, db)
+				if err != nil || !ok {
+					t.Fatalf("claim %d: ok=%v err=%v", claim, ok, err)
+				}
+				markQueueRetry(ctx, db, it.ID, "again", errClassTimeout, 0)
+				if _, err := db.Exec(ctx, `UPDATE crawl_queue SET next_try_at = NULL WHERE id = $1`, it.ID); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			info, ok, err := getQueueItem(ctx, db, siteID, hash, 3)
+			if err != nil || !ok {
+				t.Fatalf("lookup: ok=%v err=%v", ok, err)
+			}
+			if !record {
+				if info.WaitSeconds != nil {
+					t.Errorf("wait recorded while disabled: %v", *info.WaitSeconds)
+				}
+				return
+			}
+			if info.WaitSeconds == nil || *info.WaitSeconds < 89 || *info.WaitSeconds > 92 {
+				t.Fatalf("wait_seconds = %v, want about 90 (first claim)", info.WaitSeconds)
+			}
+			stats, err := queueStats(ctx, db, 3, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Wait.Items < 1 || stats.Wait.P50Seconds == nil || stats.Wait.P95Seconds == nil {
+				t.Errorf("wait stats = %+v, want at least one sample", stats.Wait)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
 
 	"golang.org/x/time/rate"
@@ -30,3 +34,84 @@ func getHostLimiter(host string, rps int, burst int) *rate.Limiter {
 	actual, _ := hostLimiterMap.LoadOrStore(host, lim)
 	return actual.(*rate.Limiter)
 }
+
+// getHostLimiterFor returns host's limiter, creating it from a politeness profile.
+// An existing limiter (e.g. set via /api/host-limit) is kept as is.
+func getHostLimiterFor(host string, p PolitenessProfile) *rate.Limiter {
+	if host == "" {
+		return nil
+	}
+	hostLimiterOnce.Do(func() { hostLimiterMap = &sync.Map{} })
+	if v, ok := hostLimiterMap.Load(host); ok {
+		return v.(*rate.Limiter)
+	}
+	actual, _ := hostLimiterMap.LoadOrStore(host, rate.NewLimiter(p.limit(), p.Burst))
+	return actual.(*rate.Limiter)
+}
+
+// hostLimitOverrides marks hosts whose limits were set via /api/host-limit (map[string]bool).
+var hostLimitOverrides sync.Map
+
+// setHostLimit updates (or creates) host's limiter in place. rate.Limiter setters are
+// synchronized, so workers blocked in Wait pick up the new rate immediately.
+func setHostLimit(host string, rps float64, burst int) *rate.Limiter {
+	lim := getHostLimiter(host, int(rps), burst)
+	lim.SetLimit(rate.Limit(rps))
+	lim.SetBurst(burst)
+	hostLimitOverrides.Store(host, true)
+	return lim
+}
+
+// HostLimit is the effective rate of one host limiter.
+type HostLimit struct {
+	Host       string  `json:"host"`
+	RPS        float64 `json:"rps"`
+	Burst      int     `json:"burst"`
+	Overridden bool    `json:"overridden"`
+}
+
+// listHostLimits returns the limiters created so far (hosts seen by workers or set via the API).
+func listHostLimits() []HostLimit {
+	out := []HostLimit{}
+	hostLimiterOnce.Do(func() { hostLimiterMap = &sync.Map{} })
+	hostLimiterMap.Range(func(k, v any) bool {
+		lim := v.(*rate.Limiter)
+		_, over := hostLimitOverrides.Load(k)
+		out = append(out, HostLimit{Host: k.(string), RPS: float64(lim.Limit()), Burst: lim.Burst(), Overridden: over})
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// handleHostLimit serves /api/host-limit: GET lists the effective per-host rates,
+// POST {host, rps, burst} overrides one host.
+func handleHostLimit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"hosts": listHostLimits()})
+	case http.MethodPost:
+		var req HostLimitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		host := normalizeHost(strings.TrimSpace(req.Host))
+		if host == "" {
+			http.Error(w, "host is required", http.StatusBadRequest)
+			return
+		}
+		if req.RPS <= 0 {
+			http.Error(w, "rps must be > 0", http.StatusBadRequest)
+			return
+		}
+		if req.Burst <= 0 {
+			req.Burst = max(1, int(req.RPS))
+		}
+		lim := setHostLimit(host, req.RPS, req.Burst)
+		Info("host limit overridden", "host", host, "rps", req.RPS, "burst", req.Burst)
+		writeJSON(w, http.StatusOK, HostLimit{Host: host, RPS: float64(lim.Limit()), Burst: lim.Burst(), Overridden: true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestHandleHostLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		wantCode  int
+		wantHost  string
+		wantRPS   float64
+		wantBurst int
+	}{
+		{name: "override", method: http.MethodPost, body: `{"host":"Limit-A.test","rps":2.5,"burst":3}`, wantCode: 200, wantHost: "limit-a.test", wantRPS: 2.5, wantBurst: 3},
+		{name: "default burst", method: http.MethodPost, body: `{"host":"limit-b.test","rps":4}`, wantCode: 200, wantHost: "limit-b.test", wantRPS: 4, wantBurst: 4},
+		{name: "fractional rps keeps burst 1", method: http.MethodPost, body: `{"host":"limit-c.test","rps":0.5}`, wantCode: 200, wantHost: "limit-c.test", wantRPS: 0.5, wantBurst: 1},
+		{name: "missing host", method: http.MethodPost, body: `{"rps":1}`, wantCode: 400},
+		{name: "zero rps", method: http.MethodPost, body: `{"host":"limit-d.test","rps":0}`, wantCode: 400},
+		{name: "bad json", method: http.MethodPost, body: `{`, wantCode: 400},
+		{name: "wrong method", method: http.MethodDelete, wantCode: 405},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleHostLimit(rec, httptest.NewRequest(tt.method, "/api/host-limit", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			var got HostLimit
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			want := HostLimit{Host: tt.wantHost, RPS: tt.wantRPS, Burst: tt.wantBurst, Overridden: true}
+			if got != want {
+				t.Errorf("response = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handleHostLimit(rec, httptest.NewRequest(http.MethodGet, "/api/host-limit", nil))
+	var list struct {
+		Hosts []HostLimit `json:"hosts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, h := range list.Hosts {
+		if h.Host == "limit-a.test" {
+			found = h.RPS == 2.5 && h.Burst == 3 && h.Overridden
+		}
+	}
+	if !found {
+		t.Errorf("GET does not list the override: %+v", list.Hosts)
+	}
+}
+
+// An override updates the limiter workers already hold, and is safe while they wait on it.
+func TestSetHostLimitUpdatesLiveLimiter(t *testing.T) {
+	host := "live-limit.test"
+	held := getHostLimiterFor(host, PolitenessProfile{RPS: 1, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := held.Wait(ctx); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	if got := setHostLimit(host, 1000, 10); got != held {
+		t.Fatal("setHostLimit replaced the limiter instead of updating it")
+	}
+	wg.Wait() // 20 waits at 1 rps would outlast the timeout
+	if held.Limit() != rate.Limit(1000) || held.Burst() != 10 {
+		t.Errorf("limiter = %v/%d, want 1000/10", held.Limit(), held.Burst())
+	}
+	if got := getHostLimiterFor(host, PolitenessProfile{RPS: 1, Burst: 1}); got.Limit() != rate.Limit(1000) {
+		t.Errorf("profile lookup reset the override to %v", got.Limit())
+	}
+}
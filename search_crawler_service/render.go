@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- Headless-render fallback ---
+//
+// JS-heavy sites return an empty shell to a static GET. When the text extracted from the
+// static response is shorter than MinTextLength, the page is fetched again through a
+// headless browser service and the rendered HTML is used instead. Rendering is expensive,
+// so it has its own concurrency cap on top of the global fetch cap.
+
+// RenderFallbackConfig configures the headless browser service.
+// The endpoint receives POST {"url": "..."} and must answer with the rendered HTML
+// (e.g. browserless /content).
+type RenderFallbackConfig struct {
+	Endpoint      string   `yaml:"endpoint"`        // empty = off
+	MinTextLength int      `yaml:"min_text_length"` // render when static text is shorter (default 200)
+	Concurrency   int      `yaml:"concurrency"`     // default 2
+	Timeout       Duration `yaml:"timeout"`         // default 30s
+}
+
+// Render paths stored in pages.render_path.
+const (
+	renderPathStatic   = "static"
+	renderPathHeadless = "headless"
+)
+
+var (
+	renderSem    chan struct{}
+	renderClient *http.Client
+)
+
+func initRenderFallback(cfg RenderFallbackConfig) {
+	if cfg.Endpoint == "" {
+		return
+	}
+	renderSem = make(chan struct{}, nonZero(cfg.Concurrency, 2))
+	timeout := cfg.Timeout.Duration
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	renderClient = &http.Client{Timeout: timeout}
+	Info("render fallback enabled", "endpoint", cfg.Endpoint, "concurrency", cap(renderSem))
+}
+
+// needsRender reports whether the statically extracted text looks like a JS shell.
+func needsRender(cfg RenderFallbackConfig, page parsedPage) bool {
+	return renderClient != nil && len(strings.TrimSpace(page.Text)) < nonZero(cfg.MinTextLength, 200)
+}
+
+// renderHTML asks the render service for the rendered HTML of rawURL (at most maxBytes).
+func renderHTML(ctx context.Context, cfg RenderFallbackConfig, rawURL string, maxBytes int) (string, error) {
+	release, err := acquireSem(ctx, renderSem)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	releaseFetch, err := acquireFetchSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer releaseFetch()
+
+	body, _ := json.Marshal(map[string]string{"url": rawURL})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := renderClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("render service: status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
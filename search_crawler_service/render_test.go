@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubRenderService answers like a headless browser service with html for any URL and
+// enables the render fallback against it for the test.
+func stubRenderService(t *testing.T, html string) (RenderFallbackConfig, *[]string) {
+	t.Helper()
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ URL string }
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		requested = append(requested, req.URL)
+		_, _ = io.WriteString(w, html)
+	}))
+	t.Cleanup(srv.Close)
+	cfg := RenderFallbackConfig{Endpoint: srv.URL, MinTextLength: 20}
+	sem, client := renderSem, renderClient
+	initRenderFallback(cfg)
+	t.Cleanup(func() { renderSem, renderClient = sem, client })
+	return cfg, &requested
+}
+
+func TestNeedsRender(t *testing.T) {
+	cfg, _ := stubRenderService(t, "")
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"", true},
+		{"   loading...   ", true},
+		{strings.Repeat("word ", 10), false},
+	}
+	for _, tt := range tests {
+		if got := needsRender(cfg, parsedPage{Text: tt.text}); got != tt.want {
+			t.Errorf("needsRender(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+	renderClient = nil // no endpoint configured
+	if needsRender(cfg, parsedPage{}) {
+		t.Error("needsRender without a render service")
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	rendered := "<html><body><p>rendered by the browser</p></body></html>"
+	cfg, requested := stubRenderService(t, rendered)
+	got, err := renderHTML(context.Background(), cfg, "http://spa.test/", 1<<20)
+	if err != nil || got != rendered {
+		t.Fatalf("renderHTML = %q, %v", got, err)
+	}
+	if len(*requested) != 1 || (*requested)[0] != "http://spa.test/" {
+		t.Errorf("render service got %q", *requested)
+	}
+	if got, _ := renderHTML(context.Background(), cfg, "http://spa.test/", 10); len(got) != 10 {
+		t.Errorf("rendered HTML not capped at maxBytes: %d bytes", len(got))
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	cfg.Endpoint = failing.URL
+	if _, err := renderHTML(context.Background(), cfg, "http://spa.test/", 1<<20); err == nil {
+		t.Error("renderHTML succeeded on a 502 from the render service")
+	}
+}
+
+// A JS shell is stored with the rendered text and render_path "headless".
+func TestRenderFallbackStoresRenderedPage(t *testing.T) {
+	db := testDB(t)
+	shell := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><title>app</title></head><body><div id="root"></div></body></html>`)
+	}))
+	defer shell.Close()
+	rf, _ := stubRenderService(t, `<html><head><title>app</title></head><body><p>Content rendered by JavaScript in the browser.</p></body></html>`)
+	cfg := testCrawlConfig()
+	cfg.Crawler.RenderFallback = rf
+	siteID := testSite(t, db, cfg, shell.URL)
+	ctx := context.Background()
+	pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, shell.URL+"/", 0)
+	if err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	p, ok, err := getPageByID(ctx, db, pageID)
+	if err != nil || !ok {
+		t.Fatalf("stored page not found: ok=%v err=%v", ok, err)
+	}
+	if p.RenderPath != renderPathHeadless {
+		t.Errorf("render_path = %q, want %q", p.RenderPath, renderPathHeadless)
+	}
+}
+
+// skip_unchanged compares the fetched shell, not the rendered HTML, so rendered pages are
+// skipped on recrawl too.
+func TestRenderFallbackSkipUnchanged(t *testing.T) {
+	db := testDB(t)
+	shell := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><title>app</title></head><body><div id="root"></div></body></html>`)
+	}))
+	defer shell.Close()
+	rf, requested := stubRenderService(t, `<html><head><title>app</title></head><body><p>Content rendered by JavaScript in the browser.</p></body></html>`)
+	cfg := testCrawlConfig()
+	cfg.Crawler.RenderFallback = rf
+	cfg.Crawler.SkipUnchanged = true
+	siteID := testSite(t, db, cfg, shell.URL)
+	ctx := context.Background()
+	pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, shell.URL+"/", 0)
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	var htmlHash, fetchHash string
+	if err := db.QueryRow(ctx, `SELECT html_hash, fetch_hash FROM pages WHERE id = $1`, pageID).Scan(&htmlHash, &fetchHash); err != nil {
+		t.Fatal(err)
+	}
+	if htmlHash == fetchHash {
+		t.Errorf("html_hash = fetch_hash = %s, want the rendered and fetched hashes", htmlHash)
+	}
+	id, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, shell.URL+"/", 0)
+	var skip *skipError
+	if !errors.As(err, &skip) || id != pageID {
+		t.Fatalf("recrawl = %d, %v; want skip of page %d", id, err, pageID)
+	}
+	if len(*requested) != 1 {
+		t.Errorf("render requests = %d, want 1 (none on the skipped recrawl)", len(*requested))
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- robots.txt ---
+//
+// Rules are fetched once per scheme+host (through the aux fetch limits) and cached for
+// robots.cache_ttl. 4xx means "no robots.txt": everything is allowed. 5xx and network
+// errors fail open too, but are logged and retried after robotsErrorTTL.
+
+const (
+	robotsMaxBytes = 512 * 1024
+	robotsErrorTTL = 5 * time.Minute
+)
+
+// robotsRule is one Allow/Disallow line of the group that applies to us.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+type robotsRules struct {
+	rules []robotsRule
+}
+
+// robotsEntry is a cache slot; once makes concurrent workers share a single fetch.
+type robotsEntry struct {
+	once    sync.Once
+	rules   *robotsRules
+	expires time.Time
+}
+
+// robotsCache maps "scheme://host" -> *robotsEntry.
+var robotsCache sync.Map
+
+// robotsAllowed reports whether cfg's agent may fetch u. With robots.respect off it is always true.
+func robotsAllowed(ctx context.Context, cfg Config, ppool *ProxyPool, u *url.URL) bool {
+	if !cfg.Robots.Respect {
+		return true
+	}
+	key := u.Scheme + "://" + u.Host
+	v, _ := robotsCache.LoadOrStore(key, &robotsEntry{})
+	e := v.(*robotsEntry)
+	// detached from ctx: the result is shared with other workers (the client timeout bounds it)
+	e.once.Do(func() { e.rules, e.expires = loadRobots(context.WithoutCancel(ctx), cfg, ppool, key) })
+	if time.Now().After(e.expires) {
+		// next caller refetches; concurrent callers keep using the stale rules meanwhile
+		robotsCache.CompareAndDelete(key, e)
+	}
+	return e.rules.allowed(robotsPath(u))
+}
+
+// loadRobots fetches and parses base/robots.txt, returning the rules and their expiry.
+func loadRobots(ctx context.Context, cfg Config, ppool *ProxyPool, base string) (*robotsRules, time.Time) {
+	ttl := cfg.Robots.CacheTTL.Duration
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	body, status, err := fetchRobots(ctx, cfg, ppool, base)
+	switch {
+	case err != nil:
+		Warn("robots.txt fetch failed, allowing all", "site", base, "err", err)
+		return &robotsRules{}, time.Now().Add(min(ttl, robotsErrorTTL))
+	case status >= 500:
+		Warn("robots.txt server error, allowing all", "site", base, "status", status)
+		return &robotsRules{}, time.Now().Add(min(ttl, robotsErrorTTL))
+	case status >= 400:
+		Debug("no robots.txt, allowing all", "site", base, "status", status)
+		return &robotsRules{}, time.Now().Add(ttl)
+	}
+	return parseRobots(strings.NewReader(body), cfg.Crawler.robotsUATokens(cfg.Robots)), time.Now().Add(ttl)
+}
+
+func fetchRobots(ctx context.Context, cfg Config, ppool *ProxyPool, base string) (string, int, error) {
+	release, err := acquireAuxFetchSlot(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+	host := ""
+	if u, err := url.Parse(base); err == nil {
+		host = normalizeHost(u.Host)
+	}
+	client := buildHTTPClient(ppool.NextFor(host), cfg.Crawler.HTMLFetchTimeout.Duration)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/robots.txt", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if ua := firstNonEmpty(cfg.Robots.UserAgent, cfg.Crawler.UserAgent); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", resp.StatusCode, nil
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxBytes))
+	if err != nil {
+		return "", 0, fmt.Errorf("read robots.txt: %w", err)
+	}
+	return string(b), resp.StatusCode, nil
+}
+
+// parseRobots keeps the rules of the groups naming one of our product tokens
+// (case-insensitive, e.g. "gosecrawler"), else of the "*" groups. Matching groups are merged.
+func parseRobots(r io.Reader, tokens []string) *robotsRules {
+	var (
+		best      []robotsRule
+		bestLen   = -1 // 1 = our token, 0 = "*"
+		cur       []robotsRule
+		curLen    = -1
+		inAgents  bool // still reading the User-agent lines of a group
+		haveGroup bool
+	)
+	flush := func() {
+		if !haveGroup || curLen < 0 {
+			return
+		}
+		switch {
+		case curLen > bestLen:
+			best, bestLen = cur, curLen
+		case curLen == bestLen:
+			best = append(best, cur...)
+		}
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				flush()
+				cur, curLen, haveGroup = nil, -1, true
+				inAgents = true
+			}
+			switch {
+			case value == "*":
+				curLen = max(curLen, 0)
+			case slices.ContainsFunc(tokens, func(t string) bool { return strings.EqualFold(t, value) }):
+				curLen = 1
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if value == "" {
+				continue // "Disallow:" with no path allows everything
+			}
+			cur = append(cur, robotsRule{allow: field == "allow", pattern: value})
+		default:
+			// sitemap, crawl-delay, ...: not part of path rules
+		}
+	}
+	flush()
+	return &robotsRules{rules: best}
+}
+
+// allowed applies the longest matching rule; on a tie Allow wins. No match = allowed.
+func (rr *robotsRules) allowed(p string) bool {
+	if rr == nil {
+		return true
+	}
+	allow, matched := true, -1
+	for _, r := range rr.rules {
+		if !robotsMatch(r.pattern, p) {
+			continue
+		}
+		if n := len(r.pattern); n > matched || (n == matched && r.allow) {
+			allow, matched = r.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch matches a robots path pattern ('*' = any run, trailing '$' = end) against p.
+func robotsMatch(pattern, p string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(p, parts[0]) {
+		return false
+	}
+	rest := p[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// robotsPath is the path+query robots rules are matched against.
+func robotsPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+// --- X-Robots-Tag ---
+
+// xRobotsParams are X-Robots-Tag directives that carry a value after a colon; any other
+// "name:" prefix addresses the rest of the header value to that user agent.
+var xRobotsParams = map[string]bool{
+	"unavailable_after": true, "max-snippet": true, "max-image-preview": true, "max-video-preview": true,
+}
+
+// headerRobotsDirectives evaluates X-Robots-Tag response headers like meta robots: values
+// without an agent prefix apply to everyone, "<agent>: ..." only when agent is one of tokens.
+func headerRobotsDirectives(h http.Header, tokens []string) (noindex, nofollow bool) {
+	for _, v := range h.Values("X-Robots-Tag") {
+		if agent, rest, ok := strings.Cut(v, ":"); ok {
+			agent = strings.ToLower(strings.TrimSpace(agent))
+			if !xRobotsParams[agent] && !strings.ContainsAny(agent, ", ") {
+				if !slices.ContainsFunc(tokens, func(t string) bool { return strings.EqualFold(strings.TrimSpace(t), agent) }) {
+					continue
+				}
+				v = rest
+			}
+		}
+		ni, nf := parseRobotsDirectives(v)
+		noindex, nofollow = noindex || ni, nofollow || nf
+	}
+	return noindex, nofollow
+}
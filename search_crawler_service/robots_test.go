@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/a", true},
+		{"/private", "/privateer", true},
+		{"/private/", "/private", false},
+		{"/*.pdf", "/docs/a.pdf", true},
+		{"/*.pdf$", "/docs/a.pdf", true},
+		{"/*.pdf$", "/docs/a.pdf?x=1", false},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/axxcyyb", false},
+		{"/search$", "/search", true},
+		{"/search$", "/search/x", false},
+		{"/*?sort=", "/list?sort=asc", true},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	const txt = `# comment
+User-agent: *
+Disallow: /private
+Allow: /private/open
+Disallow: /*.pdf$
+
+User-agent: OtherBot
+Disallow: /
+
+User-agent: GoseCrawler
+User-agent: SomeBot
+Disallow: /gose-only   # trailing comment
+Disallow:
+Sitemap: https://example.com/sitemap.xml
+
+user-agent: gosecrawler
+allow: /gose-only/ok
+`
+	tests := []struct {
+		name   string
+		tokens []string
+		path   string
+		want   bool
+	}{
+		{"star group applies without our token", nil, "/private/x", false},
+		{"longest match wins", nil, "/private/open/page", true},
+		{"anchored pattern", nil, "/a/b.pdf", false},
+		{"anchored pattern with query", nil, "/a/b.pdf?dl=1", true},
+		{"unmatched path", nil, "/public", true},
+		{"our group replaces star", []string{"gosecrawler"}, "/private/x", true},
+		{"our group rules", []string{"gosecrawler"}, "/gose-only/x", false},
+		{"our groups are merged", []string{"gosecrawler"}, "/gose-only/ok/x", true},
+		{"other bot's group ignored", []string{"gosecrawler"}, "/anything", true},
+		{"token case-insensitive", []string{"GOSECRAWLER"}, "/gose-only", false},
+	}
+	for _, tt := range tests {
+		rr := parseRobots(strings.NewReader(txt), tt.tokens)
+		if got := rr.allowed(tt.path); got != tt.want {
+			t.Errorf("%s: allowed(%q) = %v, want %v", tt.name, tt.path, got, tt.want)
+		}
+	}
+	if !(*robotsRules)(nil).allowed("/x") || !parseRobots(strings.NewReader(""), nil).allowed("/x") {
+		t.Error("missing rules must allow everything")
+	}
+	tie := parseRobots(strings.NewReader("User-agent: *\nDisallow: /page\nAllow: /page\n"), nil)
+	if !tie.allowed("/page") {
+		t.Error("equal-length Allow must win over Disallow")
+	}
+}
+
+// robots.txt is fetched once per host; 4xx and 5xx both allow everything.
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		respect  bool
+		path     string
+		want     bool
+		wantHits int32
+	}{
+		{name: "disallowed", status: 200, body: "User-agent: *\nDisallow: /private\n", respect: true, path: "/private/a", want: false, wantHits: 1},
+		{name: "allowed", status: 200, body: "User-agent: *\nDisallow: /private\n", respect: true, path: "/public", want: true, wantHits: 1},
+		{name: "404 allows all", status: 404, respect: true, path: "/private/a", want: true, wantHits: 1},
+		{name: "5xx fails open", status: 503, body: "User-agent: *\nDisallow: /\n", respect: true, path: "/private/a", want: true, wantHits: 1},
+		{name: "respect off", status: 200, body: "User-agent: *\nDisallow: /\n", path: "/private/a", want: true, wantHits: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/robots.txt" {
+					t.Errorf("unexpected fetch of %s", r.URL.Path)
+				}
+				hits.Add(1)
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Robots.Respect = tt.respect
+			u, _ := url.Parse(srv.URL + tt.path)
+			ppool := testProxyPool(t)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if got := robotsAllowed(context.Background(), cfg, ppool, u); got != tt.want {
+						t.Errorf("robotsAllowed(%s) = %v, want %v", tt.path, got, tt.want)
+					}
+				}()
+			}
+			wg.Wait()
+			if hits.Load() != tt.wantHits {
+				t.Errorf("robots.txt fetched %d times, want %d", hits.Load(), tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRobotsPath(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"http://x.test", "/"},
+		{"http://x.test/a%20b", "/a%20b"},
+		{"http://x.test/list?sort=asc", "/list?sort=asc"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.raw)
+		if got := robotsPath(u); got != tt.want {
+			t.Errorf("robotsPath(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderRobotsDirectives(t *testing.T) {
+	tokens := []string{"gosecrawler"}
+	tests := []struct {
+		name                   string
+		values                 []string
+		wantNoindex, wantNofol bool
+	}{
+		{name: "none set", values: nil},
+		{name: "noindex", values: []string{"noindex"}, wantNoindex: true},
+		{name: "list", values: []string{"NoIndex, NoFollow"}, wantNoindex: true, wantNofol: true},
+		{name: "none directive", values: []string{"none"}, wantNoindex: true, wantNofol: true},
+		{name: "several headers", values: []string{"nofollow", "noarchive", "noindex"}, wantNoindex: true, wantNofol: true},
+		{name: "our agent", values: []string{"GoseCrawler: noindex"}, wantNoindex: true},
+		{name: "other agent", values: []string{"googlebot: noindex, nofollow"}},
+		{name: "parameter is not an agent", values: []string{"unavailable_after: 25 Jun 2010 15:00:00 PST"}},
+		{name: "parameter in a list", values: []string{"noindex, max-snippet: 20"}, wantNoindex: true},
+		{name: "other agent and generic", values: []string{"otherbot: noindex", "nofollow"}, wantNofol: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range tt.values {
+				h.Add("X-Robots-Tag", v)
+			}
+			noindex, nofollow := headerRobotsDirectives(h, tokens)
+			if noindex != tt.wantNoindex || nofollow != tt.wantNofol {
+				t.Errorf("headerRobotsDirectives = %v, %v; want %v, %v", noindex, nofollow, tt.wantNoindex, tt.wantNofol)
+			}
+		})
+	}
+}
+
+// X-Robots-Tag noindex skips storing the page but still follows its links unless nofollow.
+func TestProcessURLXRobotsTag(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name, header          string
+		wantStored, wantChild bool
+	}{
+		{name: "no header", wantStored: true, wantChild: true},
+		{name: "noindex", header: "noindex", wantChild: true},
+		{name: "noindex nofollow", header: "noindex, nofollow"},
+		{name: "nofollow", header: "nofollow", wantStored: true},
+		{name: "other agent", header: "otherbot: noindex", wantStored: true, wantChild: true},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/" {
+					http.NotFound(w, r)
+					return
+				}
+				if tt.header != "" {
+					w.Header().Set("X-Robots-Tag", tt.header)
+				}
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = io.WriteString(w, `<html><title>x</title><a href="/child">child</a></html>`)
+			}))
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Robots.Respect = true
+			cfg.Crawler.RobotsUATokens = []string{"gosecrawler"}
+			siteID := testSite(t, db, cfg, srv.URL)
+			_, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+			var skip *skipError
+			if tt.wantStored && err != nil || !tt.wantStored && !errors.As(err, &skip) {
+				t.Fatalf("processURL: %v (want stored %v)", err, tt.wantStored)
+			}
+			var pages, children int
+			if err := db.QueryRow(ctx, `SELECT count(*) FROM pages WHERE site_id = $1`, siteID).Scan(&pages); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow(ctx, `SELECT count(*) FROM crawl_queue WHERE site_id = $1 AND url = $2`, siteID, srv.URL+"/child").Scan(&children); err != nil {
+				t.Fatal(err)
+			}
+			if (pages == 1) != tt.wantStored || (children == 1) != tt.wantChild {
+				t.Errorf("pages = %d, child queued = %d; want stored %v, child %v", pages, children, tt.wantStored, tt.wantChild)
+			}
+		})
+	}
+}
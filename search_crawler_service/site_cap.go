@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Per-site page cap ---
+//
+// crawler.max_pages_per_site (or sites.max_pages) bounds how many pages of a site are
+// stored or waiting in the queue. The count (sites.pages_count plus queued/processing
+// items) is read at most once per siteCapTTL; links enqueued in between are added
+// locally, so the cap can only be overshot by other crawler instances. pages_count is
+// bumped on real inserts only and recounted hourly (runSitePagesRecount).
+
+// siteCapTTL is how long a site's page count is trusted before re-reading it.
+const siteCapTTL = 30 * time.Second
+
+type siteCapState struct {
+	limit   int64 // 0 = unlimited
+	count   int64
+	checked time.Time
+	logged  bool // "cap reached" already logged
+}
+
+var siteCaps = struct {
+	sync.Mutex
+	sites map[int64]*siteCapState
+}{sites: make(map[int64]*siteCapState)}
+
+// siteCapReached reports whether no more links of the site should be enqueued.
+func siteCapReached(ctx context.Context, db *pgxpool.Pool, c CrawlerConfig, siteID int64, siteDomain string) bool {
+	siteCaps.Lock()
+	st, ok := siteCaps.sites[siteID]
+	if !ok {
+		st = &siteCapState{}
+		siteCaps.sites[siteID] = st
+	}
+	stale := time.Since(st.checked) >= siteCapTTL
+	siteCaps.Unlock()
+
+	if stale {
+		const q = `
+SELECT COALESCE(s.max_pages, $2),
+       s.pages_count + (SELECT count(*) FROM crawl_queue q
+                        WHERE q.site_id = s.id AND q.status IN ('queued', 'processing'))
+FROM sites s
+WHERE s.id = $1`
+		var limit, count int64
+		if err := db.QueryRow(ctx, q, siteID, c.MaxPagesPerSite).Scan(&limit, &count); err != nil {
+			Warn("site page count failed", "site_id", siteID, "err", err)
+			return false
+		}
+		siteCaps.Lock()
+		st.limit, st.count, st.checked = limit, count, time.Now()
+		siteCaps.Unlock()
+	}
+
+	siteCaps.Lock()
+	defer siteCaps.Unlock()
+	if st.limit <= 0 || st.count < st.limit {
+		st.logged = false
+		return false
+	}
+	if !st.logged {
+		st.logged = true
+		Info("site reached max pages, not enqueuing more links", "site_id", siteID, "domain", siteDomain, "max_pages", st.limit, "pages", st.count)
+	}
+	return true
+}
+
+// siteCapAdd counts n links of the site as enqueued until the next refresh (negative n
+// returns reservations that turned out to be duplicates).
+func siteCapAdd(siteID int64, n int64) {
+	siteCaps.Lock()
+	if st, ok := siteCaps.sites[siteID]; ok {
+		st.count += n
+	}
+	siteCaps.Unlock()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Per-site request overrides (sites.user_agent, sites.headers) ---
+//
+// Sites that block the global User-Agent can get their own, plus extra request headers,
+// by updating the sites row; no redeploy is needed. The row is re-read at most once per
+// siteFetchTTL. A site header replaces the crawler.headers entry of the same name; the
+// User-Agent is sites.user_agent, else a User-Agent in sites.headers, else crawler.user_agent.
+
+const siteFetchTTL = time.Minute
+
+type siteFetchOverride struct {
+	userAgent string
+	headers   map[string]string
+	checked   time.Time
+}
+
+var siteFetchOverrides = struct {
+	sync.Mutex
+	sites map[int64]siteFetchOverride
+}{sites: make(map[int64]siteFetchOverride)}
+
+// loadSiteFetchOverride returns the site's overrides, cached for siteFetchTTL. On a read
+// error the site keeps the global settings until the next check.
+func loadSiteFetchOverride(ctx context.Context, db *pgxpool.Pool, siteID int64) siteFetchOverride {
+	siteFetchOverrides.Lock()
+	o, ok := siteFetchOverrides.sites[siteID]
+	siteFetchOverrides.Unlock()
+	if ok && time.Since(o.checked) < siteFetchTTL {
+		return o
+	}
+	o = siteFetchOverride{checked: time.Now()}
+	var headers map[string]string
+	err := db.QueryRow(ctx, `SELECT COALESCE(user_agent, ''), headers FROM sites WHERE id = $1`, siteID).Scan(&o.userAgent, &headers)
+	if err != nil {
+		Warn("site request overrides not loaded", "site_id", siteID, "err", err)
+	}
+	for k, v := range headers {
+		if o.headers == nil {
+			o.headers = make(map[string]string, len(headers))
+		}
+		o.headers[http.CanonicalHeaderKey(k)] = v
+	}
+	siteFetchOverrides.Lock()
+	siteFetchOverrides.sites[siteID] = o
+	siteFetchOverrides.Unlock()
+	return o
+}
+
+// applySiteFetchOverrides layers the site's User-Agent and headers over opts.
+func applySiteFetchOverrides(ctx context.Context, db *pgxpool.Pool, siteID int64, opts *fetchOptions) {
+	if db == nil {
+		return
+	}
+	o := loadSiteFetchOverride(ctx, db, siteID)
+	if len(o.headers) > 0 {
+		merged := make(map[string]string, len(opts.Headers)+len(o.headers))
+		for k, v := range opts.Headers {
+			merged[http.CanonicalHeaderKey(k)] = v
+		}
+		for k, v := range o.headers {
+			merged[k] = v
+		}
+		opts.Headers = merged
+	}
+	opts.UserAgent = firstNonEmpty(o.userAgent, o.headers["User-Agent"], opts.UserAgent)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Soft 404 detection (crawler.soft_404) ---
+//
+// Some sites answer missing pages with 200 and a "not found" body. Such a page is skipped
+// (not stored) when its title contains a not-found phrase, when a short page's text does,
+// or when its body is identical to what the host returns for a random URL (probe).
+
+// Soft404Config configures the heuristics; phrases are matched case-insensitively.
+type Soft404Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Phrases maps a language to its not-found phrases; nil = defaultSoft404Phrases.
+	Phrases map[string][]string `yaml:"phrases"`
+	// MaxWords: text phrases (and a bare "404" title) only count on pages this short (default 150).
+	MaxWords int `yaml:"max_words"`
+	// Probe fetches one random URL per host (cached for a day); a 200 body is the host's
+	// not-found fingerprint.
+	Probe bool `yaml:"probe"`
+}
+
+var defaultSoft404Phrases = map[string][]string{
+	"en": {"page not found", "404 not found", "page doesn't exist", "page does not exist", "page cannot be found", "no longer available"},
+	"ru": {"страница не найдена", "ошибка 404", "страница не существует", "запрашиваемая страница не найдена", "страница удалена"},
+}
+
+var re404Word = regexp.MustCompile(`\b404\b`)
+
+func (c Soft404Config) maxWords() int { return nonZero(c.MaxWords, 150) }
+
+func (c Soft404Config) phrases() map[string][]string {
+	if c.Phrases != nil {
+		return c.Phrases
+	}
+	return defaultSoft404Phrases
+}
+
+// match returns why title/text look like a not-found page, or "".
+func (c Soft404Config) match(title, text string, words int) string {
+	title, short := strings.ToLower(title), words <= c.maxWords()
+	var body string
+	if short {
+		body = strings.ToLower(text)
+	}
+	for _, phrases := range c.phrases() {
+		for _, p := range phrases {
+			p = strings.ToLower(strings.TrimSpace(p))
+			switch {
+			case p == "":
+			case strings.Contains(title, p):
+				return "title contains " + `"` + p + `"`
+			case short && strings.Contains(body, p):
+				return "short page contains " + `"` + p + `"`
+			}
+		}
+	}
+	if short && re404Word.MatchString(title) {
+		return "short page with 404 in title"
+	}
+	return ""
+}
+
+// soft404TTL is how long a host's probe result is reused.
+const soft404TTL = 24 * time.Hour
+
+type soft404Probe struct {
+	hash    string // body hash of a random URL answered with 200; "" = proper 404
+	checked time.Time
+}
+
+var soft404Probes = struct {
+	sync.Mutex
+	hosts map[string]soft404Probe
+}{hosts: make(map[string]soft404Probe)}
+
+// soft404Fingerprint returns the body hash the host serves for a URL that cannot exist
+// ("" when it answers with an error status or a redirect, or the probe failed). opts are
+// the page's fetch options, so the probe looks like the page request.
+func soft404Fingerprint(ctx context.Context, cfg Config, host string, polite PolitenessProfile, proxyURL *url.URL, page *url.URL, opts fetchOptions) string {
+	key := page.Scheme + "://" + page.Host
+	soft404Probes.Lock()
+	p, ok := soft404Probes.hosts[key]
+	soft404Probes.Unlock()
+	if ok && time.Since(p.checked) < soft404TTL {
+		return p.hash
+	}
+
+	var buf [12]byte
+	_, _ = rand.Read(buf[:])
+	probeURL := key + "/" + hex.EncodeToString(buf[:]) + "-gose-404-probe"
+	p = soft404Probe{checked: time.Now()}
+	releaseHost, err := acquireHostSlot(ctx, host, polite.Concurrency)
+	if err != nil {
+		return ""
+	}
+	release, err := acquireFetchSlot(ctx)
+	if err != nil {
+		releaseHost()
+		return ""
+	}
+	client := buildHTTPClient(proxyURL, cfg.Crawler.HTMLFetchTimeout.Duration)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	opts.IfNoneMatch, opts.IfModifiedSince = "", ""
+	opts.AcceptStatusMin, opts.AcceptStatusMax = 200, 200
+	opts.StreamParse, opts.KeepHTML = false, false
+	res, err := fetchHTML(ctx, client, probeURL, opts)
+	release()
+	releaseHost()
+	if err == nil {
+		p.hash = res.BodyHash
+		Info("host answers missing pages with 200", "host", key, "probe", probeURL)
+	} else if ctx.Err() != nil {
+		return ""
+	}
+	soft404Probes.Lock()
+	soft404Probes.hosts[key] = p
+	soft404Probes.Unlock()
+	return p.hash
+}
@@ -2,22 +2,38 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // DB: sites
 
+// errSiteLimit is returned by ensureSite for a new domain once crawler.max_sites is reached.
+var errSiteLimit = errors.New("site limit reached (crawler.max_sites)")
+
 func ensureSite(ctx context.Context, db *pgxpool.Pool, domain string, cfg Config) (int64, error) {
 	var id int64
+	// Existing sites always pass; a new row is only inserted while below max_sites (0 = unlimited).
 	const q = `
 INSERT INTO sites (domain, enabled, rps_limit, rps_burst, depth_limit)
-VALUES ($1, TRUE, $2, $3, $4)
+SELECT $1, TRUE, $2, $3, $4
+WHERE $5 = 0
+   OR EXISTS (SELECT 1 FROM sites WHERE domain = $1)
+   OR (SELECT count(*) FROM sites) < $5
 ON CONFLICT (domain) DO UPDATE SET updated_at = now()
 RETURNING id;`
-	err := db.QueryRow(ctx, q, domain, cfg.Crawler.RPSPerHost, cfg.Crawler.RPSBurst, cfg.Crawler.DepthLimit).Scan(&id)
+	err := db.QueryRow(ctx, q, domain, cfg.Crawler.RPSPerHost, cfg.Crawler.RPSBurst, cfg.Crawler.DepthLimit, cfg.Crawler.MaxSites).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		Warn("new site refused: max_sites reached", "domain", domain, "max_sites", cfg.Crawler.MaxSites)
+		return 0, errSiteLimit
+	}
 	if err != nil {
 		Error("ensureSite failed", "domain", domain, "err", err)
 		return 0, err
@@ -28,15 +44,21 @@ RETURNING id;`
 
 // DB: crawl_queue
 
-func enqueueIfNotExists(ctx context.Context, db *pgxpool.Pool, siteID int64, url string, urlHash string, priority int) (bool, error) {
+// enqueueIfNotExists queues url unless it is already queued/processing or, with doneWindow > 0,
+// was finished within the last doneWindow (explicit recrawls pass 0).
+func enqueueIfNotExists(ctx context.Context, db *pgxpool.Pool, siteID int64, url string, urlHash string, priority, depth int, doneWindow time.Duration) (bool, error) {
 	const ins = `
-INSERT INTO crawl_queue (site_id, url, url_hash, priority, status, attempts, created_at, updated_at)
-SELECT $1, $2, $3, $4, 'queued'::crawl_status, 0, now(), now()
+INSERT INTO crawl_queue (site_id, url, url_hash, priority, depth, status, attempts, created_at, updated_at)
+SELECT $1, $2, $3, $4, $6, 'queued'::crawl_status, 0, now(), now()
 WHERE NOT EXISTS (
   SELECT 1 FROM crawl_queue
   WHERE site_id = $1 AND url_hash = $3 AND status IN ('queued','processing')
-);`
-	ct, err := db.Exec(ctx, ins, siteID, url, urlHash, priority)
+) AND ($5::float8 <= 0 OR NOT EXISTS (
+  SELECT 1 FROM crawl_queue
+  WHERE site_id = $1 AND url_hash = $3 AND status = 'done'
+    AND updated_at > now() - make_interval(secs => $5::float8)
+));`
+	ct, err := db.Exec(ctx, ins, siteID, url, urlHash, priority, doneWindow.Seconds(), depth)
 	if err != nil {
 		Error("enqueueIfNotExists failed", "site_id", siteID, "url", url, "err", err)
 		return false, err
@@ -50,37 +72,192 @@ WHERE NOT EXISTS (
 	return ok, nil
 }
 
-func markQueueError(ctx context.Context, db *pgxpool.Pool, id int64, msg string, retryAfter time.Duration) {
+// queueLink is one URL for enqueueLinks.
+type queueLink struct {
+	URL      string
+	Hash     string
+	Priority int
+}
+
+// enqueueLinks is enqueueIfNotExists for many URLs of one site in a single statement; it
+// returns how many were inserted. URLs must be distinct.
+func enqueueLinks(ctx context.Context, db *pgxpool.Pool, siteID int64, links []queueLink, depth int, doneWindow time.Duration) (int, error) {
+	if len(links) == 0 {
+		return 0, nil
+	}
+	urls := make([]string, len(links))
+	hashes := make([]string, len(links))
+	prios := make([]int32, len(links))
+	for i, l := range links {
+		urls[i], hashes[i], prios[i] = l.URL, l.Hash, int32(l.Priority)
+	}
+	// ON CONFLICT covers a concurrent insert of the same URL (partial unique index)
+	const ins = `
+INSERT INTO crawl_queue (site_id, url, url_hash, priority, depth, status, attempts, created_at, updated_at)
+SELECT $1, t.url, t.url_hash, t.priority, $5, 'queued'::crawl_status, 0, now(), now()
+FROM unnest($2::text[], $3::text[], $4::int[]) AS t(url, url_hash, priority)
+WHERE NOT EXISTS (
+  SELECT 1 FROM crawl_queue q
+  WHERE q.site_id = $1 AND q.url_hash = t.url_hash AND q.status IN ('queued','processing')
+) AND ($6::float8 <= 0 OR NOT EXISTS (
+  SELECT 1 FROM crawl_queue q
+  WHERE q.site_id = $1 AND q.url_hash = t.url_hash AND q.status = 'done'
+    AND q.updated_at > now() - make_interval(secs => $6::float8)
+))
+ON CONFLICT DO NOTHING;`
+	ct, err := db.Exec(ctx, ins, siteID, urls, hashes, prios, depth, doneWindow.Seconds())
+	if err != nil {
+		Error("enqueueLinks failed", "site_id", siteID, "links", len(links), "err", err)
+		return 0, err
+	}
+	n := int(ct.RowsAffected())
+	Debug("enqueueLinks", "site_id", siteID, "links", len(links), "inserted", n)
+	return n, nil
+}
+
+// seedQueue enqueues crawler.seed_urls the way /api/enqueue does. Seeds already queued or
+// processing are skipped by enqueueIfNotExists, so restarts do not duplicate them.
+func seedQueue(ctx context.Context, db *pgxpool.Pool, cfg Config) {
+	if len(cfg.Crawler.SeedURLs) == 0 {
+		return
+	}
+	enqueued := 0
+	for _, raw := range cfg.Crawler.SeedURLs {
+		parsed, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			Warn("seed url ignored: invalid url", "url", raw)
+			continue
+		}
+		canonicalizeURL(parsed, cfg.Crawler)
+		host := parsed.Host
+		if len(cfg.Crawler.WhitelistDomains) > 0 && !isHostAllowed(host, cfg.Crawler.WhitelistDomains) {
+			Warn("seed url ignored: host not in whitelist", "url", raw)
+			continue
+		}
+		siteID, err := ensureSite(ctx, db, host, cfg)
+		if err != nil {
+			Warn("seed url ignored: ensure site failed", "url", raw, "err", err)
+			continue
+		}
+		finalURL := parsed.String()
+		ok, err := enqueueIfNotExists(ctx, db, siteID, finalURL, sha256Hex(finalURL), 0, 0, 0)
+		if err != nil {
+			Warn("seed url ignored: enqueue failed", "url", raw, "err", err)
+			continue
+		}
+		if ok {
+			enqueued++
+		}
+	}
+	Info("seed urls enqueued", "total", len(cfg.Crawler.SeedURLs), "enqueued", enqueued)
+}
+
+// Queue state changes also maintain the per-site summary on sites (last_crawled_at, error_count)
+// in the same statement, so concurrent workers never race on read-modify-write.
+
+// markQueueError marks an item permanently failed: it is never claimed again
+// (retries go through markQueueRetry while attempts remain).
+func markQueueError(ctx context.Context, db *pgxpool.Pool, id int64, msg, class string) {
+	const q = `
+WITH q AS (
+  UPDATE crawl_queue
+  SET status = 'error',
+      last_error = $2,
+      error_class = NULLIF($3, ''),
+      next_try_at = NULL,
+      updated_at = now()
+  WHERE id = $1
+  RETURNING site_id
+)
+UPDATE sites SET last_crawled_at = now(), error_count = error_count + 1
+FROM q WHERE sites.id = q.site_id;`
+	_, _ = db.Exec(ctx, q, id, msg, class)
+	currentRun.errored.Add(1)
+	Warn("queue item marked error", "id", id, "class", class, "error", msg)
+}
+
+// markQueueRetry puts an item back to 'queued', due after retryAfter (see RetryBackoff).
+func markQueueRetry(ctx context.Context, db *pgxpool.Pool, id int64, msg, class string, retryAfter time.Duration) {
 	const q = `
 UPDATE crawl_queue
-SET status = 'error',
+SET status = 'queued',
     last_error = $2,
+    error_class = NULLIF($4, ''),
     next_try_at = now() + $3::interval,
     updated_at = now()
 WHERE id = $1;`
-	_, _ = db.Exec(ctx, q, id, msg, fmt.Sprintf("%f seconds", retryAfter.Seconds()))
-	Warn("queue item marked error", "id", id, "retry_after", retryAfter.String(), "error", msg)
+	_, _ = db.Exec(ctx, q, id, msg, fmt.Sprintf("%f seconds", retryAfter.Seconds()), class)
+	Info("queue item requeued", "id", id, "retry_after", retryAfter.String(), "class", class, "error", msg)
+}
+
+// markQueueSkipped finishes an item without storing a page; the reason is kept in last_error for triage.
+func markQueueSkipped(ctx context.Context, db *pgxpool.Pool, id int64, reason string) {
+	const q = `
+WITH q AS (
+  UPDATE crawl_queue
+  SET status = 'done', last_error = $2, error_class = NULL, updated_at = now()
+  WHERE id = $1
+  RETURNING site_id
+)
+UPDATE sites SET last_crawled_at = now()
+FROM q WHERE sites.id = q.site_id;`
+	_, _ = db.Exec(ctx, q, id, "skipped: "+reason)
+	currentRun.skipped.Add(1)
+	Info("queue item skipped", "id", id, "reason", reason)
 }
 
 func markQueueDone(ctx context.Context, db *pgxpool.Pool, id int64) {
 	const q = `
-UPDATE crawl_queue
-SET status = 'done', updated_at = now()
-WHERE id = $1;`
+WITH q AS (
+  UPDATE crawl_queue
+  SET status = 'done', error_class = NULL, updated_at = now()
+  WHERE id = $1
+  RETURNING site_id
+)
+UPDATE sites SET last_crawled_at = now()
+FROM q WHERE sites.id = q.site_id;`
 	_, _ = db.Exec(ctx, q, id)
+	currentRun.done.Add(1)
 	Debug("queue item done", "id", id)
 }
 
 // DB: pages
 
-func upsertPage(ctx context.Context, db *pgxpool.Pool, siteID int64, rawURL string, title, description, lang string, httpStatus int, contentType, html, text string) (int64, error) {
-	urlHash := sha256Hex(rawURL)
-	htmlHash := sha256Hex(html)
-	var id int64
+// pageRecord is everything upsertPage writes for one fetched page.
+type pageRecord struct {
+	SiteID      int64
+	URL         string
+	Title       string
+	Description string
+	Lang        string
+	HTTPStatus  int
+	ContentType string
+	HTML        string // inline HTML (empty when discarded or kept in an external store)
+	HTMLRef     string // external store ref (see html_store.go), empty for inline storage
+	HTMLHash    string // sha256 of the stored HTML (the rendered one for headless pages)
+	FetchHash   string // sha256 of the received body, compared by crawler.skip_unchanged
+	Text        string
+	Headers     map[string]string
+	Meta        map[string]string // crawler.meta_tags present on the page
+	RenderPath  string            // static or headless (render_fallback)
+	// fetch metrics (crawler.record_fetch_metrics); zero = not recorded
+	TTFBMS  int64
+	FetchMS int64
+	RawSize int64
+	// validators for conditional recrawls (crawler.conditional_get)
+	ETag         string
+	LastModified string
+	Charset      string   // encoding the body was transcoded from
+	Truncated    bool     // body cut at crawler.html_max_size
+	Headings     []string // <h1>-<h3> texts (crawler.index_headings); nil = NULL
+	WordCount    int      // words of Text
+	CanonicalURL string   // resolved <link rel="canonical">; "" = none declared
+}
 
-	const q = `
-INSERT INTO pages (site_id, url, url_hash, title, description, lang, http_status, content_type, html_hash, html, fetched_at, text, created_at, updated_at)
-VALUES ($1,$2,$3,$4,$5, NULLIF($6,''), $7,$8,$9,$10,now(),$11,now(),now())
+// upsertPageSQL stores a fetched page keyed by (site_id, url_hash); args from upsertPageArgs.
+const upsertPageSQL = `
+INSERT INTO pages (site_id, url, url_hash, title, description, lang, http_status, content_type, html_hash, html, html_ref, fetched_at, text, headers, render_path, ttfb_ms, fetch_ms, raw_size, meta, etag, last_modified, charset, truncated, headings, word_count, canonical_url, fetch_hash, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5, NULLIF($6,''), $7,$8,$9,NULLIF($10,''),NULLIF($11,''),now(),$12,$13::jsonb,NULLIF($14,''),NULLIF($15,0),NULLIF($16,0),NULLIF($17,0),$18::jsonb,NULLIF($19,''),NULLIF($20,''),NULLIF($21,''),$22,$23,$24,NULLIF($25,''),NULLIF($26,''),now(),now())
 ON CONFLICT (site_id, url_hash) DO UPDATE
 SET title = COALESCE(NULLIF(EXCLUDED.title, ''), pages.title),
 	   description = COALESCE(NULLIF(EXCLUDED.description, ''), pages.description),
@@ -89,18 +266,301 @@ SET title = COALESCE(NULLIF(EXCLUDED.title, ''), pages.title),
 	   content_type = EXCLUDED.content_type,
 	   html_hash = EXCLUDED.html_hash,
 	   html = EXCLUDED.html,
+	   html_ref = EXCLUDED.html_ref,
 	   fetched_at = EXCLUDED.fetched_at,
 	   text = EXCLUDED.text,
+	   headers = EXCLUDED.headers,
+	   render_path = EXCLUDED.render_path,
+	   ttfb_ms = EXCLUDED.ttfb_ms,
+	   fetch_ms = EXCLUDED.fetch_ms,
+	   raw_size = EXCLUDED.raw_size,
+	   meta = EXCLUDED.meta,
+	   etag = EXCLUDED.etag,
+	   last_modified = EXCLUDED.last_modified,
+	   charset = EXCLUDED.charset,
+	   truncated = EXCLUDED.truncated,
+	   headings = EXCLUDED.headings,
+	   word_count = EXCLUDED.word_count,
+	   canonical_url = EXCLUDED.canonical_url,
+	   fetch_hash = EXCLUDED.fetch_hash,
 	   updated_at = now()
-RETURNING id;`
-	if err := db.QueryRow(ctx, q, siteID, rawURL, urlHash, title, description, lang, httpStatus, contentType, htmlHash, html, text).Scan(&id); err != nil {
-		Error("upsertPage failed", "site_id", siteID, "url", rawURL, "err", err)
+RETURNING id, (xmax = 0) AS inserted;`
+
+func upsertPageArgs(p pageRecord) []any {
+	var headersJSON []byte
+	if len(p.Headers) > 0 {
+		headersJSON, _ = json.Marshal(p.Headers)
+	}
+	var metaJSON []byte
+	if len(p.Meta) > 0 {
+		metaJSON, _ = json.Marshal(p.Meta)
+	}
+	return []any{p.SiteID, p.URL, sha256Hex(p.URL), p.Title, p.Description, p.Lang, p.HTTPStatus, p.ContentType,
+		p.HTMLHash, p.HTML, p.HTMLRef, p.Text, headersJSON, p.RenderPath, p.TTFBMS, p.FetchMS, p.RawSize, metaJSON, p.ETag, p.LastModified, p.Charset, p.Truncated, p.Headings, p.WordCount, p.CanonicalURL, p.FetchHash}
+}
+
+// storePage upserts a page, through the write batcher when crawler.page_batch is enabled.
+func storePage(ctx context.Context, db *pgxpool.Pool, p pageRecord) (int64, error) {
+	if pageWriter != nil {
+		return pageWriter.upsert(ctx, p)
+	}
+	return upsertPage(ctx, db, p)
+}
+
+// upsertPage stores a fetched page keyed by (site_id, url_hash).
+func upsertPage(ctx context.Context, db *pgxpool.Pool, p pageRecord) (int64, error) {
+	var id int64
+	var inserted bool
+	if err := db.QueryRow(ctx, upsertPageSQL, upsertPageArgs(p)...).Scan(&id, &inserted); err != nil {
+		Error("upsertPage failed", "site_id", p.SiteID, "url", p.URL, "err", err)
 		return 0, err
 	}
-	Debug("upsertPage ok", "site_id", siteID, "url", rawURL, "id", id, "status", httpStatus, "ctype", contentType, "html_bytes", len(html), "text_bytes", len(text))
+	if inserted {
+		incSitePages(ctx, db, p.SiteID)
+	}
+	Debug("upsertPage ok", "site_id", p.SiteID, "url", p.URL, "id", id, "status", p.HTTPStatus, "ctype", p.ContentType, "html_bytes", len(p.HTML), "html_ref", p.HTMLRef, "text_bytes", len(p.Text))
 	return id, nil
 }
 
+// getPageValidators returns the stored ETag/Last-Modified of a page (ok = page exists with at least one).
+func getPageValidators(ctx context.Context, db *pgxpool.Pool, siteID int64, urlHash string) (id int64, etag, lastModified string, ok bool) {
+	const q = `
+SELECT id, COALESCE(etag, ''), COALESCE(last_modified, '')
+FROM pages
+WHERE site_id = $1 AND url_hash = $2 AND (etag IS NOT NULL OR last_modified IS NOT NULL)`
+	if err := db.QueryRow(ctx, q, siteID, urlHash).Scan(&id, &etag, &lastModified); err != nil {
+		return 0, "", "", false
+	}
+	return id, etag, lastModified, true
+}
+
+// getPageFetchHash returns the id and the hash of the body last fetched for a page (ok =
+// page exists with a hash). Rows stored before fetch_hash fall back to html_hash.
+func getPageFetchHash(ctx context.Context, db *pgxpool.Pool, siteID int64, urlHash string) (id int64, fetchHash string, ok bool) {
+	const q = `SELECT id, COALESCE(fetch_hash, html_hash) FROM pages
+WHERE site_id = $1 AND url_hash = $2 AND COALESCE(fetch_hash, html_hash) IS NOT NULL`
+	if err := db.QueryRow(ctx, q, siteID, urlHash).Scan(&id, &fetchHash); err != nil {
+		return 0, "", false
+	}
+	return id, fetchHash, true
+}
+
+// recordPageStatus records the HTTP status of a rejected response without touching stored content.
+func recordPageStatus(ctx context.Context, db *pgxpool.Pool, siteID int64, rawURL string, httpStatus int) error {
+	const q = `
+INSERT INTO pages (site_id, url, url_hash, http_status, fetched_at, created_at, updated_at)
+VALUES ($1,$2,$3,$4,now(),now(),now())
+ON CONFLICT (site_id, url_hash) DO UPDATE
+SET http_status = EXCLUDED.http_status,
+    fetched_at = EXCLUDED.fetched_at,
+    updated_at = now()
+RETURNING (xmax = 0) AS inserted;`
+	var inserted bool
+	if err := db.QueryRow(ctx, q, siteID, rawURL, sha256Hex(rawURL), httpStatus).Scan(&inserted); err != nil {
+		Error("recordPageStatus failed", "site_id", siteID, "url", rawURL, "status", httpStatus, "err", err)
+		return err
+	}
+	if inserted {
+		incSitePages(ctx, db, siteID)
+	}
+	return nil
+}
+
+// incSitePages bumps the denormalized page counter after a new pages row was inserted.
+func incSitePages(ctx context.Context, db *pgxpool.Pool, siteID int64) {
+	if _, err := db.Exec(ctx, "UPDATE sites SET pages_count = pages_count + 1 WHERE id = $1", siteID); err != nil {
+		Warn("incSitePages failed", "site_id", siteID, "err", err)
+	}
+}
+
+// runSitePagesRecount resets sites.pages_count to the real pages count now and every
+// interval, so drift (deleted pages, lost increments) doesn't skew max_pages checks.
+func runSitePagesRecount(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if n, err := recountSitePages(ctx, db); err != nil {
+			Warn("site pages recount failed", "err", err)
+		} else if n > 0 {
+			Info("site pages recount", "corrected", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// recountSitePages corrects sites whose pages_count differs from their pages rows.
+func recountSitePages(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	const q = `
+UPDATE sites s SET pages_count = c.n
+FROM (SELECT s2.id, count(p.id) AS n FROM sites s2 LEFT JOIN pages p ON p.site_id = s2.id GROUP BY s2.id) c
+WHERE s.id = c.id AND s.pages_count <> c.n;`
+	ct, err := db.Exec(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// pageFilter narrows listPages; zero values mean "no filter".
+type pageFilter struct {
+	SiteID      int64
+	Header      string // canonical header name stored in pages.headers
+	HeaderValue string
+	Since       time.Time // first seen (created_at) at or after; zero = any
+	Limit       int
+	Offset      int
+}
+
+const pageInfoColumns = `id, site_id, url, url_hash, COALESCE(title,''), COALESCE(description,''),
+  COALESCE(http_status,0), COALESCE(content_type,''), headers, COALESCE(render_path,''), fetched_at, created_at, updated_at`
+
+func scanPageInfo(row pgx.Row) (PageInfo, error) {
+	var p PageInfo
+	var headers []byte
+	if err := row.Scan(&p.ID, &p.SiteID, &p.URL, &p.URLHash, &p.Title, &p.Description,
+		&p.HTTPStatus, &p.ContentType, &headers, &p.RenderPath, &p.FetchedAt, &p.FirstSeenAt, &p.UpdatedAt); err != nil {
+		return PageInfo{}, err
+	}
+	if len(headers) > 0 {
+		_ = json.Unmarshal(headers, &p.Headers)
+	}
+	return p, nil
+}
+
+// getPageByHash looks a page up via the (site_id, url_hash) unique key; ok=false when absent.
+func getPageByHash(ctx context.Context, db *pgxpool.Pool, siteID int64, urlHash string) (PageInfo, bool, error) {
+	q := "SELECT " + pageInfoColumns + " FROM pages WHERE site_id = $1 AND url_hash = $2"
+	p, err := scanPageInfo(db.QueryRow(ctx, q, siteID, urlHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PageInfo{}, false, nil
+	}
+	if err != nil {
+		return PageInfo{}, false, err
+	}
+	return p, true, nil
+}
+
+// getPageByID looks a page up by id; ok=false when absent.
+func getPageByID(ctx context.Context, db *pgxpool.Pool, id int64) (PageInfo, bool, error) {
+	q := "SELECT " + pageInfoColumns + " FROM pages WHERE id = $1"
+	p, err := scanPageInfo(db.QueryRow(ctx, q, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PageInfo{}, false, nil
+	}
+	if err != nil {
+		return PageInfo{}, false, err
+	}
+	return p, true, nil
+}
+
+// listPages returns page metadata matching f plus the total match count.
+func listPages(ctx context.Context, db *pgxpool.Pool, f pageFilter) ([]PageInfo, int, error) {
+	var conds []string
+	var args []any
+	if f.SiteID > 0 {
+		args = append(args, f.SiteID)
+		conds = append(conds, fmt.Sprintf("site_id = $%d", len(args)))
+	}
+	if f.Header != "" {
+		if f.HeaderValue != "" {
+			// containment lets the GIN index on headers serve the lookup
+			b, _ := json.Marshal(map[string]string{f.Header: f.HeaderValue})
+			args = append(args, string(b))
+			conds = append(conds, fmt.Sprintf("headers @> $%d::jsonb", len(args)))
+		} else {
+			args = append(args, f.Header)
+			conds = append(conds, fmt.Sprintf("headers ? $%d", len(args)))
+		}
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow(ctx, "SELECT count(*) FROM pages "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	q := fmt.Sprintf("SELECT %s FROM pages %s ORDER BY id LIMIT $%d OFFSET $%d",
+		pageInfoColumns, where, len(args)+1, len(args)+2)
+	rows, err := db.Query(ctx, q, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := make([]PageInfo, 0, f.Limit)
+	for rows.Next() {
+		p, err := scanPageInfo(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, p)
+	}
+	return out, total, rows.Err()
+}
+
+// DB: html_blobs (html_storage.backend: blob)
+
+// runHTMLBlobGC periodically deletes blobs no page references anymore and logs how much
+// the deduplication saves. The grace period covers blobs written (or reused) by Put whose
+// page upsert hasn't committed yet.
+func runHTMLBlobGC(ctx context.Context, db *pgxpool.Pool, interval, grace time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := deleteOrphanHTMLBlobs(ctx, db, grace)
+		if err != nil {
+			Warn("html blob gc failed", "err", err)
+			continue
+		}
+		if n > 0 {
+			Info("html blob gc", "deleted", n)
+		}
+		if u, err := htmlBlobUsage(ctx, db); err == nil {
+			Info("html blob storage", "blobs", u.Blobs, "refs", u.Refs, "stored_bytes", u.StoredBytes, "referenced_bytes", u.ReferencedBytes)
+		}
+	}
+}
+
+// deleteOrphanHTMLBlobs deletes unreferenced blobs older than grace.
+func deleteOrphanHTMLBlobs(ctx context.Context, db *pgxpool.Pool, grace time.Duration) (int64, error) {
+	const q = `DELETE FROM html_blobs WHERE ref_count <= 0 AND created_at < now() - $1::interval;`
+	ct, err := db.Exec(ctx, q, fmt.Sprintf("%f seconds", grace.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// htmlBlobStats measures blob deduplication: StoredBytes is what html_blobs holds,
+// ReferencedBytes what the referencing pages would take with a copy each.
+type htmlBlobStats struct {
+	Blobs, Refs                  int64
+	StoredBytes, ReferencedBytes int64
+}
+
+func htmlBlobUsage(ctx context.Context, db *pgxpool.Pool) (htmlBlobStats, error) {
+	var u htmlBlobStats
+	err := db.QueryRow(ctx, `SELECT count(*), COALESCE(sum(GREATEST(ref_count, 0)), 0),
+	COALESCE(sum(octet_length(html)), 0), COALESCE(sum(octet_length(html)::bigint * GREATEST(ref_count, 0)), 0)
+FROM html_blobs`).Scan(&u.Blobs, &u.Refs, &u.StoredBytes, &u.ReferencedBytes)
+	return u, err
+}
+
 // DB: site domain & page links
 
 func getSiteDomain(ctx context.Context, db *pgxpool.Pool, siteID int64) (string, error) {
@@ -113,15 +573,112 @@ func getSiteDomain(ctx context.Context, db *pgxpool.Pool, siteID int64) (string,
 	return d, nil
 }
 
-func insertPageLink(ctx context.Context, db *pgxpool.Pool, fromPageID int64, toURL string, toURLHash string) error {
+// countInboundLinks counts distinct pages linking to the URL with the given hash.
+func countInboundLinks(ctx context.Context, db *pgxpool.Pool, urlHash string) (int64, error) {
+	var n int64
+	err := db.QueryRow(ctx, "SELECT count(*) FROM page_links WHERE to_url_hash = $1", urlHash).Scan(&n)
+	return n, err
+}
+
+func insertPageLink(ctx context.Context, db *pgxpool.Pool, fromPageID int64, toURL string, toURLHash string, anchorText string) error {
 	const q = `
-INSERT INTO page_links (from_page_id, to_url, to_url_hash)
-VALUES ($1,$2,$3)
+INSERT INTO page_links (from_page_id, to_url, to_url_hash, anchor_text)
+VALUES ($1,$2,$3,NULLIF($4,''))
 ON CONFLICT DO NOTHING;`
-	_, err := db.Exec(ctx, q, fromPageID, toURL, toURLHash)
+	_, err := db.Exec(ctx, q, fromPageID, toURL, toURLHash, anchorText)
 	if err != nil {
 		Debug("insertPageLink failed", "from_page_id", fromPageID, "to_url", toURL, "err", err)
 		return err
 	}
 	return nil
 }
+
+// pageLinkRow is one outgoing link for insertPageLinks.
+type pageLinkRow struct {
+	URL        string
+	Hash       string
+	AnchorText string
+}
+
+// insertPageLinks records a page's outgoing links in a single statement.
+func insertPageLinks(ctx context.Context, db *pgxpool.Pool, fromPageID int64, links []pageLinkRow) error {
+	if len(links) == 0 {
+		return nil
+	}
+	urls := make([]string, len(links))
+	hashes := make([]string, len(links))
+	anchors := make([]string, len(links))
+	for i, l := range links {
+		urls[i], hashes[i], anchors[i] = l.URL, l.Hash, l.AnchorText
+	}
+	const q = `
+INSERT INTO page_links (from_page_id, to_url, to_url_hash, anchor_text)
+SELECT $1, t.url, t.url_hash, NULLIF(t.anchor_text, '')
+FROM unnest($2::text[], $3::text[], $4::text[]) AS t(url, url_hash, anchor_text)
+ON CONFLICT DO NOTHING;`
+	if _, err := db.Exec(ctx, q, fromPageID, urls, hashes, anchors); err != nil {
+		Debug("insertPageLinks failed", "from_page_id", fromPageID, "links", len(links), "err", err)
+		return err
+	}
+	return nil
+}
+
+// updatePageAnchorText aggregates the anchor text of inbound links into pages.anchor_text,
+// which the FTS trigger indexes next to the page's own text.
+func updatePageAnchorText(ctx context.Context, db *pgxpool.Pool, pageID int64, urlHash string) error {
+	const q = `
+UPDATE pages SET anchor_text = (
+  SELECT left(string_agg(DISTINCT anchor_text, ' '), 4096)
+  FROM page_links
+  WHERE to_url_hash = $2 AND anchor_text IS NOT NULL AND from_page_id <> $1
+)
+WHERE id = $1;`
+	_, err := db.Exec(ctx, q, pageID, urlHash)
+	return err
+}
+
+// listPageLinks returns one page of the link graph around the page with urlHash:
+// outbound links (direction "out") or pages linking to it ("in"), plus the total count.
+// Linked pages are resolved to stored metadata when they have been crawled.
+func listPageLinks(ctx context.Context, db *pgxpool.Pool, urlHash, direction string, limit, offset int) ([]LinkInfo, int, error) {
+	var countQ, q string
+	if direction == "in" {
+		countQ = `SELECT count(*) FROM page_links WHERE to_url_hash = $1`
+		q = `
+SELECT p.url, p.url_hash, COALESCE(l.anchor_text,''), p.id, COALESCE(p.title,''), p.http_status
+FROM page_links l
+JOIN pages p ON p.id = l.from_page_id
+WHERE l.to_url_hash = $1
+ORDER BY l.id
+LIMIT $2 OFFSET $3`
+	} else {
+		countQ = `SELECT count(*) FROM page_links WHERE from_page_id IN (SELECT id FROM pages WHERE url_hash = $1)`
+		q = `
+SELECT l.to_url, l.to_url_hash, COALESCE(l.anchor_text,''), p.id, COALESCE(p.title,''), p.http_status
+FROM page_links l
+LEFT JOIN LATERAL (
+  SELECT id, title, http_status FROM pages WHERE url_hash = l.to_url_hash LIMIT 1
+) p ON true
+WHERE l.from_page_id IN (SELECT id FROM pages WHERE url_hash = $1)
+ORDER BY l.id
+LIMIT $2 OFFSET $3`
+	}
+	var total int
+	if err := db.QueryRow(ctx, countQ, urlHash).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(ctx, q, urlHash, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := make([]LinkInfo, 0, limit)
+	for rows.Next() {
+		var l LinkInfo
+		if err := rows.Scan(&l.URL, &l.URLHash, &l.AnchorText, &l.PageID, &l.Title, &l.HTTPStatus); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, l)
+	}
+	return out, total, rows.Err()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A rejected status is recorded on the page row; recording it again doesn't add a page.
+func TestRecordPageStatus(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://record-status.test/")
+	ctx := context.Background()
+	pageURL := "http://record-status.test/missing"
+	for _, status := range []int{404, 410} {
+		if err := recordPageStatus(ctx, db, siteID, pageURL, status); err != nil {
+			t.Fatalf("recordPageStatus(%d): %v", status, err)
+		}
+		p, ok, err := getPageByHash(ctx, db, siteID, sha256Hex(pageURL))
+		if err != nil || !ok {
+			t.Fatalf("page not found: ok=%v err=%v", ok, err)
+		}
+		if p.HTTPStatus != status || p.Title != "" {
+			t.Errorf("page = status %d, title %q; want status %d, no content", p.HTTPStatus, p.Title, status)
+		}
+	}
+}
+
+// sites.pages_count stays equal to the number of pages rows when workers write concurrently,
+// including rewrites of pages that already exist.
+func TestSitePagesCountConsistent(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://site-summary.test/")
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_ = recordPageStatus(ctx, db, siteID, fmt.Sprintf("http://site-summary.test/p%d", i), 404)
+			}
+		}()
+	}
+	wg.Wait()
+	var counter, rows int
+	err := db.QueryRow(ctx, `SELECT s.pages_count, (SELECT count(*) FROM pages p WHERE p.site_id = s.id)
+FROM sites s WHERE s.id = $1`, siteID).Scan(&counter, &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != rows || rows != 10 {
+		t.Errorf("pages_count = %d, pages rows = %d, want both 10", counter, rows)
+	}
+}
+
+// A URL finished within enqueue_done_window is not queued again; explicit recrawls (0) are.
+func TestEnqueueDoneWindow(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://done-window.test/")
+	ctx := context.Background()
+	const u = "http://done-window.test/a"
+	h := sha256Hex(u)
+	if ok, err := enqueueIfNotExists(ctx, db, siteID, u, h, 0, 0, time.Hour); err != nil || !ok {
+		t.Fatalf("first enqueue = %v, %v", ok, err)
+	}
+	var id int64
+	if err := db.QueryRow(ctx, `SELECT id FROM crawl_queue WHERE site_id = $1 AND url_hash = $2`, siteID, h).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	markQueueDone(ctx, db, id)
+
+	if ok, _ := enqueueIfNotExists(ctx, db, siteID, u, h, 0, 0, time.Hour); ok {
+		t.Error("re-enqueued a URL finished within the window")
+	}
+	if n, _ := enqueueLinks(ctx, db, siteID, []queueLink{{URL: u, Hash: h}}, 1, time.Hour); n != 0 {
+		t.Error("enqueueLinks re-enqueued a URL finished within the window")
+	}
+	if _, err := db.Exec(ctx, `UPDATE crawl_queue SET updated_at = now() - interval '2 hours' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := enqueueLinks(ctx, db, siteID, []queueLink{{URL: u, Hash: h}}, 1, time.Hour); n != 1 {
+		t.Error("URL finished before the window was not re-enqueued")
+	}
+	// already queued again: a recrawl with window 0 still won't duplicate it
+	if ok, _ := enqueueIfNotExists(ctx, db, siteID, u, h, 0, 0, 0); ok {
+		t.Error("duplicate of a queued URL inserted")
+	}
+}
+
+// created_at (first seen) survives recrawls while fetched_at moves; since filters on it.
+func TestPageFirstSeenStable(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, "http://first-seen.test/")
+	ctx := context.Background()
+	rec := pageRecord{SiteID: siteID, URL: "http://first-seen.test/a", Title: "v1", HTTPStatus: 200, ContentType: "text/html"}
+	if _, err := upsertPage(ctx, db, rec); err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := getPageByHash(ctx, db, siteID, sha256Hex(rec.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	rec.Title = "v2"
+	if _, err := upsertPage(ctx, db, rec); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := getPageByHash(ctx, db, siteID, sha256Hex(rec.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.FirstSeenAt.Equal(first.FirstSeenAt) {
+		t.Errorf("created_at moved on recrawl: %v -> %v", first.FirstSeenAt, again.FirstSeenAt)
+	}
+	if first.FetchedAt == nil || again.FetchedAt == nil || !again.FetchedAt.After(*first.FetchedAt) {
+		t.Errorf("fetched_at not updated: %v -> %v", first.FetchedAt, again.FetchedAt)
+	}
+	tests := []struct {
+		name  string
+		since time.Time
+		want  int
+	}{
+		{"before first seen", first.FirstSeenAt.Add(-time.Second), 1},
+		{"after first seen", first.FirstSeenAt.Add(time.Second), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, total, err := listPages(ctx, db, pageFilter{SiteID: siteID, Since: tt.since, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.want {
+				t.Errorf("total = %d, want %d", total, tt.want)
+			}
+		})
+	}
+}
+
+// Once max_sites is reached new domains are refused while existing sites keep resolving.
+func TestEnsureSiteMaxSites(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	cfg := testCrawlConfig()
+	existing := testSite(t, db, cfg, "http://max-sites-old.test/")
+	var count int
+	if err := db.QueryRow(ctx, `SELECT count(*) FROM sites`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Crawler.MaxSites = count + 1
+	t.Cleanup(func() {
+		_, _ = db.Exec(context.Background(), `DELETE FROM sites WHERE domain LIKE 'max-sites-new%.test'`)
+	})
+	tests := []struct {
+		domain  string
+		wantErr error
+	}{
+		{domain: "max-sites-new1.test"},                        // last free slot
+		{domain: "max-sites-new2.test", wantErr: errSiteLimit}, // over the cap
+		{domain: "max-sites-old.test"},                         // existing site
+		{domain: "max-sites-new1.test"},                        // now existing
+		{domain: "max-sites-new3.test", wantErr: errSiteLimit},
+	}
+	for _, tt := range tests {
+		id, err := ensureSite(ctx, db, tt.domain, cfg)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("ensureSite(%s) = %d, %v; want %v", tt.domain, id, err, tt.wantErr)
+		}
+		if tt.domain == "max-sites-old.test" && id != existing {
+			t.Errorf("existing site id = %d, want %d", id, existing)
+		}
+	}
+	cfg.Crawler.MaxSites = 0
+	if _, err := ensureSite(ctx, db, "max-sites-new2.test", cfg); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+}
+
+// Seeds are canonicalized, filtered by the whitelist and enqueued once across restarts.
+func TestSeedQueue(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	cfg := testCrawlConfig()
+	cfg.Crawler.WhitelistDomains = []string{"seed-a.test", "seed-b.test"}
+	cfg.Crawler.SeedURLs = []string{
+		"http://SEED-A.test/start#top",
+		"  http://seed-b.test/  ",
+		"http://seed-a.test/start", // same as the first after canonicalization
+		"http://seed-blocked.test/",
+		"not a url",
+		"/relative/only",
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec(context.Background(), `DELETE FROM sites WHERE domain LIKE 'seed-%.test'`)
+	})
+	count := func() map[string]int {
+		rows, err := db.Query(ctx, `SELECT q.url, count(*) FROM crawl_queue q JOIN sites s ON s.id = q.site_id
+WHERE s.domain LIKE 'seed-%.test' GROUP BY q.url`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		got := map[string]int{}
+		for rows.Next() {
+			var u string
+			var n int
+			if err := rows.Scan(&u, &n); err != nil {
+				t.Fatal(err)
+			}
+			got[u] = n
+		}
+		return got
+	}
+	want := map[string]int{"http://seed-a.test/start": 1, "http://seed-b.test/": 1}
+	for run := 1; run <= 2; run++ {
+		seedQueue(ctx, db, cfg)
+		if got := count(); !maps.Equal(got, want) {
+			t.Errorf("run %d: queued %v, want %v", run, got, want)
+		}
+	}
+}
+
+// The recount repairs a drifted counter and leaves correct ones alone.
+func TestRecountSitePages(t *testing.T) {
+	db := testDB(t)
+	cfg := testCrawlConfig()
+	ctx := context.Background()
+	drifted := testSite(t, db, cfg, "http://recount-drifted.test/")
+	exact := testSite(t, db, cfg, "http://recount-exact.test/")
+	for i := range 3 {
+		if err := recordPageStatus(ctx, db, drifted, fmt.Sprintf("http://recount-drifted.test/p%d", i), 200); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordPageStatus(ctx, db, exact, "http://recount-exact.test/", 200); err != nil {
+		t.Fatal(err)
+	}
+	// a lost increment and deleted pages: counter 7 for 2 rows
+	if _, err := db.Exec(ctx, `DELETE FROM pages WHERE site_id = $1 AND url = $2`, drifted, "http://recount-drifted.test/p0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `UPDATE sites SET pages_count = 7 WHERE id = $1`, drifted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recountSitePages(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		siteID int64
+		want   int
+	}{{drifted, 2}, {exact, 1}}
+	for _, tt := range tests {
+		var n int
+		if err := db.QueryRow(ctx, `SELECT pages_count FROM sites WHERE id = $1`, tt.siteID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.want {
+			t.Errorf("site %d: pages_count = %d, want %d", tt.siteID, n, tt.want)
+		}
+	}
+}
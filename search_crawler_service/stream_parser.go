@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// --- Streaming extraction (x/net/html tokenizer) ---
+
+// parsedPage holds everything the worker needs from a page body, extracted in one pass.
+type parsedPage struct {
+	Title       string
+	Description string
+	Links       []pageLink // raw <a href> values with anchor text, in document order
+	BaseHref    string     // first <base href>: relative links resolve against it
+	Text        string
+	// MetaRobots maps lower-cased <meta name> ("robots", "googlebot", ...) to its content;
+	// only names that can carry crawler directives are kept (see addMetaRobots).
+	MetaRobots map[string]string
+	// Meta maps lower-cased <meta name> or <meta property> to the content of its first
+	// occurrence (see addMeta); crawler.meta_tags picks what is stored.
+	Meta map[string]string
+	// Alternates are the <link rel="alternate" hreflang> variants (see hreflang.go);
+	// Lang is the page's own hreflang, set by applyHreflang.
+	Alternates []hreflangAlt
+	Lang       string
+	// Headings are the <h1>-<h3> texts in document order (crawler.index_headings).
+	Headings []string
+	// LDTitle/LDDescription come from JSON-LD blocks (see jsonld.go).
+	LDTitle       string
+	LDDescription string
+	// Refresh is the target of a <meta http-equiv="refresh"> after RefreshDelay (see meta_refresh.go).
+	Refresh      string
+	RefreshDelay time.Duration
+	// Canonical is the first <link rel="canonical"> href, unresolved (see canonical.go).
+	Canonical string
+}
+
+// pageLink is one <a href> of a page.
+type pageLink struct {
+	Href  string
+	Text  string // visible anchor text (collapsed, truncated)
+	Boost int    // added to the enqueue priority (preferred hreflang alternates)
+}
+
+// maxAnchorText caps stored anchor text per link.
+const maxAnchorText = 256
+
+// maxHeadings and maxHeadingText cap the headings kept per page.
+const (
+	maxHeadings    = 32
+	maxHeadingText = 256
+)
+
+// addHeading records one <h1>-<h3> text; empty and over-limit headings are dropped.
+func (p *parsedPage) addHeading(raw string) {
+	if h := cleanInlineText(raw, maxHeadingText); h != "" && len(p.Headings) < maxHeadings {
+		p.Headings = append(p.Headings, h)
+	}
+}
+
+// parseHTMLStream tokenizes r as it is read and extracts title, description, links and
+// visible text without materializing the whole document. It always consumes r to EOF.
+func parseHTMLStream(r io.Reader) (parsedPage, error) {
+	var (
+		p        parsedPage
+		text     strings.Builder
+		skipText int // depth inside script/style/noscript/template
+		anchor   strings.Builder
+		inAnchor bool // collecting text for the last link
+		// the first <title> in <head> wins over the first one elsewhere; titles inside
+		// svg/math foreign content (e.g. SVG tooltips) are ignored
+		headTitle strings.Builder
+		bodyTitle strings.Builder
+		titleDst  *strings.Builder // non-nil while inside a collected <title>
+		inHead    bool
+		foreign   int // depth inside svg/math
+		heading   strings.Builder
+		inHeading bool // collecting text for an <h1>-<h3>
+		jsonLD    strings.Builder
+		inJSONLD  bool // inside <script type="application/ld+json">
+	)
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return p, err
+			}
+			p.Title = firstNonEmpty(cleanInlineText(headTitle.String(), 512), cleanInlineText(bodyTitle.String(), 512))
+			p.Description = p.metaFirst(defaultDescriptionMeta)
+			p.applyMetaFallbacks()
+			p.Text = strings.TrimSpace(spaceSeq.ReplaceAllString(text.String(), " "))
+			return p, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template:
+				if tt == html.StartTagToken {
+					skipText++
+					if atom.Lookup(name) == atom.Script && hasAttr && isJSONLDType(tokenAttr(z, "type")) {
+						inJSONLD = true
+						jsonLD.Reset()
+					}
+				}
+			case atom.Head:
+				inHead = tt == html.StartTagToken
+			case atom.Body:
+				inHead = false
+			case atom.Svg, atom.Math:
+				if tt == html.StartTagToken {
+					foreign++
+				}
+			case atom.Title:
+				titleDst = nil
+				if tt == html.StartTagToken && foreign == 0 {
+					dst := &bodyTitle
+					if inHead {
+						dst = &headTitle
+					}
+					if dst.Len() == 0 {
+						titleDst = dst
+					}
+				}
+			case atom.H1, atom.H2, atom.H3:
+				if tt == html.StartTagToken && foreign == 0 {
+					inHeading = true
+					heading.Reset()
+				}
+			case atom.Link:
+				if hasAttr && foreign == 0 {
+					attrs := tokenAttrs(z)
+					p.addAlternate(attrs["rel"], attrs["hreflang"], attrs["href"])
+					p.addCanonical(attrs["rel"], attrs["href"])
+				}
+			case atom.Base:
+				if hasAttr && p.BaseHref == "" && foreign == 0 {
+					p.BaseHref = strings.TrimSpace(tokenAttr(z, "href"))
+				}
+			case atom.A:
+				if hasAttr {
+					if href := tokenAttr(z, "href"); href != "" {
+						p.Links = append(p.Links, pageLink{Href: href})
+						inAnchor = tt == html.StartTagToken
+						anchor.Reset()
+					}
+				}
+			case atom.Meta:
+				if hasAttr {
+					attrs := tokenAttrs(z)
+					content := attrs["content"]
+					p.addMetaRobots(attrs["name"], content)
+					p.addMeta(firstNonEmpty(attrs["name"], attrs["property"]), content)
+					p.addRefresh(attrs["http-equiv"], content)
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template:
+				if skipText > 0 {
+					skipText--
+				}
+				if inJSONLD {
+					p.addJSONLD(jsonLD.String())
+					inJSONLD = false
+				}
+			case atom.Head:
+				inHead = false
+			case atom.Svg, atom.Math:
+				if foreign > 0 {
+					foreign--
+				}
+			case atom.Title:
+				titleDst = nil
+			case atom.H1, atom.H2, atom.H3:
+				if inHeading {
+					p.addHeading(heading.String())
+					inHeading = false
+				}
+			case atom.A:
+				if inAnchor {
+					p.Links[len(p.Links)-1].Text = cleanInlineText(anchor.String(), maxAnchorText)
+					inAnchor = false
+				}
+			}
+
+		case html.TextToken:
+			if inJSONLD && jsonLD.Len() <= maxJSONLDBlock {
+				jsonLD.Write(z.Text())
+			}
+			if skipText > 0 {
+				continue
+			}
+			raw := z.Text()
+			if titleDst != nil {
+				titleDst.Write(raw)
+			}
+			if inAnchor && anchor.Len() < maxAnchorText*2 {
+				anchor.Write(raw)
+				anchor.WriteByte(' ')
+			}
+			if inHeading && heading.Len() < maxHeadingText*2 {
+				heading.Write(raw)
+				heading.WriteByte(' ')
+			}
+			// title text is part of the visible text, as in extractVisibleText
+			text.Write(raw)
+			text.WriteByte(' ')
+		}
+	}
+}
+
+// addMetaRobots records a <meta name=... content=...> that may hold robots directives.
+// Any name is accepted except well-known non-directive ones, so agent-specific names
+// configured later (robots_ua_tokens) still match.
+func (p *parsedPage) addMetaRobots(name, content string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	content = strings.TrimSpace(content)
+	if name == "" || content == "" || nonRobotsMetaNames[name] || strings.ContainsAny(name, ":.") {
+		return
+	}
+	if p.MetaRobots == nil {
+		p.MetaRobots = make(map[string]string)
+	}
+	if prev := p.MetaRobots[name]; prev != "" {
+		content = prev + "," + content
+	}
+	p.MetaRobots[name] = content
+}
+
+// maxMetaTags caps how many distinct meta names are kept per page.
+const maxMetaTags = 64
+
+// defaultDescriptionMeta is where the page description comes from without crawler.description_meta.
+var defaultDescriptionMeta = []string{"description", "og:description"}
+
+// addMeta records the first non-empty content of a meta name (name= or property=).
+func (p *parsedPage) addMeta(name, content string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	content = cleanInlineText(content, 1024)
+	if name == "" || content == "" || len(p.Meta) >= maxMetaTags {
+		return
+	}
+	if p.Meta == nil {
+		p.Meta = make(map[string]string)
+	}
+	if _, ok := p.Meta[name]; !ok {
+		p.Meta[name] = content
+	}
+}
+
+// metaFirst returns the content of the first of names present on the page.
+func (p parsedPage) metaFirst(names []string) string {
+	for _, n := range names {
+		if v := p.Meta[strings.ToLower(strings.TrimSpace(n))]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// selectMeta picks the configured meta names for storage; nil when none are present.
+func (p parsedPage) selectMeta(names []string) map[string]string {
+	var out map[string]string
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if v := p.Meta[n]; v != "" {
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[n] = v
+		}
+	}
+	return out
+}
+
+var nonRobotsMetaNames = map[string]bool{
+	"description": true, "keywords": true, "viewport": true, "author": true, "generator": true,
+	"theme-color": true, "referrer": true, "format-detection": true, "application-name": true,
+}
+
+// robotsDirectives evaluates meta robots for the generic "robots" name and the given agent tokens.
+func (p parsedPage) robotsDirectives(tokens []string) (noindex, nofollow bool) {
+	names := append([]string{"robots"}, tokens...)
+	for _, n := range names {
+		content, ok := p.MetaRobots[strings.ToLower(strings.TrimSpace(n))]
+		if !ok {
+			continue
+		}
+		ni, nf := parseRobotsDirectives(content)
+		noindex, nofollow = noindex || ni, nofollow || nf
+	}
+	return noindex, nofollow
+}
+
+// parseRobotsDirectives reads a comma-separated directive list ("noindex, nofollow", "none").
+func parseRobotsDirectives(content string) (noindex, nofollow bool) {
+	for _, d := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "noindex":
+			noindex = true
+		case "nofollow":
+			nofollow = true
+		case "none":
+			noindex, nofollow = true, true
+		}
+	}
+	return noindex, nofollow
+}
+
+// tokenAttr returns the value of attribute key on the current tag (consumes attributes).
+func tokenAttr(z *html.Tokenizer, key string) string {
+	for {
+		k, v, more := z.TagAttr()
+		if string(k) == key {
+			return strings.TrimSpace(string(v))
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// tokenAttrs returns all attributes of the current tag (keys are lower-cased by the tokenizer).
+func tokenAttrs(z *html.Tokenizer) map[string]string {
+	out := make(map[string]string, 4)
+	for {
+		k, v, more := z.TagAttr()
+		out[string(k)] = string(v)
+		if !more {
+			return out
+		}
+	}
+}
+
+// cleanInlineText collapses whitespace and truncates to max bytes (entities must already be
+// unescaped). Unicode spaces such as &nbsp; count as whitespace; zero-width characters are dropped.
+func cleanInlineText(s string, max int) string {
+	s = strings.TrimSpace(spaceSeq.ReplaceAllString(normalizeSpaces(s), " "))
+	if len(s) > max {
+		s = strings.TrimSpace(strings.ToValidUTF8(s[:max], ""))
+	}
+	return s
+}
+
+// normalizeSpaces maps Unicode whitespace (NBSP, thin/em spaces, ideographic space, ...) to
+// ' ' and removes zero-width characters, so the ASCII-only \s collapse sees all of them.
+func normalizeSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+			return -1
+		}
+		if r > 0x7f && unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// --- URL canonicalization (applied before hashing in every enqueue path) ---
+
+// canonicalizeURL normalizes u in place: drops the fragment, normalizes the host and,
+// when enabled, applies RFC 3986 percent-encoding/dot-segment normalization, collapses
+// repeated path slashes and strips/sorts query parameters.
+func canonicalizeURL(u *url.URL, cfg CrawlerConfig) {
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Host = normalizeHost(u.Host)
+	if cfg.NormalizePercentEncoding {
+		normalizeURLEncoding(u)
+	}
+	if cfg.CollapseSlashes {
+		collapsePathSlashes(u)
+	}
+	if len(cfg.StripQueryParams) > 0 || cfg.SortQueryParams {
+		normalizeQuery(u, cfg.StripQueryParams, cfg.SortQueryParams)
+	}
+}
+
+// normalizeQuery drops parameters matching strip and optionally sorts the rest by name.
+// Parameters are kept in their escaped form, so only their order changes.
+func normalizeQuery(u *url.URL, strip []string, sortParams bool) {
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+		return
+	}
+	type param struct{ name, raw string }
+	var params []param
+	for _, raw := range strings.Split(u.RawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if queryParamStripped(name, strip) {
+			continue
+		}
+		params = append(params, param{name, raw})
+	}
+	if sortParams {
+		slices.SortStableFunc(params, func(a, b param) int { return strings.Compare(a.name, b.name) })
+	}
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	u.ForceQuery = false
+}
+
+// queryParamStripped reports whether name matches one of the strip patterns.
+func queryParamStripped(name string, strip []string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range strip {
+		pat = strings.ToLower(strings.TrimSpace(pat))
+		if prefix, ok := strings.CutSuffix(pat, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pat {
+			return true
+		}
+	}
+	return false
+}
+
+// collapsePathSlashes turns runs of "/" in the path into one ("//a///b" -> "/a/b").
+// Only the path is touched; encoded slashes (%2F) are left alone.
+func collapsePathSlashes(u *url.URL) {
+	p := u.EscapedPath()
+	if !strings.Contains(p, "//") {
+		return
+	}
+	var b strings.Builder
+	b.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && i > 0 && p[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(p[i])
+	}
+	c := b.String()
+	if dec, err := url.PathUnescape(c); err == nil {
+		u.Path = dec
+		u.RawPath = c
+	}
+}
+
+// normalizeURLEncoding decodes percent-encoded unreserved characters, upper-cases the
+// remaining escapes and removes dot segments from the path (RFC 3986 6.2.2). Reserved
+// characters stay encoded, so "/a%2Fb" never turns into "/a/b".
+func normalizeURLEncoding(u *url.URL) {
+	p := removeDotSegments(normalizePercentEncoding(u.EscapedPath()))
+	if dec, err := url.PathUnescape(p); err == nil {
+		u.Path = dec
+		u.RawPath = p
+	}
+	u.RawQuery = normalizePercentEncoding(u.RawQuery)
+}
+
+// normalizePercentEncoding rewrites %XX escapes in an already-escaped component.
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(c)
+			continue
+		}
+		v := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(v) {
+			b.WriteByte(v)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(upperHex(s[i+1]))
+			b.WriteByte(upperHex(s[i+2]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// removeDotSegments implements RFC 3986 5.2.4 on an escaped path.
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	in := p
+	var out []string
+	for in != "" {
+		switch {
+		case strings.HasPrefix(in, "../"):
+			in = in[3:]
+		case strings.HasPrefix(in, "./"):
+			in = in[2:]
+		case strings.HasPrefix(in, "/./"):
+			in = in[2:]
+		case in == "/.":
+			in = "/"
+		case strings.HasPrefix(in, "/../"):
+			in = in[3:]
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case in == "/..":
+			in = "/"
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case in == "." || in == "..":
+			in = ""
+		default:
+			// move the first segment (including its leading "/") to the output
+			start := 0
+			if in[0] == '/' {
+				start = 1
+			}
+			end := strings.IndexByte(in[start:], '/')
+			if end < 0 {
+				end = len(in)
+			} else {
+				end += start
+			}
+			out = append(out, in[:end])
+			in = in[end:]
+		}
+	}
+	return strings.Join(out, "")
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func upperHex(c byte) byte {
+	if 'a' <= c && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// canonical parses raw and returns its canonical form under cfg.
+func canonical(t *testing.T, raw string, cfg CrawlerConfig) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	canonicalizeURL(u, cfg)
+	return u.String()
+}
+
+func TestNormalizePercentEncoding(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/~user", "/~user"},
+		{"/%7Euser", "/~user"},
+		{"/%7euser", "/~user"},
+		{"/%41%62c", "/Abc"},
+		{"/a%2fb", "/a%2Fb"},
+		{"/a%2Fb", "/a%2Fb"},
+		{"/%e2%82%ac", "/%E2%82%AC"},
+		{"/100%", "/100%"},
+		{"/%zz", "/%zz"},
+		{"/%2", "/%2"},
+		{"q=a%20b&c=%3d", "q=a%20b&c=%3D"},
+	}
+	for _, tt := range tests {
+		if got := normalizePercentEncoding(tt.in); got != tt.want {
+			t.Errorf("normalizePercentEncoding(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/a/b/c/./../../g", "/a/g"},
+		{"mid/content=5/../6", "mid/6"},
+		{"/a/./b", "/a/b"},
+		{"/a/b/..", "/a/"},
+		{"/a/b/.", "/a/b/"},
+		{"/../a", "/a"},
+		{"/a.html", "/a.html"},
+		{"/a/..b/c", "/a/..b/c"},
+		{"/", "/"},
+	}
+	for _, tt := range tests {
+		if got := removeDotSegments(tt.in); got != tt.want {
+			t.Errorf("removeDotSegments(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalizeURLPercentEncoding(t *testing.T) {
+	on := CrawlerConfig{NormalizePercentEncoding: true}
+	variants := []string{
+		"http://Example.COM/~user/page",
+		"http://example.com/%7Euser/page",
+		"http://example.com/%7euser/page",
+		"http://example.com:80/%7Euser/./page",
+		"http://example.com/%7Euser/x/../page#frag",
+	}
+	want := sha256Hex(canonical(t, variants[0], on))
+	for _, v := range variants[1:] {
+		if got := canonical(t, v, on); sha256Hex(got) != want {
+			t.Errorf("%q canonicalizes to %q, want the hash of %q", v, got, canonical(t, variants[0], on))
+		}
+	}
+	// reserved characters keep their meaning
+	if a, b := canonical(t, "http://example.com/a%2Fb", on), canonical(t, "http://example.com/a/b", on); a == b {
+		t.Errorf("%%2F was decoded: %q", a)
+	}
+	// off: only fragment and host are normalized
+	if got := canonical(t, "http://example.com/%7euser", CrawlerConfig{}); got != "http://example.com/%7euser" {
+		t.Errorf("normalization off: got %q", got)
+	}
+}
+
+func TestCollapseSlashes(t *testing.T) {
+	on := CrawlerConfig{CollapseSlashes: true}
+	tests := []struct{ in, want string }{
+		{"https://x.com//a///b", "https://x.com/a/b"},
+		{"https://x.com/a//b/", "https://x.com/a/b/"},
+		{"https://x.com//", "https://x.com/"},
+		{"https://x.com/a%2F%2Fb//c", "https://x.com/a%2F%2Fb/c"},                         // encoded slashes are data
+		{"https://x.com//a?next=http://y.com//b", "https://x.com/a?next=http://y.com//b"}, // query untouched
+		{"https://x.com/a/b", "https://x.com/a/b"},
+	}
+	for _, tt := range tests {
+		if got := canonical(t, tt.in, on); got != tt.want {
+			t.Errorf("canonical(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	variants := []string{"https://x.com/a/b", "https://x.com//a/b", "https://x.com/a//b", "https://x.com///a///b"}
+	hashes := map[string]bool{}
+	for _, v := range variants {
+		hashes[sha256Hex(canonical(t, v, on))] = true
+	}
+	if len(hashes) != 1 {
+		t.Errorf("collapse_slashes: %d hashes for %q, want 1", len(hashes), variants)
+	}
+	hashes = map[string]bool{}
+	for _, v := range variants {
+		hashes[sha256Hex(canonical(t, v, CrawlerConfig{}))] = true
+	}
+	if len(hashes) != len(variants) {
+		t.Errorf("collapse off: %d hashes for %d variants", len(hashes), len(variants))
+	}
+}
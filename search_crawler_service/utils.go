@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -46,8 +47,34 @@ func isHostAllowed(host string, whitelist []string) bool {
 	return false
 }
 
+// parseIntDefault parses s as int, returning def when empty or invalid.
+func parseIntDefault(s string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// nonZero returns v, or def when v <= 0.
+func nonZero(v int, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
 // sha256Hex returns hex-encoded SHA256 of a string.
 func sha256Hex(s string) string {
 	sum := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(sum[:])
 }
+
+// isSHA256Hex reports whether s looks like a lower-case sha256 hex digest.
+func isSHA256Hex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
@@ -2,13 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
 	"net/url"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/text/unicode/norm"
+)
+
+// pageStore keeps raw HTML outside the DB when html_storage.backend != db (nil = inline in pages.html).
+var pageStore htmlStore
+
+// claimSem limits how many workers run the claim transaction simultaneously (nil = unlimited).
+var claimSem chan struct{}
+
+// recordQueueWait stamps crawl_queue.first_claimed_at on the first claim (crawler.record_queue_wait).
+var recordQueueWait bool
+
+const (
+	minIdleSleep = 500 * time.Millisecond
+	maxIdleSleep = 10 * time.Second
 )
 
 // runWorkers starts background loop that takes tasks from DB and processes them.
@@ -25,12 +44,16 @@ func runWorkers(ctx context.Context, db *pgxpool.Pool, cfg Config, ppool *ProxyP
 			wc = 1
 		}
 	}
-	Info("starting workers", "count", wc)
+	claimSem = newClaimSem(cfg.Crawler.MaxClaimConcurrency, wc)
+	recordQueueWait = cfg.Crawler.RecordQueueWait
+	Info("starting workers", "count", wc, "max_claim_concurrency", cap(claimSem))
+	initFetchLimits(cfg.Crawler)
+	initRenderFallback(cfg.Crawler.RenderFallback)
 
 	for i := 0; i < wc; i++ {
 		go func(id int) {
 			Info("worker started", "worker", id)
-			idleSleep := 500 * time.Millisecond
+			idleSleep := minIdleSleep
 			for {
 				if ctx.Err() != nil {
 					return
@@ -42,104 +65,671 @@ func runWorkers(ctx context.Context, db *pgxpool.Pool, cfg Config, ppool *ProxyP
 					continue
 				}
 				if !ok {
+					// queue empty: back off exponentially so idle workers don't poll in lockstep
 					time.Sleep(idleSleep)
+					idleSleep *= 2
+					if idleSleep > maxIdleSleep {
+						idleSleep = maxIdleSleep
+					}
+					continue
 				}
+				idleSleep = minIdleSleep
 			}
 		}(i + 1)
 	}
 }
 
 type queueItem struct {
-	ID     int64
-	SiteID int64
-	URL    string
+	ID       int64
+	SiteID   int64
+	URL      string
+	Attempts int // including the current claim
+	Depth    int // link hops from a seed / API-enqueued URL
 }
 
-func pickAndProcessOne(ctx context.Context, db *pgxpool.Pool, cfg Config, ppool *ProxyPool) (bool, error) {
+// newClaimSem sizes claimSem; nil when the cap is off or not below the worker count.
+func newClaimSem(max, workers int) chan struct{} {
+	if max > 0 && max < workers {
+		return make(chan struct{}, max)
+	}
+	return nil
+}
+
+// claimQueueItem atomically moves one due queue item to 'processing'. ok=false when nothing is due.
+// Items matching the crawl focus (see focus.go) are claimed first.
+func claimQueueItem(ctx context.Context, db *pgxpool.Pool) (it queueItem, ok bool, err error) {
+	release, err := acquireSem(ctx, claimSem)
+	if err != nil {
+		return it, false, err
+	}
+	defer release()
+
+	if f := currentFocus(); f != nil {
+		where, args := f.where()
+		if it, ok, err = claimWhere(ctx, db, where, args...); ok || err != nil || f.Exclusive {
+			return it, ok, err
+		}
+	}
+	return claimWhere(ctx, db, "")
+}
+
+// claimWhere claims the best due item also matching filter (an SQL condition over crawl_queue
+// using args as $1..; empty = any).
+func claimWhere(ctx context.Context, db *pgxpool.Pool, filter string, args ...any) (it queueItem, ok bool, err error) {
+	if filter != "" {
+		filter = "\n  AND " + filter
+	}
 	tx, err := db.Begin(ctx)
 	if err != nil {
-		return false, err
+		return it, false, err
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	const sel = `
-SELECT id, site_id, url
+	sel := `
+SELECT id, site_id, url, attempts + 1, depth
 FROM crawl_queue
 WHERE status = 'queued'
-  AND (next_try_at IS NULL OR next_try_at <= now())
+  AND (next_try_at IS NULL OR next_try_at <= now())` + filter + `
 ORDER BY priority DESC, id
 FOR UPDATE SKIP LOCKED
 LIMIT 1;`
-	var it queueItem
-	if err := tx.QueryRow(ctx, sel).Scan(&it.ID, &it.SiteID, &it.URL); err != nil {
+	if err := tx.QueryRow(ctx, sel, args...).Scan(&it.ID, &it.SiteID, &it.URL, &it.Attempts, &it.Depth); err != nil {
 		// no rows
 		if strings.Contains(err.Error(), "no rows") {
 			_ = tx.Rollback(ctx)
-			return false, nil
+			return it, false, nil
 		}
-		return false, err
+		return it, false, err
 	}
 
 	const updToProcessing = `
 UPDATE crawl_queue
-SET status = 'processing', attempts = attempts + 1, updated_at = now()
+SET status = 'processing', attempts = attempts + 1, updated_at = now(),
+    first_claimed_at = CASE WHEN $2 THEN COALESCE(first_claimed_at, now()) ELSE first_claimed_at END
 WHERE id = $1;`
-	if _, err := tx.Exec(ctx, updToProcessing, it.ID); err != nil {
-		return false, err
+	if _, err := tx.Exec(ctx, updToProcessing, it.ID, recordQueueWait); err != nil {
+		return it, false, err
 	}
 	if err := tx.Commit(ctx); err != nil {
+		return it, false, err
+	}
+	return it, true, nil
+}
+
+func pickAndProcessOne(ctx context.Context, db *pgxpool.Pool, cfg Config, ppool *ProxyPool) (bool, error) {
+	it, ok, err := claimQueueItem(ctx, db)
+	if err != nil || !ok {
 		return false, err
 	}
 	Debug("picked queue item", "id", it.ID, "site_id", it.SiteID, "url", it.URL)
 
+	_, err = processURL(ctx, db, cfg, ppool, it.SiteID, it.URL, it.Depth)
+	var skipErr *skipError
+	var retryErr *retryError
+	var transientErr *transientError
+	var permErr *permanentError
+	var throttleErr *throttledError
+	switch {
+	case err == nil:
+		markQueueDone(ctx, db, it.ID)
+	case errors.As(err, &skipErr):
+		markQueueSkipped(ctx, db, it.ID, skipErr.reason)
+	case errors.As(err, &transientErr):
+		if it.Attempts < nonZero(cfg.Crawler.DNSRetryMaxAttempts, 3) {
+			markQueueRetry(ctx, db, it.ID, transientErr.msg, transientErr.class, cfg.Crawler.RetryBackoff.delay(transientErr.after, it.Attempts))
+		} else {
+			markQueueError(ctx, db, it.ID, transientErr.msg, transientErr.class)
+		}
+	case errors.As(err, &throttleErr):
+		if it.Attempts < nonZero(cfg.Crawler.ThrottleMaxAttempts, 10) {
+			markQueueRetry(ctx, db, it.ID, throttleErr.msg, throttleErr.class, min(throttleErr.after, cfg.Crawler.RetryBackoff.maxDelay()))
+		} else {
+			markQueueError(ctx, db, it.ID, throttleErr.msg, throttleErr.class)
+		}
+	case errors.As(err, &permErr):
+		markQueueError(ctx, db, it.ID, permErr.msg, permErr.class)
+	case errors.As(err, &retryErr):
+		if it.Attempts < cfg.Crawler.maxAttempts() {
+			markQueueRetry(ctx, db, it.ID, retryErr.msg, retryErr.class, cfg.Crawler.RetryBackoff.delay(retryErr.after, it.Attempts))
+		} else {
+			markQueueError(ctx, db, it.ID, retryErr.msg, retryErr.class)
+		}
+	default:
+		// context cancelled while waiting for a fetch slot
+		return true, err
+	}
+	return true, nil
+}
+
+// RetryBackoff spreads queue retries out: the n-th attempt's failure waits
+// base * 2^(n-1), capped at Max, randomized by ±Jitter (base is the delay the failure suggests).
+type RetryBackoff struct {
+	Max    Duration `yaml:"max"`    // default 24h
+	Jitter float64  `yaml:"jitter"` // fraction, default 0.2; negative = none
+}
+
+func (b RetryBackoff) maxDelay() time.Duration {
+	if b.Max.Duration <= 0 {
+		return 24 * time.Hour
+	}
+	return b.Max.Duration
+}
+
+func (b RetryBackoff) delay(base time.Duration, attempts int) time.Duration {
+	maxDelay := b.maxDelay()
+	d := base
+	for i := 1; i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	jitter := b.Jitter
+	if jitter == 0 {
+		jitter = 0.2
+	}
+	if jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * jitter * float64(d))
+	}
+	return d
+}
+
+// retryError is a processing failure after which the queue item is retried later.
+type retryError struct {
+	msg   string
+	after time.Duration
+	class string // crawl_queue.error_class (see fetch_errors.go)
+}
+
+func (e *retryError) Error() string { return e.msg }
+
+// transientError is a failure worth a quick retry (e.g. resolver hiccup): the item goes
+// back to 'queued' after a short delay, up to crawler.dns_retry_max_attempts claims.
+type transientError struct {
+	msg   string
+	after time.Duration
+	class string
+}
+
+func (e *transientError) Error() string { return e.msg }
+
+// throttledError is a 429/503 with Retry-After: the item is retried when the server
+// asked (capped at retry_backoff.max), up to crawler.throttle_max_attempts claims.
+type throttledError struct {
+	msg   string
+	after time.Duration
+	class string
+}
+
+func (e *throttledError) Error() string { return e.msg }
+
+// permanentError is a failure that retrying can't fix (e.g. NXDOMAIN).
+type permanentError struct {
+	msg   string
+	class string
+}
+
+func (e *permanentError) Error() string { return e.msg }
+
+// classifyFetchError maps a fetch failure to its retry policy: NXDOMAIN is terminal,
+// temporary DNS errors are retried soon, everything else uses the regular error retry
+// with the base delay of its error class (crawler.error_backoff).
+func classifyFetchError(err error, cfg CrawlerConfig) error {
+	msg := fmt.Sprintf("fetch: %v", err)
+	class := fetchErrorClass(err)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return &permanentError{msg, class}
+		case dnsErr.IsTemporary || dnsErr.IsTimeout:
+			after := cfg.DNSRetryAfter.Duration
+			if after <= 0 {
+				after = 30 * time.Second
+			}
+			return &transientError{msg, after, class}
+		}
+	}
+	// the chain will not fix itself soon; a later recrawl re-enqueues the URL
+	if class == errClassRedirect {
+		return &permanentError{msg, class}
+	}
+	return &retryError{msg, cfg.errorBackoff(class), class}
+}
+
+// processURL fetches, parses and stores one URL of a site and enqueues its in-domain links
+// at depth+1 (none beyond crawler.depth_limit). It returns the stored page id; *skipError and *retryError describe how the caller should
+// settle a queue item. Used by the workers and by the synchronous /api/crawl-now.
+func processURL(ctx context.Context, db *pgxpool.Pool, cfg Config, ppool *ProxyPool, siteID int64, rawURL string, depth int) (int64, error) {
 	// per-host rate limit
 	host := ""
-	if u, err := url.Parse(it.URL); err == nil {
+	if u, err := url.Parse(rawURL); err == nil {
 		host = normalizeHost(u.Host)
+		if !robotsAllowed(ctx, cfg, ppool, u) {
+			return 0, &skipError{reason: "disallowed by robots.txt"}
+		}
 	}
-	lim := getHostLimiter(host, cfg.Crawler.RPSPerHost, cfg.Crawler.RPSBurst)
+	polite := hostPoliteness(ctx, db, cfg.Crawler, siteID, host)
+	lim := getHostLimiterFor(host, polite)
 	if lim != nil {
 		_ = lim.Wait(ctx)
 	}
 
-	// Build HTTP client with proxy (http/https only for MVP)
-	proxyURL := ppool.Next()
-	client := buildHTTPClient(proxyURL, cfg.Crawler.HTMLFetchTimeout.Duration)
+	// Recrawl of a page with stored validators: conditional GET
+	opts := newFetchOptions(cfg)
+	applySiteFetchOverrides(ctx, db, siteID, &opts)
+	var prevID int64
+	if cfg.Crawler.ConditionalGet && db != nil {
+		prevID, opts.IfNoneMatch, opts.IfModifiedSince, _ = getPageValidators(ctx, db, siteID, sha256Hex(rawURL))
+	}
 
-	// Fetch
-	status, ctype, html, err := fetchHTML(ctx, client, it.URL, int(cfg.Crawler.HTMLMaxSize.Bytes), cfg.Crawler.UserAgent)
+	// Fetch through a proxy (http/https only for MVP), bounded by the host and global fetch caps
+	fetch := func(proxyURL *url.URL) (fetchResult, error) {
+		client := buildHTTPClient(proxyURL, cfg.Crawler.HTMLFetchTimeout.Duration)
+		var hops []string
+		client.CheckRedirect = redirectPolicy(cfg.Crawler.maxRedirects(), &hops)
+		releaseHost, err := acquireHostSlot(ctx, host, polite.Concurrency)
+		if err != nil {
+			return fetchResult{}, err
+		}
+		defer releaseHost()
+		release, err := acquireFetchSlot(ctx)
+		if err != nil {
+			return fetchResult{}, err
+		}
+		defer release()
+		done := ppool.Acquire(proxyURL)
+		res, err := fetchHTML(ctx, client, rawURL, opts)
+		done()
+		res.Redirects = hops
+		if ctx.Err() == nil {
+			ppool.Report(proxyURL, proxyWorked(err))
+		}
+		return res, err
+	}
+	proxyURL := ppool.NextFor(host)
+	// Preflight: don't download what content_types/html_max_size would reject anyway
+	var head http.Header
+	var herr error
+	if cfg.Crawler.HeadPreflight {
+		head, herr = headCheck(ctx, cfg, host, polite, proxyURL, rawURL, opts)
+		if herr == nil {
+			if err := preflightReject(cfg.Crawler, head); err != nil {
+				return 0, err
+			}
+		} else {
+			Debug("head preflight failed, fetching", "url", rawURL, "error", herr)
+		}
+	}
+	// Recrawl: a HEAD whose size/date match the stored response saves the GET
+	if cfg.Crawler.RecrawlHeadCheck && db != nil {
+		if prev, ok, _ := getPageByHash(ctx, db, siteID, sha256Hex(rawURL)); ok && len(prev.Headers) > 0 {
+			if !cfg.Crawler.HeadPreflight {
+				head, herr = headCheck(ctx, cfg, host, polite, proxyURL, rawURL, opts)
+			}
+			if herr == nil && headUnchanged(prev.Headers, head) {
+				touchPageFetched(ctx, db, prev.ID)
+				return prev.ID, &skipError{reason: "unchanged since last fetch (HEAD check)"}
+			}
+			if herr != nil {
+				Debug("head check failed, fetching", "url", rawURL, "error", herr)
+			}
+		}
+	}
+	res, err := fetch(proxyURL)
+	// 401/403 is often an IP block: try other proxies before giving up
+	if cfg.Crawler.RetryForbiddenWithNewProxy && ppool.Len() > 1 {
+		retries := nonZero(cfg.Crawler.ForbiddenProxyRetries, 2)
+		for i := 0; i < retries && isForbidden(err); i++ {
+			proxyBlocks.record(host, proxyURL)
+			proxyURL = ppool.NextFor(host)
+			Debug("forbidden, retrying via another proxy", "url", rawURL, "attempt", i+1)
+			res, err = fetch(proxyURL)
+		}
+		if isForbidden(err) {
+			proxyBlocks.record(host, proxyURL)
+		} else if err == nil {
+			proxyBlocks.clear(host, proxyURL)
+		}
+	}
+	// connection/timeout error (bad proxy): re-issue through other proxies; fetch reported the failure
+	if ppool.Len() > 1 {
+		for i := 0; i < cfg.Crawler.FetchRetries && isTransportError(err) && ctx.Err() == nil; i++ {
+			Debug("fetch failed, retrying via another proxy", "url", rawURL, "attempt", i+1, "error", err)
+			proxyURL = ppool.NextFor(host)
+			res, err = fetch(proxyURL)
+		}
+	}
+	// truncated body (flaky proxy): re-issue the request, through another proxy when there is one
+	for i := 0; i < cfg.Crawler.BodyReadRetries && isBodyReadError(err) && ctx.Err() == nil; i++ {
+		Debug("body read failed, retrying", "url", rawURL, "attempt", i+1, "error", err)
+		proxyURL = ppool.NextFor(host)
+		res, err = fetch(proxyURL)
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		return 0, err
+	}
+	// retrying a compression bomb is pointless
+	var bombErr *bombError
+	if errors.As(err, &bombErr) {
+		return 0, &skipError{bombErr.Error()}
+	}
+	var stErr *statusError
+	if errors.As(err, &stErr) && stErr.RetryAfter > 0 {
+		return 0, &throttledError{fmt.Sprintf("fetch: %v (retry-after %s)", err, stErr.RetryAfter), stErr.RetryAfter, errClassHTTPStatus}
+	}
+	if errors.As(err, &stErr) && cfg.Crawler.RecordRejectedStatus {
+		if err := recordPageStatus(ctx, db, siteID, rawURL, stErr.Status); err != nil {
+			return 0, &retryError{fmt.Sprintf("store: %v", err), 10 * time.Minute, errClassStore}
+		}
+		return 0, &skipError{stErr.Error()}
+	}
 	if err != nil {
-		// mark error with next_try_at
-		markQueueError(ctx, db, it.ID, fmt.Sprintf("fetch: %v", err), 5*time.Minute)
-		return true, nil
+		return 0, classifyFetchError(err, cfg.Crawler)
+	}
+	if res.NotModified {
+		touchPageFetched(ctx, db, prevID)
+		return prevID, &skipError{reason: "not modified (304)"}
+	}
+	// Redirected: store the page under the URL it was served from, if still in the crawl scope
+	pageURL, err := redirectTarget(ctx, db, cfg, siteID, rawURL, res.FinalURL)
+	if err != nil {
+		return 0, err
+	}
+	// Same body as last fetched (before any rendering): nothing to re-extract or re-index
+	fetchHash := res.BodyHash
+	if cfg.Crawler.SkipUnchanged && fetchHash != "" {
+		if id, hash, ok := getPageFetchHash(ctx, db, siteID, sha256Hex(pageURL)); ok && hash == fetchHash {
+			touchPageFetched(ctx, db, id)
+			return id, &skipError{reason: "content unchanged since last fetch"}
+		}
+	}
+	status, ctype, html := res.Status, res.ContentType, res.HTML
+	Debug("fetched html", "status", status, "ctype", ctype, "bytes", res.Size, "streamed", res.Parsed != nil)
+	if res.Truncated {
+		Info("body truncated at html_max_size", "url", pageURL, "bytes", res.Size)
 	}
-	Debug("fetched html", "status", status, "ctype", ctype, "bytes", len(html))
 	// Only allow text/html
 	if !isAllowedContentType(ctype, cfg.Crawler.ContentTypes) {
-		markQueueError(ctx, db, it.ID, fmt.Sprintf("content-type not allowed: %s", ctype), 30*time.Minute)
-		return true, nil
+		return 0, &retryError{fmt.Sprintf("content-type not allowed: %s", ctype), 30 * time.Minute, errClassContentType}
+	}
+	// Extract title/description/links/text: already done while streaming, or regex-based (MVP)
+	var page parsedPage
+	if res.Parsed != nil {
+		page = *res.Parsed
+	} else {
+		page = parsePage(html)
+	}
+	// Nothing worth storing
+	if isBlankBody(res, page) {
+		return 0, &skipError{"empty body"}
+	}
+	// JS shell: use the headless-rendered HTML when it yields more text
+	renderPath := renderPathStatic
+	if needsRender(cfg.Crawler.RenderFallback, page) {
+		rendered, err := renderHTML(ctx, cfg.Crawler.RenderFallback, pageURL, int(cfg.Crawler.HTMLMaxSize.Bytes))
+		if err != nil {
+			Warn("render fallback failed", "url", pageURL, "err", err)
+		} else if rp := parsePage(rendered); len(rp.Text) > len(page.Text) {
+			page, html, renderPath = rp, rendered, renderPathHeadless
+			res.BodyHash = sha256Hex(rendered)
+		}
+	}
+	// Index the main content block only (navigation/footers repeat on every page)
+	if cfg.Crawler.MainContent && html != "" {
+		if text, ok := extractMainText(html); ok {
+			page.Text = text
+		}
+	}
+	// Meta robots and X-Robots-Tag (generic and addressed to our agent tokens)
+	if cfg.Robots.Respect {
+		tokens := cfg.Crawler.robotsUATokens(cfg.Robots)
+		noindex, nofollow := page.robotsDirectives(tokens)
+		hdrNoindex, hdrNofollow := headerRobotsDirectives(res.Header, tokens)
+		if nofollow || hdrNofollow {
+			page.Links, page.Refresh = nil, ""
+		}
+		switch {
+		case noindex:
+			enqueuePageLinks(ctx, db, cfg, siteID, 0, pageURL, depth, page)
+			return 0, &skipError{"meta robots noindex"}
+		case hdrNoindex:
+			enqueuePageLinks(ctx, db, cfg, siteID, 0, pageURL, depth, page)
+			return 0, &skipError{"X-Robots-Tag noindex"}
+		}
+	}
+	// <meta http-equiv="refresh"> landing page: follow the target like a redirect
+	refresh := cfg.Crawler.refreshRedirect(pageURL, page)
+	if refresh != "" && cfg.Crawler.MetaRefresh.SkipIndex {
+		enqueueRedirectTarget(ctx, db, cfg, siteID, 0, pageURL, depth, refresh)
+		return 0, &skipError{"meta refresh to " + refresh}
+	}
+	// <link rel="canonical">: an in-scope canonical elsewhere makes this page an alias
+	var canonical string
+	if page.Canonical != "" {
+		canonical = resolvePageHref(cfg.Crawler, pageURL, page.BaseHref, page.Canonical)
+	}
+	if cfg.Crawler.CanonicalDedupe && canonical != "" && canonical != pageURL {
+		if siteDomain, err := getSiteDomain(ctx, db, siteID); err == nil && urlInCrawlScope(cfg.Crawler, canonical, siteDomain) {
+			enqueueRedirectTarget(ctx, db, cfg, siteID, 0, pageURL, depth, canonical)
+			return 0, &skipError{"non-canonical alias of " + canonical}
+		}
+	}
+	// Language variants: pages.lang from hreflang, preferred variants first
+	if reason := applyHreflang(cfg.Crawler, pageURL, &page); reason != "" {
+		enqueuePageLinks(ctx, db, cfg, siteID, 0, pageURL, depth, page)
+		return 0, &skipError{reason}
+	}
+	// 200 pages that are really "not found"
+	if sc := cfg.Crawler.Soft404; sc.Enabled {
+		reason := sc.match(page.Title, page.Text, wordCount(page.Text))
+		if u, err := url.Parse(pageURL); reason == "" && sc.Probe && err == nil && strings.Trim(u.Path, "/") != "" {
+			if fp := soft404Fingerprint(ctx, cfg, host, polite, proxyURL, u, opts); fp != "" && fp == res.BodyHash {
+				reason = "same body as a random URL of the host"
+			}
+		}
+		if reason != "" {
+			Info("soft 404 detected", "url", pageURL, "reason", reason)
+			return 0, &skipError{"soft 404: " + reason}
+		}
+	}
+	rec := pageRecord{
+		SiteID:       siteID,
+		URL:          pageURL,
+		Lang:         page.Lang,
+		Title:        page.Title,
+		Description:  page.Description,
+		HTTPStatus:   status,
+		ContentType:  ctype,
+		HTML:         html,
+		HTMLHash:     res.BodyHash,
+		FetchHash:    fetchHash,
+		Text:         page.Text,
+		Headers:      selectHeaders(res.Header, cfg.Crawler.storedHeaders()),
+		Meta:         page.selectMeta(cfg.Crawler.MetaTags),
+		RenderPath:   renderPath,
+		Charset:      res.Charset,
+		Truncated:    res.Truncated,
+		WordCount:    wordCount(page.Text),
+		CanonicalURL: canonical,
+	}
+	if rec.Lang == "" && cfg.Crawler.DetectLanguage {
+		rec.Lang = detectLanguage(page.Text, cfg.Crawler.Languages)
+	}
+	if cfg.Crawler.ConditionalGet {
+		rec.ETag = res.Header.Get("ETag")
+		rec.LastModified = res.Header.Get("Last-Modified")
+	}
+	if len(cfg.Crawler.DescriptionMeta) > 0 {
+		rec.Description = firstNonEmpty(page.metaFirst(cfg.Crawler.DescriptionMeta), page.LDDescription)
+	}
+	if cfg.Crawler.IndexHeadings {
+		rec.Headings = page.Headings
+	}
+	if cfg.Crawler.HeadingTitleFallback && rec.Title == "" && len(page.Headings) > 0 {
+		rec.Title = page.Headings[0]
+	}
+	if cfg.Crawler.RecordFetchMetrics {
+		// max(1): a sub-millisecond local fetch is still a recorded measurement
+		rec.TTFBMS = max(1, res.TTFB.Milliseconds())
+		rec.FetchMS = max(1, res.Elapsed.Milliseconds())
+		rec.RawSize = res.Size
+	}
+	if cfg.Crawler.NormalizeUnicode {
+		rec.normalizeUnicode()
+	}
+	switch {
+	case cfg.Crawler.DiscardHTML:
+		rec.HTML = ""
+	case pageStore != nil && html != "":
+		// keep only the reference in the DB
+		ref, err := pageStore.Put(ctx, res.BodyHash, []byte(html))
+		if err != nil {
+			return 0, &retryError{fmt.Sprintf("store html: %v", err), 10 * time.Minute, errClassStore}
+		}
+		rec.HTML, rec.HTMLRef = "", ref
 	}
-	// Extract text (very basic for MVP)
-	text := extractVisibleText(html)
-
-	// Extract title/description (MVP)
-	title := extractTitle(html)
-	description := extractMetaDescription(html)
-	lang := ""
 
 	// Upsert page
-	pageID, err := upsertPage(ctx, db, it.SiteID, it.URL, title, description, lang, status, ctype, html, text)
+	pageID, err := storePage(ctx, db, rec)
 	if err != nil {
-		markQueueError(ctx, db, it.ID, fmt.Sprintf("store: %v", err), 10*time.Minute)
-		return true, nil
+		return 0, &retryError{fmt.Sprintf("store: %v", err), 10 * time.Minute, errClassStore}
+	}
+
+	if cfg.Crawler.RecordRedirects {
+		recordRedirectLinks(ctx, db, cfg.Crawler, pageID, res.Redirects)
 	}
 
-	// Extract links and enqueue in-domain
-	if siteDomain, err := getSiteDomain(ctx, db, it.SiteID); err == nil {
-		eCount, total, _ := extractAndEnqueueLinks(ctx, db, cfg, it.SiteID, siteDomain, pageID, it.URL, html)
+	// Inbound anchor text makes pages with sparse text findable by how others describe them
+	if cfg.Crawler.IndexAnchorText {
+		if err := updatePageAnchorText(ctx, db, pageID, sha256Hex(pageURL)); err != nil {
+			Warn("anchor text update failed", "page_id", pageID, "err", err)
+		}
+	}
+
+	if refresh != "" {
+		enqueueRedirectTarget(ctx, db, cfg, siteID, pageID, pageURL, depth, refresh)
+	}
+	enqueuePageLinks(ctx, db, cfg, siteID, pageID, pageURL, depth, page)
+	return pageID, nil
+}
+
+// redirectTarget returns the canonical URL a fetch of rawURL ended at (rawURL without a
+// redirect). A redirect leaving the site's crawl scope is skipped: the page belongs to another site.
+func redirectTarget(ctx context.Context, db *pgxpool.Pool, cfg Config, siteID int64, rawURL, finalURL string) (string, error) {
+	if finalURL == "" || finalURL == rawURL {
+		return rawURL, nil
+	}
+	u, err := url.Parse(finalURL)
+	if err != nil {
+		return rawURL, nil
+	}
+	canonicalizeURL(u, cfg.Crawler)
+	if u.String() == rawURL {
+		return rawURL, nil
+	}
+	siteDomain, err := getSiteDomain(ctx, db, siteID)
+	if err != nil {
+		return "", &retryError{fmt.Sprintf("site lookup: %v", err), 10 * time.Minute, errClassStore}
+	}
+	if !inCrawlScope(u.Host, siteDomain, cfg.Crawler.CrawlScope) {
+		return "", &skipError{"redirected out of crawl scope to " + u.String()}
+	}
+	Debug("redirected, storing under final url", "url", rawURL, "final_url", u.String())
+	return u.String(), nil
+}
+
+// enqueuePageLinks records and enqueues the in-domain links of a page (pageID 0: page not stored).
+func enqueuePageLinks(ctx context.Context, db *pgxpool.Pool, cfg Config, siteID, pageID int64, rawURL string, depth int, page parsedPage) {
+	if len(page.Links) == 0 {
+		return
+	}
+	if siteDomain, err := getSiteDomain(ctx, db, siteID); err == nil {
+		eCount, total, _ := extractAndEnqueueLinks(ctx, db, cfg, siteID, siteDomain, pageID, rawURL, page.BaseHref, depth+1, page.Links)
 		Debug("links processed", "found", total, "enqueued", eCount)
 	}
+}
 
-	markQueueDone(ctx, db, it.ID)
-	return true, nil
+// enqueueRedirectTarget enqueues the absolute target of a page's meta refresh or canonical
+// link at the page's own depth, as for an HTTP redirect; in-scope and cap checks are those
+// of ordinary links.
+func enqueueRedirectTarget(ctx context.Context, db *pgxpool.Pool, cfg Config, siteID, pageID int64, rawURL string, depth int, target string) {
+	siteDomain, err := getSiteDomain(ctx, db, siteID)
+	if err != nil {
+		return
+	}
+	n, _, _ := extractAndEnqueueLinks(ctx, db, cfg, siteID, siteDomain, pageID, rawURL, "", depth, []pageLink{{Href: target}})
+	Debug("redirect target", "url", rawURL, "target", target, "enqueued", n)
+}
+
+// recordRedirectLinks stores the redirect hops of a fetch as links of the page, at most
+// crawler.max_redirect_links of them (the first hops).
+func recordRedirectLinks(ctx context.Context, db *pgxpool.Pool, c CrawlerConfig, pageID int64, hops []string) {
+	limit := c.MaxRedirectLinks
+	if limit <= 0 {
+		limit = c.maxRedirects()
+	}
+	if len(hops) > limit {
+		Debug("redirect links capped", "page_id", pageID, "hops", len(hops), "recorded", limit)
+		hops = hops[:limit]
+	}
+	for _, h := range hops {
+		_ = insertPageLink(ctx, db, pageID, h, sha256Hex(h), "")
+	}
+}
+
+// isForbidden reports a 401/403 response.
+func isForbidden(err error) bool {
+	var stErr *statusError
+	return errors.As(err, &stErr) && (stErr.Status == http.StatusUnauthorized || stErr.Status == http.StatusForbidden)
+}
+
+// proxyWorked tells whether a fetch outcome vouches for the proxy: any response counts
+// (a bad status is the target's doing); transport and body read failures don't.
+func proxyWorked(err error) bool {
+	var stErr *statusError
+	var skipErr *skipError
+	var bomb *bombError
+	var loopErr *redirectLoopError
+	var limitErr *redirectLimitError
+	return err == nil || errors.As(err, &stErr) || errors.As(err, &skipErr) || errors.As(err, &bomb) ||
+		errors.As(err, &loopErr) || errors.As(err, &limitErr)
+}
+
+// isTransportError reports a fetch that failed before a usable response: connection,
+// TLS, proxy or timeout errors. DNS failures and cut bodies are handled separately.
+func isTransportError(err error) bool {
+	var dnsErr *net.DNSError
+	return !proxyWorked(err) && !isBodyReadError(err) && !errors.As(err, &dnsErr) &&
+		!errors.Is(err, context.Canceled)
+}
+
+func isBodyReadError(err error) bool {
+	var brErr *bodyReadError
+	return errors.As(err, &brErr)
+}
+
+// isBlankBody reports a response with nothing worth storing.
+func isBlankBody(res fetchResult, page parsedPage) bool {
+	if res.Size == 0 {
+		return true
+	}
+	if res.HTML != "" {
+		return strings.TrimSpace(res.HTML) == ""
+	}
+	// streamed without keeping HTML: judge by what was extracted
+	return page.Title == "" && page.Text == "" && len(page.Links) == 0
+}
+
+// normalizeUnicode applies NFC to the indexed text fields, so decomposed input matches
+// precomposed queries.
+func (rec *pageRecord) normalizeUnicode() {
+	rec.Title = norm.NFC.String(rec.Title)
+	rec.Description = norm.NFC.String(rec.Description)
+	rec.Text = norm.NFC.String(rec.Text)
+	for i, h := range rec.Headings {
+		rec.Headings[i] = norm.NFC.String(h)
+	}
 }
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewClaimSem(t *testing.T) {
+	tests := []struct {
+		max, workers, wantCap int
+		wantNil               bool
+	}{
+		{max: 0, workers: 8, wantNil: true},
+		{max: -1, workers: 8, wantNil: true},
+		{max: 8, workers: 8, wantNil: true},
+		{max: 16, workers: 8, wantNil: true},
+		{max: 2, workers: 8, wantCap: 2},
+		{max: 1, workers: 2, wantCap: 1},
+	}
+	for _, tt := range tests {
+		sem := newClaimSem(tt.max, tt.workers)
+		if (sem == nil) != tt.wantNil || (sem != nil && cap(sem) != tt.wantCap) {
+			t.Errorf("newClaimSem(%d, %d) = cap %d (nil %v), want cap %d (nil %v)",
+				tt.max, tt.workers, cap(sem), sem == nil, tt.wantCap, tt.wantNil)
+		}
+	}
+}
+
+func TestClaimSemCapsConcurrency(t *testing.T) {
+	const limit, workers = 3, 20
+	sem := newClaimSem(limit, workers)
+	var cur, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquireSem(context.Background(), sem)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := cur.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			cur.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+	if p := peak.Load(); p > limit || p == 0 {
+		t.Fatalf("peak concurrent claims = %d, want 1..%d", p, limit)
+	}
+}
+
+func TestAcquireSemCancelled(t *testing.T) {
+	sem := make(chan struct{}, 1)
+	sem <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := acquireSem(ctx, sem); err == nil {
+		t.Fatal("acquireSem on a full semaphore with a cancelled context should fail")
+	}
+	release, err := acquireSem(ctx, nil)
+	if err != nil {
+		t.Fatalf("nil semaphore: %v", err)
+	}
+	release()
+}
+
+func TestPageRecordNormalizeUnicode(t *testing.T) {
+	tests := []struct{ decomposed, precomposed string }{
+		{"cafe\u0301", "caf\u00e9"},
+		{"\u0438\u0306\u043e\u0434", "\u0439\u043e\u0434"}, // йод
+		{"\u0435\u0308\u0436", "\u0451\u0436"},             // ёж
+		{"plain ascii", "plain ascii"},
+	}
+	for _, tt := range tests {
+		rec := pageRecord{Title: tt.decomposed, Description: tt.decomposed, Text: "text " + tt.decomposed, Headings: []string{tt.decomposed}}
+		rec.normalizeUnicode()
+		if rec.Title != tt.precomposed || rec.Description != tt.precomposed ||
+			rec.Text != "text "+tt.precomposed || rec.Headings[0] != tt.precomposed {
+			t.Errorf("%q normalized to %+q, want %+q", tt.decomposed, rec.Title, tt.precomposed)
+		}
+	}
+}
+
+// Decomposed page text matches a precomposed query once normalize_unicode is on.
+func TestNormalizeUnicodeMatchesPrecomposedQuery(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, "<html><head><title>t</title></head><body><p>йодистый раствор</p></body></html>")
+	}))
+	defer srv.Close()
+	cfg := testCrawlConfig()
+	cfg.Crawler.NormalizeUnicode = true
+	cfg.Crawler.Languages = []string{"ru"}
+	siteID := testSite(t, db, cfg, srv.URL)
+	pageID, err := processURL(context.Background(), db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+	if err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	var match bool
+	err = db.QueryRow(context.Background(),
+		`SELECT tsv_ru @@ plainto_tsquery('russian', unaccent($2)) FROM pages WHERE id = $1`, pageID, "\u0439\u043e\u0434\u0438\u0441\u0442\u044b\u0439").Scan(&match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Error("precomposed query does not match the decomposed page text")
+	}
+}
+
+func TestClassifyFetchError(t *testing.T) {
+	dial := func(dnsErr *net.DNSError) error {
+		return &url.Error{Op: "Get", URL: "http://example.test/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: dnsErr}}
+	}
+	var cfg CrawlerConfig
+	tests := []struct {
+		name      string
+		err       error
+		cfg       CrawlerConfig
+		wantKind  string // permanent, transient, retry
+		wantAfter time.Duration
+		wantClass string
+	}{
+		{name: "nxdomain", err: dial(&net.DNSError{Err: "no such host", Name: "nope.test", IsNotFound: true}),
+			wantKind: "permanent", wantClass: errClassDNS},
+		{name: "temporary", err: dial(&net.DNSError{Err: "server misbehaving", Name: "a.test", IsTemporary: true}),
+			wantKind: "transient", wantAfter: 30 * time.Second, wantClass: errClassDNS},
+		{name: "resolver timeout", err: dial(&net.DNSError{Err: "i/o timeout", Name: "a.test", IsTimeout: true}),
+			wantKind: "transient", wantAfter: 30 * time.Second, wantClass: errClassDNS},
+		{name: "configured retry", err: dial(&net.DNSError{Err: "i/o timeout", Name: "a.test", IsTimeout: true}),
+			cfg: CrawlerConfig{DNSRetryAfter: Duration{5 * time.Second}}, wantKind: "transient", wantAfter: 5 * time.Second, wantClass: errClassDNS},
+		{name: "other dns failure", err: dial(&net.DNSError{Err: "cannot unmarshal DNS message", Name: "a.test"}),
+			wantKind: "retry", wantAfter: cfg.errorBackoff(errClassDNS), wantClass: errClassDNS},
+		{name: "refused", err: &url.Error{Op: "Get", URL: "http://a.test/", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			wantKind: "retry", wantAfter: cfg.errorBackoff(errClassConnRefused), wantClass: errClassConnRefused},
+		{name: "redirect loop", err: &redirectLoopError{}, wantKind: "permanent", wantClass: errClassRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				kind, class string
+				after       time.Duration
+			)
+			switch e := classifyFetchError(tt.err, tt.cfg).(type) {
+			case *permanentError:
+				kind, class = "permanent", e.class
+			case *transientError:
+				kind, class, after = "transient", e.class, e.after
+			case *retryError:
+				kind, class, after = "retry", e.class, e.after
+			default:
+				t.Fatalf("unexpected %T", e)
+			}
+			if kind != tt.wantKind || class != tt.wantClass || after != tt.wantAfter {
+				t.Errorf("got %s/%s after %v, want %s/%s after %v", kind, class, after, tt.wantKind, tt.wantClass, tt.wantAfter)
+			}
+		})
+	}
+}
+
+// With record_fetch_metrics a stored page carries its fetch latency and size; without it, none.
+func TestProcessURLRecordsFetchMetrics(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head><title>metrics</title></head><body>timed page</body></html>")
+	}))
+	defer srv.Close()
+	for _, record := range []bool{true, false} {
+		cfg := testCrawlConfig()
+		cfg.Crawler.RecordFetchMetrics = record
+		siteID := testSite(t, db, cfg, srv.URL)
+		ctx := context.Background()
+		pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+		if err != nil {
+			t.Fatalf("processURL: %v", err)
+		}
+		var ttfb, fetch, size *int64
+		if err := db.QueryRow(ctx, `SELECT ttfb_ms, fetch_ms, raw_size FROM pages WHERE id = $1`, pageID).Scan(&ttfb, &fetch, &size); err != nil {
+			t.Fatal(err)
+		}
+		if !record {
+			if ttfb != nil || fetch != nil || size != nil {
+				t.Errorf("metrics recorded while disabled: %v %v %v", ttfb, fetch, size)
+			}
+			continue
+		}
+		if ttfb == nil || *ttfb < 20 || fetch == nil || *fetch < *ttfb || size == nil || *size == 0 {
+			t.Errorf("ttfb_ms=%v fetch_ms=%v raw_size=%v, want ttfb >= 20, fetch >= ttfb, size > 0", ttfb, fetch, size)
+		}
+		_, _ = db.Exec(ctx, `DELETE FROM pages WHERE id = $1`, pageID)
+	}
+}
+
+// A body cut short by the connection is re-requested up to body_read_retries times.
+func TestProcessURLRetriesBodyRead(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name      string
+		retries   int
+		truncate  int32
+		wantErr   bool
+		wantCalls int32
+	}{
+		{name: "no retry", retries: 0, truncate: 1, wantErr: true, wantCalls: 1},
+		{name: "recovered", retries: 2, truncate: 1, wantCalls: 2},
+		{name: "retries exhausted", retries: 2, truncate: 5, wantErr: true, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, calls := truncatingHandler("<html><head><title>full</title></head><body>"+strings.Repeat("x", 4096)+"</body></html>", tt.truncate)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Crawler.BodyReadRetries = tt.retries
+			siteID := testSite(t, db, cfg, srv.URL)
+			_, err := processURL(context.Background(), db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processURL err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("requests = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// Redirect hops become links of the page, capped at max_redirect_links.
+func TestProcessURLRecordsRedirectLinks(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name      string
+		record    bool
+		maxLinks  int
+		wantLinks int
+	}{
+		{name: "disabled", record: false, wantLinks: 0},
+		{name: "capped", record: true, maxLinks: 3, wantLinks: 3},
+		{name: "default cap is max_redirects", record: true, wantLinks: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(chainHandler(6, 0))
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Crawler.RecordRedirects = tt.record
+			cfg.Crawler.MaxRedirectLinks = tt.maxLinks
+			siteID := testSite(t, db, cfg, srv.URL)
+			ctx := context.Background()
+			pageID, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/hop/0", 0)
+			if err != nil {
+				t.Fatalf("processURL: %v", err)
+			}
+			var n int
+			if err := db.QueryRow(ctx, `SELECT count(*) FROM page_links WHERE from_page_id = $1 AND to_url LIKE $2`,
+				pageID, srv.URL+"/hop/%").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantLinks {
+				t.Errorf("redirect links = %d, want %d", n, tt.wantLinks)
+			}
+		})
+	}
+}
+
+// The links of a page fetched at depth d are queued at d+1.
+func TestProcessURLEnqueuesChildDepth(t *testing.T) {
+	db := testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, `<html><head><title>depth</title></head><body><a href="/child">child</a></body></html>`)
+	}))
+	defer srv.Close()
+	cfg := testCrawlConfig()
+	siteID := testSite(t, db, cfg, srv.URL)
+	ctx := context.Background()
+	if _, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 2); err != nil {
+		t.Fatalf("processURL: %v", err)
+	}
+	var depth int
+	if err := db.QueryRow(ctx, `SELECT depth FROM crawl_queue WHERE site_id = $1 AND url = $2`, siteID, srv.URL+"/child").Scan(&depth); err != nil {
+		t.Fatal(err)
+	}
+	if depth != 3 {
+		t.Errorf("child depth = %d, want 3", depth)
+	}
+}
+
+// A recrawl of a stored page sends its validators; a 304 only touches fetched_at.
+func TestProcessURLConditionalGet(t *testing.T) {
+	db := testDB(t)
+	const lastMod = "Mon, 02 Jan 2006 15:04:05 GMT"
+	for _, conditional := range []bool{true, false} {
+		t.Run(fmt.Sprintf("conditional_get=%v", conditional), func(t *testing.T) {
+			var full atomic.Int32
+			h := etagHandler(lastMod)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") == "" {
+					full.Add(1)
+				}
+				h(w, r)
+			}))
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Crawler.ConditionalGet = conditional
+			siteID := testSite(t, db, cfg, srv.URL)
+			ctx := context.Background()
+			id, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+			if err != nil {
+				t.Fatalf("first fetch: %v", err)
+			}
+			var etag, modified string
+			var fetched1 time.Time
+			if err := db.QueryRow(ctx, `SELECT COALESCE(etag, ''), COALESCE(last_modified, ''), fetched_at FROM pages WHERE id = $1`, id).Scan(&etag, &modified, &fetched1); err != nil {
+				t.Fatal(err)
+			}
+			if etag != `"v1"` || modified != lastMod {
+				t.Errorf("stored validators = %q %q", etag, modified)
+			}
+
+			id2, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+			var skip *skipError
+			if conditional {
+				if !errors.As(err, &skip) || id2 != id {
+					t.Fatalf("recrawl = %d, %v; want skip of page %d", id2, err, id)
+				}
+				var fetched2 time.Time
+				if err := db.QueryRow(ctx, `SELECT fetched_at FROM pages WHERE id = $1`, id).Scan(&fetched2); err != nil {
+					t.Fatal(err)
+				}
+				if !fetched2.After(fetched1) {
+					t.Errorf("fetched_at not touched: %v -> %v", fetched1, fetched2)
+				}
+			} else if err != nil {
+				t.Fatalf("recrawl: %v", err)
+			}
+			want := int32(2)
+			if conditional {
+				want = 1
+			}
+			if got := full.Load(); got != want {
+				t.Errorf("unconditional requests = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+// With skip_unchanged, a recrawl returning the stored body only bumps fetched_at.
+func TestProcessURLSkipUnchanged(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name     string
+		skip     bool
+		bodies   []string // served on successive fetches
+		wantSkip []bool
+		wantText string
+	}{
+		{name: "unchanged skipped", skip: true, bodies: []string{"first", "first"}, wantSkip: []bool{false, true}, wantText: "first"},
+		{name: "changed reprocessed", skip: true, bodies: []string{"first", "second"}, wantSkip: []bool{false, false}, wantText: "second"},
+		{name: "disabled", skip: false, bodies: []string{"first", "first"}, wantSkip: []bool{false, false}, wantText: "first"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body atomic.Value
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = io.WriteString(w, "<html><head><title>hash</title></head><body>"+body.Load().(string)+"</body></html>")
+			}))
+			defer srv.Close()
+			cfg := testCrawlConfig()
+			cfg.Crawler.SkipUnchanged = tt.skip
+			siteID := testSite(t, db, cfg, srv.URL)
+			ctx := context.Background()
+			var firstID int64
+			var fetched time.Time
+			for i, b := range tt.bodies {
+				body.Store(b)
+				id, err := processURL(ctx, db, cfg, testProxyPool(t), siteID, srv.URL+"/", 0)
+				var skip *skipError
+				if errors.As(err, &skip) != tt.wantSkip[i] || (err != nil && skip == nil) {
+					t.Fatalf("fetch %d: err = %v, want skip = %v", i, err, tt.wantSkip[i])
+				}
+				if i == 0 {
+					firstID = id
+				} else if id != firstID {
+					t.Errorf("fetch %d: page id %d, want %d", i, id, firstID)
+				}
+				var f time.Time
+				if err := db.QueryRow(ctx, `SELECT fetched_at FROM pages WHERE id = $1`, id).Scan(&f); err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 && !f.After(fetched) {
+					t.Errorf("fetch %d: fetched_at not bumped (%v -> %v)", i, fetched, f)
+				}
+				fetched = f
+			}
+			var text string
+			if err := db.QueryRow(ctx, `SELECT text FROM pages WHERE id = $1`, firstID).Scan(&text); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(text, tt.wantText) {
+				t.Errorf("text = %q, want it to contain %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffDelay(t *testing.T) {
+	noJitter := RetryBackoff{Jitter: -1}
+	tests := []struct {
+		name     string
+		b        RetryBackoff
+		base     time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{name: "first attempt", b: noJitter, base: time.Minute, attempts: 1, want: time.Minute},
+		{name: "doubles", b: noJitter, base: time.Minute, attempts: 2, want: 2 * time.Minute},
+		{name: "fifth attempt", b: noJitter, base: time.Minute, attempts: 5, want: 16 * time.Minute},
+		{name: "zero attempts", b: noJitter, base: time.Minute, attempts: 0, want: time.Minute},
+		{name: "default cap", b: noJitter, base: time.Hour, attempts: 10, want: 24 * time.Hour},
+		{name: "configured cap", b: RetryBackoff{Max: Duration{10 * time.Minute}, Jitter: -1}, base: time.Minute, attempts: 6, want: 10 * time.Minute},
+		{name: "huge attempt count", b: noJitter, base: time.Second, attempts: 1000, want: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.delay(tt.base, tt.attempts); got != tt.want {
+				t.Errorf("delay(%v, %d) = %v, want %v", tt.base, tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      RetryBackoff
+		spread float64
+	}{
+		{name: "default 20%", b: RetryBackoff{}, spread: 0.2},
+		{name: "configured 50%", b: RetryBackoff{Jitter: 0.5}, spread: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const base = 4 * time.Minute // attempt 3 -> 16m before jitter
+			nominal := 16 * time.Minute
+			lo := time.Duration(float64(nominal) * (1 - tt.spread))
+			hi := time.Duration(float64(nominal) * (1 + tt.spread))
+			distinct := map[time.Duration]bool{}
+			for range 200 {
+				d := tt.b.delay(base, 3)
+				if d < lo || d > hi {
+					t.Fatalf("delay = %v, want within [%v, %v]", d, lo, hi)
+				}
+				distinct[d] = true
+			}
+			if len(distinct) < 10 {
+				t.Errorf("only %d distinct delays in 200 draws", len(distinct))
+			}
+		})
+	}
+}
+
+// A failing URL is retried later and later until max_attempts, then stays in error.
+func TestPickAndProcessOneBackoff(t *testing.T) {
+	db := testDB(t)
+	resetFocus(t)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close() // connection refused: a retryable fetch error
+	cfg := testCrawlConfig()
+	cfg.Crawler.MaxAttempts = 3
+	cfg.Crawler.RetryBackoff = RetryBackoff{Jitter: -1}
+	siteID := testSite(t, db, cfg, dead.URL)
+	ctx := context.Background()
+	pageURL := dead.URL + "/gone"
+	if _, err := enqueueIfNotExists(ctx, db, siteID, pageURL, sha256Hex(pageURL), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setFocus(ctx, db, CrawlFocus{SiteID: siteID, Exclusive: true}); err != nil {
+		t.Fatal(err)
+	}
+	var prevDelay float64
+	for attempt := 1; attempt <= 3; attempt++ {
+		if _, err := db.Exec(ctx, `UPDATE crawl_queue SET next_try_at = NULL WHERE site_id = $1 AND status = 'queued'`, siteID); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := pickAndProcessOne(ctx, db, cfg, testProxyPool(t)); !ok || err != nil {
+			t.Fatalf("attempt %d: ok=%v err=%v", attempt, ok, err)
+		}
+		var status string
+		var delay *float64
+		if err := db.QueryRow(ctx, `SELECT status::text, EXTRACT(EPOCH FROM next_try_at - updated_at)::float8
+FROM crawl_queue WHERE site_id = $1 AND url = $2`, siteID, pageURL).Scan(&status, &delay); err != nil {
+			t.Fatal(err)
+		}
+		if attempt < 3 {
+			if status != "queued" || delay == nil || *delay <= prevDelay {
+				t.Fatalf("attempt %d: status=%s delay=%v, want queued with a delay above %.0fs", attempt, status, delay, prevDelay)
+			}
+			prevDelay = *delay
+			continue
+		}
+		if status != "error" {
+			t.Errorf("after max_attempts: status = %s, want error", status)
+		}
+	}
+}
+
+// A 429 with Retry-After schedules the retry at that delay rather than the error backoff.
+func TestPickAndProcessOneRetryAfter(t *testing.T) {
+	db := testDB(t)
+	resetFocus(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	cfg := testCrawlConfig()
+	cfg.Crawler.ThrottleMaxAttempts = 2
+	siteID := testSite(t, db, cfg, srv.URL)
+	ctx := context.Background()
+	pageURL := srv.URL + "/busy"
+	if _, err := enqueueIfNotExists(ctx, db, siteID, pageURL, sha256Hex(pageURL), 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setFocus(ctx, db, CrawlFocus{SiteID: siteID, Exclusive: true}); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, err := db.Exec(ctx, `UPDATE crawl_queue SET next_try_at = NULL WHERE site_id = $1 AND status = 'queued'`, siteID); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := pickAndProcessOne(ctx, db, cfg, testProxyPool(t)); !ok || err != nil {
+			t.Fatalf("attempt %d: ok=%v err=%v", attempt, ok, err)
+		}
+		var status string
+		var delay *float64
+		if err := db.QueryRow(ctx, `SELECT status::text, EXTRACT(EPOCH FROM next_try_at - updated_at)::float8
+FROM crawl_queue WHERE site_id = $1 AND url = $2`, siteID, pageURL).Scan(&status, &delay); err != nil {
+			t.Fatal(err)
+		}
+		if attempt == 1 && (status != "queued" || delay == nil || *delay < 119 || *delay > 121) {
+			t.Errorf("after 429: status=%s delay=%v, want queued for 120s", status, delay)
+		}
+		if attempt == 2 && status != "error" {
+			t.Errorf("after throttle_max_attempts: status = %s, want error", status)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- /api/config: effective configuration, secrets redacted ---
+
+const redacted = "REDACTED"
+
+// AuthConf protects operator endpoints (/api/config). Env SEARCH_UI_AUTH_TOKEN overrides Token.
+type AuthConf struct {
+	Token string `yaml:"token"`
+}
+
+// requireAuth guards operator endpoints with the configured bearer token.
+// Without a configured token the endpoints are disabled.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg.Auth.Token
+		if token == "" {
+			http.Error(w, "disabled: auth.token is not configured", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="search"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// redactURL hides the password of a DSN or proxy URL: user:pass@host, a ?password=
+// query parameter, or password= in a key/value DSN. Other strings are returned unchanged.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return reDSNPassword.ReplaceAllString(s, "${1}"+redacted)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// reDSNPassword matches the password of a key/value DSN ("host=db password='s e'").
+var reDSNPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// handleConfig: GET /api/config — the loaded config (YAML keys) plus env overrides, redacted.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.cfg
+	cfg.HTMLStorage.S3.AccessKey = redactSecret(cfg.HTMLStorage.S3.AccessKey)
+	cfg.HTMLStorage.S3.SecretKey = redactSecret(cfg.HTMLStorage.S3.SecretKey)
+	cfg.Auth.Token = redactSecret(cfg.Auth.Token)
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var out map[string]any
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]any{
+		"config": out,
+		"env":    map[string]string{"PG_DSN": redactURL(os.Getenv("PG_DSN"))},
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
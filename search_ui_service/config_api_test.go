@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// No secret of the config or of PG_DSN reaches the /api/config response.
+func TestHandleConfigRedactsSecrets(t *testing.T) {
+	tests := []struct {
+		name, dsn string
+	}{
+		{"url dsn", "postgres://gose:dsn-secret@db/gose"},
+		{"query dsn", "postgres://gose@db/gose?password=dsn-secret"},
+		{"key/value dsn", "host=db user=gose password=dsn-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PG_DSN", tt.dsn)
+			var cfg Config
+			cfg.HTMLStorage.S3.AccessKey = "s3-access-secret"
+			cfg.HTMLStorage.S3.SecretKey = "s3-key-secret"
+			cfg.Auth.Token = "token-secret"
+			s := &Server{cfg: cfg}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+			req.Header.Set("Authorization", "Bearer token-secret")
+			s.requireAuth(s.handleConfig)(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			body := rec.Body.String()
+			for _, secret := range []string{"dsn-secret", "s3-access-secret", "s3-key-secret", "token-secret"} {
+				if strings.Contains(body, secret) {
+					t.Errorf("response leaks %q", secret)
+				}
+			}
+			if !strings.Contains(body, "REDACTED") || s.cfg.Auth.Token != "token-secret" {
+				t.Errorf("unexpected redaction: %s", body)
+			}
+		})
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	tests := []struct {
+		token, auth string
+		want        int
+	}{
+		{"secret", "Bearer secret", http.StatusNoContent},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		s := &Server{cfg: Config{Auth: AuthConf{Token: tt.token}}}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		s.requireAuth(ok)(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("token %q auth %q: status %d, want %d", tt.token, tt.auth, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tests that need Postgres run against GOSE_TEST_DSN, a scratch database initialized with
+// deploy/db/init.sql, and are skipped when it is not set.
+
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("GOSE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GOSE_TEST_DSN not set")
+	}
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testPages stores a site with the given pages (url -> text) as the crawler would and
+// removes it when the test ends; the pages trigger fills tsv_ru/tsv_en.
+func testPages(t *testing.T, db *pgxpool.Pool, domain string, pages map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	var siteID int64
+	if err := db.QueryRow(ctx, `INSERT INTO sites (domain) VALUES ($1) RETURNING id`, domain).Scan(&siteID); err != nil {
+		t.Fatalf("insert site: %v", err)
+	}
+	t.Cleanup(func() { _, _ = db.Exec(context.Background(), `DELETE FROM sites WHERE id = $1`, siteID) })
+	for u, text := range pages {
+		sum := sha256.Sum256([]byte(u))
+		_, err := db.Exec(ctx, `INSERT INTO pages (site_id, url, url_hash, title, text, http_status, fetched_at)
+VALUES ($1, $2, $3, $2, $4, 200, now())`, siteID, u, hex.EncodeToString(sum[:]), text)
+		if err != nil {
+			t.Fatalf("insert page %s: %v", u, err)
+		}
+	}
+}
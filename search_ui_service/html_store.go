@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- Raw HTML storage (read side) ---
+//
+// Pages keep raw HTML either inline (pages.html, backend "db") or in a content-addressed
+// store keyed by html_hash, with only pages.html_ref in the DB. The ref format is
+// "<backend>:<html_hash>". The crawler writes the stores (search_crawler_service/html_store.go);
+// the UI only reads them, with the same ref parsing and object layout.
+
+// HTMLStorageConfig selects where raw HTML is kept.
+type HTMLStorageConfig struct {
+	Backend string          `yaml:"backend"` // db (default), blob, fs, s3
+	FSDir   string          `yaml:"fs_dir"`
+	S3      S3StorageConfig `yaml:"s3"`
+}
+
+type S3StorageConfig struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"` // env HTML_STORE_S3_ACCESS_KEY overrides
+	SecretKey string `yaml:"secret_key"` // env HTML_STORE_S3_SECRET_KEY overrides
+}
+
+var errHTMLNotFound = errors.New("html not found in store")
+
+type htmlStore interface {
+	// Get loads html by ref.
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// newHTMLStore builds a reader for the configured store; nil means HTML is inline in the DB.
+func newHTMLStore(cfg HTMLStorageConfig, db *pgxpool.Pool) (htmlStore, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", "db":
+		return nil, nil
+	case "blob":
+		return &blobHTMLStore{db: db}, nil
+	case "fs":
+		if cfg.FSDir == "" {
+			return nil, errors.New("html_storage.fs_dir is required for fs backend")
+		}
+		return &fsHTMLStore{dir: cfg.FSDir}, nil
+	case "s3":
+		sc := cfg.S3
+		sc.AccessKey = getenv("HTML_STORE_S3_ACCESS_KEY", sc.AccessKey)
+		sc.SecretKey = getenv("HTML_STORE_S3_SECRET_KEY", sc.SecretKey)
+		if sc.Endpoint == "" || sc.Bucket == "" {
+			return nil, errors.New("html_storage.s3.endpoint and bucket are required for s3 backend")
+		}
+		ep, err := url.Parse(strings.TrimSuffix(sc.Endpoint, "/"))
+		if err != nil || ep.Host == "" {
+			return nil, fmt.Errorf("invalid html_storage.s3.endpoint %q", sc.Endpoint)
+		}
+		if sc.Region == "" {
+			sc.Region = "us-east-1"
+		}
+		return &s3HTMLStore{cfg: sc, endpoint: ep, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown html_storage.backend %q (want db|blob|fs|s3)", cfg.Backend)
+	}
+}
+
+// splitHTMLRef parses "<backend>:<hash>" and validates the hash so it is safe in paths/keys.
+func splitHTMLRef(ref string) (backend, hash string, err error) {
+	backend, hash, ok := strings.Cut(ref, ":")
+	if !ok || len(hash) != 64 {
+		return "", "", fmt.Errorf("invalid html ref %q", ref)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", "", fmt.Errorf("invalid html ref %q", ref)
+	}
+	return backend, hash, nil
+}
+
+// htmlObjectKey shards objects by the first hash byte to keep directories small.
+func htmlObjectKey(hash string) string {
+	return hash[:2] + "/" + hash + ".html"
+}
+
+// blobHTMLStore reads HTML from the html_blobs table, one row per distinct html_hash.
+type blobHTMLStore struct {
+	db *pgxpool.Pool
+}
+
+func (s *blobHTMLStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	var html string
+	err = s.db.QueryRow(ctx, "SELECT html FROM html_blobs WHERE html_hash = $1", hash).Scan(&html)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errHTMLNotFound
+	}
+	return []byte(html), err
+}
+
+// fsHTMLStore reads HTML files under dir/<hh>/<hash>.html.
+type fsHTMLStore struct {
+	dir string
+}
+
+func (s *fsHTMLStore) Get(_ context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(htmlObjectKey(hash))))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errHTMLNotFound
+	}
+	return b, err
+}
+
+// s3HTMLStore reads from any S3-compatible service using path-style URLs and SigV4.
+type s3HTMLStore struct {
+	cfg      S3StorageConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (s *s3HTMLStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hash, err := splitHTMLRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.get(ctx, s.cfg.Prefix+htmlObjectKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errHTMLNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("s3 get: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// get sends a SigV4-signed GET for key (path-style: endpoint/bucket/key).
+func (s *s3HTMLStore) get(ctx context.Context, key string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + strings.TrimPrefix(key, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(nil)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		"", // no query
+		"host:" + u.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+
+	key4 := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key4 = hmacSHA256(key4, s.cfg.Region)
+	key4 = hmacSHA256(key4, "s3")
+	key4 = hmacSHA256(key4, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key4, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+	return s.client.Do(req)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
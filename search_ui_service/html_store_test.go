@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The UI reads the object layout the crawler writes: <dir>/<hh>/<hash>.html.
+func TestFSHTMLStoreGet(t *testing.T) {
+	dir := t.TempDir()
+	st, err := newHTMLStore(HTMLStorageConfig{Backend: "fs", FSDir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := strings.Repeat("ab", 32)
+	if err := os.MkdirAll(filepath.Join(dir, "ab"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ab", hash+".html"), []byte("<p>stored</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{ref: "fs:" + hash, want: "<p>stored</p>"},
+		{ref: "fs:" + strings.Repeat("cd", 32), wantErr: errHTMLNotFound},
+		{ref: "fs:../../etc/passwd"},
+	}
+	for _, tt := range tests {
+		b, err := st.Get(context.Background(), tt.ref)
+		switch {
+		case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: err = %v, want %v", tt.ref, err, tt.wantErr)
+		case tt.wantErr == nil && tt.want == "" && err == nil:
+			t.Errorf("%s: invalid ref accepted", tt.ref)
+		case tt.want != "" && (err != nil || string(b) != tt.want):
+			t.Errorf("%s: got %q, %v; want %q", tt.ref, b, err, tt.want)
+		}
+	}
+}
+
+func TestS3HTMLStoreGet(t *testing.T) {
+	hash := strings.Repeat("0f", 32)
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, hash+".html") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("<p>from s3</p>"))
+	}))
+	defer srv.Close()
+	st, err := newHTMLStore(HTMLStorageConfig{Backend: "s3", S3: S3StorageConfig{
+		Endpoint: srv.URL, Bucket: "pages", Prefix: "html/", AccessKey: "AK", SecretKey: "SK",
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := st.Get(context.Background(), "s3:"+hash)
+	if err != nil || string(b) != "<p>from s3</p>" {
+		t.Fatalf("Get = %q, %v", b, err)
+	}
+	if want := "/pages/html/0f/" + hash + ".html"; gotPath != want {
+		t.Errorf("path = %q, want %q", gotPath, want)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AK/") {
+		t.Errorf("Authorization = %q, want a SigV4 credential for AK", gotAuth)
+	}
+	if _, err := st.Get(context.Background(), "s3:"+strings.Repeat("aa", 32)); !errors.Is(err, errHTMLNotFound) {
+		t.Errorf("missing object: err = %v, want not found", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"html/template"
 	"log"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"gopkg.in/yaml.v3"
 )
@@ -25,6 +27,10 @@ type Config struct {
 	HTTP    HTTPConf  `yaml:"http"`
 	Search  SearchCfg `yaml:"search"`
 	UI      UIConf    `yaml:"ui"`
+
+	// HTMLStorage must match the crawler's html_storage so /view can load external HTML.
+	HTMLStorage HTMLStorageConfig `yaml:"html_storage"`
+	Auth        AuthConf          `yaml:"auth"`
 }
 
 type HTTPConf struct {
@@ -37,11 +43,30 @@ type SearchCfg struct {
 	HighlightStart string   `yaml:"highlight_start"`
 	HighlightEnd   string   `yaml:"highlight_end"`
 	Languages      []string `yaml:"languages"`
+	// SnippetFallback is tried in order when ts_headline yields nothing: description, title,
+	// headings (crawler.index_headings), or meta:<name> for a meta tag the crawler stored in pages.meta (crawler.meta_tags).
+	SnippetFallback []string `yaml:"snippet_fallback"`
+	// StreamResults renders results as rows arrive from the cursor, flushing after each.
+	StreamResults bool `yaml:"stream_results"`
+	// MinRank drops matches whose best ts_rank_cd (ru/en) is below it; 0 disables.
+	MinRank float64 `yaml:"min_rank"`
+	// ShowMatchedLang shows which language (ru/en) matched next to each result.
+	ShowMatchedLang bool `yaml:"show_matched_lang"`
+	// Query limits (see query_sanitize.go): longer queries, more words or longer words are
+	// refused with 400; 0 = defaults 256 characters, 32 words, 64 characters per word.
+	MaxQueryLength int `yaml:"max_query_length"`
+	MaxQueryTerms  int `yaml:"max_query_terms"`
+	MaxTermLength  int `yaml:"max_term_length"`
+	// DedupURLVariants collapses results whose URLs differ only in scheme or a trailing
+	// slash into the best-ranked one; the total counts collapsed results.
+	DedupURLVariants bool `yaml:"dedup_url_variants"`
 }
 
 type UIConf struct {
 	Title        string `yaml:"title"`
 	TemplatesDir string `yaml:"templates_dir"`
+	// TextView enables /text?url= (extracted text as text/plain).
+	TextView TextViewConf `yaml:"text_view"`
 }
 
 type Server struct {
@@ -49,6 +74,7 @@ type Server struct {
 	db    *pgxpool.Pool
 	tmpl  *template.Template
 	title string
+	html  htmlStore // nil when HTML is stored inline in pages.html
 }
 
 func main() {
@@ -57,6 +83,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to load config %q: %v", cfgPath, err)
 	}
+	cfg.Auth.Token = getenv("SEARCH_UI_AUTH_TOKEN", cfg.Auth.Token)
 
 	// DB DSN must come from environment (.env)
 	dsn := os.Getenv("PG_DSN")
@@ -75,30 +102,34 @@ func main() {
 	if templatesDir == "" {
 		templatesDir = "./templates"
 	}
-	funcs := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"sub": func(a, b int) int { return a - b },
-		"mul": func(a, b int) int { return a * b },
-		// Mark snippet HTML (ts_headline with StartSel/StopSel) as safe for rendering
-		"raw": func(s string) template.HTML { return template.HTML(s) },
-	}
-	tmpl, err := template.New("base").Funcs(funcs).ParseGlob(filepath.Join(templatesDir, "*.html"))
+	tmpl, err := template.New("base").Funcs(templateFuncs()).ParseGlob(filepath.Join(templatesDir, "*.html"))
 	if err != nil {
 		log.Fatalf("failed to parse templates: %v", err)
 	}
 
+	store, err := newHTMLStore(cfg.HTMLStorage, pool)
+	if err != nil {
+		log.Fatalf("failed to init html storage: %v", err)
+	}
+
 	srv := &Server{
 		cfg:   cfg,
 		db:    pool,
 		tmpl:  tmpl,
 		title: cfg.UI.Title,
+		html:  store,
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", srv.handleIndex)
 	mux.HandleFunc("/search", srv.handleSearch)
+	mux.HandleFunc("/api/search", srv.handleAPISearch)
 	mux.HandleFunc("/page", srv.handlePage)
 	mux.HandleFunc("/view", srv.handleView)
+	mux.HandleFunc("/api/config", srv.requireAuth(srv.handleConfig))
+	if cfg.UI.TextView.Enabled {
+		mux.HandleFunc("/text", srv.handleText)
+	}
 
 	addr := cfg.HTTP.Addr
 	if addr == "" {
@@ -123,31 +154,21 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	sort := strings.TrimSpace(r.URL.Query().Get("sort"))
 	if q == "" {
 		// Render empty form page
-		data := map[string]any{
-			"Title": s.title,
-			"Q":     "",
-			"Site":  site,
-			"Sort":  sort,
-		}
-		s.render(w, "index.html", data)
+		s.render(w, "index.html", searchView{Title: s.title, Site: site, Sort: sort})
 		return
 	}
 	// If q present on index, render full page with results block
-	results, total, err := s.query(r.Context(), q, page, s.pageSize(), site, sort)
+	data, err := s.searchData(w, r, q, page, site, sort)
+	var qErr *queryError
+	if errors.As(err, &qErr) {
+		http.Error(w, qErr.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "search error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	data := map[string]any{
-		"Title":    s.title,
-		"Q":        q,
-		"Results":  results,
-		"Page":     page,
-		"PageSize": s.pageSize(),
-		"Total":    total,
-		"Site":     site,
-		"Sort":     sort,
-	}
+	data.Title = s.title
 	s.render(w, "index.html", data)
 }
 
@@ -161,23 +182,57 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("query is required"))
 		return
 	}
-	results, total, err := s.query(r.Context(), q, page, s.pageSize(), site, sort)
+	data, err := s.searchData(w, r, q, page, site, sort)
+	var qErr *queryError
+	if errors.As(err, &qErr) {
+		http.Error(w, qErr.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "search error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	data := map[string]any{
-		"Q":        q,
-		"Results":  results,
-		"Page":     page,
-		"PageSize": s.pageSize(),
-		"Total":    total,
-		"Site":     site,
-		"Sort":     sort,
-	}
+	data.Title = s.title
 	s.render(w, "results.html", data)
 }
 
+// searchResponse is the GET /api/search body.
+type searchResponse struct {
+	Query    string   `json:"query"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"page_size"`
+	Total    int      `json:"total"`
+	Results  []Result `json:"results"`
+}
+
+// handleAPISearch: GET /api/search?q=&page=&site=&sort= — the /search results as JSON.
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	input := strings.TrimSpace(r.URL.Query().Get("q"))
+	page := parsePositiveInt(r.URL.Query().Get("page"), 1)
+	site := strings.TrimSpace(r.URL.Query().Get("site"))
+	sort := strings.TrimSpace(r.URL.Query().Get("sort"))
+	if input == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	q, err := sanitizeQuery(input, s.cfg.Search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, total, err := s.query(r.Context(), q, page, s.pageSize(), site, sort)
+	if err != nil {
+		http.Error(w, "search error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(searchResponse{Query: input, Page: page, PageSize: s.pageSize(), Total: total, Results: results})
+}
+
 func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
 	urlParam := strings.TrimSpace(r.URL.Query().Get("url"))
 	if urlParam == "" {
@@ -189,25 +244,17 @@ SELECT
   url,
   COALESCE(NULLIF(title, ''), url) AS title,
   COALESCE(description, '') AS description,
-  fetched_at
+  fetched_at,
+  created_at
 FROM pages
 WHERE url = $1
 LIMIT 1;`
-	var pv struct {
-		URL         string
-		Title       string
-		Description string
-		FetchedAt   time.Time
-	}
-	if err := s.db.QueryRow(r.Context(), q, urlParam).Scan(&pv.URL, &pv.Title, &pv.Description, &pv.FetchedAt); err != nil {
+	var pv pageDetails
+	if err := s.db.QueryRow(r.Context(), q, urlParam).Scan(&pv.URL, &pv.Title, &pv.Description, &pv.FetchedAt, &pv.FirstSeenAt); err != nil {
 		http.Error(w, "page not found", http.StatusNotFound)
 		return
 	}
-	data := map[string]any{
-		"Title": s.title,
-		"Page":  pv,
-	}
-	s.render(w, "page.html", data)
+	s.render(w, "page.html", pageView{Title: s.title, Page: pv, TextView: s.cfg.UI.TextView.Enabled})
 }
 
 func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
@@ -219,28 +266,74 @@ func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
 	const q = `
 SELECT
   COALESCE(html, '') AS html,
+  COALESCE(html_ref, '') AS html_ref,
   COALESCE(NULLIF(content_type, ''), 'text/html; charset=utf-8') AS content_type
 FROM pages
 WHERE url = $1
 LIMIT 1;`
 	var html string
+	var htmlRef string
 	var contentType string
-	if err := s.db.QueryRow(r.Context(), q, urlParam).Scan(&html, &contentType); err != nil {
+	if err := s.db.QueryRow(r.Context(), q, urlParam).Scan(&html, &htmlRef, &contentType); err != nil {
 		http.Error(w, "page not found", http.StatusNotFound)
 		return
 	}
+	body := []byte(html)
+	if htmlRef != "" {
+		if s.html == nil {
+			http.Error(w, "html is kept in external storage, but html_storage is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		b, err := s.html.Get(r.Context(), htmlRef)
+		if errors.Is(err, errHTMLNotFound) {
+			http.Error(w, "page html not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "html storage error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		body = b
+	}
 	w.Header().Set("Content-Type", contentType)
-	_, _ = w.Write([]byte(html))
+	_, _ = w.Write(body)
 }
 
 type Result struct {
-	URL       string
-	Title     string
-	Snippet   string
-	FetchedAt time.Time
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Snippet     string    `json:"snippet"`                // HTML: highlighted with search.highlight_start/end
+	FetchedAt   time.Time `json:"fetched_at"`             // last fetch
+	FirstSeenAt time.Time `json:"first_seen_at"`          // pages.created_at, kept across recrawls
+	MatchedLang string    `json:"matched_lang,omitempty"` // "ru" or "en": whichever rank dominated; "" when neither matched
 }
 
 func (s *Server) query(ctx context.Context, q string, page, pageSize int, site, sort string) ([]Result, int, error) {
+	rows, total, err := s.openResults(ctx, q, page, pageSize, site, sort)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Result, 0, pageSize)
+	for rows.Next() {
+		res, err := s.scanResult(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, res)
+	}
+	if rows.Err() != nil {
+		return nil, 0, rows.Err()
+	}
+	return out, total, nil
+}
+
+// urlVariantKey groups URLs that differ only in scheme or a trailing slash (search.dedup_url_variants).
+const urlVariantKey = `rtrim(regexp_replace(url, '^https?://', ''), '/')`
+
+// openResults runs the count query and opens the cursor for one results page.
+func (s *Server) openResults(ctx context.Context, q string, page, pageSize int, site, sort string) (pgx.Rows, int, error) {
 	offset := (page - 1) * pageSize
 
 	where := "(tsv_ru @@ websearch_to_tsquery('russian', $1) OR tsv_en @@ websearch_to_tsquery('english', $1))"
@@ -251,9 +344,21 @@ func (s *Server) query(ctx context.Context, q string, page, pageSize int, site,
 		where += " AND s.domain = $2"
 		args = append(args, site)
 	}
+	// Same rank expression as the results query, so count and pages agree.
+	if s.cfg.Search.MinRank > 0 {
+		args = append(args, s.cfg.Search.MinRank)
+		where += " AND GREATEST(" +
+			"ts_rank_cd(COALESCE(tsv_ru, to_tsvector('russian','')), websearch_to_tsquery('russian', $1)), " +
+			"ts_rank_cd(COALESCE(tsv_en, to_tsvector('english','')), websearch_to_tsquery('english', $1))" +
+			") >= $" + strconv.Itoa(len(args))
+	}
 
 	// Count
-	countSQL := "SELECT count(*) FROM pages " + join + " WHERE " + where + ";"
+	countExpr := "count(*)"
+	if s.cfg.Search.DedupURLVariants {
+		countExpr = "count(DISTINCT " + urlVariantKey + ")"
+	}
+	countSQL := "SELECT " + countExpr + " FROM pages " + join + " WHERE " + where + ";"
 	var total int
 	if err := s.db.QueryRow(ctx, countSQL, args...).Scan(&total); err != nil {
 		return nil, 0, err
@@ -265,31 +370,53 @@ func (s *Server) query(ctx context.Context, q string, page, pageSize int, site,
 		order = "ORDER BY fetched_at DESC"
 	}
 
-	// Build LIMIT/OFFSET placeholders depending on presence of site filter
+	// Build LIMIT/OFFSET placeholders depending on presence of site/min_rank filters
 	limitIdx := len(args) + 1
 	offsetIdx := len(args) + 2
 
-	searchSQL := `
-SELECT
-	 url,
-	 title,
-	 fetched_at,
-	 rank_ru,
-	 rank_en,
-	 snippet_ru,
-	 snippet_en
-FROM (
+	matches := `
 	 SELECT
 	   url,
 	   COALESCE(NULLIF(title, ''), url) AS title,
+	   COALESCE(description, '') AS description,
+	   COALESCE(meta, '{}'::jsonb) AS meta,
+	   COALESCE(array_to_string(headings, ' · '), '') AS headings,
 	   fetched_at,
+	   created_at,
 	   ts_rank_cd(COALESCE(tsv_ru, to_tsvector('russian','')), websearch_to_tsquery('russian', $1)) AS rank_ru,
 	   ts_rank_cd(COALESCE(tsv_en, to_tsvector('english','')), websearch_to_tsquery('english', $1)) AS rank_en,
 	   ts_headline('russian', text, websearch_to_tsquery('russian', $1), 'StartSel=<mark>,StopSel=</mark>,MaxFragments=2,MaxWords=20,MinWords=10') AS snippet_ru,
 	   ts_headline('english', text, websearch_to_tsquery('english', $1), 'StartSel=<mark>,StopSel=</mark>,MaxFragments=2,MaxWords=20,MinWords=10') AS snippet_en
 	 FROM pages
 	 ` + join + `
-	 WHERE ` + where + `
+	 WHERE ` + where
+	if s.cfg.Search.DedupURLVariants {
+		// keep the best-ranked page of each URL variant group
+		matches = `
+	 SELECT * FROM (
+	   SELECT m.*, row_number() OVER (
+	     PARTITION BY ` + urlVariantKey + `
+	     ORDER BY GREATEST(m.rank_ru, m.rank_en) DESC, m.fetched_at DESC) AS variant_n
+	   FROM (` + matches + `
+	   ) m
+	 ) v
+	 WHERE variant_n = 1`
+	}
+
+	searchSQL := `
+SELECT
+	 url,
+	 title,
+	 description,
+	 meta,
+	 headings,
+	 fetched_at,
+	 created_at,
+	 rank_ru,
+	 rank_en,
+	 snippet_ru,
+	 snippet_en
+FROM (` + matches + `
 ) sub
 ` + order + `
 LIMIT $` + strconv.Itoa(limitIdx) + ` OFFSET $` + strconv.Itoa(offsetIdx) + `;`
@@ -299,31 +426,71 @@ LIMIT $` + strconv.Itoa(limitIdx) + ` OFFSET $` + strconv.Itoa(offsetIdx) + `;`
 	if err != nil {
 		return nil, 0, err
 	}
-	defer rows.Close()
+	return rows, total, nil
+}
 
-	out := make([]Result, 0, pageSize)
-	for rows.Next() {
-		var url, title, snippetRu, snippetEn string
-		var fetchedAt time.Time
-		var rankRu, rankEn float32
-		if err := rows.Scan(&url, &title, &fetchedAt, &rankRu, &rankEn, &snippetRu, &snippetEn); err != nil {
-			return nil, 0, err
+// scanResult reads one row of openResults, applying the snippet fallback.
+func (s *Server) scanResult(rows pgx.Rows) (Result, error) {
+	var url, title, description, headings, snippetRu, snippetEn string
+	var meta map[string]string
+	var fetchedAt, firstSeenAt time.Time
+	var rankRu, rankEn float32
+	if err := rows.Scan(&url, &title, &description, &meta, &headings, &fetchedAt, &firstSeenAt, &rankRu, &rankEn, &snippetRu, &snippetEn); err != nil {
+		return Result{}, err
+	}
+	snippet := firstNonEmpty(snippetRu, snippetEn)
+	if strings.TrimSpace(snippet) == "" {
+		snippet = s.fallbackSnippet(description, title, headings, meta)
+	}
+	return Result{
+		URL:         url,
+		Title:       title,
+		Snippet:     snippet,
+		FetchedAt:   fetchedAt,
+		FirstSeenAt: firstSeenAt,
+		MatchedLang: matchedLang(rankRu, rankEn),
+	}, nil
+}
+
+// matchedLang attributes a result to the language whose rank dominated (ties go to ru,
+// matching the snippet preference).
+func matchedLang(rankRu, rankEn float32) string {
+	switch {
+	case rankEn > rankRu:
+		return "en"
+	case rankRu > 0:
+		return "ru"
+	}
+	return ""
+}
+
+// fallbackSnippet picks the first non-empty field from search.snippet_fallback.
+// Snippets are rendered as HTML, so plain-text fallbacks are escaped here.
+func (s *Server) fallbackSnippet(description, title, headings string, meta map[string]string) string {
+	order := s.cfg.Search.SnippetFallback
+	if len(order) == 0 {
+		order = []string{"description", "title"}
+	}
+	for _, f := range order {
+		var v string
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch f {
+		case "description":
+			v = description
+		case "title":
+			v = title
+		case "headings":
+			v = headings
+		default:
+			if name, ok := strings.CutPrefix(f, "meta:"); ok {
+				v = meta[name]
+			}
 		}
-		snippet := firstNonEmpty(snippetRu, snippetEn)
-		if snippet == "" {
-			snippet = title
+		if strings.TrimSpace(v) != "" {
+			return template.HTMLEscapeString(v)
 		}
-		out = append(out, Result{
-			URL:       url,
-			Title:     title,
-			Snippet:   snippet,
-			FetchedAt: fetchedAt,
-		})
-	}
-	if rows.Err() != nil {
-		return nil, 0, rows.Err()
 	}
-	return out, total, nil
+	return ""
 }
 
 func (s *Server) pageSize() int {
@@ -376,3 +543,16 @@ func firstNonEmpty(a, b string) string {
 	}
 	return b
 }
+
+// templateFuncs are the helpers the templates call.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"add": func(a, b int) int { return a + b },
+		"sub": func(a, b int) int { return a - b },
+		"mul": func(a, b int) int { return a * b },
+		// Mark snippet HTML (ts_headline with StartSel/StopSel) as safe for rendering
+		"raw": func(s string) template.HTML { return template.HTML(s) },
+		// Flush streamed output (search.stream_results); no-op for non-streamed pages
+		"flush": flushTemplate,
+	}
+}
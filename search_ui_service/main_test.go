@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFallbackSnippet(t *testing.T) {
+	meta := map[string]string{"og:description": "OG text"}
+	tests := []struct {
+		name                         string
+		order                        []string
+		description, title, headings string
+		want                         string
+	}{
+		{name: "default prefers description", description: "Desc", title: "Title", want: "Desc"},
+		{name: "default falls back to title", description: "  ", title: "Title", want: "Title"},
+		{name: "default without fields", want: ""},
+		{name: "headings first", order: []string{"headings", "description"}, description: "Desc", headings: "H1", want: "H1"},
+		{name: "empty headings skipped", order: []string{"headings", "description"}, description: "Desc", want: "Desc"},
+		{name: "meta field", order: []string{"meta:og:description", "title"}, title: "Title", want: "OG text"},
+		{name: "missing meta field", order: []string{"meta:keywords", "title"}, title: "Title", want: "Title"},
+		{name: "case and spaces", order: []string{" Title "}, title: "Title", want: "Title"},
+		{name: "unknown field ignored", order: []string{"body", "title"}, title: "Title", want: "Title"},
+		{name: "plain text escaped", description: "a < b & c", want: "a &lt; b &amp; c"},
+	}
+	for _, tt := range tests {
+		s := &Server{cfg: Config{Search: SearchCfg{SnippetFallback: tt.order}}}
+		if got := s.fallbackSnippet(tt.description, tt.title, tt.headings, meta); got != tt.want {
+			t.Errorf("%s: fallbackSnippet = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// min_rank drops the weak matches from both the page and the total.
+func TestQueryMinRank(t *testing.T) {
+	db := testDB(t)
+	const domain = "min-rank.test"
+	testPages(t, db, domain, map[string]string{
+		// ts_rank_cd adds ~0.1 per covered occurrence: 1.0 vs 0.1
+		"http://min-rank.test/strong": strings.Repeat("zebrafinch song. ", 10),
+		"http://min-rank.test/weak":   "a long page about gardening that mentions zebrafinch once " + strings.Repeat("soil water light ", 50),
+		"http://min-rank.test/none":   "nothing relevant here",
+	})
+	tests := []struct {
+		minRank float64
+		want    []string
+	}{
+		{minRank: 0, want: []string{"http://min-rank.test/strong", "http://min-rank.test/weak"}},
+		{minRank: 0.3, want: []string{"http://min-rank.test/strong"}},
+		{minRank: 100, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.minRank), func(t *testing.T) {
+			s := &Server{db: db, cfg: Config{Search: SearchCfg{MinRank: tt.minRank}}}
+			res, total, err := s.query(context.Background(), "zebrafinch", 1, 10, domain, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range res {
+				got = append(got, r.URL)
+			}
+			if !slices.Equal(got, tt.want) || total != len(tt.want) {
+				t.Errorf("results = %v (total %d), want %v", got, total, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchedLang(t *testing.T) {
+	tests := []struct {
+		ru, en float32
+		want   string
+	}{
+		{0.5, 0.1, "ru"},
+		{0.1, 0.5, "en"},
+		{0.3, 0.3, "ru"}, // tie goes to ru, like the snippet
+		{0, 0.2, "en"},
+		{0.2, 0, "ru"},
+		{0, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := matchedLang(tt.ru, tt.en); got != tt.want {
+			t.Errorf("matchedLang(%v, %v) = %q, want %q", tt.ru, tt.en, got, tt.want)
+		}
+	}
+}
+
+// Each result is attributed to the language whose stemmer matched the query.
+func TestQueryMatchedLang(t *testing.T) {
+	db := testDB(t)
+	const domain = "matched-lang.test"
+	testPages(t, db, domain, map[string]string{
+		"http://matched-lang.test/ru": "рыжие кошки спят",
+		"http://matched-lang.test/en": "the dogs were running in the park",
+	})
+	tests := []struct {
+		q    string
+		want map[string]string // url -> matched_lang
+	}{
+		{q: "кошка", want: map[string]string{"http://matched-lang.test/ru": "ru"}},
+		{q: "dog", want: map[string]string{"http://matched-lang.test/en": "en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			s := &Server{db: db}
+			res, _, err := s.query(context.Background(), tt.q, 1, 10, domain, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, r := range res {
+				got[r.URL] = r.MatchedLang
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// dedup_url_variants keeps the best-ranked of http/https and slash variants, in the total too.
+func TestQueryDedupURLVariants(t *testing.T) {
+	db := testDB(t)
+	const domain = "dedup-variants.test"
+	testPages(t, db, domain, map[string]string{
+		"http://dedup-variants.test/a":   "kingfisher " + strings.Repeat("river bank ", 30),
+		"https://dedup-variants.test/a/": strings.Repeat("kingfisher dive. ", 10),
+		"https://dedup-variants.test/a":  "kingfisher twice kingfisher " + strings.Repeat("reeds ", 30),
+		"http://dedup-variants.test/b":   "kingfisher nest",
+		"http://dedup-variants.test/ab":  "kingfisher eggs",
+	})
+	tests := []struct {
+		name  string
+		dedup bool
+		want  []string // sorted
+	}{
+		{name: "off", want: []string{"http://dedup-variants.test/a", "http://dedup-variants.test/ab", "http://dedup-variants.test/b", "https://dedup-variants.test/a", "https://dedup-variants.test/a/"}},
+		{name: "on", dedup: true, want: []string{"http://dedup-variants.test/ab", "http://dedup-variants.test/b", "https://dedup-variants.test/a/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: db, cfg: Config{Search: SearchCfg{DedupURLVariants: tt.dedup}}}
+			var got []string
+			for page := 1; page <= len(tt.want)+1; page++ {
+				res, total, err := s.query(context.Background(), "kingfisher", page, 2, domain, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total != len(tt.want) {
+					t.Errorf("page %d: total = %d, want %d", page, total, len(tt.want))
+				}
+				for _, r := range res {
+					got = append(got, r.URL)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Headings carry tsvector weight A, so a heading match outranks repeated body matches.
+func TestQueryRanksHeadings(t *testing.T) {
+	db := testDB(t)
+	const domain = "headings-rank.test"
+	testPages(t, db, domain, map[string]string{
+		"http://headings-rank.test/heading": "field notes " + strings.Repeat("meadow grass ", 20),
+		"http://headings-rank.test/body":    "otter otter otter " + strings.Repeat("meadow grass ", 20),
+	})
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, `UPDATE pages SET headings = ARRAY['Otter'] WHERE url = $1`, "http://headings-rank.test/heading"); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db}
+	res, _, err := s.query(ctx, "otter", 1, 10, domain, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range res {
+		got = append(got, r.URL)
+	}
+	if want := []string{"http://headings-rank.test/heading", "http://headings-rank.test/body"}; !slices.Equal(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+}
+
+func TestHandleAPISearch(t *testing.T) {
+	db := testDB(t)
+	const domain = "api-search.test"
+	testPages(t, db, domain, map[string]string{
+		"http://api-search.test/ru": "рыжие кошки спят",
+		"http://api-search.test/en": "the dogs were running in the park",
+	})
+	s := &Server{db: db}
+	tests := []struct {
+		q        string
+		wantURL  string
+		wantLang string
+	}{
+		{q: "кошка", wantURL: "http://api-search.test/ru", wantLang: "ru"},
+		{q: "dog", wantURL: "http://api-search.test/en", wantLang: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleAPISearch(rec, httptest.NewRequest(http.MethodGet, "/api/search?site="+domain+"&q="+url.QueryEscape(tt.q), nil))
+			if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			}
+			var resp struct {
+				Query   string `json:"query"`
+				Total   int    `json:"total"`
+				Results []struct {
+					URL         string `json:"url"`
+					MatchedLang string `json:"matched_lang"`
+				} `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Query != tt.q || resp.Total != 1 || len(resp.Results) != 1 ||
+				resp.Results[0].URL != tt.wantURL || resp.Results[0].MatchedLang != tt.wantLang {
+				t.Errorf("response = %+v, want %s matched %s", resp, tt.wantURL, tt.wantLang)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// queryError is user input the search refuses; its message is shown as-is (400).
+type queryError struct {
+	msg string
+}
+
+func (e *queryError) Error() string { return e.msg }
+
+func (c SearchCfg) maxQueryLength() int { return positiveOr(c.MaxQueryLength, 256) }
+func (c SearchCfg) maxQueryTerms() int  { return positiveOr(c.MaxQueryTerms, 32) }
+func (c SearchCfg) maxTermLength() int  { return positiveOr(c.MaxTermLength, 64) }
+
+func positiveOr(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// sanitizeQuery prepares user input for websearch_to_tsquery: control characters and
+// tsquery operator characters (& | ! ( ) : * < > \) become spaces, whitespace is collapsed
+// and an unbalanced quote is dropped. Websearch syntax ("phrase", -term, or) is kept.
+// Queries over the length, term count or term length limits are refused.
+func sanitizeQuery(q string, c SearchCfg) (string, error) {
+	if !utf8.ValidString(q) {
+		return "", &queryError{"query is not valid UTF-8"}
+	}
+	if n := utf8.RuneCountInString(q); n > c.maxQueryLength() {
+		return "", &queryError{fmt.Sprintf("query is too long (%d characters, at most %d)", n, c.maxQueryLength())}
+	}
+	q = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.IsSpace(r):
+			return ' '
+		case strings.ContainsRune(`&|!():*<>\`, r):
+			return ' '
+		}
+		return r
+	}, q)
+	if strings.Count(q, `"`)%2 == 1 {
+		i := strings.LastIndex(q, `"`)
+		q = q[:i] + " " + q[i+1:]
+	}
+	terms := strings.Fields(q)
+	if len(terms) > c.maxQueryTerms() {
+		return "", &queryError{fmt.Sprintf("query has too many words (%d, at most %d)", len(terms), c.maxQueryTerms())}
+	}
+	for _, t := range terms {
+		if utf8.RuneCountInString(t) > c.maxTermLength() {
+			return "", &queryError{fmt.Sprintf("query word is too long (at most %d characters)", c.maxTermLength())}
+		}
+	}
+	q = strings.Join(terms, " ")
+	if strings.Trim(q, `"- `) == "" {
+		return "", &queryError{"query has no searchable words"}
+	}
+	return q, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeQuery(t *testing.T) {
+	small := SearchCfg{MaxQueryLength: 40, MaxQueryTerms: 4, MaxTermLength: 10}
+	tests := []struct {
+		name    string
+		q       string
+		cfg     SearchCfg
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", q: "golang search", want: "golang search"},
+		{name: "websearch syntax kept", q: `"exact phrase" -minus or plus`, want: `"exact phrase" -minus or plus`},
+		{name: "tsquery operators stripped", q: `foo & bar | !baz (qux):* <-> a\b`, want: "foo bar baz qux - a b"},
+		{name: "control characters and whitespace", q: "foo\x00bar\tbaz\n\u200bqux end", want: "foo bar baz \u200bqux end"},
+		{name: "unbalanced quote dropped", q: `"open phrase "closed" tail`, want: `"open phrase "closed tail`},
+		{name: "single quote char", q: `abc"`, want: "abc"},
+		{name: "cyrillic", q: "поиск  сайта", want: "поиск сайта"},
+		{name: "only operators", q: "&&| !()", wantErr: true},
+		{name: "only quotes and minus", q: `"" - --`, wantErr: true},
+		{name: "invalid utf-8", q: "bad \xff byte", wantErr: true},
+		{name: "too long", q: strings.Repeat("a ", 20) + "x", cfg: small, wantErr: true},
+		{name: "length counts runes", q: strings.Repeat("я", 10), cfg: SearchCfg{MaxQueryLength: 10, MaxTermLength: 10}, want: strings.Repeat("я", 10)},
+		{name: "too many terms", q: "a b c d e", cfg: small, wantErr: true},
+		{name: "operators do not count as terms", q: "a & b | c & d", cfg: small, want: "a b c d"},
+		{name: "term too long", q: "short waytoolongword", cfg: small, wantErr: true},
+		{name: "default length cap", q: strings.Repeat("x", 257), wantErr: true},
+		{name: "default term cap", q: strings.Repeat("x", 65), wantErr: true},
+		{name: "default terms cap", q: strings.Repeat("w ", 33), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeQuery(tt.q, tt.cfg)
+			var qErr *queryError
+			if tt.wantErr {
+				if !errors.As(err, &qErr) {
+					t.Errorf("sanitizeQuery(%q) = %q, %v; want a queryError", tt.q, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("sanitizeQuery(%q) = %q, %v; want %q", tt.q, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Refused queries are a 400 with the reason, before any database work.
+func TestSearchRejectsAbusiveQuery(t *testing.T) {
+	s := &Server{cfg: Config{Search: SearchCfg{MaxQueryTerms: 3}}}
+	for _, tt := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/search", s.handleSearch},
+		{"/", s.handleIndex},
+		{"/api/search", s.handleAPISearch},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.path+"?q="+url.QueryEscape("one two three four"), nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "too many words") {
+			t.Errorf("%s: status %d body %q, want 400 too many words", tt.path, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// resultStream carries the outcome of a streamed results page. Err is only meaningful
+// after the results channel is closed (the template reads it after {{ range }}).
+type resultStream struct {
+	err error
+}
+
+// Err returns the error that cut the stream short, or "".
+func (rs *resultStream) Err() string {
+	if rs == nil || rs.err == nil {
+		return ""
+	}
+	return rs.err.Error()
+}
+
+// streamResults is query for search.stream_results: the count runs up front (for
+// pagination), rows are sent to the channel as the cursor yields them.
+func (s *Server) streamResults(ctx context.Context, q string, page, pageSize int, site, sort string) (<-chan Result, *resultStream, int, error) {
+	rows, total, err := s.openResults(ctx, q, page, pageSize, site, sort)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ch := make(chan Result)
+	st := &resultStream{}
+	go func() {
+		defer close(ch)
+		defer rows.Close()
+		for rows.Next() {
+			res, err := s.scanResult(rows)
+			if err != nil {
+				st.err = err
+				return
+			}
+			select {
+			case ch <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := rows.Err(); err != nil && ctx.Err() == nil {
+			st.err = err
+		}
+	}()
+	return ch, st, total, nil
+}
+
+// searchData builds the template data for a results page, streaming when enabled.
+// Templates range over Rows and call {{ flush $.Flush }} after each result;
+// StreamState.Err reports a failure after headers were already sent.
+func (s *Server) searchData(w http.ResponseWriter, r *http.Request, q string, page int, site, sort string) (searchView, error) {
+	input := q
+	q, err := sanitizeQuery(q, s.cfg.Search)
+	if err != nil {
+		return searchView{}, err
+	}
+	data := searchView{
+		Q:        input,
+		Page:     page,
+		PageSize: s.pageSize(),
+		Site:     site,
+		Sort:     sort,
+		ShowLang: s.cfg.Search.ShowMatchedLang,
+	}
+	if s.cfg.Search.StreamResults {
+		ch, st, total, err := s.streamResults(r.Context(), q, page, s.pageSize(), site, sort)
+		if err != nil {
+			return searchView{}, err
+		}
+		data.Stream = ch
+		data.Total = total
+		data.StreamState = st
+		if f, ok := w.(http.Flusher); ok {
+			data.Flush = f
+		}
+		return data, nil
+	}
+	results, total, err := s.query(r.Context(), q, page, s.pageSize(), site, sort)
+	if err != nil {
+		return searchView{}, err
+	}
+	data.Results = results
+	data.Total = total
+	return data, nil
+}
+
+// flushTemplate is the "flush" template func: pushes rendered output to the client
+// when given an http.Flusher, no-op otherwise.
+func flushTemplate(f any) string {
+	if fl, ok := f.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TextViewConf controls /text — the stored extracted text served as plain text.
+type TextViewConf struct {
+	Enabled bool `yaml:"enabled"`
+	// CacheSeconds is the Cache-Control max-age (default 300).
+	CacheSeconds int `yaml:"cache_seconds"`
+}
+
+// textChunkChars is how many characters of pages.text are read per query when streaming.
+const textChunkChars = 64 * 1024
+
+// handleText serves pages.text as text/plain. The text is read in chunks so large pages
+// are never held in memory whole; ETag/Last-Modified follow the page's last update.
+func (s *Server) handleText(w http.ResponseWriter, r *http.Request) {
+	urlParam := strings.TrimSpace(r.URL.Query().Get("url"))
+	if urlParam == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	const q = `
+SELECT id, COALESCE(char_length(text), 0), updated_at
+FROM pages
+WHERE url = $1 AND text IS NOT NULL
+LIMIT 1;`
+	var (
+		id        int64
+		length    int
+		updatedAt time.Time
+	)
+	if err := s.db.QueryRow(r.Context(), q, urlParam).Scan(&id, &length, &updatedAt); err != nil {
+		http.Error(w, "page not found", http.StatusNotFound)
+		return
+	}
+
+	maxAge := s.cfg.UI.TextView.CacheSeconds
+	if maxAge <= 0 {
+		maxAge = 300
+	}
+	etag := fmt.Sprintf(`W/"%d-%d"`, id, updatedAt.UnixNano())
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, updatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	const chunkQ = `SELECT substr(text, $2, $3) FROM pages WHERE id = $1;`
+	for from := 1; from <= length; from += textChunkChars {
+		var chunk string
+		if err := s.db.QueryRow(r.Context(), chunkQ, id, from, textChunkChars).Scan(&chunk); err != nil {
+			// Headers are already sent; all we can do is cut the response short.
+			return
+		}
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			return
+		}
+	}
+}
+
+// notModified reports whether the conditional request headers match the current version.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimSpace(t); t == etag || t == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !modified.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
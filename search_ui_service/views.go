@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// View models of the templates: every field a template reads is declared here, so a
+// renamed or misspelled field fails the build instead of rendering empty.
+
+// searchView is the data of index.html (Q == "" renders the empty search form) and results.html.
+type searchView struct {
+	Title       string
+	Q           string // the query as typed
+	Site        string
+	Sort        string
+	Page        int
+	PageSize    int
+	Total       int
+	ShowLang    bool
+	Results     []Result      // the buffered page
+	Stream      <-chan Result // with search.stream_results, instead of Results
+	StreamState *resultStream // reports an error that cut a streamed page short
+	Flush       http.Flusher  // nil: the "flush" template func is a no-op
+}
+
+// Rows yields the page's results for {{ range .Rows }}: the stream when there is one,
+// else Results.
+func (v searchView) Rows() <-chan Result {
+	if v.Stream != nil {
+		return v.Stream
+	}
+	ch := make(chan Result, len(v.Results))
+	for _, r := range v.Results {
+		ch <- r
+	}
+	close(ch)
+	return ch
+}
+
+// HasRows tells whether the page has results to show. A stream is not read yet, so it is
+// judged by the up-front count; a buffered page by its Results.
+func (v searchView) HasRows() bool {
+	if v.Stream != nil {
+		return v.Total > (v.Page-1)*v.PageSize
+	}
+	return len(v.Results) > 0
+}
+
+// pageView is the data of page.html.
+type pageView struct {
+	Title    string
+	Page     pageDetails
+	TextView bool
+}
+
+type pageDetails struct {
+	URL         string
+	Title       string
+	Description string
+	FetchedAt   time.Time
+	FirstSeenAt time.Time
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearchViewRendersBufferedAndStreamed(t *testing.T) {
+	tmpl, err := template.New("base").Funcs(templateFuncs()).ParseGlob("templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates: %v", err)
+	}
+	results := []Result{{URL: "https://a.example/"}, {URL: "https://b.example/"}}
+	stream := func() <-chan Result {
+		ch := make(chan Result, len(results))
+		for _, r := range results {
+			ch <- r
+		}
+		close(ch)
+		return ch
+	}
+	for _, name := range []string{"index.html", "results.html"} {
+		views := map[string]searchView{
+			"buffered": {Q: "q", Page: 1, PageSize: 10, Total: 2, Results: results},
+			"streamed": {Q: "q", Page: 1, PageSize: 10, Total: 2, Stream: stream(), StreamState: &resultStream{}},
+		}
+		for mode, v := range views {
+			var b strings.Builder
+			if err := tmpl.ExecuteTemplate(&b, name, v); err != nil {
+				t.Fatalf("%s %s: %v", name, mode, err)
+			}
+			for _, r := range results {
+				if !strings.Contains(b.String(), r.URL) {
+					t.Errorf("%s %s: missing %s", name, mode, r.URL)
+				}
+			}
+		}
+	}
+}
+
+// An empty page says so, streamed or not, without an empty results block.
+func TestSearchViewEmpty(t *testing.T) {
+	tmpl, err := template.New("base").Funcs(templateFuncs()).ParseGlob("templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates: %v", err)
+	}
+	closed := func() <-chan Result {
+		ch := make(chan Result)
+		close(ch)
+		return ch
+	}
+	views := map[string]searchView{
+		"buffered":            {Q: "q", Page: 1, PageSize: 10},
+		"streamed":            {Q: "q", Page: 1, PageSize: 10, Stream: closed(), StreamState: &resultStream{}},
+		"streamed past total": {Q: "q", Page: 3, PageSize: 10, Total: 20, Stream: closed(), StreamState: &resultStream{}},
+	}
+	for _, name := range []string{"index.html", "results.html"} {
+		for mode, v := range views {
+			var b strings.Builder
+			if err := tmpl.ExecuteTemplate(&b, name, v); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(b.String(), "No results found.") || strings.Contains(b.String(), `class="pagination"`) {
+				t.Errorf("%s %s: want only the no-results message", name, mode)
+			}
+		}
+	}
+}
+
+func TestSearchViewHasRows(t *testing.T) {
+	stream := make(chan Result)
+	tests := []struct {
+		name string
+		v    searchView
+		want bool
+	}{
+		{"buffered empty", searchView{Page: 1, PageSize: 10}, false},
+		{"buffered", searchView{Page: 1, PageSize: 10, Results: []Result{{}}}, true},
+		{"stream no matches", searchView{Page: 1, PageSize: 10, Stream: stream}, false},
+		{"stream first page", searchView{Page: 1, PageSize: 10, Total: 1, Stream: stream}, true},
+		{"stream last page", searchView{Page: 2, PageSize: 10, Total: 11, Stream: stream}, true},
+		{"stream past the end", searchView{Page: 3, PageSize: 10, Total: 20, Stream: stream}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.v.HasRows(); got != tt.want {
+			t.Errorf("%s: HasRows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testTemplates(t *testing.T) *template.Template {
+	t.Helper()
+	tmpl, err := template.New("base").Funcs(templateFuncs()).ParseGlob("templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates: %v", err)
+	}
+	return tmpl
+}
+
+func TestSearchViewRows(t *testing.T) {
+	buffered := []Result{{URL: "https://a.example/"}, {URL: "https://b.example/"}}
+	streamed := make(chan Result, 1)
+	streamed <- Result{URL: "https://s.example/"}
+	close(streamed)
+	tests := []struct {
+		name string
+		v    searchView
+		want []string
+	}{
+		{name: "buffered", v: searchView{Results: buffered}, want: []string{"https://a.example/", "https://b.example/"}},
+		{name: "stream wins", v: searchView{Results: buffered, Stream: streamed}, want: []string{"https://s.example/"}},
+		{name: "empty", v: searchView{}, want: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for r := range tt.v.Rows() {
+			got = append(got, r.URL)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Rows() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPageViewRenders(t *testing.T) {
+	tmpl := testTemplates(t)
+	tests := []struct {
+		name     string
+		v        pageView
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:     "plain",
+			v:        pageView{Title: "Gose", Page: pageDetails{URL: "https://a.example/x?y=1", Title: "Page A"}},
+			want:     []string{"Page A", "/view?url=https%3A%2F%2Fa.example%2Fx%3Fy%3D1", "Gose"},
+			dontWant: []string{"Open saved text", "Description:"},
+		},
+		{
+			name: "text view and description",
+			v:    pageView{TextView: true, Page: pageDetails{URL: "https://a.example/", Title: "A", Description: "About <b>A</b>"}},
+			want: []string{"Open saved text", "Description:", "About &lt;b&gt;A&lt;/b&gt;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := tmpl.ExecuteTemplate(&b, "page.html", tt.v); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(b.String(), s) {
+					t.Errorf("missing %q", s)
+				}
+			}
+			for _, s := range tt.dontWant {
+				if strings.Contains(b.String(), s) {
+					t.Errorf("unexpected %q", s)
+				}
+			}
+		})
+	}
+}
+
+// A template reading a field the view model lacks fails instead of rendering empty.
+func TestViewModelMissingField(t *testing.T) {
+	tmpl := template.Must(template.New("t").Parse(`{{ .Reslts }}`))
+	for name, v := range map[string]any{"searchView": searchView{}, "pageView": pageView{}} {
+		if err := tmpl.Execute(io.Discard, v); err == nil {
+			t.Errorf("%s: misspelled field rendered without error", name)
+		}
+	}
+}
+
+func TestHandleIndexEmptyForm(t *testing.T) {
+	s := &Server{tmpl: testTemplates(t), title: "Gose"}
+	rec := httptest.NewRecorder()
+	s.handleIndex(rec, httptest.NewRequest(http.MethodGet, "/?site=a.example&sort=fresh", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `placeholder="Enter query"`) || !strings.Contains(body, `value="fresh" selected`) {
+		t.Errorf("empty form not rendered with the chosen sort")
+	}
+	if strings.Contains(body, "No results found.") {
+		t.Error("empty form should not report missing results")
+	}
+}
+
+// /page renders stored page details and 404s for unknown URLs.
+func TestHandlePage(t *testing.T) {
+	db := testDB(t)
+	testPages(t, db, "page-view.example", map[string]string{"https://page-view.example/doc": "page body"})
+	s := &Server{db: db, tmpl: testTemplates(t), title: "Gose"}
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"https://page-view.example/doc", http.StatusOK},
+		{"https://page-view.example/missing", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handlePage(rec, httptest.NewRequest(http.MethodGet, "/page?url="+url.QueryEscape(tt.url), nil))
+		if rec.Code != tt.want {
+			t.Errorf("%q: status = %d, want %d", tt.url, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), tt.url) {
+			t.Errorf("%q: page details missing the URL", tt.url)
+		}
+	}
+}
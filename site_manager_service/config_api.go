@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// redactURL hides the password of a DSN or proxy URL: user:pass@host, a ?password=
+// query parameter, or password= in a key/value DSN. Other strings are returned unchanged.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return reDSNPassword.ReplaceAllString(s, "${1}"+redacted)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// reDSNPassword matches the password of a key/value DSN ("host=db password='s e'").
+var reDSNPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// handleConfig: GET /api/config — the loaded config (YAML keys) plus env overrides, redacted.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.cfg
+	if cfg.Auth.Token != "" {
+		cfg.Auth.Token = redacted
+	}
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var out map[string]any
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		http.Error(w, "config encode error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"config": out,
+		"env":    map[string]string{"PG_DSN": redactURL(os.Getenv("PG_DSN"))},
+	})
+}
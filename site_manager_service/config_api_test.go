@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Neither the auth token nor the PG_DSN password reaches the /api/config response.
+func TestHandleConfigRedactsSecrets(t *testing.T) {
+	for _, dsn := range []string{"postgres://gose:dsn-secret@db/gose", "host=db password=dsn-secret"} {
+		t.Setenv("PG_DSN", dsn)
+		s := &Server{cfg: Config{Auth: AuthConf{Token: "token-secret"}}}
+		rec := httptest.NewRecorder()
+		s.handleConfig(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		body := rec.Body.String()
+		for _, secret := range []string{"dsn-secret", "token-secret"} {
+			if strings.Contains(body, secret) {
+				t.Errorf("PG_DSN %q: response leaks %q", dsn, secret)
+			}
+		}
+	}
+}
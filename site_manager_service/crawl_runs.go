@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CrawlRun mirrors a crawl_runs row; counters are flushed by the crawler every few seconds.
+type CrawlRun struct {
+	ID           int64      `json:"id"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	ItemsDone    int64      `json:"items_done"`
+	ItemsError   int64      `json:"items_error"`
+	ItemsSkipped int64      `json:"items_skipped"`
+}
+
+const crawlRunColumns = `id, started_at, finished_at, items_done, items_error, items_skipped`
+
+func scanCrawlRun(row pgx.Row) (CrawlRun, error) {
+	var r CrawlRun
+	err := row.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &r.ItemsDone, &r.ItemsError, &r.ItemsSkipped)
+	return r, err
+}
+
+// currentRun returns the run marked current; ok=false when none was recorded yet.
+func (s *Server) currentRun(ctx context.Context) (CrawlRun, bool, error) {
+	r, err := scanCrawlRun(s.db.QueryRow(ctx, "SELECT "+crawlRunColumns+" FROM crawl_runs WHERE is_current"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CrawlRun{}, false, nil
+	}
+	if err != nil {
+		return CrawlRun{}, false, err
+	}
+	return r, true, nil
+}
+
+// handleStartRun: POST /api/runs/start — finish the current run and make a fresh one current.
+// Elapsed/ETA on the dashboard are then measured from the new run's start.
+func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, "UPDATE crawl_runs SET is_current = false, finished_at = now() WHERE is_current"); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	run, err := scanCrawlRun(tx.QueryRow(ctx, "INSERT INTO crawl_runs (is_current) VALUES (true) RETURNING "+crawlRunColumns))
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -26,6 +25,12 @@ type Config struct {
 	Version int      `yaml:"version"`
 	HTTP    HTTPConf `yaml:"http"`
 	UI      UIConf   `yaml:"ui"`
+	Auth    AuthConf `yaml:"auth"`
+}
+
+// AuthConf protects operator endpoints (/api/...). Env MANAGER_AUTH_TOKEN overrides Token.
+type AuthConf struct {
+	Token string `yaml:"token"`
 }
 
 type HTTPConf struct {
@@ -46,17 +51,29 @@ type Server struct {
 
 type Stats struct {
 	PagesTotal      int64 `json:"pages_total"`
+	WordsTotal      int64 `json:"words_total"` // sum of pages.word_count (content volume)
 	QueueTotal      int64 `json:"queue_total"`
 	QueueQueued     int64 `json:"queue_queued"`
 	QueueProcessing int64 `json:"queue_processing"`
 	QueueDone       int64 `json:"queue_done"`
 	QueueError      int64 `json:"queue_error"`
 
+	// Wait from enqueue to first claim over items claimed in the last 24h
+	// (crawl_queue.first_claimed_at, crawler.record_queue_wait); nil without samples
+	QueueWaitItems      int64    `json:"queue_wait_items"`
+	QueueWaitP50Seconds *float64 `json:"queue_wait_p50_seconds"`
+	QueueWaitP95Seconds *float64 `json:"queue_wait_p95_seconds"`
+	QueueWaitP50Pretty  string   `json:"queue_wait_p50_pretty"`
+	QueueWaitP95Pretty  string   `json:"queue_wait_p95_pretty"`
+
 	IndexedPercent float64 `json:"indexed_percent"`
 
 	DBSizeBytes  int64  `json:"db_size_bytes"`
 	DBSizePretty string `json:"db_size_pretty"`
 
+	// Current crawl run (nil when the crawler hasn't recorded one yet)
+	Run *CrawlRun `json:"run,omitempty"`
+
 	// Indexing time metrics
 	IndexingStartedAt          time.Time `json:"indexing_started_at"`
 	IndexingElapsedSeconds     int64     `json:"indexing_elapsed_seconds"`
@@ -66,9 +83,43 @@ type Stats struct {
 	EstimatedFinalDBSizeBytes  int64     `json:"estimated_final_db_size_bytes"`
 	EstimatedFinalDBSizePretty string    `json:"estimated_final_db_size_pretty"`
 
+	// Deduplicated HTML storage (html_storage.backend: blob)
+	HTMLBlobs            int64  `json:"html_blobs"`
+	HTMLBlobRefs         int64  `json:"html_blob_refs"`
+	HTMLBlobBytes        int64  `json:"html_blob_bytes"`
+	HTMLDedupSaved       int64  `json:"html_dedup_saved_bytes"`
+	HTMLDedupSavedPretty string `json:"html_dedup_saved_pretty"`
+
+	// Table health (sizes, dead tuples) for bloat monitoring
+	Tables []TableHealth `json:"tables"`
+
+	// Most recently crawled sites (denormalized counters from the sites table)
+	Sites []SiteSummary `json:"sites"`
+
 	GeneratedAt time.Time `json:"generated_at"`
 }
 
+type SiteSummary struct {
+	ID            int64      `json:"id"`
+	Domain        string     `json:"domain"`
+	LastCrawledAt *time.Time `json:"last_crawled_at"`
+	PagesCount    int64      `json:"pages_count"`
+	ErrorCount    int64      `json:"error_count"`
+
+	// Fetch performance over the site's most recent pages (crawler.record_fetch_metrics);
+	// nil when the crawler hasn't recorded any.
+	P50FetchMS    *int64 `json:"p50_fetch_ms"`
+	P95FetchMS    *int64 `json:"p95_fetch_ms"`
+	AvgSize       *int64 `json:"avg_size"`
+	AvgSizePretty string `json:"avg_size_pretty"`
+}
+
+// siteMetricsWindow is how many of a site's latest pages feed its latency/size figures.
+const siteMetricsWindow = 1000
+
+// sitesSummaryLimit bounds the per-site table on the dashboard.
+const sitesSummaryLimit = 20
+
 func main() {
 	cfgPath := getenv("MANAGER_UI_CONFIG_PATH", defaultConfigPath)
 	cfg, err := loadConfig(cfgPath)
@@ -76,6 +127,8 @@ func main() {
 		log.Fatalf("failed to load config %q: %v", cfgPath, err)
 	}
 
+	cfg.Auth.Token = getenv("MANAGER_AUTH_TOKEN", cfg.Auth.Token)
+
 	// DB DSN from env (.env / Compose)
 	dsn := os.Getenv("PG_DSN")
 	if dsn == "" {
@@ -111,6 +164,10 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", srv.handleIndex)
 	mux.HandleFunc("/metrics", srv.handleMetrics)
+	mux.HandleFunc("/api/maintenance", srv.requireAuth(srv.handleMaintenanceReport))
+	mux.HandleFunc("/api/maintenance/run", srv.requireAuth(srv.handleMaintenanceRun))
+	mux.HandleFunc("/api/runs/start", srv.requireAuth(srv.handleStartRun))
+	mux.HandleFunc("/api/config", srv.requireAuth(srv.handleConfig))
 
 	addr := cfg.HTTP.Addr
 	if addr == "" {
@@ -147,17 +204,14 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "stats error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(stats)
+	writeJSON(w, http.StatusOK, stats)
 }
 
 func (s *Server) collectStats(ctx context.Context) (Stats, error) {
 	var st Stats
 
 	// pages total
-	if err := s.db.QueryRow(ctx, "SELECT count(*) FROM pages;").Scan(&st.PagesTotal); err != nil {
+	if err := s.db.QueryRow(ctx, "SELECT count(*), COALESCE(sum(word_count), 0)::bigint FROM pages;").Scan(&st.PagesTotal, &st.WordsTotal); err != nil {
 		return Stats{}, err
 	}
 
@@ -177,10 +231,30 @@ FROM crawl_queue;`
 	if err := s.db.QueryRow(ctx, qQueue).Scan(&st.QueueTotal, &st.QueueQueued, &st.QueueProcessing, &st.QueueDone, &st.QueueError, &firstEnqueued, &firstStarted); err != nil {
 		return Stats{}, err
 	}
-	// prefer the actual start moment (first item left 'queued'), fallback to first enqueue
-	if firstStarted.Valid {
+	const qWait = `
+SELECT count(*),
+	 percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_claimed_at - created_at)::float8),
+	 percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_claimed_at - created_at)::float8)
+FROM crawl_queue
+WHERE first_claimed_at > now() - interval '24 hours';`
+	if err := s.db.QueryRow(ctx, qWait).Scan(&st.QueueWaitItems, &st.QueueWaitP50Seconds, &st.QueueWaitP95Seconds); err != nil {
+		return Stats{}, err
+	}
+	st.QueueWaitP50Pretty = formatSeconds(st.QueueWaitP50Seconds)
+	st.QueueWaitP95Pretty = formatSeconds(st.QueueWaitP95Seconds)
+	// prefer the explicit current crawl run; otherwise infer from queue timestamps:
+	// the actual start moment (first item left 'queued'), fallback to first enqueue
+	run, hasRun, err := s.currentRun(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	switch {
+	case hasRun:
+		st.Run = &run
+		st.IndexingStartedAt = run.StartedAt
+	case firstStarted.Valid:
 		st.IndexingStartedAt = firstStarted.Time
-	} else if firstEnqueued.Valid {
+	case firstEnqueued.Valid:
 		st.IndexingStartedAt = firstEnqueued.Time
 	}
 
@@ -230,11 +304,14 @@ FROM crawl_queue;`
 		}
 		st.IndexingElapsedPretty = formatDur(elapsed)
 
-		// ETA based on throughput of done items since start
-		if st.QueueTotal > 0 && st.QueueDone > 0 && st.IndexingElapsedSeconds > 0 {
-			rate := float64(st.QueueDone) / float64(st.IndexingElapsedSeconds) // items per second
+		// ETA based on throughput of done items since start (per-run counters when a run is recorded)
+		done, remain := st.QueueDone, st.QueueTotal-st.QueueDone
+		if st.Run != nil {
+			done, remain = st.Run.ItemsDone, st.QueueQueued+st.QueueProcessing
+		}
+		if st.QueueTotal > 0 && done > 0 && st.IndexingElapsedSeconds > 0 {
+			rate := float64(done) / float64(st.IndexingElapsedSeconds) // items per second
 			if rate > 0 {
-				remain := st.QueueTotal - st.QueueDone
 				etaSec := float64(remain) / rate
 				if etaSec < 0 {
 					etaSec = 0
@@ -258,10 +335,79 @@ FROM crawl_queue;`
 		}
 	}
 
+	// dedup savings: every reference beyond the first would otherwise be another copy
+	const qBlobs = `SELECT count(*), COALESCE(sum(ref_count),0), COALESCE(sum(octet_length(html)),0) FROM html_blobs;`
+	if err := s.db.QueryRow(ctx, qBlobs).Scan(&st.HTMLBlobs, &st.HTMLBlobRefs, &st.HTMLBlobBytes); err != nil {
+		return Stats{}, err
+	}
+	if st.HTMLBlobs > 0 && st.HTMLBlobRefs > st.HTMLBlobs {
+		avg := float64(st.HTMLBlobBytes) / float64(st.HTMLBlobs)
+		st.HTMLDedupSaved = int64(avg * float64(st.HTMLBlobRefs-st.HTMLBlobs))
+	}
+	st.HTMLDedupSavedPretty = formatBytes(st.HTMLDedupSaved)
+
+	tables, err := s.collectTableHealth(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	st.Tables = tables
+
+	sites, err := s.collectSites(ctx, sitesSummaryLimit)
+	if err != nil {
+		return Stats{}, err
+	}
+	st.Sites = sites
+
 	st.GeneratedAt = now
 	return st, nil
 }
 
+// collectSites reads per-site counters maintained by the crawler, plus fetch latency and
+// size over each site's latest siteMetricsWindow pages (pages_site_fetched_idx).
+func (s *Server) collectSites(ctx context.Context, limit int) ([]SiteSummary, error) {
+	const q = `
+SELECT s.id, s.domain, s.last_crawled_at, s.pages_count, s.error_count,
+       m.p50::bigint, m.p95::bigint, m.avg_size::bigint
+FROM (
+  SELECT id, domain, last_crawled_at, pages_count, error_count
+  FROM sites
+  WHERE last_crawled_at IS NOT NULL
+  ORDER BY last_crawled_at DESC
+  LIMIT $1
+) s
+LEFT JOIN LATERAL (
+  SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY fetch_ms)  AS p50,
+         percentile_cont(0.95) WITHIN GROUP (ORDER BY fetch_ms) AS p95,
+         avg(raw_size) AS avg_size
+  FROM (
+    SELECT fetch_ms, raw_size FROM pages
+    WHERE site_id = s.id AND fetch_ms IS NOT NULL
+    ORDER BY fetched_at DESC
+    LIMIT $2
+  ) recent
+) m ON true
+ORDER BY s.last_crawled_at DESC;`
+	rows, err := s.db.Query(ctx, q, limit, siteMetricsWindow)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]SiteSummary, 0, limit)
+	for rows.Next() {
+		var ss SiteSummary
+		if err := rows.Scan(&ss.ID, &ss.Domain, &ss.LastCrawledAt, &ss.PagesCount, &ss.ErrorCount,
+			&ss.P50FetchMS, &ss.P95FetchMS, &ss.AvgSize); err != nil {
+			return nil, err
+		}
+		ss.AvgSizePretty = "-"
+		if ss.AvgSize != nil {
+			ss.AvgSizePretty = formatBytes(*ss.AvgSize)
+		}
+		out = append(out, ss)
+	}
+	return out, rows.Err()
+}
+
 func (s *Server) render(w http.ResponseWriter, name string, data any) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := s.tmpl.ExecuteTemplate(w, name, data); err != nil {
@@ -288,6 +434,14 @@ func getenv(k, def string) string {
 	return def
 }
 
+// formatSeconds renders an optional duration in seconds as 1h2m3s ("-" when nil).
+func formatSeconds(secs *float64) string {
+	if secs == nil {
+		return "-"
+	}
+	return (time.Duration(*secs) * time.Second).String()
+}
+
 func formatBytes(b int64) string {
 	if b < 1024 {
 		return "0.00 MB"
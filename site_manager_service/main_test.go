@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestFormatSeconds(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		secs *float64
+		want string
+	}{
+		{nil, "-"},
+		{f(0), "0s"},
+		{f(42), "42s"},
+		{f(42.9), "42s"},
+		{f(3723), "1h2m3s"},
+		{f(90000), "25h0m0s"},
+	}
+	for _, tt := range tests {
+		if got := formatSeconds(tt.secs); got != tt.want {
+			t.Errorf("formatSeconds(%v) = %q, want %q", tt.secs, got, tt.want)
+		}
+	}
+}
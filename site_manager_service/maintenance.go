@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maintenanceTables are the tables covered by ANALYZE/VACUUM and the health report.
+var maintenanceTables = []string{"pages", "page_links", "crawl_queue", "sites", "html_blobs"}
+
+type TableHealth struct {
+	Table           string     `json:"table"`
+	TotalBytes      int64      `json:"total_bytes"`
+	TableBytes      int64      `json:"table_bytes"`
+	IndexBytes      int64      `json:"index_bytes"`
+	TotalPretty     string     `json:"total_pretty"`
+	LiveTuples      int64      `json:"live_tuples"`
+	DeadTuples      int64      `json:"dead_tuples"`
+	DeadPercent     float64    `json:"dead_percent"`
+	LastVacuum      *time.Time `json:"last_vacuum,omitempty"`
+	LastAutovacuum  *time.Time `json:"last_autovacuum,omitempty"`
+	LastAnalyze     *time.Time `json:"last_analyze,omitempty"`
+	LastAutoanalyze *time.Time `json:"last_autoanalyze,omitempty"`
+}
+
+// collectTableHealth reports sizes and estimated dead tuples from pg_stat_user_tables (cheap, no scans).
+func (s *Server) collectTableHealth(ctx context.Context) ([]TableHealth, error) {
+	const q = `
+SELECT relname,
+       pg_total_relation_size(relid),
+       pg_relation_size(relid),
+       pg_indexes_size(relid),
+       n_live_tup, n_dead_tup,
+       last_vacuum, last_autovacuum, last_analyze, last_autoanalyze
+FROM pg_stat_user_tables
+WHERE relname = ANY($1)
+ORDER BY pg_total_relation_size(relid) DESC;`
+	rows, err := s.db.Query(ctx, q, maintenanceTables)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TableHealth
+	for rows.Next() {
+		var t TableHealth
+		if err := rows.Scan(&t.Table, &t.TotalBytes, &t.TableBytes, &t.IndexBytes, &t.LiveTuples, &t.DeadTuples,
+			&t.LastVacuum, &t.LastAutovacuum, &t.LastAnalyze, &t.LastAutoanalyze); err != nil {
+			return nil, err
+		}
+		t.TotalPretty = formatBytes(t.TotalBytes)
+		if n := t.LiveTuples + t.DeadTuples; n > 0 {
+			t.DeadPercent = float64(t.DeadTuples*1000/n) / 10.0 // one decimal
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// requireAuth guards operator endpoints with the configured bearer token.
+// Without a configured token the endpoints are disabled.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg.Auth.Token
+		if token == "" {
+			http.Error(w, "disabled: auth.token is not configured", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="manager"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleMaintenanceReport: GET /api/maintenance — table/index sizes and dead tuples.
+func (s *Server) handleMaintenanceReport(w http.ResponseWriter, r *http.Request) {
+	tables, err := s.collectTableHealth(r.Context())
+	if err != nil {
+		http.Error(w, "report error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tables": tables, "generated_at": time.Now()})
+}
+
+// handleMaintenanceRun: POST /api/maintenance/run?op=analyze|vacuum[&full=true]
+// ANALYZE and plain VACUUM don't block reads/writes; VACUUM FULL takes an exclusive lock
+// and only runs with an explicit full=true.
+func (s *Server) handleMaintenanceRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	op := strings.ToLower(r.URL.Query().Get("op"))
+	var stmt string
+	switch op {
+	case "analyze":
+		stmt = "ANALYZE "
+	case "vacuum":
+		stmt = "VACUUM (ANALYZE) "
+		if r.URL.Query().Get("full") == "true" {
+			stmt = "VACUUM (FULL, ANALYZE) "
+		}
+	default:
+		http.Error(w, "op must be analyze or vacuum", http.StatusBadRequest)
+		return
+	}
+
+	type result struct {
+		Table    string `json:"table"`
+		Duration string `json:"duration"`
+		Error    string `json:"error,omitempty"`
+	}
+	var out []result
+	for _, t := range maintenanceTables {
+		started := time.Now()
+		res := result{Table: t}
+		// table names come from the fixed allowlist above, never from the request
+		if _, err := s.db.Exec(r.Context(), stmt+t); err != nil {
+			res.Error = err.Error()
+		}
+		res.Duration = time.Since(started).Round(time.Millisecond).String()
+		out = append(out, res)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"op": strings.TrimSpace(stmt), "results": out})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}